@@ -0,0 +1,606 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// The header of the .bitmap files git writes.
+const (
+	bitmapVersion   = 1
+	bitmapFullDAG   = 1 // the bitmaps cover everything their commits reach
+	bitmapHashCache = 4 // the file ends with the name hash of each object
+	bitmapMaxXOR    = 160
+)
+
+// bitmapTypes are the types of the objects in each of a bitmap file's type
+// bitmaps, in the order it stores them.
+var bitmapTypes = [4]string{"commit", "tree", "blob", "tag"}
+
+// bitmapIndex is a reachability bitmap file: for some of the commits in a
+// pack, or in the packs a multi-pack-index covers, a bitmap of the objects
+// the commit reaches. Bits stand for objects in pack order, which for a
+// multi-pack-index is the order of its preferred pack and then of the rest
+// in turn.
+type bitmapIndex struct {
+	numObjects int
+	find       func(hash []byte) int // the position of hash in the index, or -1
+	lexHash    func(i int) []byte
+	lexToBit   []uint32
+	bitToLex   []uint32
+	types      [4]bitset // in the order of bitmapTypes
+	entries    []bitmapEntry
+	byCommit   map[string]int // indexes entries
+	nameHashes []byte         // the hash cache, in index order, if there is one
+	// extended holds the objects outside the bitmapped packs that walks have
+	// found; they take the bits after numObjects.
+	extended    []ListedObject
+	extendedPos map[string]int
+}
+
+type bitmapEntry struct {
+	lex       int
+	xorOffset int // the entry this one is XORed with, counting back
+	raw       bitset
+	decoded   bitset
+}
+
+// newBitmapIndex returns a bitmapIndex without bitmaps for the numObjects
+// objects of an index, where bitToLex gives the index position of each bit.
+func newBitmapIndex(numObjects int, find func([]byte) int, lexHash func(int) []byte, bitToLex []uint32) *bitmapIndex {
+	bi := &bitmapIndex{numObjects: numObjects, find: find, lexHash: lexHash, bitToLex: bitToLex,
+		lexToBit: make([]uint32, numObjects), byCommit: make(map[string]int), extendedPos: make(map[string]int)}
+	for bit, lex := range bitToLex {
+		bi.lexToBit[lex] = uint32(bit)
+	}
+	return bi
+}
+
+// parse reads the bitmaps of a .bitmap file, which must have been written
+// for the pack or multi-pack-index with the checksum sum.
+func (bi *bitmapIndex) parse(data, sum []byte) error {
+	if len(data) < 32+sha1.Size || string(data[:4]) != "BITM" {
+		return errors.New("corrupted bitmap index file (wrong header)")
+	}
+	if v := binary.BigEndian.Uint16(data[4:]); v != bitmapVersion {
+		return fmt.Errorf("unsupported version '%d' for bitmap index file", v)
+	}
+	options := binary.BigEndian.Uint16(data[6:])
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	if !bytes.Equal(data[12:32], sum) {
+		return errors.New("bitmap checksum doesn't match its pack")
+	}
+	rest := data[32 : len(data)-sha1.Size]
+	if options&bitmapHashCache != 0 {
+		if len(rest) < bi.numObjects*4 {
+			return errors.New("corrupted bitmap index file (too short to fit hash cache)")
+		}
+		bi.nameHashes = rest[len(rest)-bi.numObjects*4:]
+		rest = rest[:len(rest)-bi.numObjects*4]
+	}
+	var err error
+	for i := range bi.types {
+		if bi.types[i], rest, err = readEWAH(rest); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < count; i++ {
+		if len(rest) < 6 {
+			return errors.New("corrupted bitmap index file (truncated entry)")
+		}
+		e := bitmapEntry{lex: int(binary.BigEndian.Uint32(rest)), xorOffset: int(rest[4])}
+		if e.lex >= bi.numObjects || e.xorOffset > bitmapMaxXOR || e.xorOffset > i {
+			return errors.New("corrupted bitmap pack index")
+		}
+		if e.raw, rest, err = readEWAH(rest[6:]); err != nil {
+			return err
+		}
+		bi.byCommit[fmt.Sprintf("%x", bi.lexHash(e.lex))] = len(bi.entries)
+		bi.entries = append(bi.entries, e)
+	}
+	return nil
+}
+
+// commitBitmap returns the bitmap of the objects reachable from the commit
+// hash, or nil if there isn't one.
+func (bi *bitmapIndex) commitBitmap(hash string) bitset {
+	i, ok := bi.byCommit[hash]
+	if !ok {
+		return nil
+	}
+	return bi.decode(i)
+}
+
+func (bi *bitmapIndex) decode(i int) bitset {
+	e := &bi.entries[i]
+	if e.decoded == nil {
+		b := append(bitset(nil), e.raw...)
+		if e.xorOffset > 0 {
+			b.xor(bi.decode(i - e.xorOffset))
+		}
+		e.decoded = b
+	}
+	return e.decoded
+}
+
+// position returns the bit for the object hash of type objectType, giving
+// it one after the bitmapped objects if they don't include it.
+func (bi *bitmapIndex) position(hash []byte, objectType string) int {
+	if lex := bi.find(hash); lex != -1 {
+		return int(bi.lexToBit[lex])
+	}
+	name := fmt.Sprintf("%x", hash)
+	if pos, ok := bi.extendedPos[name]; ok {
+		return pos
+	}
+	pos := bi.numObjects + len(bi.extended)
+	bi.extendedPos[name] = pos
+	bi.extended = append(bi.extended, ListedObject{Hash: name, Type: objectType})
+	return pos
+}
+
+// object returns the object the bit pos stands for.
+func (bi *bitmapIndex) object(pos int) ListedObject {
+	if pos >= bi.numObjects {
+		return bi.extended[pos-bi.numObjects]
+	}
+	lex := int(bi.bitToLex[pos])
+	o := ListedObject{Hash: fmt.Sprintf("%x", bi.lexHash(lex))}
+	for i, b := range bi.types {
+		if b.get(pos) {
+			o.Type = bitmapTypes[i]
+		}
+	}
+	if bi.nameHashes != nil {
+		o.NameHash = binary.BigEndian.Uint32(bi.nameHashes[lex*4:])
+	}
+	return o
+}
+
+// reach returns the bitmap of the objects reachable from roots. Commits
+// with bitmaps of their own stop the walk.
+func (bi *bitmapIndex) reach(roots []string) (bitset, error) {
+	result := bitset(nil)
+	commits, trees := []string(nil), []string(nil)
+	for _, hash := range roots {
+		for {
+			objectType, data, err := readObject(hash)
+			if err != nil {
+				return nil, err
+			}
+			if objectType == "commit" {
+				commits = append(commits, hash)
+				break
+			} else if objectType == "tree" {
+				trees = append(trees, hash)
+				break
+			}
+			result.set(bi.position(hashToBytes(hash), objectType))
+			if objectType != "tag" {
+				break
+			}
+			if hash, _, err = tagTarget(data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for len(commits) > 0 {
+		hash := commits[len(commits)-1]
+		commits = commits[:len(commits)-1]
+		pos := bi.position(hashToBytes(hash), "commit")
+		if result.get(pos) {
+			continue
+		}
+		if b := bi.commitBitmap(hash); b != nil {
+			result.or(b)
+			continue
+		}
+		result.set(pos)
+		c, err := LookupCommitInfo(hash)
+		if err != nil {
+			return nil, err
+		}
+		trees = append(trees, c.Tree)
+		commits = append(commits, c.Parents...)
+	}
+	// The trees come last so that those the bitmaps have are skipped.
+	for _, tree := range trees {
+		if err := bi.addTree(hashToBytes(tree), &result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (bi *bitmapIndex) addTree(hash []byte, result *bitset) error {
+	pos := bi.position(hash, "tree")
+	if result.get(pos) {
+		return nil
+	}
+	result.set(pos)
+	entries, err := readTree(fmt.Sprintf("%x", hash))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		switch {
+		case e.isTree():
+			if err := bi.addTree(e.hash[:], result); err != nil {
+				return err
+			}
+		case e.mode == "160000":
+			// Submodule commits aren't in this repository.
+		default:
+			result.set(bi.position(e.hash[:], "blob"))
+		}
+	}
+	return nil
+}
+
+// objects lists the objects in b: commits, then tags, then trees and blobs,
+// each in pack order.
+func (bi *bitmapIndex) objects(b bitset) []ListedObject {
+	commits, tags, others := []ListedObject(nil), []ListedObject(nil), []ListedObject(nil)
+	b.each(func(pos int) {
+		o := bi.object(pos)
+		switch o.Type {
+		case "commit":
+			commits = append(commits, o)
+		case "tag":
+			tags = append(tags, o)
+		default:
+			others = append(others, o)
+		}
+	})
+	return append(append(commits, tags...), others...)
+}
+
+var (
+	loadedBitmap *bitmapIndex
+	bitmapLoaded bool
+)
+
+// loadBitmap returns the reachability bitmap of the packs, if there is one
+// ggit can use: the multi-pack-index's, or else that of the first pack with
+// one. Bitmaps that can't be read are ignored.
+func loadBitmap() *bitmapIndex {
+	if bitmapLoaded {
+		return loadedBitmap
+	}
+	if err := loadPacks(); err != nil {
+		return nil
+	}
+	bitmapLoaded = true
+	if m := parsedMIDX; m != nil {
+		if bi, err := openMIDXBitmap(m); err == nil && bi != nil {
+			loadedBitmap = bi
+			return bi
+		}
+	}
+	for _, p := range parsedPackFiles {
+		if bi, err := openPackBitmap(p); err == nil && bi != nil {
+			loadedBitmap = bi
+			break
+		}
+	}
+	return loadedBitmap
+}
+
+// closeBitmap forgets the loaded bitmap, so that the next use reads it
+// afresh.
+func closeBitmap() {
+	loadedBitmap, bitmapLoaded = nil, false
+}
+
+// packBitmapIndex returns a bitmapIndex without bitmaps for the objects of
+// the pack with the index idx.
+func packBitmapIndex(idx packIndexFile) *bitmapIndex {
+	p := &pack{idx: idx}
+	return newBitmapIndex(idx.numEntries, p.find, p.idx.hash, idx.packOrder())
+}
+
+// openPackBitmap reads the pack's .bitmap file, returning nil if it has
+// none.
+func openPackBitmap(p *pack) (*bitmapIndex, error) {
+	data, err := ioutil.ReadFile(".git/objects/pack/" + p.baseFileName + ".bitmap")
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := p.loadIndex(); err != nil {
+		return nil, err
+	}
+	bi := packBitmapIndex(p.idx)
+	sum := p.idx.data[len(p.idx.data)-2*sha1.Size : len(p.idx.data)-sha1.Size]
+	return bi, bi.parse(data, sum)
+}
+
+// midxFilesPath returns the path the multi-pack-index's bitmap and reverse
+// index have, less their extension.
+func midxFilesPath(sum []byte) string {
+	return fmt.Sprintf("%s-%x", midxPath, sum)
+}
+
+// midxBitmapIndex returns a bitmapIndex without bitmaps for the objects of
+// the multi-pack-index.
+func midxBitmapIndex(m *multiPackIndex) (*bitmapIndex, error) {
+	rev := m.revIndex
+	if rev == nil {
+		data, err := ioutil.ReadFile(midxFilesPath(m.data[len(m.data)-sha1.Size:]) + ".rev")
+		if err != nil {
+			return nil, err
+		}
+		if len(data) != 12+m.numObjects*4+2*sha1.Size || string(data[:4]) != "RIDX" {
+			return nil, errors.New("multi-pack-index reverse index is corrupt")
+		}
+		rev = data[12 : 12+m.numObjects*4]
+	}
+	order := make([]uint32, m.numObjects)
+	for i := range order {
+		order[i] = binary.BigEndian.Uint32(rev[i*4:])
+		if int(order[i]) >= m.numObjects {
+			return nil, errors.New("multi-pack-index reverse index is corrupt")
+		}
+	}
+	return newBitmapIndex(m.numObjects, m.find, m.hash, order), nil
+}
+
+// openMIDXBitmap reads the multi-pack-index's bitmap, returning nil if it
+// has none.
+func openMIDXBitmap(m *multiPackIndex) (*bitmapIndex, error) {
+	sum := m.data[len(m.data)-sha1.Size:]
+	data, err := ioutil.ReadFile(midxFilesPath(sum) + ".bitmap")
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	bi, err := midxBitmapIndex(m)
+	if err != nil {
+		return nil, err
+	}
+	return bi, bi.parse(data, sum)
+}
+
+// BitmapObjects lists the objects reachable from the objects named in
+// include but not from those in exclude using the packs' reachability
+// bitmap, walking only the history it doesn't cover. It lists commits, then
+// tags, then trees and blobs. Unlike ListObjects it leaves out everything
+// reachable from exclude. It returns false if there is no bitmap.
+func BitmapObjects(include, exclude []string) ([]ListedObject, bool, error) {
+	bi := loadBitmap()
+	if bi == nil {
+		return nil, false, nil
+	}
+	want, err := bi.reach(include)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(exclude) > 0 {
+		have, err := bi.reach(exclude)
+		if err != nil {
+			return nil, false, err
+		}
+		want.andNot(have)
+	}
+	return bi.objects(want), true, nil
+}
+
+// selectBitmapCommits picks the commits to give bitmaps as git does: all of
+// them if there are few, else the newest hundred and then one of each
+// stretch of commits, growing longer with age, preferring merges.
+func selectBitmapCommits(commits []CommitInfo) []CommitInfo {
+	sort.SliceStable(commits, func(i, j int) bool { return commits[i].Date.After(commits[j].Date) })
+	if len(commits) < 100 {
+		return commits
+	}
+	next := func(i int) int {
+		const (
+			minCommits, maxCommits = 100, 5000
+			mustRegion, minRegion  = 100, 20000
+		)
+		switch {
+		case i <= mustRegion:
+			return 0
+		case i <= minRegion:
+			if i-mustRegion < minCommits {
+				return i - mustRegion
+			}
+			return minCommits
+		case i-minRegion > maxCommits:
+			return maxCommits
+		case i-minRegion > minCommits:
+			return i - minRegion
+		}
+		return minCommits
+	}
+	selected := []CommitInfo(nil)
+	for i := 0; ; {
+		n := next(i)
+		if i+n >= len(commits) {
+			break
+		}
+		chosen := commits[i+n]
+		for _, c := range commits[i : i+n] {
+			if len(c.Parents) > 1 {
+				chosen = c
+			}
+		}
+		selected = append(selected, chosen)
+		i += n + 1
+	}
+	return selected
+}
+
+// build returns a .bitmap file for bi, which must have every object
+// reachable from objects, to go with the pack or multi-pack-index with the
+// checksum sum. objects gives the types and names of the objects; those of
+// the rest are looked up.
+func (bi *bitmapIndex) build(objects []ListedObject, sum []byte) ([]byte, error) {
+	nameHashes := make([]byte, bi.numObjects*4)
+	typed := make([]bool, bi.numObjects)
+	commits := []CommitInfo(nil)
+	for _, o := range objects {
+		lex := bi.find(hashToBytes(o.Hash))
+		if lex == -1 {
+			return nil, fmt.Errorf("Failed to write bitmap index. Packfile doesn't have full closure (object %s is missing)", o.Hash)
+		}
+		if typed[lex] || o.Type == "" {
+			continue
+		}
+		typed[lex] = true
+		bi.setType(lex, o.Type)
+		nameHash := o.NameHash
+		if o.Name != "" {
+			nameHash = packNameHash(o.Name)
+		}
+		binary.BigEndian.PutUint32(nameHashes[lex*4:], nameHash)
+		if o.Type == "commit" {
+			c, err := LookupCommitInfo(o.Hash)
+			if err != nil {
+				return nil, err
+			}
+			commits = append(commits, c)
+		}
+	}
+	for lex := range typed {
+		if typed[lex] {
+			continue
+		}
+		o, err := LookupObject(fmt.Sprintf("%x", bi.lexHash(lex)))
+		if err != nil {
+			return nil, err
+		}
+		o.Close()
+		bi.setType(lex, o.ObjectType)
+	}
+
+	selected := selectBitmapCommits(commits)
+	for i := len(selected) - 1; i >= 0; i-- {
+		b, err := bi.reach([]string{selected[i].Hash})
+		if err != nil {
+			return nil, err
+		}
+		if len(bi.extended) > 0 {
+			return nil, fmt.Errorf("Failed to write bitmap index. Packfile doesn't have full closure (object %s is missing)", bi.extended[0].Hash)
+		}
+		bi.byCommit[selected[i].Hash] = len(bi.entries)
+		bi.entries = append(bi.entries, bitmapEntry{lex: bi.find(hashToBytes(selected[i].Hash)), raw: b, decoded: b})
+	}
+
+	b := bytes.NewBuffer(nil)
+	word := make([]byte, 4)
+	b.WriteString("BITM")
+	binary.BigEndian.PutUint16(word, bitmapVersion)
+	binary.BigEndian.PutUint16(word[2:], bitmapFullDAG|bitmapHashCache)
+	b.Write(word)
+	binary.BigEndian.PutUint32(word, uint32(len(bi.entries)))
+	b.Write(word)
+	b.Write(sum)
+	for _, t := range bi.types {
+		b.Write(appendEWAH(nil, t))
+	}
+	// Newest first, as git writes them.
+	for i := len(bi.entries) - 1; i >= 0; i-- {
+		e := bi.entries[i]
+		binary.BigEndian.PutUint32(word, uint32(e.lex))
+		b.Write(word)
+		b.Write([]byte{0, 0})
+		b.Write(appendEWAH(nil, e.raw))
+	}
+	b.Write(nameHashes)
+	checksum := sha1.Sum(b.Bytes())
+	b.Write(checksum[:])
+	return b.Bytes(), nil
+}
+
+func (bi *bitmapIndex) setType(lex int, objectType string) {
+	for i, t := range bitmapTypes {
+		if t == objectType {
+			bi.types[i].set(int(bi.lexToBit[lex]))
+		}
+	}
+}
+
+// writePackBitmap writes a .bitmap file for the pack named name, which
+// holds objects and everything they reach.
+func writePackBitmap(name string, objects []ListedObject) error {
+	base := ".git/objects/pack/pack-" + name
+	data, err := ioutil.ReadFile(base + ".idx")
+	if err != nil {
+		return err
+	}
+	idx, err := parsePackIndexFile(data)
+	if err != nil {
+		return err
+	}
+	bitmap, err := packBitmapIndex(idx).build(objects, data[len(data)-2*sha1.Size:len(data)-sha1.Size])
+	if err != nil {
+		return err
+	}
+	return writeFileLocked(base+".bitmap", bitmap)
+}
+
+// writeMIDXBitmap writes a bitmap for the multi-pack-index, covering the
+// commits the refs reach that are in the packs it covers. Everything those
+// commits reach must be in the packs too.
+func writeMIDXBitmap() error {
+	closePacks()
+	if err := loadPacks(); err != nil {
+		return err
+	}
+	m := parsedMIDX
+	if m == nil {
+		return errors.New("could not load multi-pack-index")
+	}
+	bi, err := midxBitmapIndex(m)
+	if err != nil {
+		return err
+	}
+	refs, err := ListRefs("refs/")
+	if err != nil {
+		return err
+	}
+	tips := []string(nil)
+	for _, r := range refs {
+		hash, err := peel(r.Hash, "")
+		if err != nil {
+			return err
+		}
+		if o, err := LookupObject(hash); err == nil {
+			o.Close()
+			if o.ObjectType == "commit" {
+				tips = append(tips, hash)
+			}
+		}
+	}
+	objects, err := WalkObjects(tips, nil)
+	if err != nil {
+		return err
+	}
+	packed := objects[:0]
+	for _, o := range objects {
+		if m.find(hashToBytes(o.Hash)) != -1 {
+			packed = append(packed, o)
+		}
+	}
+	objects = packed
+	sum := m.data[len(m.data)-sha1.Size:]
+	bitmap, err := bi.build(objects, sum)
+	if err != nil {
+		return err
+	}
+	return writeFileLocked(midxFilesPath(sum)+".bitmap", bitmap)
+}
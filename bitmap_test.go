@@ -0,0 +1,146 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestEWAH(t *testing.T) {
+	for _, bits := range [][]int{
+		nil,
+		{0},
+		{63, 64, 65},
+		{5, 1000, 1001, 100000},
+	} {
+		b := bitset(nil)
+		for _, i := range bits {
+			b.set(i)
+		}
+		// A long run of ones as well.
+		for i := 2000; i < 2000+64*40; i++ {
+			b.set(i)
+		}
+		data := appendEWAH(nil, b)
+		got, rest, err := readEWAH(append(data, 'x'))
+		if err != nil {
+			t.Fatalf("readEWAH(appendEWAH(%v)): %v", bits, err)
+		}
+		if string(rest) != "x" {
+			t.Errorf("readEWAH left %q, want \"x\"", rest)
+		}
+		want := []int(nil)
+		b.each(func(i int) { want = append(want, i) })
+		have := []int(nil)
+		got.each(func(i int) { have = append(have, i) })
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("round trip of %v gave %d bits, want %d", bits, len(have), len(want))
+		}
+	}
+}
+
+func sortedHashes(objects []ListedObject) []string {
+	hashes := []string(nil)
+	for _, o := range objects {
+		hashes = append(hashes, o.Hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+func TestBitmap(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	commits := []string(nil)
+	parent := []string(nil)
+	for i := 0; i < 5; i++ {
+		who.When = who.When.Add(time.Minute)
+		tree := makeTree(t, map[string]string{"a": fmt.Sprintf("%d\n", i), "d/b": "b\n"})
+		commit, err := CommitTree(tree, parent, who, who, fmt.Sprintf("commit %d\n", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits, parent = append(commits, commit), []string{commit}
+	}
+	if err := UpdateRef("refs/heads/master", commits[4], "", who, "commit"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Repack(RepackOptions{WriteBitmap: true}); err == nil {
+		t.Error("incremental repack wrote a bitmap")
+	}
+	name, err := Repack(RepackOptions{All: true, Delete: true, WriteBitmap: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bitmaps, _ := filepath.Glob(".git/objects/pack/*.bitmap"); len(bitmaps) != 1 || bitmaps[0] != ".git/objects/pack/pack-"+name+".bitmap" {
+		t.Fatalf("bitmaps after repack: %v", bitmaps)
+	}
+	bi := loadBitmap()
+	if bi == nil || len(bi.entries) != 5 {
+		t.Fatalf("loadBitmap() = %+v, want 5 commits", bi)
+	}
+
+	// A commit the bitmap doesn't have is walked.
+	who.When = who.When.Add(time.Minute)
+	tree := makeTree(t, map[string]string{"a": "new\n", "d/b": "b\n"})
+	tip, err := CommitTree(tree, []string{commits[4]}, who, who, "new\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ include, exclude []string }{
+		{[]string{commits[4]}, nil},
+		{[]string{tip}, nil},
+		{[]string{tip}, []string{commits[1]}},
+		{[]string{commits[2]}, []string{commits[3]}},
+	} {
+		got, ok, err := BitmapObjects(tc.include, tc.exclude)
+		if err != nil || !ok {
+			t.Fatalf("BitmapObjects(%v, %v): %v, %v", tc.include, tc.exclude, ok, err)
+		}
+		want, err := WalkObjects(tc.include, tc.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(sortedHashes(got), sortedHashes(want)) {
+			t.Errorf("BitmapObjects(%v, %v) = %v, want %v", tc.include, tc.exclude, sortedHashes(got), sortedHashes(want))
+		}
+		for _, o := range got {
+			if o.Type == "" {
+				t.Errorf("%s listed without a type", o.Hash)
+			}
+		}
+	}
+
+	// A multi-pack-index bitmap covers the same commits.
+	if err := WriteMultiPackIndex(MultiPackIndexOptions{Bitmap: true}); err != nil {
+		t.Fatal(err)
+	}
+	closePacks()
+	if err := loadPacks(); err != nil {
+		t.Fatal(err)
+	}
+	if bitmaps, _ := filepath.Glob(midxPath + "-*.bitmap"); len(bitmaps) != 1 {
+		t.Fatalf("multi-pack-index bitmaps: %v", bitmaps)
+	}
+	if bi := loadBitmap(); bi == nil || len(bi.entries) != 5 || bi.numObjects != parsedMIDX.numObjects {
+		t.Fatalf("loadBitmap() = %+v, want the multi-pack-index's", bi)
+	}
+	got, ok, err := BitmapObjects([]string{tip}, nil)
+	if err != nil || !ok {
+		t.Fatalf("BitmapObjects: %v, %v", ok, err)
+	}
+	want, _ := WalkObjects([]string{tip}, nil)
+	if !reflect.DeepEqual(sortedHashes(got), sortedHashes(want)) {
+		t.Errorf("BitmapObjects with the multi-pack-index = %v, want %v", sortedHashes(got), sortedHashes(want))
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// The settings of the changed-path Bloom filters git writes.
+const (
+	bloomHashVersion   = 1
+	bloomNumHashes     = 7
+	bloomBitsPerEntry  = 10
+	bloomMaxChanges    = 512 // commits changing more paths get a filter that matches everything
+	bloomHeaderSize    = 12
+	bloomSeed0         = 0x293ae76f
+	bloomSeed1         = 0x7e646e2c
+	bloomLargeFilter   = 0xff
+	bloomMaxFilterSize = 1 << 28
+)
+
+// murmur3 is the 32-bit MurmurHash3 of data. Like git's version 1 filters,
+// it treats bytes as signed chars, so bytes from 0x80 up hash as git's do.
+func murmur3(seed uint32, data []byte) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+	signed := func(b byte) uint32 { return uint32(int32(int8(b))) }
+	rotl := func(x uint32, r uint) uint32 { return x<<r | x>>(32-r) }
+	h := seed
+	n := len(data) / 4
+	for i := 0; i < n; i++ {
+		b := data[i*4:]
+		k := signed(b[0]) | signed(b[1])<<8 | signed(b[2])<<16 | signed(b[3])<<24
+		k *= c1
+		k = rotl(k, 15)
+		k *= c2
+		h ^= k
+		h = rotl(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	tail := data[n*4:]
+	k := uint32(0)
+	switch len(tail) {
+	case 3:
+		k ^= signed(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= signed(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= signed(tail[0])
+		k *= c1
+		k = rotl(k, 15)
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// bloomKey is the positions a path sets in a filter, before they are
+// reduced to the filter's size.
+type bloomKey [bloomNumHashes]uint32
+
+func newBloomKey(path string) bloomKey {
+	h0 := murmur3(bloomSeed0, []byte(path))
+	h1 := murmur3(bloomSeed1, []byte(path))
+	k := bloomKey{}
+	for i := range k {
+		k[i] = h0 + uint32(i)*h1
+	}
+	return k
+}
+
+// bloomFilter is the changed-path filter of a commit: a bit for each of the
+// hashes of each path that differs from its first parent, and of their
+// leading directories.
+type bloomFilter []byte
+
+// newBloomFilter returns the filter for paths, or one that matches every
+// path if there are too many.
+func newBloomFilter(paths []string) bloomFilter {
+	if len(paths) > bloomMaxChanges {
+		return bloomFilter{bloomLargeFilter}
+	}
+	keys := make(map[string]bool)
+	for _, p := range paths {
+		for p != "" {
+			keys[p] = true
+			slash := strings.LastIndexByte(p, '/')
+			if slash == -1 {
+				break
+			}
+			p = p[:slash]
+		}
+	}
+	size := (len(keys)*bloomBitsPerEntry + 7) / 8
+	if size == 0 {
+		size = 1
+	}
+	f := make(bloomFilter, size)
+	for p := range keys {
+		f.add(newBloomKey(p))
+	}
+	return f
+}
+
+func (f bloomFilter) add(k bloomKey) {
+	bits := uint64(len(f)) * 8
+	for _, h := range k {
+		pos := uint64(h) % bits
+		f[pos/8] |= 1 << (pos % 8)
+	}
+}
+
+// mayContain reports whether the filter may have the key. If not, the path
+// is certainly unchanged.
+func (f bloomFilter) mayContain(k bloomKey) bool {
+	if len(f) == 0 {
+		return true
+	}
+	bits := uint64(len(f)) * 8
+	for _, h := range k {
+		pos := uint64(h) % bits
+		if f[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomFilter returns the changed-path filter the commit-graph has for the
+// commit at pos, if it has one.
+func (g *commitGraph) bloomFilter(pos int) (bloomFilter, bool) {
+	l, i := g.layerAt(pos)
+	if l == nil || l.bloomIndex == nil {
+		return nil, false
+	}
+	start := uint32(0)
+	if i > 0 {
+		start = binary.BigEndian.Uint32(l.bloomIndex[(i-1)*4:])
+	}
+	end := binary.BigEndian.Uint32(l.bloomIndex[i*4:])
+	if start > end || int(end) > len(l.bloomData)-bloomHeaderSize {
+		return nil, false
+	}
+	return bloomFilter(l.bloomData[bloomHeaderSize+start : bloomHeaderSize+end]), true
+}
+
+// parseBloomChunks checks the header of the layer's BDAT chunk, leaving the
+// layer without filters if they aren't ones ggit can use.
+func (l *commitGraphLayer) parseBloomChunks(index, data []byte) {
+	if index == nil || data == nil || len(index) != l.numCommits*4 || len(data) < bloomHeaderSize {
+		return
+	}
+	if binary.BigEndian.Uint32(data) != bloomHashVersion ||
+		binary.BigEndian.Uint32(data[4:]) != bloomNumHashes ||
+		binary.BigEndian.Uint32(data[8:]) != bloomBitsPerEntry {
+		return
+	}
+	l.bloomIndex, l.bloomData = index, data
+}
+
+// bloomChunks returns the BIDX and BDAT chunks for filters, in the order of
+// the commits.
+func bloomChunks(filters []bloomFilter) (index, data []byte, err error) {
+	index = make([]byte, len(filters)*4)
+	data = make([]byte, bloomHeaderSize)
+	binary.BigEndian.PutUint32(data, bloomHashVersion)
+	binary.BigEndian.PutUint32(data[4:], bloomNumHashes)
+	binary.BigEndian.PutUint32(data[8:], bloomBitsPerEntry)
+	for i, f := range filters {
+		data = append(data, f...)
+		if len(data)-bloomHeaderSize > bloomMaxFilterSize {
+			return nil, nil, fmt.Errorf("changed-path filters are too large")
+		}
+		binary.BigEndian.PutUint32(index[i*4:], uint32(len(data)-bloomHeaderSize))
+	}
+	return index, data, nil
+}
+
+// changedPaths returns the paths of the files that differ between the trees
+// a and b, either of which may be "" for an empty tree. It stops once it has
+// found more than max.
+func changedPaths(a, b string, max int) ([]string, error) {
+	paths := []string(nil)
+	err := diffTrees(a, b, "", func(dir string) bool { return len(paths) <= max }, func(p string) bool {
+		paths = append(paths, p)
+		return len(paths) <= max
+	})
+	return paths, err
+}
+
+// diffTrees calls changed with the path of each file that differs between
+// the trees a and b, under the directory prefix, descending only into the
+// directories descend allows. "" stands for an empty tree. It stops when
+// changed returns false.
+func diffTrees(a, b, prefix string, descend func(dir string) bool, changed func(path string) bool) error {
+	_, err := diffTreeLevel(a, b, prefix, descend, changed)
+	return err
+}
+
+func diffTreeLevel(a, b, prefix string, descend func(dir string) bool, changed func(path string) bool) (bool, error) {
+	if a == b {
+		return true, nil
+	}
+	entries := func(hash string) ([]treeEntry, error) {
+		if hash == "" {
+			return nil, nil
+		}
+		return readTree(hash)
+	}
+	ea, err := entries(a)
+	if err != nil {
+		return false, err
+	}
+	eb, err := entries(b)
+	if err != nil {
+		return false, err
+	}
+	inB := make(map[string]treeEntry, len(eb))
+	for _, e := range eb {
+		inB[e.name] = e
+	}
+	// visit reports the differences in one entry, either of which may be
+	// missing.
+	visit := func(x, y *treeEntry) (bool, error) {
+		name := x
+		if name == nil {
+			name = y
+		}
+		p := prefix + name.name
+		subtree := func(e *treeEntry) string {
+			if e == nil || !e.isTree() {
+				return ""
+			}
+			return fmt.Sprintf("%x", e.hash)
+		}
+		isFile := func(e *treeEntry) bool { return e != nil && !e.isTree() }
+		if x != nil && x.isTree() || y != nil && y.isTree() {
+			if descend(p) {
+				if more, err := diffTreeLevel(subtree(x), subtree(y), p+"/", descend, changed); !more || err != nil {
+					return more, err
+				}
+			}
+		}
+		// A file, or a file that became a tree or the other way around.
+		if isFile(x) || isFile(y) {
+			return changed(p), nil
+		}
+		return true, nil
+	}
+	for i := range ea {
+		x := &ea[i]
+		if y, ok := inB[x.name]; ok {
+			delete(inB, x.name)
+			if y.hash == x.hash && y.mode == x.mode {
+				continue
+			}
+			if more, err := visit(x, &y); !more || err != nil {
+				return more, err
+			}
+			continue
+		}
+		if more, err := visit(x, nil); !more || err != nil {
+			return more, err
+		}
+	}
+	for i := range eb {
+		y := &eb[i]
+		if _, ok := inB[y.name]; !ok {
+			continue
+		}
+		if more, err := visit(nil, y); !more || err != nil {
+			return more, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMurmur3(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		want uint32
+	}{
+		{"", 0},
+		{"Hello world!", 0x627b0c2c},
+		{"The quick brown fox jumps over the lazy dog", 0x2e4ff723},
+	} {
+		if got := murmur3(0, []byte(test.data)); got != test.want {
+			t.Errorf("murmur3(0, %q) = %#x, want %#x", test.data, got, test.want)
+		}
+	}
+	want := bloomKey{0x5615800c, 0x5b966560, 0x61174ab4, 0x66983008, 0x6c19155c, 0x7199fab0, 0x771ae004}
+	if got := newBloomKey(""); got != want {
+		t.Errorf("newBloomKey(\"\") = %#x, want %#x", got, want)
+	}
+
+	f := newBloomFilter([]string{"a/b/c", "d"})
+	for _, p := range []string{"a/b/c", "a/b", "a", "d"} {
+		if !f.mayContain(newBloomKey(p)) {
+			t.Errorf("filter lacks %s", p)
+		}
+	}
+	if f := newBloomFilter(make([]string, bloomMaxChanges+1)); !reflect.DeepEqual(f, bloomFilter{0xff}) {
+		t.Errorf("filter for too many changes = %x", f)
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1000000000, 0).UTC()}
+	commit := func(files map[string]string, parents ...string) string {
+		t.Helper()
+		hash, err := CommitTree(makeTree(t, files), parents, who, who, "commit\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		who.When = who.When.Add(time.Minute)
+		return hash
+	}
+	root := commit(map[string]string{"a": "1\n", "d/x": "1\n"})
+	changeA := commit(map[string]string{"a": "2\n", "d/x": "1\n"}, root)
+	changeD := commit(map[string]string{"a": "2\n", "d/x": "2\n", "d/y": "1\n"}, changeA)
+	if err := UpdateRef("refs/heads/master", changeD, "", who, "commit"); err != nil {
+		t.Fatal(err)
+	}
+	info := func(hash string) CommitInfo {
+		c, err := LookupCommitInfo(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	paths, err := changedPaths(info(changeA).Tree, info(changeD).Tree, bloomMaxChanges)
+	sort.Strings(paths)
+	if err != nil || !reflect.DeepEqual(paths, []string{"d/x", "d/y"}) {
+		t.Errorf("changedPaths = %v, %v", paths, err)
+	}
+
+	ps, _ := ParsePathspec([]string{"d"})
+	check := func(graph string, f *PathFilter) {
+		t.Helper()
+		for _, test := range []struct {
+			hash string
+			show bool
+		}{{root, true}, {changeA, false}, {changeD, true}} {
+			show, parents, err := f.Simplify(info(test.hash))
+			if err != nil || show != test.show || len(parents) != len(info(test.hash).Parents) {
+				t.Errorf("%s: Simplify(%s) = %v, %v, %v; want %v", graph, test.hash, show, parents, err, test.show)
+			}
+		}
+	}
+	check("without filters", NewPathFilter(ps))
+	if err := WriteCommitGraph(CommitGraphOptions{ChangedPaths: true}); err != nil {
+		t.Fatal(err)
+	}
+	f := NewPathFilter(ps)
+	check("with filters", f)
+	if f.BloomChecked != 2 || f.BloomSkipped != 1 {
+		t.Errorf("filters checked for %d commits and skipped %d, want 2 and 1", f.BloomChecked, f.BloomSkipped)
+	}
+}
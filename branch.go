@@ -6,9 +6,7 @@
 package ggit
 
 import (
-	"io"
-	"io/ioutil"
-	"os"
+	"fmt"
 	"strings"
 	"sync"
 )
@@ -18,28 +16,15 @@ type Branch struct {
 }
 
 func readBranches() ([]Branch, error) {
-	// TODO: understand .git/packed-refs
-	branches := []Branch(nil)
-	dir := ".git/refs/heads/"
-	dirs, err := ioutil.ReadDir(".git/refs/heads/")
+	refs, err := refStore().listRefs()
 	if err != nil {
 		return nil, err
 	}
-
-	for _, n := range dirs {
-		if n.IsDir() {
-			continue
-		}
-		f, err := os.Open(dir + n.Name())
-		if err != nil {
-			return nil, err
-		}
-		asciiHash := make([]byte, 40)
-		_, err = io.ReadFull(f, asciiHash)
-		if err != nil {
-			return nil, err
+	branches := []Branch(nil)
+	for _, r := range refs {
+		if strings.HasPrefix(r.name, "refs/heads/") && !strings.HasPrefix(r.value, refPrefix) {
+			branches = append(branches, Branch{Name: r.name[len("refs/heads/"):], Hash: r.value})
 		}
-		branches = append(branches, Branch{Name: n.Name(), Hash: string(asciiHash)})
 	}
 	return branches, nil
 }
@@ -55,20 +40,123 @@ func ReadBranches() ([]Branch, error) {
 	return branches, branchesErr
 }
 
+// invalidateBranches forgets the branches read by ReadBranches so that the
+// next call sees ref updates made since.
+func invalidateBranches() {
+	branchesOnce = sync.Once{}
+}
+
 const refPrefix = "ref: "
 
 func CurrentBranch() (string, error) {
-	b, err := ioutil.ReadFile(".git/HEAD")
+	s, err := readRef("HEAD")
 	if err != nil {
 		return "", err
 	}
-	s := string(b)
-	if strings.HasPrefix(s, refPrefix) {
-		ref := s[len(refPrefix):]
-		if strings.HasPrefix(ref, "refs/heads/") {
-			return ref[len("refs/heads/") : len(ref)-1], nil
+	if strings.HasPrefix(s, refPrefix+"refs/heads/") {
+		return s[len(refPrefix+"refs/heads/"):], nil
+	}
+	return s, nil
+}
+
+// Upstream returns the remote-tracking ref that the branch with full name
+// ref is configured to follow, or "" if it has none.
+func Upstream(ref string) string {
+	if !strings.HasPrefix(ref, "refs/heads/") {
+		return ""
+	}
+	name := ref[len("refs/heads/"):]
+	remote, _ := ConfigValue("branch." + name + ".remote")
+	merge, _ := ConfigValue("branch." + name + ".merge")
+	if remote == "" || merge == "" {
+		return ""
+	}
+	if remote == "." {
+		return merge
+	}
+	fetch, _ := ConfigValue("remote." + remote + ".fetch")
+	return mapRefspec(fetch, merge)
+}
+
+// mapRefspec maps ref from the source to the destination side of a fetch
+// refspec such as "+refs/heads/*:refs/remotes/origin/*". It returns "" if
+// the refspec doesn't cover ref.
+func mapRefspec(refspec, ref string) string {
+	colon := strings.IndexByte(refspec, ':')
+	if colon == -1 {
+		return ""
+	}
+	return applyRefspec(strings.TrimPrefix(refspec[:colon], "+"), refspec[colon+1:], ref)
+}
+
+// unmapRefspec maps ref from the destination back to the source side of a
+// fetch refspec.
+func unmapRefspec(refspec, ref string) string {
+	colon := strings.IndexByte(refspec, ':')
+	if colon == -1 {
+		return ""
+	}
+	return applyRefspec(refspec[colon+1:], strings.TrimPrefix(refspec[:colon], "+"), ref)
+}
+
+func applyRefspec(from, to, ref string) string {
+	star := strings.IndexByte(from, '*')
+	if star == -1 {
+		if from == ref {
+			return to
 		}
+		return ""
+	}
+	pre, post := from[:star], from[star+1:]
+	if len(ref) < len(pre)+len(post) || !strings.HasPrefix(ref, pre) || !strings.HasSuffix(ref, post) {
+		return ""
 	}
+	return strings.Replace(to, "*", ref[len(pre):len(ref)-len(post)], 1)
+}
 
-	return s, nil
+// SetUpstream configures the branch name to follow upstream, the full name
+// of a local or remote-tracking branch. An empty upstream removes the
+// configuration.
+func SetUpstream(name, upstream string) error {
+	remote, merge := "", ""
+	switch {
+	case upstream == "":
+		if err := UnsetConfigValue("branch." + name + ".remote"); err != nil {
+			return err
+		}
+		return UnsetConfigValue("branch." + name + ".merge")
+	case strings.HasPrefix(upstream, "refs/heads/"):
+		remote, merge = ".", upstream
+	case strings.HasPrefix(upstream, "refs/remotes/"):
+		rest := upstream[len("refs/remotes/"):]
+		if slash := strings.IndexByte(rest, '/'); slash != -1 {
+			remote = rest[:slash]
+			fetch, _ := ConfigValue("remote." + remote + ".fetch")
+			merge = unmapRefspec(fetch, upstream)
+		}
+	}
+	if merge == "" {
+		return fmt.Errorf("cannot set up tracking information; starting point '%s' is not a branch", ShortRefName(upstream))
+	}
+	if err := SetConfigValue("branch."+name+".remote", remote); err != nil {
+		return err
+	}
+	return SetConfigValue("branch."+name+".merge", merge)
+}
+
+// AheadBehind counts the commits reachable from a but not b, and those
+// reachable from b but not a.
+func AheadBehind(a, b string) (ahead, behind int) {
+	fromA, fromB := reachableFrom(a), reachableFrom(b)
+	for h := range fromA {
+		if !fromB[h] {
+			ahead++
+		}
+	}
+	for h := range fromB {
+		if !fromA[h] {
+			behind++
+		}
+	}
+	return ahead, behind
 }
@@ -0,0 +1,115 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRenameRef(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	tree := makeTree(t, map[string]string{"a": "a\n"})
+	one, _ := CommitTree(tree, nil, who, who, "one\n")
+	two, _ := CommitTree(tree, []string{one}, who, who, "two\n")
+	UpdateRef("refs/heads/master", one, ZeroHash, who, "create")
+	UpdateRef("refs/heads/master", two, one, who, "move")
+	UpdateRef("refs/heads/other", one, ZeroHash, who, "create")
+
+	if err := RenameRef("refs/heads/master", "refs/heads/other", false, who, "rename"); err == nil {
+		t.Error("renamed onto an existing ref without force")
+	}
+	if err := RenameRef("refs/heads/master", "refs/heads/main", false, who, "rename"); err != nil {
+		t.Fatal(err)
+	}
+	if _, hash, _ := ResolveRef("refs/heads/master"); hash != "" {
+		t.Errorf("old ref still points at %s", hash)
+	}
+	if ref, hash, _ := ResolveRef("HEAD"); ref != "refs/heads/main" || hash != two {
+		t.Errorf("HEAD resolves to %s at %s, want refs/heads/main at %s", ref, hash, two)
+	}
+	entries, _ := ReadReflog("refs/heads/main")
+	if len(entries) != 3 || entries[0].Message != "create" || entries[2].Old != two || entries[2].New != two || entries[2].Message != "rename" {
+		t.Errorf("unexpected reflog after rename %+v", entries)
+	}
+	if ReflogExists("refs/heads/master") {
+		t.Errorf("old reflog left behind")
+	}
+
+	if err := RenameRef("refs/heads/main", "refs/heads/other", true, who, "forced"); err != nil {
+		t.Fatal(err)
+	}
+	if _, hash, _ := ResolveRef("refs/heads/other"); hash != two {
+		t.Errorf("forced rename left other at %s, want %s", hash, two)
+	}
+	if entries, _ := ReadReflog("refs/heads/other"); len(entries) != 4 {
+		t.Errorf("forced rename kept %d reflog entries, want 4", len(entries))
+	}
+}
+
+func TestUpstream(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	tree := makeTree(t, map[string]string{"a": "a\n"})
+	one, _ := CommitTree(tree, nil, who, who, "one\n")
+	two, _ := CommitTree(tree, []string{one}, who, who, "two\n")
+	three, _ := CommitTree(tree, []string{one}, who, who, "three\n")
+	UpdateRef("refs/heads/master", two, "", who, "")
+	UpdateRef("refs/remotes/origin/main", three, "", who, "")
+	ioutil.WriteFile(".git/config", []byte("[core]\n\tbare = false ; comment\n"+
+		"[remote \"origin\"]\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n"), 0666)
+
+	if err := SetUpstream("master", "refs/remotes/origin/main"); err != nil {
+		t.Fatal(err)
+	}
+	if got := Upstream("refs/heads/master"); got != "refs/remotes/origin/main" {
+		t.Errorf("upstream is %q after setting it to origin/main", got)
+	}
+	if v, _ := ConfigValue("branch.master.merge"); v != "refs/heads/main" {
+		t.Errorf("branch.master.merge = %q, want refs/heads/main", v)
+	}
+	if ahead, behind := AheadBehind(two, three); ahead != 1 || behind != 1 {
+		t.Errorf("AheadBehind = %d, %d, want 1, 1", ahead, behind)
+	}
+	if ahead, behind := AheadBehind(one, three); ahead != 0 || behind != 1 {
+		t.Errorf("AheadBehind = %d, %d, want 0, 1", ahead, behind)
+	}
+
+	if err := SetUpstream("master", "refs/heads/topic"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := ConfigValue("branch.master.remote"); v != "." {
+		t.Errorf("local upstream set remote %q", v)
+	}
+	if err := SetUpstream("master", "refs/tags/v1"); err == nil {
+		t.Errorf("set a tag as upstream")
+	}
+	if err := RenameConfigSection("branch.master", "branch.main"); err != nil {
+		t.Fatal(err)
+	}
+	if got := Upstream("refs/heads/main"); got != "refs/heads/topic" {
+		t.Errorf("upstream is %q after renaming the section", got)
+	}
+	if err := SetUpstream("main", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfigValue("core.editor", "vi # not a comment"); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(".git/config")
+	want := "[core]\n\tbare = false ; comment\n\teditor = \"vi # not a comment\"\n" +
+		"[remote \"origin\"]\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n[branch \"main\"]\n"
+	if string(b) != want {
+		t.Errorf("config is\n%s\nwant\n%s", b, want)
+	}
+	if v, _ := ConfigValue("core.editor"); v != "vi # not a comment" {
+		t.Errorf("core.editor = %q", v)
+	}
+}
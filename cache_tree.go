@@ -0,0 +1,143 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cacheTree mirrors the index's TREE extension, which remembers the tree
+// object names of directories whose entries haven't changed since the tree
+// was last written.
+type cacheTree struct {
+	name       string
+	entryCount int // index entries covered by this tree, -1 if invalidated
+	hash       [sha1.Size]byte
+	subtrees   []*cacheTree
+}
+
+// parseCacheTree parses one node and its subtrees from data, returning the
+// unconsumed remainder.
+func parseCacheTree(data []byte) (*cacheTree, []byte, error) {
+	nul := bytes.IndexByte(data, 0)
+	if nul == -1 {
+		return nil, nil, fmt.Errorf("cache tree: unterminated path")
+	}
+	ct := &cacheTree{name: string(data[:nul])}
+	data = data[nul+1:]
+	nl := bytes.IndexByte(data, '\n')
+	if nl == -1 {
+		return nil, nil, fmt.Errorf("cache tree: unterminated counts")
+	}
+	counts := strings.Split(string(data[:nl]), " ")
+	data = data[nl+1:]
+	if len(counts) != 2 {
+		return nil, nil, fmt.Errorf("cache tree: bad counts %q", counts)
+	}
+	entryCount, err := strconv.Atoi(counts[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("cache tree: %v", err)
+	}
+	subtreeCount, err := strconv.Atoi(counts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("cache tree: %v", err)
+	}
+	ct.entryCount = entryCount
+	if entryCount >= 0 {
+		if len(data) < sha1.Size {
+			return nil, nil, fmt.Errorf("cache tree: truncated hash")
+		}
+		copy(ct.hash[:], data[:sha1.Size])
+		data = data[sha1.Size:]
+	}
+	for i := 0; i < subtreeCount; i++ {
+		sub := (*cacheTree)(nil)
+		sub, data, err = parseCacheTree(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		ct.subtrees = append(ct.subtrees, sub)
+	}
+	return ct, data, nil
+}
+
+func (ct *cacheTree) marshal(b *bytes.Buffer) {
+	fmt.Fprintf(b, "%s\x00%d %d\n", ct.name, ct.entryCount, len(ct.subtrees))
+	if ct.entryCount >= 0 {
+		b.Write(ct.hash[:])
+	}
+	for _, sub := range ct.subtrees {
+		sub.marshal(b)
+	}
+}
+
+func (ct *cacheTree) subtree(name string) *cacheTree {
+	if ct == nil {
+		return nil
+	}
+	for _, sub := range ct.subtrees {
+		if sub.name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// invalidate marks every tree containing path as needing to be rewritten.
+func (ct *cacheTree) invalidate(path string) {
+	for ct != nil {
+		ct.entryCount = -1
+		slash := strings.IndexByte(path, '/')
+		if slash == -1 {
+			return
+		}
+		ct = ct.subtree(path[:slash])
+		path = path[slash+1:]
+	}
+}
+
+// cacheTree returns the index's cache tree, or nil if it has none or it
+// can't be parsed. It is parsed once and written back by Write.
+func (idx *Index) cacheTree() *cacheTree {
+	if !idx.treeLoaded {
+		idx.treeLoaded = true
+		if e := idx.extension("TREE"); e != nil {
+			idx.tree, _, _ = parseCacheTree(e.Data)
+		}
+	}
+	return idx.tree
+}
+
+func (idx *Index) setCacheTree(ct *cacheTree) {
+	idx.tree = ct
+	idx.treeLoaded = true
+}
+
+// marshalCacheTree stores the cache tree back into the TREE extension.
+func (idx *Index) marshalCacheTree() {
+	if !idx.treeLoaded {
+		return
+	}
+	if idx.tree == nil {
+		idx.setExtension("TREE", nil)
+		return
+	}
+	b := bytes.NewBuffer(nil)
+	idx.tree.marshal(b)
+	idx.setExtension("TREE", b.Bytes())
+}
+
+// invalidatePath drops the cache tree's knowledge of the directories
+// containing path. It must be called whenever an entry is changed.
+func (idx *Index) invalidatePath(path string) {
+	if ct := idx.cacheTree(); ct != nil {
+		ct.invalidate(path)
+	}
+}
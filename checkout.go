@@ -0,0 +1,565 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Worktree is the filesystem checkouts read and write. Paths are relative to
+// the top of the worktree and use '/' as the separator.
+type Worktree interface {
+	Lstat(path string) (os.FileInfo, error)
+	ReadFile(path string) ([]byte, error)
+	Readlink(path string) (string, error)
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	Symlink(target, path string) error
+	MkdirAll(path string, perm os.FileMode) error
+	Remove(path string) error
+}
+
+// StreamingWorktree is a Worktree that can read and write files as streams,
+// so that checking out or hashing a large file needn't hold it all in
+// memory.
+type StreamingWorktree interface {
+	Worktree
+	Open(path string) (io.ReadCloser, error)
+	WriteFileFrom(path string, r io.Reader, perm os.FileMode) error
+}
+
+// DirWorktree is a Worktree rooted at a directory on disk.
+type DirWorktree string
+
+func (d DirWorktree) path(p string) string {
+	return filepath.Join(string(d), filepath.FromSlash(p))
+}
+
+func (d DirWorktree) Lstat(p string) (os.FileInfo, error) { return os.Lstat(d.path(p)) }
+func (d DirWorktree) ReadFile(p string) ([]byte, error)   { return ioutil.ReadFile(d.path(p)) }
+func (d DirWorktree) Readlink(p string) (string, error)   { return os.Readlink(d.path(p)) }
+func (d DirWorktree) Symlink(target, p string) error      { return os.Symlink(target, d.path(p)) }
+func (d DirWorktree) MkdirAll(p string, perm os.FileMode) error {
+	return os.MkdirAll(d.path(p), perm)
+}
+func (d DirWorktree) Remove(p string) error { return os.Remove(d.path(p)) }
+func (d DirWorktree) Open(p string) (io.ReadCloser, error) {
+	return os.Open(d.path(p))
+}
+func (d DirWorktree) WriteFile(p string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(d.path(p), data, perm)
+}
+func (d DirWorktree) WriteFileFrom(p string, r io.Reader, perm os.FileMode) error {
+	f, err := os.OpenFile(d.path(p), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// bigFileThreshold is core.bigFileThreshold, -1 until hashWorktreeFile first
+// needs it.
+var bigFileThreshold int64 = -1
+
+// hashWorktreeFile is HashFile for an arbitrary Worktree. Files over
+// core.bigFileThreshold are streamed if wt can read them that way.
+func hashWorktreeFile(wt Worktree, p string, fi os.FileInfo, write bool) (string, error) {
+	data := []byte(nil)
+	err := error(nil)
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		target := ""
+		target, err = wt.Readlink(p)
+		data = []byte(target)
+	case fi.Mode().IsRegular():
+		if sw, ok := wt.(StreamingWorktree); ok {
+			if bigFileThreshold == -1 {
+				threshold, err := ConfigInt("core.bigFileThreshold", defaultBigFileThreshold)
+				if err != nil {
+					return "", err
+				}
+				bigFileThreshold = threshold
+			}
+			if fi.Size() > bigFileThreshold {
+				return hashStreamed(sw, p, fi.Size(), write)
+			}
+		}
+		data, err = wt.ReadFile(p)
+	default:
+		return "", fmt.Errorf("%s: unsupported file type", p)
+	}
+	if err != nil {
+		return "", err
+	}
+	if write {
+		return WriteObject("blob", data)
+	}
+	return fmt.Sprintf("%x", hashObject("blob", data)), nil
+}
+
+// hashStreamed is hashWorktreeFile for a file too big to read into memory.
+func hashStreamed(sw StreamingWorktree, p string, size int64, write bool) (string, error) {
+	r, err := sw.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	hash := ""
+	if write {
+		hash, err = writeObjectFrom("blob", r, size)
+	} else {
+		hash, err = hashObjectFrom("blob", r, size)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", p, err)
+	}
+	return hash, nil
+}
+
+// worktreeMatches reports whether the file at e's path still has the contents
+// and mode e records. A missing file counts as matching, as in git, since
+// nothing would be lost by overwriting it.
+func worktreeMatches(wt Worktree, e Entry) (bool, error) {
+	fi, err := wt.Lstat(string(e.Path))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if e.StatMatches(fi) {
+		return true, nil
+	}
+	if fi.IsDir() || modeFromFileInfo(fi) != e.Mode {
+		return false, nil
+	}
+	hash, err := hashWorktreeFile(wt, string(e.Path), fi, false)
+	if err != nil {
+		return false, err
+	}
+	return hash == fmt.Sprintf("%x", e.Hash), nil
+}
+
+type treeFile struct {
+	mode uint32
+	hash [20]byte
+}
+
+func (f treeFile) matches(e Entry) bool {
+	return f.mode == e.Mode && f.hash == e.Hash && e.ExtendedFlags&entryIntentToAdd == 0
+}
+
+// flattenTree lists every non-tree entry below the tree named by hash by its
+// full path. An empty hash stands for the empty tree.
+func flattenTree(hash string) (map[string]treeFile, error) {
+	files := make(map[string]treeFile)
+	if hash == "" {
+		return files, nil
+	}
+	var walk func(hash, prefix string) error
+	walk = func(hash, prefix string) error {
+		entries, err := readTree(hash)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.isTree() {
+				if err := walk(fmt.Sprintf("%x", e.hash), prefix+e.name+"/"); err != nil {
+					return err
+				}
+				continue
+			}
+			mode := uint32(0)
+			fmt.Sscanf(e.mode, "%o", &mode)
+			files[prefix+e.name] = treeFile{mode: mode, hash: e.hash}
+		}
+		return nil
+	}
+	return files, walk(hash, "")
+}
+
+// CheckoutError is returned when a checkout would lose local changes.
+type CheckoutError struct {
+	Reason string
+	Paths  []string
+}
+
+func (e *CheckoutError) Error() string {
+	return fmt.Sprintf("%s\n\t%s", e.Reason, strings.Join(e.Paths, "\n\t"))
+}
+
+// removeWorktreeFile deletes p and any directories left empty by doing so.
+func removeWorktreeFile(wt Worktree, p string) error {
+	if err := wt.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if wt.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// checkoutFile writes the blob f to p, replacing whatever is there, and
+// returns an index entry describing the new file.
+func checkoutFile(wt Worktree, p string, f treeFile) (Entry, error) {
+	if dir := path.Dir(p); dir != "." {
+		if err := wt.MkdirAll(dir, 0777); err != nil {
+			return Entry{}, err
+		}
+	}
+	if fi, err := wt.Lstat(p); err == nil {
+		if err := wt.Remove(p); err != nil {
+			if fi.IsDir() {
+				return Entry{}, &CheckoutError{
+					Reason: "Updating the following directories would lose untracked files in them:",
+					Paths:  []string{p}}
+			}
+			return Entry{}, err
+		}
+	}
+	switch f.mode {
+	case 0160000:
+		// Submodules are left for the user to populate.
+		if err := wt.MkdirAll(p, 0777); err != nil {
+			return Entry{}, err
+		}
+	case 0120000:
+		_, data, err := readObject(fmt.Sprintf("%x", f.hash))
+		if err != nil {
+			return Entry{}, err
+		}
+		if err := wt.Symlink(string(data), p); err != nil {
+			return Entry{}, err
+		}
+	default:
+		perm := os.FileMode(0666)
+		if f.mode == 0100755 {
+			perm = 0777
+		}
+		if err := writeWorktreeBlob(wt, p, fmt.Sprintf("%x", f.hash), perm); err != nil {
+			return Entry{}, err
+		}
+	}
+	fi, err := wt.Lstat(p)
+	if err != nil {
+		return Entry{}, err
+	}
+	e := NewEntry(p, fi, f.hash)
+	e.Mode = f.mode
+	return e, nil
+}
+
+// writeWorktreeBlob writes the blob hash to p, streaming it if the worktree
+// can take it that way.
+func writeWorktreeBlob(wt Worktree, p, hash string, perm os.FileMode) error {
+	sw, ok := wt.(StreamingWorktree)
+	if !ok {
+		_, data, err := readObject(hash)
+		if err != nil {
+			return err
+		}
+		return wt.WriteFile(p, data, perm)
+	}
+	o, err := LookupObject(hash)
+	if err != nil {
+		return err
+	}
+	defer o.Close()
+	return sw.WriteFileFrom(p, o.Reader, perm)
+}
+
+type checkoutAction struct {
+	path   string
+	remove bool
+	file   treeFile
+}
+
+// applyCheckout performs actions, removals first so that files can replace
+// directories and the reverse.
+func applyCheckout(wt Worktree, idx *Index, actions []checkoutAction) error {
+	for _, a := range actions {
+		if a.remove {
+			idx.Remove(a.path)
+			if err := removeWorktreeFile(wt, a.path); err != nil {
+				return err
+			}
+		}
+	}
+	for _, a := range actions {
+		if !a.remove {
+			e, err := checkoutFile(wt, a.path, a.file)
+			if err != nil {
+				return err
+			}
+			idx.Add(e)
+		}
+	}
+	return nil
+}
+
+func sortedPaths(sets ...map[string]treeFile) []string {
+	seen := make(map[string]bool)
+	for _, set := range sets {
+		for p := range set {
+			seen[p] = true
+		}
+	}
+	paths := make([]string, 0, len(seen))
+	for p := range seen {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func indexFiles(idx *Index) map[string]treeFile {
+	files := make(map[string]treeFile)
+	for _, e := range idx.Entries {
+		files[string(e.Path)] = treeFile{mode: e.Mode, hash: e.Hash}
+	}
+	return files
+}
+
+// blockingFiles returns the untracked files and symlinks in the worktree
+// that sit where the files actions write need a directory.
+func blockingFiles(wt Worktree, idx *Index, actions []checkoutAction) []string {
+	checked := make(map[string]bool)
+	blocking := []string(nil)
+	for _, a := range actions {
+		if a.remove {
+			continue
+		}
+		for dir := path.Dir(a.path); dir != "." && !checked[dir]; dir = path.Dir(dir) {
+			checked[dir] = true
+			if _, tracked := idx.Entry(dir); tracked {
+				continue
+			}
+			// Below a file Lstat fails, but the file itself is found
+			// further up.
+			if fi, err := wt.Lstat(dir); err == nil && !fi.IsDir() {
+				blocking = append(blocking, dir)
+			}
+		}
+	}
+	sort.Strings(blocking)
+	return blocking
+}
+
+// CheckoutTree moves the index and worktree from oldTree, the tree of the
+// commit currently checked out, to newTree. Paths that are the same in both
+// trees are left alone so that local changes to them are carried over. If a
+// local change would be lost CheckoutTree fails without changing anything,
+// unless force is set, in which case the index and every tracked file are
+// made to match newTree.
+func CheckoutTree(wt Worktree, idx *Index, oldTree, newTree string, force bool) error {
+	if !force {
+		for _, e := range idx.Entries {
+			if e.Stage() != 0 {
+				return fmt.Errorf("you need to resolve your current index first")
+			}
+		}
+	}
+	oldFiles, err := flattenTree(oldTree)
+	if err != nil {
+		return err
+	}
+	newFiles, err := flattenTree(newTree)
+	if err != nil {
+		return err
+	}
+	actions := []checkoutAction(nil)
+	changed, untracked := []string(nil), []string(nil)
+	for _, p := range sortedPaths(oldFiles, newFiles, indexFiles(idx)) {
+		o, inOld := oldFiles[p]
+		n, inNew := newFiles[p]
+		e, inIndex := idx.Entry(p)
+		if force {
+			switch {
+			case !inNew:
+				actions = append(actions, checkoutAction{path: p, remove: true})
+			case inIndex && n.matches(e):
+				if clean, err := worktreeMatches(wt, e); err != nil || !clean {
+					actions = append(actions, checkoutAction{path: p, file: n})
+				} else if _, err := wt.Lstat(p); os.IsNotExist(err) {
+					actions = append(actions, checkoutAction{path: p, file: n})
+				}
+			default:
+				actions = append(actions, checkoutAction{path: p, file: n})
+			}
+			continue
+		}
+		if inOld == inNew && o == n {
+			continue
+		}
+		if (inIndex && inNew && n.matches(e)) || (!inIndex && !inNew) {
+			// The index already has what newTree wants.
+			continue
+		}
+		if inIndex != inOld || (inIndex && !o.matches(e)) {
+			changed = append(changed, p)
+			continue
+		}
+		if inIndex {
+			clean, err := worktreeMatches(wt, e)
+			if err != nil {
+				return err
+			}
+			if !clean {
+				changed = append(changed, p)
+				continue
+			}
+		} else if fi, err := wt.Lstat(p); err == nil && !fi.IsDir() {
+			hash, err := hashWorktreeFile(wt, p, fi, false)
+			if err != nil || hash != fmt.Sprintf("%x", n.hash) {
+				untracked = append(untracked, p)
+				continue
+			}
+		}
+		actions = append(actions, checkoutAction{path: p, remove: !inNew, file: n})
+	}
+	blocking := blockingFiles(wt, idx, actions)
+	if force {
+		for _, p := range blocking {
+			actions = append(actions, checkoutAction{path: p, remove: true})
+		}
+	} else if len(blocking) > 0 {
+		untracked = append(untracked, blocking...)
+		sort.Strings(untracked)
+	}
+	if len(changed) > 0 {
+		return &CheckoutError{
+			Reason: "Your local changes to the following files would be overwritten by checkout:",
+			Paths:  changed}
+	}
+	if len(untracked) > 0 {
+		return &CheckoutError{
+			Reason: "The following untracked working tree files would be overwritten by checkout:",
+			Paths:  untracked}
+	}
+	return applyCheckout(wt, idx, actions)
+}
+
+// CheckoutPaths copies the files in tree selected by ps into the index and
+// the worktree, overwriting any local changes to them. Files not in tree are
+// left alone.
+func CheckoutPaths(wt Worktree, idx *Index, tree string, ps Pathspec) error {
+	files, err := flattenTree(tree)
+	if err != nil {
+		return err
+	}
+	return checkoutMatching(wt, idx, files, ps)
+}
+
+// CheckoutIndexPaths copies the index entries selected by ps into the
+// worktree, discarding local changes to them.
+func CheckoutIndexPaths(wt Worktree, idx *Index, ps Pathspec) error {
+	for _, e := range idx.Entries {
+		if p := string(e.Path); e.Stage() != 0 && ps.Match(p) {
+			return fmt.Errorf("path '%s' is unmerged", p)
+		}
+	}
+	return checkoutMatching(wt, idx, indexFiles(idx), ps)
+}
+
+func checkoutMatching(wt Worktree, idx *Index, files map[string]treeFile, ps Pathspec) error {
+	paths := sortedPaths(files)
+	if unmatched := ps.Unmatched(paths); len(unmatched) > 0 {
+		return fmt.Errorf("pathspec '%s' did not match any file(s) known to git", unmatched[0])
+	}
+	actions := []checkoutAction(nil)
+	for _, p := range paths {
+		if !ps.Match(p) {
+			continue
+		}
+		f := files[p]
+		if e, ok := idx.Entry(p); ok && f.matches(e) {
+			if clean, err := worktreeMatches(wt, e); err == nil && clean {
+				if _, err := wt.Lstat(p); err == nil {
+					continue
+				}
+			}
+		}
+		actions = append(actions, checkoutAction{path: p, file: f})
+	}
+	return applyCheckout(wt, idx, actions)
+}
+
+// RestorePaths makes the paths selected by ps match source in the index if
+// staged is set and in the worktree if worktree is set. source is a tree, or
+// "" to restore the worktree from the index. Unlike CheckoutPaths, tracked
+// files missing from source are deleted.
+func RestorePaths(wt Worktree, idx *Index, source string, ps Pathspec, staged, worktree bool) error {
+	files := indexFiles(idx)
+	if source != "" {
+		var err error
+		if files, err = flattenTree(source); err != nil {
+			return err
+		}
+	} else if staged {
+		return fmt.Errorf("cannot restore the index from itself")
+	}
+	paths := sortedPaths(files, indexFiles(idx))
+	if source == "" {
+		for _, e := range idx.Entries {
+			if p := string(e.Path); e.Stage() != 0 && ps.Match(p) {
+				return fmt.Errorf("path '%s' is unmerged", p)
+			}
+		}
+	}
+	matched := []string(nil)
+	for _, p := range paths {
+		if ps.Match(p) {
+			matched = append(matched, p)
+		}
+	}
+	if unmatched := ps.Unmatched(matched); len(unmatched) > 0 {
+		return fmt.Errorf("pathspec '%s' did not match any file(s) known to git", unmatched[0])
+	}
+	if worktree {
+		for _, p := range matched {
+			f, ok := files[p]
+			e, inIndex := idx.Entry(p)
+			switch {
+			case !ok:
+				if err := removeWorktreeFile(wt, p); err != nil {
+					return err
+				}
+			case inIndex && f.matches(e):
+				if clean, err := worktreeMatches(wt, e); err == nil && clean {
+					if _, err := wt.Lstat(p); err == nil {
+						continue
+					}
+				}
+				fallthrough
+			default:
+				ne, err := checkoutFile(wt, p, f)
+				if err != nil {
+					return err
+				}
+				if !staged && inIndex && f.matches(e) {
+					// Refresh the stat information of the entry just restored.
+					idx.Add(ne)
+				}
+			}
+		}
+	}
+	if staged {
+		return ResetIndex(idx, source, ps)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// makeTree writes files to the worktree, indexes them and returns the tree.
+// The worktree and index are left empty.
+func makeTree(t *testing.T, files map[string]string) string {
+	idx := &Index{Version: 2}
+	for p, contents := range files {
+		wt := DirWorktree(".")
+		if err := wt.MkdirAll(path.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := wt.WriteFile(p, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := idx.AddFile(p, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := WriteTree(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := range files {
+		removeWorktreeFile(DirWorktree("."), p)
+	}
+	return tree
+}
+
+func checkFile(t *testing.T, p, want string) {
+	b, err := ioutil.ReadFile(p)
+	if want == "" {
+		if !os.IsNotExist(err) {
+			t.Errorf("%s should not exist, err %v", p, err)
+		}
+		return
+	}
+	if err != nil || string(b) != want {
+		t.Errorf("%s is %q, %v, want %q", p, b, err, want)
+	}
+}
+
+func TestCheckoutTree(t *testing.T) {
+	defer withTempRepo(t)()
+
+	one := makeTree(t, map[string]string{"a": "one\n", "d/e/b": "b\n", "same": "same\n"})
+	two := makeTree(t, map[string]string{"a": "two\n", "n": "n\n", "same": "same\n"})
+	wt := DirWorktree(".")
+	idx := &Index{Version: 2}
+	if err := CheckoutTree(wt, idx, "", one, false); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, "a", "one\n")
+	checkFile(t, "d/e/b", "b\n")
+
+	ioutil.WriteFile("same", []byte("local\n"), 0666)
+	if err := CheckoutTree(wt, idx, one, two, false); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, "a", "two\n")
+	checkFile(t, "n", "n\n")
+	checkFile(t, "d/e/b", "")
+	checkFile(t, "same", "local\n")
+	if _, err := os.Stat("d"); !os.IsNotExist(err) {
+		t.Errorf("empty directory d was left behind")
+	}
+	if got, want := indexPaths(idx), []string{"a", "n", "same"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index has %v, want %v", got, want)
+	}
+
+	ioutil.WriteFile("a", []byte("local change\n"), 0666)
+	err := CheckoutTree(wt, idx, two, one, false)
+	if e, ok := err.(*CheckoutError); !ok || !reflect.DeepEqual(e.Paths, []string{"a"}) {
+		t.Errorf("expected conflict on a, got %v", err)
+	}
+	checkFile(t, "n", "n\n")
+
+	if err := CheckoutTree(wt, idx, two, one, true); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, "a", "one\n")
+	checkFile(t, "n", "")
+	checkFile(t, "same", "same\n")
+
+	ioutil.WriteFile("n", []byte("untracked\n"), 0666)
+	err = CheckoutTree(wt, idx, one, two, false)
+	if e, ok := err.(*CheckoutError); !ok || !reflect.DeepEqual(e.Paths, []string{"n"}) {
+		t.Errorf("expected untracked conflict on n, got %v", err)
+	}
+}
+
+func TestCheckoutTreeUntrackedFileInTheWay(t *testing.T) {
+	defer withTempRepo(t)()
+
+	one := makeTree(t, map[string]string{"a": "one\n"})
+	two := makeTree(t, map[string]string{"a": "two\n", "d/e/new": "new\n"})
+	wt := DirWorktree(".")
+	idx := &Index{Version: 2}
+	if err := CheckoutTree(wt, idx, "", one, false); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile("d", []byte("untracked\n"), 0666)
+	err := CheckoutTree(wt, idx, one, two, false)
+	if e, ok := err.(*CheckoutError); !ok || !reflect.DeepEqual(e.Paths, []string{"d"}) {
+		t.Fatalf("expected untracked conflict on d, got %v", err)
+	}
+	checkFile(t, "a", "one\n")
+	checkFile(t, "d", "untracked\n")
+	if got, want := indexPaths(idx), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index has %v, want %v", got, want)
+	}
+
+	if err := CheckoutTree(wt, idx, one, two, true); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, "a", "two\n")
+	checkFile(t, "d/e/new", "new\n")
+}
+
+func TestCheckoutPaths(t *testing.T) {
+	defer withTempRepo(t)()
+
+	tree := makeTree(t, map[string]string{"a": "a\n", "b": "b\n"})
+	wt := DirWorktree(".")
+	idx := &Index{Version: 2}
+	if err := CheckoutTree(wt, idx, "", tree, false); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile("a", []byte("changed\n"), 0666)
+	ioutil.WriteFile("b", []byte("changed\n"), 0666)
+	ps, _ := ParsePathspec([]string{"a"})
+	if err := CheckoutIndexPaths(wt, idx, ps); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, "a", "a\n")
+	checkFile(t, "b", "changed\n")
+
+	ps, _ = ParsePathspec([]string{"missing"})
+	if err := CheckoutPaths(wt, idx, tree, ps); err == nil {
+		t.Errorf("expected an error for an unmatched pathspec")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jamesr/ggit"
+)
+
+func add(args []string) {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	all := fs.Bool("A", false, "add, modify and remove index entries to match the whole worktree")
+	fs.BoolVar(all, "all", false, "same as -A")
+	update := fs.Bool("u", false, "update tracked files only, never adding new ones")
+	fs.BoolVar(update, "update", false, "same as -u")
+	intentToAdd := fs.Bool("N", false, "record only that new paths will be added later")
+	fs.BoolVar(intentToAdd, "intent-to-add", false, "same as -N")
+	force := fs.Bool("f", false, "allow adding otherwise ignored files")
+	fs.BoolVar(force, "force", false, "same as -f")
+	dryRun := fs.Bool("n", false, "don't actually add the files, just show what would happen")
+	fs.BoolVar(dryRun, "dry-run", false, "same as -n")
+	verbose := fs.Bool("v", false, "be verbose")
+	fs.Parse(args)
+	if fs.NArg() == 0 && !*all && !*update {
+		fmt.Println("Nothing specified, nothing added.")
+		return
+	}
+	ps, err := ggit.ParsePathspec(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	idx, err := ggit.ReadIndex(".git/index")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error reading index:", err)
+		os.Exit(1)
+	}
+	report := func(action, p string) {
+		if *verbose || *dryRun {
+			fmt.Printf("%s '%s'\n", action, p)
+		}
+	}
+
+	matched := []string(nil)
+	tracked := make(map[string]bool)
+	for _, e := range append([]ggit.Entry(nil), idx.Entries...) {
+		p := string(e.Path)
+		if tracked[p] || !ps.Match(p) {
+			continue
+		}
+		tracked[p] = true
+		matched = append(matched, p)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			report("remove", p)
+			idx.Remove(p)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		if e.Stage() == 0 && e.StatMatches(fi) {
+			continue
+		}
+		report("add", p)
+		if !*dryRun {
+			if err := idx.AddFile(p, false); err != nil {
+				fmt.Fprintln(os.Stderr, "error: unable to index file", p+":", err)
+				os.Exit(128)
+			}
+		}
+	}
+
+	if !*update {
+		ig := ggit.NewIgnorer()
+		walkIgnorer := ig
+		if *force {
+			walkIgnorer = nil
+		}
+		err = ggit.WalkWorktree(walkIgnorer, func(p string, fi os.FileInfo) error {
+			if tracked[p] || !ps.Match(p) {
+				return nil
+			}
+			matched = append(matched, p)
+			report("add", p)
+			if *dryRun {
+				return nil
+			}
+			return idx.AddFile(p, *intentToAdd)
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(128)
+		}
+		ignored := []string(nil)
+		for _, p := range ps.Unmatched(matched) {
+			if _, err := os.Lstat(p); err == nil && ig.Ignored(p, false) {
+				ignored = append(ignored, p)
+			}
+		}
+		if len(ignored) > 0 {
+			fmt.Fprintln(os.Stderr, "The following paths are ignored by one of your .gitignore files:")
+			for _, p := range ignored {
+				fmt.Fprintln(os.Stderr, p)
+			}
+			fmt.Fprintln(os.Stderr, "Use -f if you really want to add them.")
+			os.Exit(1)
+		}
+	}
+	for _, p := range ps.Unmatched(matched) {
+		fmt.Fprintf(os.Stderr, "fatal: pathspec '%s' did not match any files\n", p)
+		os.Exit(128)
+	}
+	if *dryRun {
+		return
+	}
+	if err := idx.Write(".git/index"); err != nil {
+		fmt.Fprintln(os.Stderr, "error writing index:", err)
+		os.Exit(1)
+	}
+}
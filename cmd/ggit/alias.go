@@ -0,0 +1,152 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/jamesr/ggit"
+)
+
+// lookupAlias returns the expansion of alias.<name>.
+func lookupAlias(name string) (string, bool) {
+	c, err := ggit.ReadConfig()
+	if err != nil {
+		return "", false
+	}
+	e, ok := c.Get("alias." + name)
+	return e.Value, ok
+}
+
+// aliases returns every alias defined in the configuration, with its
+// expansion.
+func aliases() map[string]string {
+	all := make(map[string]string)
+	c, err := ggit.ReadConfig()
+	if err != nil {
+		return all
+	}
+	for _, e := range c.Entries {
+		if name := strings.TrimPrefix(e.Key, "alias."); name != e.Key && !strings.Contains(name, ".") {
+			all[name] = e.Value
+		}
+	}
+	return all
+}
+
+// checkAliasLoop dies if next, the command an alias expanded to, is itself
+// an alias already expanded, listing the loop as git does.
+func checkAliasLoop(expanded []string, next string) {
+	seen := -1
+	for i, name := range expanded {
+		if name == next {
+			seen = i
+		}
+	}
+	if seen == -1 {
+		return
+	}
+	loop := ""
+	for i, name := range expanded {
+		loop += "\n  " + name
+		switch {
+		case i == seen:
+			loop += " <=="
+		case i == len(expanded)-1:
+			loop += " ==>"
+		}
+	}
+	fatal("alias loop detected: expansion of '%s' does not terminate:%s", next, loop)
+}
+
+// splitCommandLine splits an alias into words as git does: at whitespace
+// outside quotes, with single quotes taking everything literally and
+// backslashes escaping the next character elsewhere.
+func splitCommandLine(s string) ([]string, error) {
+	words := []string(nil)
+	word, inWord, quote := []byte(nil), false, byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == 0 && (c == ' ' || c == '\t' || c == '\n'):
+			if inWord {
+				words = append(words, string(word))
+				word, inWord = nil, false
+			}
+			continue
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case c == quote:
+			quote = 0
+		case c == '\\' && quote != '\'':
+			if i++; i == len(s) {
+				return nil, errors.New("cmdline ends with \\")
+			}
+			word = append(word, s[i])
+		default:
+			word = append(word, c)
+		}
+		inWord = true
+	}
+	if quote != 0 {
+		return nil, errors.New("unclosed quote")
+	}
+	if inWord {
+		words = append(words, string(word))
+	}
+	return words, nil
+}
+
+// exitWith exits with the status of a command whose run returned err.
+func exitWith(err error) {
+	if err == nil {
+		os.Exit(0)
+	}
+	if exit, ok := err.(*exec.ExitError); ok {
+		if ws, ok := exit.Sys().(syscall.WaitStatus); ok && ws.Exited() {
+			os.Exit(ws.ExitStatus())
+		}
+	}
+	os.Exit(1)
+}
+
+// runExternal runs ggit-<name> from the PATH with args and exits with its
+// status. It returns if there is no such program.
+func runExternal(name string, args []string) {
+	path, err := exec.LookPath("ggit-" + name)
+	if err != nil {
+		return
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	exitWith(cmd.Run())
+}
+
+// runShellAlias runs the command of a "!" alias with args and exits with its
+// status. A command using shell syntax is run by the shell, which gets the
+// arguments as "$@".
+func runShellAlias(name, command string, args []string) {
+	argv := []string{command}
+	if strings.ContainsAny(command, "|&;<>()$`\\\"' \t\n*?[#~=%") {
+		script := command
+		if len(args) > 0 {
+			script += ` "$@"`
+		}
+		argv = []string{"sh", "-c", script, command}
+	}
+	cmd := exec.Command(argv[0], append(argv[1:], args...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), "GIT_PREFIX=")
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		fatal("while expanding alias '%s': '%s': %v", name, command, err)
+	}
+	exitWith(err)
+}
@@ -9,51 +9,509 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path"
 	"strings"
-	"text/tabwriter"
 
 	"github.com/jamesr/ggit"
 )
 
-func branch(args []string) {
-	fs := flag.NewFlagSet("branch", flag.ExitOnError)
-	var verbose bool
-	fs.BoolVar(&verbose, "v", false, "")
-	fs.Parse(args)
+const (
+	colorReset = "\x1b[m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorBlue  = "\x1b[34m"
+)
 
-	// assume --list for now
-	branches, err := ggit.ReadBranches()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "failed to fetch branches", err)
-		os.Exit(1)
+// colorFlag is a --color[=<when>] option.
+type colorFlag string
+
+func (c *colorFlag) String() string { return string(*c) }
+
+func (c *colorFlag) Set(s string) error {
+	switch s {
+	case "true":
+		s = "always"
+	case "always", "never", "auto":
+	default:
+		return fmt.Errorf("invalid color value: %s", s)
 	}
-	current, err := ggit.CurrentBranch()
+	*c = colorFlag(s)
+	return nil
+}
+
+func (c *colorFlag) IsBoolFlag() bool { return true }
+
+// useColor decides whether to color output given the --color option when,
+// falling back to the config keys in order and then to "auto", which colors
+// output to a terminal.
+func useColor(when string, keys ...string) bool {
+	for _, key := range keys {
+		if when != "" {
+			break
+		}
+		when, _ = ggit.ConfigValue(key)
+	}
+	switch when {
+	case "always", "true":
+		return true
+	case "never", "false":
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// fatal prints a git-style fatal error and exits.
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "fatal: "+format+"\n", args...)
+	os.Exit(128)
+}
+
+// branchItem is a line of the branch listing.
+type branchItem struct {
+	label   string
+	ref     ggit.Ref
+	current bool
+	remote  bool
+}
+
+// detachedLabel describes a detached HEAD at hash as git does, naming what
+// was checked out if the reflog says.
+func detachedLabel(hash string) string {
+	entries, _ := ggit.ReadReflog("HEAD")
+	for i := len(entries) - 1; i >= 0; i-- {
+		msg := entries[i].Message
+		if !strings.HasPrefix(msg, "checkout: moving from ") {
+			continue
+		}
+		to := msg[strings.LastIndex(msg, " to ")+len(" to "):]
+		target, err := ggit.ResolveRevision(to)
+		if len(to) == 40 {
+			to = to[:7]
+		}
+		if err == nil && target == hash {
+			return "(HEAD detached at " + to + ")"
+		}
+		return "(HEAD detached from " + to + ")"
+	}
+	return "(no branch)"
+}
+
+type branchListOptions struct {
+	all, remotes   bool
+	verbose        int
+	color          bool
+	patterns       []string
+	filter         ggit.RefFilter
+	ignoreDetached bool
+}
+
+func listBranches(o branchListOptions) {
+	items := []branchItem(nil)
+	headRef, headHash, _ := ggit.ResolveRef("HEAD")
+	if !o.remotes && headRef == "HEAD" && headHash != "" {
+		items = append(items, branchItem{label: detachedLabel(headHash), ref: ggit.Ref{Name: "HEAD", Hash: headHash}, current: true})
+	}
+	prefixes := []string{"refs/heads/"}
+	if o.remotes {
+		prefixes = []string{"refs/remotes/"}
+	} else if o.all {
+		prefixes = append(prefixes, "refs/remotes/")
+	}
+	for _, prefix := range prefixes {
+		refs, err := ggit.ListRefs(prefix)
+		if err != nil {
+			fatal("%v", err)
+		}
+		for _, r := range refs {
+			short := r.Name[len(prefix):]
+			if len(o.patterns) > 0 && !matchesAny(o.patterns, short) {
+				continue
+			}
+			item := branchItem{label: short, ref: r, current: r.Name == headRef, remote: prefix == "refs/remotes/"}
+			if item.remote && o.all {
+				item.label = "remotes/" + short
+			}
+			items = append(items, item)
+		}
+	}
+
+	refs := []ggit.Ref(nil)
+	for _, item := range items {
+		refs = append(refs, item.ref)
+	}
+	kept, err := o.filter.Filter(refs)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "failed to fetch current branch", err)
-		os.Exit(1)
+		fatal("%v", err)
 	}
-	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
-	defer tw.Flush()
-	for _, b := range branches {
-		prefix := " "
-		if b.Name == current {
-			prefix = "*" // TODO: color code?
+	keep := make(map[string]bool)
+	for _, r := range kept {
+		keep[r.Name] = true
+	}
+
+	width := 0
+	for _, item := range items {
+		if keep[item.ref.Name] && len(item.label) > width {
+			width = len(item.label)
 		}
-		if verbose {
-			c, err := ggit.ReadCommit(b.Hash)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "error reading commit", b.Hash, err)
-				os.Exit(1)
+	}
+	f := ggit.NewRefFormatter()
+	for _, item := range items {
+		if !keep[item.ref.Name] {
+			continue
+		}
+		line := "  "
+		if item.current {
+			line = "* "
+		}
+		label := item.label
+		if o.verbose > 0 {
+			label = fmt.Sprintf("%-*s", width, label)
+		}
+		if o.color {
+			color := ""
+			switch {
+			case item.current:
+				color = colorGreen
+			case item.remote:
+				color = colorRed
 			}
-			defer c.Close()
-			m := c.Message()
-			n := strings.IndexByte(m, '\n')
-			if n != -1 {
-				m = m[:n]
+			label = color + label + colorReset
+		}
+		line += label
+		if item.ref.Symref != "" {
+			line += " -> " + ggit.ShortRefName(item.ref.Symref)
+			fmt.Println(line)
+			continue
+		}
+		if o.verbose > 0 {
+			hash, _ := f.Atom(item.ref, "objectname:short")
+			subject, err := f.Atom(item.ref, "subject")
+			if err != nil {
+				fatal("%v", err)
 			}
-			fmt.Fprintln(tw, strings.Join([]string{prefix, b.Name, b.Hash[:7], m}, "\t"))
+			line += " " + hash + " " + trackingInfo(item.ref, o.verbose > 1, o.color) + subject
+		}
+		fmt.Println(line)
+	}
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// trackingInfo describes how r relates to its upstream, for branch -v: how
+// far ahead and behind it is and, if withName is set, the upstream's name.
+func trackingInfo(r ggit.Ref, withName, color bool) string {
+	upstream := ggit.Upstream(r.Name)
+	if upstream == "" {
+		return ""
+	}
+	info := []string(nil)
+	if _, hash, _ := ggit.ResolveRef(upstream); hash == "" {
+		info = append(info, "gone")
+	} else {
+		ahead, behind := ggit.AheadBehind(r.Hash, hash)
+		if ahead > 0 {
+			info = append(info, fmt.Sprintf("ahead %d", ahead))
+		}
+		if behind > 0 {
+			info = append(info, fmt.Sprintf("behind %d", behind))
+		}
+	}
+	s := strings.Join(info, ", ")
+	if withName {
+		name := ggit.ShortRefName(upstream)
+		if color {
+			name = colorBlue + name + colorReset
+		}
+		if s != "" {
+			s = name + ": " + s
 		} else {
-			fmt.Fprintf(tw, "%s\t%s\n", prefix, b.Name)
+			s = name
+		}
+	}
+	if s == "" {
+		return ""
+	}
+	return "[" + s + "] "
+}
+
+// setUpTracking makes the new branch name follow start if start names a
+// branch: any branch for track "direct", or only a remote-tracking branch
+// for "auto".
+func setUpTracking(name, start, track string) {
+	if track == "no" {
+		return
+	}
+	ref, _, _ := ggit.DwimRef(start)
+	if !strings.HasPrefix(ref, "refs/remotes/") && (track != "direct" || !strings.HasPrefix(ref, "refs/heads/")) {
+		if track == "direct" {
+			fatal("cannot set up tracking information; starting point '%s' is not a branch", start)
+		}
+		return
+	}
+	if err := ggit.SetUpstream(name, ref); err != nil {
+		if track == "direct" {
+			fatal("%v", err)
+		}
+		return
+	}
+	fmt.Printf("branch '%s' set up to track '%s'.\n", name, ggit.ShortRefName(ref))
+}
+
+func createBranch(name, start string, force bool, track string) {
+	if !ggit.ValidBranchName(name) {
+		fatal("'%s' is not a valid branch name", name)
+	}
+	hash, err := ggit.ResolveRevision(start + "^{commit}")
+	if err != nil {
+		fatal("not a valid object name: '%s'", start)
+	}
+	ref := "refs/heads/" + name
+	_, old, _ := ggit.ResolveRef(ref)
+	msg := "branch: Created from " + start
+	if old != "" {
+		if !force {
+			fatal("a branch named '%s' already exists", name)
+		}
+		if current, _ := ggit.CurrentBranch(); current == name {
+			fatal("cannot force update the current branch.")
+		}
+		msg = "branch: Reset to " + start
+	}
+	oldHash := ggit.ZeroHash
+	if force {
+		oldHash = ""
+	}
+	if err := ggit.UpdateRef(ref, hash, oldHash, ggit.ReflogSignature(), msg); err != nil {
+		fatal("%v", err)
+	}
+	setUpTracking(name, start, track)
+}
+
+func deleteBranches(names []string, remotes, force bool) {
+	prefix, kind := "refs/heads/", "branch"
+	if remotes {
+		prefix, kind = "refs/remotes/", "remote-tracking branch"
+	}
+	current, _ := ggit.CurrentBranch()
+	failed := false
+	for _, name := range names {
+		ref := prefix + name
+		_, hash, _ := ggit.ResolveRef(ref)
+		switch {
+		case hash == "":
+			fmt.Fprintf(os.Stderr, "error: %s '%s' not found.\n", kind, name)
+			failed = true
+			continue
+		case !remotes && name == current:
+			wd, _ := os.Getwd()
+			fmt.Fprintf(os.Stderr, "error: Cannot delete branch '%s' checked out at '%s'\n", name, wd)
+			failed = true
+			continue
+		}
+		if !remotes && !force {
+			// A branch must be merged into its upstream, or HEAD if it has
+			// none, before it can be deleted safely.
+			into := "HEAD"
+			if upstream := ggit.Upstream(ref); upstream != "" {
+				if _, h, _ := ggit.ResolveRef(upstream); h != "" {
+					into = upstream
+				}
+			}
+			target, _ := ggit.ResolveRevision(into)
+			if ahead, _ := ggit.AheadBehind(hash, target); ahead > 0 {
+				fmt.Fprintf(os.Stderr, "error: The branch '%s' is not fully merged.\n"+
+					"If you are sure you want to delete it, run 'ggit branch -D %s'.\n", name, name)
+				failed = true
+				continue
+			}
+		}
+		t := ggit.NewRefTransaction()
+		err := t.Delete(ref, hash, "", true)
+		if err == nil {
+			err = t.Commit(ggit.ReflogSignature())
+		}
+		if err == nil && !remotes {
+			err = ggit.RenameConfigSection("branch."+name, "")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			failed = true
+			continue
+		}
+		fmt.Printf("Deleted %s %s (was %s).\n", kind, name, hash[:7])
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func renameBranch(args []string, force bool) {
+	oldName, newName := "", ""
+	switch len(args) {
+	case 1:
+		current, _ := ggit.CurrentBranch()
+		if ref, _, _ := ggit.ResolveRef("HEAD"); ref == "HEAD" {
+			fatal("cannot rename the current branch while not on any.")
+		}
+		oldName, newName = current, args[0]
+	case 2:
+		oldName, newName = args[0], args[1]
+	default:
+		fatal("too many arguments for a rename operation")
+	}
+	oldRef, newRef := "refs/heads/"+oldName, "refs/heads/"+newName
+	if _, hash, _ := ggit.ResolveRef(oldRef); hash == "" {
+		fatal("No branch named '%s'.", oldName)
+	}
+	if !ggit.ValidBranchName(newName) {
+		fatal("'%s' is not a valid branch name", newName)
+	}
+	if _, hash, _ := ggit.ResolveRef(newRef); hash != "" && oldName != newName {
+		if !force {
+			fatal("a branch named '%s' already exists", newName)
+		}
+		if err := ggit.RenameConfigSection("branch."+newName, ""); err != nil {
+			fatal("%v", err)
+		}
+	}
+	msg := fmt.Sprintf("Branch: renamed %s to %s", oldRef, newRef)
+	if err := ggit.RenameRef(oldRef, newRef, force, ggit.ReflogSignature(), msg); err != nil {
+		fatal("%v", err)
+	}
+	if err := ggit.RenameConfigSection("branch."+oldName, "branch."+newName); err != nil {
+		fatal("%v", err)
+	}
+}
+
+// branchToConfigure returns the branch named in args, or the current one.
+func branchToConfigure(args []string, what string) string {
+	if len(args) > 1 {
+		fatal("too many arguments to %s", what)
+	}
+	if len(args) == 1 {
+		if _, hash, _ := ggit.ResolveRef("refs/heads/" + args[0]); hash == "" {
+			fatal("branch '%s' does not exist", args[0])
+		}
+		return args[0]
+	}
+	if ref, _, _ := ggit.ResolveRef("HEAD"); !strings.HasPrefix(ref, "refs/heads/") {
+		fatal("could not %s of HEAD when it does not point to any branch.", what)
+	}
+	current, _ := ggit.CurrentBranch()
+	return current
+}
+
+func branch(args []string) {
+	fs := flag.NewFlagSet("branch", flag.ExitOnError)
+	del := fs.Bool("d", false, "delete a fully merged branch")
+	fs.BoolVar(del, "delete", false, "same as -d")
+	forceDel := fs.Bool("D", false, "delete a branch even if it isn't merged")
+	move := fs.Bool("m", false, "rename a branch and its reflog")
+	fs.BoolVar(move, "move", false, "same as -m")
+	forceMove := fs.Bool("M", false, "rename a branch even if the new name exists")
+	force := fs.Bool("f", false, "reset an existing branch, or force a delete or rename")
+	fs.BoolVar(force, "force", false, "same as -f")
+	all := fs.Bool("a", false, "list both local and remote-tracking branches")
+	fs.BoolVar(all, "all", false, "same as -a")
+	remotes := fs.Bool("r", false, "list or delete remote-tracking branches")
+	fs.BoolVar(remotes, "remotes", false, "same as -r")
+	verbose := fs.Bool("v", false, "show hash and subject, and how far ahead or behind the upstream each branch is")
+	fs.BoolVar(verbose, "verbose", false, "same as -v")
+	veryVerbose := fs.Bool("vv", false, "as -v, also naming the upstream branch")
+	upstream := fs.String("u", "", "set the upstream of a branch")
+	fs.StringVar(upstream, "set-upstream-to", "", "same as -u")
+	unsetUpstream := fs.Bool("unset-upstream", false, "remove the upstream of a branch")
+	track := fs.Bool("t", false, "set up the new branch to track its starting point")
+	fs.BoolVar(track, "track", false, "same as -t")
+	noTrack := fs.Bool("no-track", false, "don't set up tracking even if the starting point is a remote-tracking branch")
+	list := fs.Bool("list", false, "list branches matching the given patterns")
+	fs.BoolVar(list, "l", false, "same as --list")
+	showCurrent := fs.Bool("show-current", false, "print the name of the current branch")
+	var color colorFlag
+	fs.Var(&color, "color", "color the output: always, never or auto")
+	noColor := fs.Bool("no-color", false, "don't color the output")
+	var contains, merged, noMerged stringList
+	fs.Var(&contains, "contains", "only list branches that contain the commit")
+	fs.Var(&merged, "merged", "only list branches merged into the commit")
+	fs.Var(&noMerged, "no-merged", "only list branches not merged into the commit")
+	fs.Parse(defaultLastArg(args, "HEAD", "--contains", "--merged", "--no-merged"))
+
+	switch {
+	case *showCurrent:
+		if ref, _, _ := ggit.ResolveRef("HEAD"); strings.HasPrefix(ref, "refs/heads/") {
+			fmt.Println(ref[len("refs/heads/"):])
+		}
+	case *del || *forceDel:
+		if fs.NArg() == 0 {
+			fatal("branch name required")
+		}
+		deleteBranches(fs.Args(), *remotes, *forceDel || *force)
+	case *move || *forceMove:
+		if fs.NArg() == 0 {
+			fatal("branch name required")
+		}
+		renameBranch(fs.Args(), *forceMove || *force)
+	case *upstream != "":
+		name := branchToConfigure(fs.Args(), "set upstream")
+		ref, hash, _ := ggit.DwimRef(*upstream)
+		if hash == "" {
+			fatal("the requested upstream branch '%s' does not exist", *upstream)
+		}
+		if err := ggit.SetUpstream(name, ref); err != nil {
+			fatal("%v", err)
+		}
+		fmt.Printf("branch '%s' set up to track '%s'.\n", name, ggit.ShortRefName(ref))
+	case *unsetUpstream:
+		name := branchToConfigure(fs.Args(), "unset upstream")
+		if ggit.Upstream("refs/heads/"+name) == "" {
+			if v, _ := ggit.ConfigValue("branch." + name + ".merge"); v == "" {
+				fatal("Branch '%s' has no upstream information", name)
+			}
+		}
+		if err := ggit.SetUpstream(name, ""); err != nil {
+			fatal("%v", err)
+		}
+	case fs.NArg() > 0 && !*list && len(contains)+len(merged)+len(noMerged) == 0 && !*all && !*remotes:
+		if fs.NArg() > 2 {
+			fatal("too many arguments")
+		}
+		start := "HEAD"
+		if fs.NArg() == 2 {
+			start = fs.Arg(1)
+		}
+		mode := "auto"
+		if *track {
+			mode = "direct"
+		} else if *noTrack {
+			mode = "no"
+		}
+		createBranch(fs.Arg(0), start, *force, mode)
+	default:
+		o := branchListOptions{all: *all, remotes: *remotes, patterns: fs.Args()}
+		if *verbose {
+			o.verbose = 1
+		}
+		if *veryVerbose {
+			o.verbose = 2
+		}
+		when := string(color)
+		if *noColor {
+			when = "never"
+		}
+		o.color = useColor(when, "color.branch", "color.ui")
+		o.filter = ggit.RefFilter{
+			Contains: resolveAll(contains, "^{commit}"),
+			Merged:   resolveAll(merged, "^{commit}"),
+			NoMerged: resolveAll(noMerged, "^{commit}"),
 		}
+		listBranches(o)
 	}
 }
@@ -6,11 +6,12 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/jamesr/ggit"
 )
@@ -48,25 +49,227 @@ func dumpPrettyPrint(hash string) error {
 	}
 }
 
+// dumpPrettyPrintObject copies o to stdout as it is read, so that large
+// blobs needn't fit in memory.
 func dumpPrettyPrintObject(o ggit.Object) error {
-	b := bytes.NewBuffer(nil)
-	_, err := io.Copy(b, o.Reader)
+	defer o.Close()
+	_, err := io.Copy(os.Stdout, o.Reader)
+	return err
+}
+
+const catFileUsage = `Usage: ggit cat-file [-t|-s|-e|-p] <object>
+   or: ggit cat-file (--batch | --batch-check | --batch-command) [--batch-all-objects] [--buffer]`
+
+const defaultBatchFormat = "%(objectname) %(objecttype) %(objectsize)"
+
+// batchAtoms fill in the %(atom)s of a batch format.
+var batchAtoms = map[string]func(ggit.ObjectInfo) string{
+	"objectname":      func(info ggit.ObjectInfo) string { return info.Hash },
+	"objecttype":      func(info ggit.ObjectInfo) string { return info.Type },
+	"objectsize":      func(info ggit.ObjectInfo) string { return fmt.Sprint(info.Size) },
+	"objectsize:disk": func(info ggit.ObjectInfo) string { return fmt.Sprint(info.DiskSize) },
+	"deltabase": func(info ggit.ObjectInfo) string {
+		if info.DeltaBase == "" {
+			return strings.Repeat("0", 40)
+		}
+		return info.DeltaBase
+	},
+}
+
+// expandBatchFormat describes the object info as format asks.
+func expandBatchFormat(format string, info ggit.ObjectInfo) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(format, '%')
+		if i == -1 || i == len(format)-1 {
+			b.WriteString(format)
+			return b.String(), nil
+		}
+		b.WriteString(format[:i])
+		format = format[i+1:]
+		switch {
+		case format[0] == '%':
+			b.WriteByte('%')
+			format = format[1:]
+		case format[0] == '(' && strings.IndexByte(format, ')') != -1:
+			end := strings.IndexByte(format, ')')
+			atom, ok := batchAtoms[format[1:end]]
+			if !ok {
+				return "", fmt.Errorf("unknown format element: %s", format[1:end])
+			}
+			b.WriteString(atom(info))
+			format = format[end+1:]
+		default:
+			b.WriteByte('%')
+		}
+	}
+}
+
+// catFileBatch answers the object names and commands of the batch modes.
+type catFileBatch struct {
+	format string
+	buffer bool // flush only when asked to or at the end
+	out    *bufio.Writer
+}
+
+// object writes the line describing the object name, followed by its
+// contents if asked for.
+func (b *catFileBatch) object(name string, contents bool) {
+	hash, err := ggit.ResolveRevision(name)
+	info := ggit.ObjectInfo{}
+	if err == nil {
+		info, err = ggit.StatObject(hash)
+	}
 	if err != nil {
-		return err
+		if strings.HasSuffix(err.Error(), " is ambiguous") {
+			fmt.Fprintf(b.out, "%s ambiguous\n", name)
+		} else {
+			fmt.Fprintf(b.out, "%s missing\n", name)
+		}
+		b.done()
+		return
+	}
+	line, _ := expandBatchFormat(b.format, info)
+	fmt.Fprintln(b.out, line)
+	if contents {
+		o, err := ggit.LookupObject(hash)
+		if err != nil {
+			fatal("unable to read %s: %v", hash, err)
+		}
+		_, err = io.Copy(b.out, o.Reader)
+		o.Close()
+		if err != nil {
+			fatal("unable to read %s: %v", hash, err)
+		}
+		b.out.WriteByte('\n')
+	}
+	b.done()
+}
+
+// done flushes the output after each object unless it is being buffered.
+func (b *catFileBatch) done() {
+	if !b.buffer {
+		b.flush()
 	}
-	fmt.Print(b.String())
-	o.Close()
-	return nil
+}
+
+func (b *catFileBatch) flush() {
+	if err := b.out.Flush(); err != nil {
+		fatal("unable to write to stdout: %v", err)
+	}
+}
+
+// command runs a line of --batch-command input.
+func (b *catFileBatch) command(line string) {
+	if line == "" {
+		fatal("empty command in input")
+	}
+	for _, cmd := range []string{"contents", "info", "flush"} {
+		if !strings.HasPrefix(line, cmd) {
+			continue
+		}
+		rest := line[len(cmd):]
+		if cmd == "flush" {
+			if rest != "" {
+				fatal("flush takes no arguments")
+			}
+			if !b.buffer {
+				fatal("flush is only for --buffer mode")
+			}
+			b.flush()
+			return
+		}
+		if !strings.HasPrefix(rest, " ") {
+			fatal("%s requires arguments", cmd)
+		}
+		b.object(rest[1:], cmd == "contents")
+		return
+	}
+	fatal("unknown command: '%s'", line)
+}
+
+func catFileUsageError(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n\n", args...)
+	fmt.Fprintln(os.Stderr, catFileUsage)
+	os.Exit(129)
+}
+
+// catFileBatchMode reads object names or commands from stdin, or lists
+// every object, as the chosen --batch option asks.
+func catFileBatchMode(mode string, format optionalString, all, buffer bool) {
+	b := &catFileBatch{format: format.value, buffer: buffer, out: bufio.NewWriter(os.Stdout)}
+	if _, err := expandBatchFormat(b.format, ggit.ObjectInfo{}); err != nil {
+		fatal("%v", err)
+	}
+	contents := mode == "batch"
+	if all {
+		err := ggit.ForEachObject(func(hash string) error {
+			b.object(hash, contents)
+			return nil
+		})
+		if err != nil {
+			fatal("%v", err)
+		}
+		b.flush()
+		return
+	}
+	in := bufio.NewReader(os.Stdin)
+	for {
+		line, err := in.ReadString('\n')
+		if line == "" && err != nil {
+			break
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if mode == "batch-command" {
+			b.command(line)
+		} else {
+			b.object(line, contents)
+		}
+	}
+	b.flush()
 }
 
 func catFile(args []string) {
 	fs := flag.NewFlagSet("", flag.ExitOnError)
-	var typeOnly, sizeOnly, existsOnly, prettyPrint bool
+	var typeOnly, sizeOnly, existsOnly, prettyPrint, all, buffer bool
 	fs.BoolVar(&typeOnly, "t", false, "")
 	fs.BoolVar(&sizeOnly, "s", false, "")
 	fs.BoolVar(&existsOnly, "e", false, "")
 	fs.BoolVar(&prettyPrint, "p", false, "")
+	// An empty format given as --batch= is kept.
+	batch := optionalString{value: defaultBatchFormat}
+	batchCheck, batchCommand := batch, batch
+	fs.Var(&batch, "batch", "print the description and contents of each object named on stdin")
+	fs.Var(&batchCheck, "batch-check", "print the description of each object named on stdin")
+	fs.Var(&batchCommand, "batch-command", "read contents, info and flush commands from stdin")
+	fs.BoolVar(&all, "batch-all-objects", false, "describe every object instead of reading stdin")
+	fs.BoolVar(&buffer, "buffer", false, "buffer the batch output")
 	fs.Parse(args)
+	mode, format := "", optionalString{}
+	for _, m := range []struct {
+		name string
+		opt  optionalString
+	}{{"batch", batch}, {"batch-check", batchCheck}, {"batch-command", batchCommand}} {
+		if m.opt.set {
+			if mode != "" {
+				catFileUsageError("error: only one batch option may be specified")
+			}
+			mode, format = m.name, m.opt
+		}
+	}
+	if mode == "" && all {
+		catFileUsageError("fatal: '--batch-all-objects' requires a batch mode")
+	}
+	if mode == "" && buffer {
+		catFileUsageError("fatal: '--buffer' requires a batch mode")
+	}
+	if mode != "" {
+		if fs.NArg() != 0 || typeOnly || sizeOnly || existsOnly || prettyPrint {
+			catFileUsageError("fatal: batch modes take no arguments")
+		}
+		catFileBatchMode(mode, format, all, buffer)
+		return
+	}
 	name := fs.Arg(fs.NArg() - 1)
 	if len(name) == 0 {
 		fmt.Fprintln(os.Stderr, "Usage: ggit cat-file [-t|-s|-e|-p] <object>")
@@ -0,0 +1,278 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jamesr/ggit"
+)
+
+// splitDashDash splits args at the first "--", which the flag package would
+// otherwise swallow. dashDash reports whether there was one.
+func splitDashDash(args []string) (before, after []string, dashDash bool) {
+	for i, a := range args {
+		if a == "--" {
+			return args[:i], args[i+1:], true
+		}
+	}
+	return args, nil, false
+}
+
+// commitTreeOf returns the tree of the commit named by hash, or "" for the
+// empty hash of an unborn branch.
+func commitTreeOf(hash string) string {
+	if hash == "" {
+		return ""
+	}
+	c, err := ggit.ReadCommit(hash)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: reading commit %s: %v\n", hash, err)
+		os.Exit(128)
+	}
+	defer c.Close()
+	return c.Tree
+}
+
+// describeHEAD prints hash with the subject of its commit, as git does when
+// leaving or arriving at a detached HEAD.
+func describeHEAD(prefix, hash string) {
+	c, err := ggit.ReadCommit(hash)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	fmt.Fprintf(os.Stderr, "%s %s %s\n", prefix, hash[:7], subject(c.Message()))
+}
+
+// switchOptions describe a branch switch for checkout and switch.
+type switchOptions struct {
+	target     string // branch or commit to switch to
+	newBranch  string // branch to create at target first
+	forceNew   bool   // reset newBranch if it already exists
+	detach     bool   // detach HEAD even if target is a branch
+	force      bool   // throw away local changes
+	noBranchOk bool   // allow a non-branch target without detach
+}
+
+func switchBranch(cmd string, o switchOptions) {
+	headRef, headHash, err := ggit.ResolveRef("HEAD")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal: reading HEAD:", err)
+		os.Exit(128)
+	}
+	who := ggit.ReflogSignature()
+
+	target := o.target
+	if target == "" {
+		target = "HEAD"
+	}
+	if target == "-" {
+		target = "@{-1}"
+	}
+	if strings.HasPrefix(target, "@{-") && strings.HasSuffix(target, "}") {
+		// Switch back to the branch itself rather than detaching at its tip.
+		n, err := strconv.Atoi(target[3 : len(target)-1])
+		if err == nil {
+			target, err = ggit.PreviousBranch(n)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: invalid reference: %s\n", o.target)
+			os.Exit(128)
+		}
+	}
+	ref := ""
+	if o.newBranch == "" && !o.detach && target != "HEAD" {
+		if _, hash, err := ggit.ResolveRef("refs/heads/" + target); err == nil && hash != "" {
+			ref = "refs/heads/" + target
+		}
+	}
+	hash, err := ggit.ResolveRevision(target + "^{commit}")
+	if err != nil {
+		if target == "HEAD" && headHash == "" && o.newBranch != "" {
+			// Creating a branch on an unborn HEAD just renames it.
+			hash = ""
+		} else {
+			fmt.Fprintf(os.Stderr, "fatal: invalid reference: %s\n", target)
+			os.Exit(128)
+		}
+	}
+	if ref == "" && o.newBranch == "" && !o.detach && !o.noBranchOk {
+		fmt.Fprintf(os.Stderr, "fatal: a branch is expected, got '%s'\n", target)
+		os.Exit(128)
+	}
+
+	if o.newBranch != "" && !o.forceNew {
+		if _, hash, _ := ggit.ResolveRef("refs/heads/" + o.newBranch); hash != "" {
+			fmt.Fprintf(os.Stderr, "fatal: a branch named '%s' already exists\n", o.newBranch)
+			os.Exit(128)
+		}
+	}
+
+	idx, err := ggit.ReadIndex(".git/index")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error reading index:", err)
+		os.Exit(1)
+	}
+	err = ggit.CheckoutTree(ggit.DirWorktree("."), idx, commitTreeOf(headHash), commitTreeOf(hash), o.force)
+	if err, ok := err.(*ggit.CheckoutError); ok {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		if strings.Contains(err.Reason, "untracked") {
+			fmt.Fprintln(os.Stderr, "Please move or remove them before you switch branches.")
+		} else {
+			fmt.Fprintln(os.Stderr, "Please commit your changes or stash them before you switch branches.")
+		}
+		fmt.Fprintln(os.Stderr, "Aborting")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	if err := idx.Write(".git/index"); err != nil {
+		fmt.Fprintln(os.Stderr, "error writing index:", err)
+		os.Exit(1)
+	}
+
+	if o.newBranch != "" {
+		ref = "refs/heads/" + o.newBranch
+		if hash != "" {
+			old := ggit.ZeroHash
+			if o.forceNew {
+				old = ""
+			}
+			if err := ggit.UpdateRef(ref, hash, old, who, "branch: Created from "+target); err != nil {
+				fmt.Fprintln(os.Stderr, "fatal:", err)
+				os.Exit(128)
+			}
+		}
+	}
+
+	from := strings.TrimPrefix(headRef, "refs/heads/")
+	if headRef == "HEAD" {
+		from = headHash
+	}
+	newHEAD, to := hash, hash
+	if ref != "" {
+		newHEAD, to = ref, strings.TrimPrefix(ref, "refs/heads/")
+	}
+	if err := ggit.SetHEAD(newHEAD, who, fmt.Sprintf("%s: moving from %s to %s", cmd, from, to)); err != nil {
+		fmt.Fprintln(os.Stderr, "fatal: updating HEAD:", err)
+		os.Exit(128)
+	}
+
+	if headRef == "HEAD" && headHash != hash {
+		describeHEAD("Previous HEAD position was", headHash)
+	}
+	switch {
+	case ref == "":
+		describeHEAD("HEAD is now at", hash)
+	case o.newBranch != "" && o.forceNew:
+		fmt.Fprintf(os.Stderr, "Switched to and reset branch '%s'\n", to)
+	case o.newBranch != "":
+		fmt.Fprintf(os.Stderr, "Switched to a new branch '%s'\n", to)
+	case ref == headRef:
+		fmt.Fprintf(os.Stderr, "Already on '%s'\n", to)
+	default:
+		fmt.Fprintf(os.Stderr, "Switched to branch '%s'\n", to)
+	}
+	runHook(ggit.PostCheckoutHook(headHash, hash, true))
+}
+
+// checkoutPaths restores paths from tree, or from the index if tree is "".
+func checkoutPaths(tree string, paths []string) {
+	ps, err := ggit.ParsePathspec(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	idx, err := ggit.ReadIndex(".git/index")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error reading index:", err)
+		os.Exit(1)
+	}
+	if tree == "" {
+		err = ggit.CheckoutIndexPaths(ggit.DirWorktree("."), idx, ps)
+	} else {
+		err = ggit.CheckoutPaths(ggit.DirWorktree("."), idx, tree, ps)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	if err := idx.Write(".git/index"); err != nil {
+		fmt.Fprintln(os.Stderr, "error writing index:", err)
+		os.Exit(1)
+	}
+	_, head, _ := ggit.ResolveRef("HEAD")
+	runHook(ggit.PostCheckoutHook(head, head, false))
+}
+
+func checkout(args []string) {
+	fs := flag.NewFlagSet("checkout", flag.ExitOnError)
+	force := fs.Bool("f", false, "throw away local changes")
+	fs.BoolVar(force, "force", false, "same as -f")
+	newBranch := fs.String("b", "", "create a new branch and check it out")
+	resetBranch := fs.String("B", "", "create or reset a branch and check it out")
+	detach := fs.Bool("detach", false, "detach HEAD at the named commit")
+	before, paths, dashDash := splitDashDash(args)
+	fs.Parse(before)
+	if !dashDash && fs.NArg() > 1 {
+		paths = fs.Args()[1:]
+	}
+	if fs.NArg() > 1 && dashDash {
+		fmt.Fprintln(os.Stderr, "Usage: ggit checkout [-f] [-b <branch>] [<branch>|<commit>] [--] [<paths>...]")
+		os.Exit(1)
+	}
+	target := fs.Arg(0)
+	if !dashDash && fs.NArg() == 1 && target != "-" && *newBranch == "" && *resetBranch == "" {
+		// A lone argument that isn't a revision is a path.
+		if _, err := ggit.ResolveRevision(target); err != nil {
+			target, paths = "", fs.Args()
+		}
+	}
+
+	if len(paths) > 0 {
+		if *newBranch != "" || *resetBranch != "" || *detach {
+			fmt.Fprintln(os.Stderr, "fatal: cannot update paths and switch to a branch at the same time")
+			os.Exit(128)
+		}
+		tree := ""
+		if target != "" {
+			hash, err := ggit.ResolveRevision(target + "^{tree}")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: invalid reference: %s\n", target)
+				os.Exit(128)
+			}
+			tree = hash
+		}
+		checkoutPaths(tree, paths)
+		return
+	}
+	if target == "" && *newBranch == "" && *resetBranch == "" {
+		fmt.Fprintln(os.Stderr, "Usage: ggit checkout [-f] [-b <branch>] [<branch>|<commit>] [--] [<paths>...]")
+		os.Exit(1)
+	}
+	o := switchOptions{
+		target:     target,
+		newBranch:  *newBranch,
+		detach:     *detach,
+		force:      *force,
+		noBranchOk: true,
+	}
+	if *resetBranch != "" {
+		o.newBranch, o.forceNew = *resetBranch, true
+	}
+	if o.newBranch != "" && !ggit.ValidBranchName(o.newBranch) {
+		fmt.Fprintf(os.Stderr, "fatal: '%s' is not a valid branch name\n", o.newBranch)
+		os.Exit(128)
+	}
+	switchBranch("checkout", o)
+}
@@ -0,0 +1,53 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jamesr/ggit"
+)
+
+const commitGraphUsage = `usage: ggit commit-graph verify
+   or: ggit commit-graph write --reachable [--split] [--changed-paths]`
+
+func commitGraph(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, commitGraphUsage)
+		os.Exit(129)
+	}
+	fs := flag.NewFlagSet("commit-graph "+args[0], flag.ExitOnError)
+	var reachable, split, changedPaths *bool
+	switch args[0] {
+	case "write":
+		reachable = fs.Bool("reachable", false, "walk the commits reachable from the refs")
+		split = fs.Bool("split", false, "add a layer to the commit-graph chain")
+		changedPaths = fs.Bool("changed-paths", false, "write Bloom filters of the paths each commit changes")
+	case "verify":
+	default:
+		fmt.Fprintf(os.Stderr, "error: unrecognized subcommand: %s\n", args[0])
+		fmt.Fprintln(os.Stderr, commitGraphUsage)
+		os.Exit(129)
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() != 0 || reachable != nil && !*reachable {
+		fmt.Fprintln(os.Stderr, commitGraphUsage)
+		os.Exit(129)
+	}
+
+	if args[0] == "verify" {
+		if err := ggit.VerifyCommitGraph(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := ggit.WriteCommitGraph(ggit.CommitGraphOptions{Split: *split, ChangedPaths: *changedPaths}); err != nil {
+		fatal("%v", err)
+	}
+}
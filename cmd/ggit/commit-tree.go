@@ -0,0 +1,78 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/jamesr/ggit"
+)
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func signatures() (author, committer ggit.Signature) {
+	author, err := ggit.DefaultSignature("author")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	committer, err = ggit.DefaultSignature("committer")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return author, committer
+}
+
+func commitTree(args []string) {
+	fs := flag.NewFlagSet("commit-tree", flag.ExitOnError)
+	var parents, messages stringList
+	fs.Var(&parents, "p", "id of a parent commit object")
+	fs.Var(&messages, "m", "paragraph of the commit log message")
+	fs.Parse(args)
+	// git accepts the tree before or after the options.
+	tree := ""
+	if fs.NArg() > 0 {
+		tree = fs.Arg(0)
+		fs.Parse(fs.Args()[1:])
+	}
+	if tree == "" || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: ggit commit-tree <tree> [-p <parent>]... [-m <message>]...")
+		os.Exit(1)
+	}
+	message := ""
+	if len(messages) > 0 {
+		message = strings.Join(messages, "\n\n") + "\n"
+	} else {
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error reading message:", err)
+			os.Exit(1)
+		}
+		message = string(b)
+	}
+	author, committer := signatures()
+	hash, err := ggit.CommitTree(ggit.CommitishToHash(tree), parents, author, committer, message)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error writing commit:", err)
+		os.Exit(1)
+	}
+	fmt.Println(hash)
+}
@@ -0,0 +1,159 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/jamesr/ggit"
+)
+
+// cleanupMessage strips trailing whitespace from every line, collapses runs of
+// blank lines and removes leading and trailing blank lines, like git's
+// --cleanup=whitespace.
+func cleanupMessage(m string) string {
+	lines := []string(nil)
+	blank := false
+	for _, l := range strings.Split(m, "\n") {
+		l = strings.TrimRight(l, " \t\r")
+		if len(l) == 0 {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, l)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func subject(message string) string {
+	if n := strings.IndexByte(message, '\n'); n != -1 {
+		return message[:n]
+	}
+	return message
+}
+
+// runHook runs h, exiting if it fails. A hook that ran and failed has said
+// why itself, so as in git nothing more is printed.
+func runHook(h ggit.Hook) {
+	if err := h.Run(); err != nil {
+		if _, ok := err.(*ggit.HookError); !ok {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func commitCmd(args []string) {
+	fs := flag.NewFlagSet("commit", flag.ExitOnError)
+	var messages stringList
+	fs.Var(&messages, "m", "use the given message as the commit message")
+	allowEmpty := fs.Bool("allow-empty", false, "allow recording a commit with the same tree as its parent")
+	noVerify := fs.Bool("no-verify", false, "bypass the pre-commit and commit-msg hooks")
+	fs.BoolVar(noVerify, "n", false, "short for -no-verify")
+	fs.Parse(args)
+	if len(messages) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: ggit commit -m <message> [--allow-empty] [--no-verify]")
+		os.Exit(1)
+	}
+
+	if !*noVerify {
+		runHook(ggit.CommitHook("pre-commit"))
+	}
+	// The hook may have changed the index, so read it only now.
+	idx, err := ggit.ReadIndex(".git/index")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error reading index:", err)
+		os.Exit(1)
+	}
+	tree, err := ggit.WriteTree(idx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error writing tree:", err)
+		os.Exit(1)
+	}
+	if err := idx.Write(".git/index"); err != nil {
+		fmt.Fprintln(os.Stderr, "error writing index:", err)
+		os.Exit(1)
+	}
+
+	ref, parent, err := ggit.ResolveRef("HEAD")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error reading HEAD:", err)
+		os.Exit(1)
+	}
+	parents := []string(nil)
+	empty := len(idx.Entries) == 0
+	if parent != "" {
+		parents = append(parents, parent)
+		c, err := ggit.ReadCommit(parent)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error reading HEAD commit:", err)
+			os.Exit(1)
+		}
+		empty = c.Tree == tree
+		c.Close()
+	}
+	if empty && !*allowEmpty {
+		fmt.Fprintln(os.Stderr, "nothing to commit")
+		os.Exit(1)
+	}
+
+	// The message hooks may edit the message in place; --no-verify skips
+	// only commit-msg.
+	const editMsg = ".git/COMMIT_EDITMSG"
+	message := cleanupMessage(strings.Join(messages, "\n\n"))
+	if err := ioutil.WriteFile(editMsg, []byte(message), 0666); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	runHook(ggit.CommitHook("prepare-commit-msg", editMsg, "message"))
+	if !*noVerify {
+		runHook(ggit.CommitHook("commit-msg", editMsg))
+	}
+	b, err := ioutil.ReadFile(editMsg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	message = cleanupMessage(string(b))
+	if message == "" {
+		fmt.Fprintln(os.Stderr, "Aborting commit due to empty commit message.")
+		os.Exit(1)
+	}
+
+	author, committer := signatures()
+	hash, err := ggit.CommitTree(tree, parents, author, committer, message)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error writing commit:", err)
+		os.Exit(1)
+	}
+	logMsg, root := "commit: ", ""
+	if parent == "" {
+		logMsg, root = "commit (initial): ", " (root-commit)"
+		parent = ggit.ZeroHash
+	}
+	err = ggit.UpdateRef("HEAD", hash, parent, committer, logMsg+subject(message))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error updating", ref+":", err)
+		os.Exit(1)
+	}
+	ggit.CommitHook("post-commit").Run()
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+	if ref == "HEAD" {
+		branch = "detached HEAD"
+	}
+	fmt.Printf("[%s%s %s] %s\n", branch, root, hash[:7], subject(message))
+}
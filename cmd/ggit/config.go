@@ -0,0 +1,396 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jamesr/ggit"
+)
+
+const configUsage = "Usage: ggit config [<file-option>] [--type=<type>] [-z] " +
+	"[--get | --get-all | --get-regexp | --add | --replace-all | --unset | --unset-all | " +
+	"--rename-section | --remove-section | -l | --get-color] <args>..."
+
+// configKeyError reports an invalid key to change and exits as git does: 2
+// if the key is missing its section or name and 1 otherwise.
+func configKeyError(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	if strings.HasPrefix(err.Error(), "key does not contain") {
+		os.Exit(2)
+	}
+	os.Exit(1)
+}
+
+// valueMatcher returns a function matching values against pattern, a regular
+// expression that a leading "!" negates, or nil if there is no pattern.
+func valueMatcher(pattern string, given bool) func(string) bool {
+	if !given {
+		return nil
+	}
+	negate := strings.HasPrefix(pattern, "!")
+	re, err := regexp.Compile(strings.TrimPrefix(pattern, "!"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: invalid pattern: %s\n", pattern)
+		os.Exit(6)
+	}
+	return func(value string) bool { return re.MatchString(value) != negate }
+}
+
+// typedConfigValue returns e's value as typ. It reports false for a key with
+// no value when there is no type to give it one.
+func typedConfigValue(e ggit.ConfigEntry, typ string) (string, bool) {
+	var s string
+	var err error
+	switch typ {
+	case "bool":
+		var b bool
+		b, err = e.Bool()
+		s = strconv.FormatBool(b)
+	case "int":
+		var n int64
+		n, err = e.Int()
+		s = strconv.FormatInt(n, 10)
+	case "bool-or-int":
+		n, isBool, e := e.BoolOrInt()
+		s, err = strconv.FormatInt(n, 10), e
+		if isBool {
+			s = strconv.FormatBool(n != 0)
+		}
+	case "path":
+		s, err = e.Path()
+	case "color":
+		s, err = e.Color()
+	default:
+		return e.Value, !e.NoValue
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+	return s, true
+}
+
+// globalConfigFile returns the user's config file to write to: the XDG one
+// if it exists and ~/.gitconfig doesn't, and otherwise ~/.gitconfig.
+func globalConfigFile() string {
+	files := ggit.GlobalConfigFiles()
+	if len(files) == 0 {
+		fatal("$HOME not set")
+	}
+	home := files[len(files)-1]
+	if len(files) > 1 {
+		if _, err := os.Stat(home); os.IsNotExist(err) {
+			if _, err := os.Stat(files[0]); err == nil {
+				return files[0]
+			}
+		}
+	}
+	return home
+}
+
+func config(args []string) {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	global := fs.Bool("global", false, "use the user's config file")
+	system := fs.Bool("system", false, "use the system-wide config file")
+	local := fs.Bool("local", false, "use the repository's config file")
+	worktree := fs.Bool("worktree", false, "use the worktree's config file")
+	file := fs.String("f", "", "use the given config file")
+	fs.StringVar(file, "file", "", "same as -f")
+	get := fs.Bool("get", false, "get the value of a key")
+	getAll := fs.Bool("get-all", false, "get all values of a key")
+	getRegexp := fs.Bool("get-regexp", false, "get the values of the keys matching a regular expression")
+	getColor := fs.Bool("get-color", false, "get the ANSI sequence for a color key")
+	add := fs.Bool("add", false, "add a value to a key without replacing any")
+	replaceAll := fs.Bool("replace-all", false, "replace every value of a key")
+	unset := fs.Bool("unset", false, "remove a key")
+	unsetAll := fs.Bool("unset-all", false, "remove every value of a key")
+	renameSection := fs.Bool("rename-section", false, "rename a section")
+	removeSection := fs.Bool("remove-section", false, "remove a section")
+	list := fs.Bool("list", false, "list every key and value")
+	fs.BoolVar(list, "l", false, "same as --list")
+	typ := fs.String("type", "", "interpret values as bool, int, bool-or-int, path or color")
+	boolType := fs.Bool("bool", false, "same as --type=bool")
+	intType := fs.Bool("int", false, "same as --type=int")
+	boolOrInt := fs.Bool("bool-or-int", false, "same as --type=bool-or-int")
+	pathType := fs.Bool("path", false, "same as --type=path")
+	null := fs.Bool("z", false, "end values with NUL and keys with a newline")
+	fs.BoolVar(null, "null", false, "same as -z")
+	nameOnly := fs.Bool("name-only", false, "show only key names")
+	showOrigin := fs.Bool("show-origin", false, "show the file each value comes from")
+	showScope := fs.Bool("show-scope", false, "show the scope each value comes from")
+	def := fs.String("default", "", "the value to use when a key is missing")
+	includes := fs.Bool("includes", false, "follow include directives when reading a single file")
+	noIncludes := fs.Bool("no-includes", false, "don't follow include directives")
+	rest := parseInterspersed(fs, args)
+	defSet := false
+	fs.Visit(func(f *flag.Flag) { defSet = defSet || f.Name == "default" })
+
+	for name, set := range map[string]bool{"bool": *boolType, "int": *intType, "bool-or-int": *boolOrInt, "path": *pathType} {
+		if set {
+			*typ = name
+		}
+	}
+	switch *typ {
+	case "", "bool", "int", "bool-or-int", "path", "color":
+	default:
+		fmt.Fprintf(os.Stderr, "error: unrecognized --type argument, %s\n", *typ)
+		os.Exit(129)
+	}
+
+	// path is the file to use, or "" for all of them, as for reading
+	// without a file option.
+	path, scope := "", ""
+	switch {
+	case *file != "":
+		path, scope = *file, "command"
+	case *global:
+		path, scope = globalConfigFile(), "global"
+	case *system:
+		path, scope = ggit.SystemConfigFile(), "system"
+		if path == "" {
+			path = "/etc/gitconfig"
+		}
+	case *local:
+		path, scope = ".git/config", "local"
+	case *worktree:
+		path, scope = ggit.WorktreeConfigFile(), "worktree"
+	}
+	writePath := path
+	if writePath == "" {
+		writePath = ".git/config"
+	}
+	read := func() *ggit.Config {
+		var c *ggit.Config
+		var err error
+		if path == "" {
+			c, err = ggit.ReadConfig()
+		} else {
+			c, err = ggit.ReadConfigFile(path, *includes && !*noIncludes)
+		}
+		if err != nil {
+			fatal("%v", err)
+		}
+		if path != "" {
+			for i := range c.Entries {
+				c.Entries[i].Scope = scope
+			}
+		}
+		return c
+	}
+
+	actions := 0
+	for _, a := range []bool{*get, *getAll, *getRegexp, *getColor, *add, *replaceAll, *unset, *unsetAll,
+		*renameSection, *removeSection, *list} {
+		if a {
+			actions++
+		}
+	}
+	if actions > 1 {
+		fmt.Fprintln(os.Stderr, "error: only one action at a time")
+		fmt.Fprintln(os.Stderr, configUsage)
+		os.Exit(129)
+	}
+	nargs := func(min, max int) {
+		if len(rest) < min || len(rest) > max {
+			fmt.Fprintln(os.Stderr, "error: wrong number of arguments")
+			fmt.Fprintln(os.Stderr, configUsage)
+			os.Exit(129)
+		}
+	}
+	if actions == 0 {
+		switch len(rest) {
+		case 1:
+			*get = true
+		case 2, 3:
+		default:
+			fmt.Fprintln(os.Stderr, configUsage)
+			os.Exit(129)
+		}
+	}
+
+	term, delim, keyDelim := "\n", "\t", " "
+	if *null {
+		term, delim, keyDelim = "\x00", "\x00", "\n"
+	}
+	show := func(e ggit.ConfigEntry, showKey bool, sep string) {
+		line := ""
+		if *showScope {
+			line += e.Scope + delim
+		}
+		if *showOrigin {
+			if e.File == "" {
+				line += "command line:" + delim
+			} else {
+				line += "file:" + e.File + delim
+			}
+		}
+		if showKey {
+			line += e.Key
+		}
+		if !showKey || !*nameOnly {
+			if v, ok := typedConfigValue(e, *typ); ok {
+				if showKey {
+					line += sep
+				}
+				line += v
+			}
+		}
+		fmt.Print(line + term)
+	}
+
+	switch {
+	case *list:
+		nargs(0, 0)
+		sep := "="
+		if *null {
+			sep = "\n"
+		}
+		for _, e := range read().Entries {
+			show(e, true, sep)
+		}
+
+	case *get, *getAll:
+		nargs(1, 2)
+		key, err := ggit.CanonicalConfigKey(rest[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		match := valueMatcher(strings.Join(rest[1:], ""), len(rest) > 1)
+		found := []ggit.ConfigEntry(nil)
+		for _, e := range read().GetAll(key) {
+			if match == nil || match(e.Value) {
+				found = append(found, e)
+			}
+		}
+		if len(found) == 0 {
+			if !defSet {
+				os.Exit(1)
+			}
+			found = []ggit.ConfigEntry{{Key: key, Value: *def, Scope: "command"}}
+		}
+		if *get {
+			found = found[len(found)-1:]
+		}
+		for _, e := range found {
+			show(e, false, "")
+		}
+
+	case *getRegexp:
+		nargs(1, 2)
+		// Like git, lower-case the key pattern's section and name.
+		pattern := rest[0]
+		first, last := strings.IndexByte(pattern, '.'), strings.LastIndexByte(pattern, '.')
+		if first == -1 {
+			pattern = strings.ToLower(pattern)
+		} else {
+			pattern = strings.ToLower(pattern[:first]) + pattern[first:last] + strings.ToLower(pattern[last:])
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid key pattern: %s\n", rest[0])
+			os.Exit(6)
+		}
+		match := valueMatcher(strings.Join(rest[1:], ""), len(rest) > 1)
+		found := false
+		for _, e := range read().Entries {
+			if re.MatchString(e.Key) && (match == nil || match(e.Value)) {
+				show(e, true, keyDelim)
+				found = true
+			}
+		}
+		if !found {
+			os.Exit(1)
+		}
+
+	case *getColor:
+		nargs(1, 2)
+		spec, ok := "", false
+		if e, found := read().Get(rest[0]); found {
+			spec, ok = e.Value, true
+		} else if len(rest) > 1 {
+			spec, ok = rest[1], true
+		}
+		if ok {
+			color, err := ggit.ParseColor(spec)
+			if err != nil {
+				fatal("unable to parse default color value")
+			}
+			fmt.Print(color)
+		}
+
+	case *renameSection, *removeSection:
+		newName := ""
+		if *renameSection {
+			nargs(2, 2)
+			newName = rest[1]
+		} else {
+			nargs(1, 1)
+		}
+		found, err := ggit.RenameConfigFileSection(writePath, rest[0], newName)
+		if err != nil {
+			fatal("%v", err)
+		}
+		if !found {
+			fatal("no such section: %s", rest[0])
+		}
+
+	case *unset, *unsetAll:
+		nargs(1, 2)
+		key, err := ggit.CanonicalConfigKey(rest[0])
+		if err != nil {
+			configKeyError(err)
+		}
+		match := valueMatcher(strings.Join(rest[1:], ""), len(rest) > 1)
+		n, err := ggit.UnsetConfigFileValue(writePath, key, match, *unsetAll)
+		if err == ggit.ErrMultipleConfigValues {
+			fmt.Fprintf(os.Stderr, "warning: %s has multiple values\n", key)
+			os.Exit(5)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(255)
+		}
+		if n == 0 {
+			os.Exit(5)
+		}
+
+	default:
+		// Setting, with --add, --replace-all or neither.
+		if *add {
+			nargs(2, 2)
+		} else {
+			nargs(2, 3)
+		}
+		key, err := ggit.CanonicalConfigKey(rest[0])
+		if err != nil {
+			configKeyError(err)
+		}
+		// Values are checked against the type, and booleans and numbers
+		// written in canonical form.
+		value := rest[1]
+		if typed, _ := typedConfigValue(ggit.ConfigEntry{Key: key, Value: value}, *typ); *typ != "path" && *typ != "color" {
+			value = typed
+		}
+		match := valueMatcher(strings.Join(rest[2:], ""), len(rest) > 2)
+		if *add {
+			match = func(string) bool { return false }
+		}
+		err = ggit.SetConfigFileValue(writePath, rest[0], value, match, *replaceAll)
+		if err == ggit.ErrMultipleConfigValues {
+			fmt.Fprintf(os.Stderr, "warning: %s has multiple values\n", key)
+			fmt.Fprintf(os.Stderr, "error: %v\n       Use a regexp, --add or --replace-all to change %s.\n", err, key)
+			os.Exit(5)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(255)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/jamesr/ggit"
+)
+
+// optionalString is an option such as --dirty[=<mark>] whose value may be
+// left off.
+type optionalString struct {
+	value string
+	set   bool
+}
+
+func (o *optionalString) String() string { return o.value }
+
+func (o *optionalString) Set(s string) error {
+	o.set = true
+	if s != "true" {
+		o.value = s
+	}
+	return nil
+}
+
+func (o *optionalString) IsBoolFlag() bool { return true }
+
+func describe(args []string) {
+	fs := flag.NewFlagSet("describe", flag.ExitOnError)
+	tags := fs.Bool("tags", false, "use lightweight tags as well as annotated ones")
+	long := fs.Bool("long", false, "always show the number of commits and abbreviated name")
+	var dirty optionalString
+	fs.Var(&dirty, "dirty", "describe the worktree, appending a mark (default \"-dirty\") if it has local changes")
+	fs.Parse(args)
+
+	revs := fs.Args()
+	if dirty.set {
+		if len(revs) > 0 {
+			fatal("option '--dirty' and commit-ishes cannot be used together")
+		}
+		if dirty.value == "" {
+			dirty.value = "-dirty"
+		}
+	}
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+	for _, rev := range revs {
+		hash, err := ggit.ResolveRevision(rev + "^{commit}")
+		if err != nil {
+			fatal("Not a valid object name %s", rev)
+		}
+		name, depth, err := ggit.Describe(hash, *tags)
+		if err != nil {
+			fatal("%v", err)
+		}
+		if depth > 0 || *long {
+			name = fmt.Sprintf("%s-%d-g%s", name, depth, hash[:7])
+		}
+		if dirty.set {
+			idx, err := ggit.ReadIndex(".git/index")
+			if err != nil {
+				fatal("%v", err)
+			}
+			if d, err := ggit.WorktreeDirty(ggit.DirWorktree("."), idx); err != nil {
+				fatal("%v", err)
+			} else if d {
+				name += dirty.value
+			}
+		}
+		fmt.Println(name)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jamesr/ggit"
+)
+
+// defaultLastArg appends def to args if they end with one of flags, whose
+// argument is optional when given last, as with --merged.
+func defaultLastArg(args []string, def string, flags ...string) []string {
+	if len(args) == 0 {
+		return args
+	}
+	for _, f := range flags {
+		if args[len(args)-1] == f {
+			return append(args, def)
+		}
+	}
+	return args
+}
+
+// resolveAll resolves each revision in revs, with suffix such as "^{commit}"
+// appended, exiting if one doesn't name an object.
+func resolveAll(revs []string, suffix string) []string {
+	hashes := []string(nil)
+	for _, rev := range revs {
+		hash, err := ggit.ResolveRevision(rev + suffix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: malformed object name %s\n", rev)
+			os.Exit(129)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+func forEachRef(args []string) {
+	fs := flag.NewFlagSet("for-each-ref", flag.ExitOnError)
+	format := fs.String("format", "%(objectname) %(objecttype)\t%(refname)", "format to use for the output")
+	var sorts, contains, merged, noMerged, pointsAt stringList
+	fs.Var(&sorts, "sort", "field name to sort on; prefix - to reverse")
+	count := fs.Int("count", 0, "show only this many matching refs")
+	fs.Var(&contains, "contains", "print only refs which contain the commit")
+	fs.Var(&merged, "merged", "print only refs which are merged into the commit")
+	fs.Var(&noMerged, "no-merged", "print only refs which are not merged into the commit")
+	fs.Var(&pointsAt, "points-at", "print only refs which point at the given object")
+	fs.Parse(defaultLastArg(args, "HEAD", "--contains", "--merged", "--no-merged"))
+
+	refs, err := ggit.ListRefs("refs/")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	filter := &ggit.RefFilter{
+		Patterns: fs.Args(),
+		Contains: resolveAll(contains, "^{commit}"),
+		Merged:   resolveAll(merged, "^{commit}"),
+		NoMerged: resolveAll(noMerged, "^{commit}"),
+		PointsAt: resolveAll(pointsAt, ""),
+	}
+	if refs, err = filter.Filter(refs); err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	// The last --sort given is the primary key.
+	keys := []string(nil)
+	for i := len(sorts) - 1; i >= 0; i-- {
+		keys = append(keys, sorts[i])
+	}
+	f := ggit.NewRefFormatter()
+	if len(keys) > 0 {
+		if err := f.Sort(refs, keys); err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+	}
+	if *count > 0 && len(refs) > *count {
+		refs = refs[:*count]
+	}
+	for _, r := range refs {
+		line, err := f.Format(*format, r)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jamesr/ggit"
+)
+
+func fsck(args []string) {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	_ = fs.Bool("full", true, "check objects in packs as well as loose ones")
+	connectivityOnly := fs.Bool("connectivity-only", false, "only check that reachable objects are present")
+	unreachable := fs.Bool("unreachable", false, "show unreachable objects")
+	noDangling := fs.Bool("no-dangling", false, "don't show dangling objects")
+	lostFound := fs.Bool("lost-found", false, "write dangling objects to .git/lost-found")
+	fs.Parse(args)
+	opts := ggit.FsckOptions{ConnectivityOnly: *connectivityOnly, Unreachable: *unreachable, NoReflogs: *lostFound}
+
+	result, err := ggit.Fsck(opts)
+	for _, m := range result.Messages {
+		fmt.Fprintln(os.Stderr, m)
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+	for _, l := range result.BrokenLinks {
+		fmt.Printf("broken link from %7s %s\n              to %7s %s\n", l.FromType, l.From, l.ToType, l.To)
+	}
+	for _, o := range result.Objects {
+		if o.State == "dangling" && *noDangling {
+			continue
+		}
+		fmt.Printf("%s %s %s\n", o.State, o.Type, o.Hash)
+	}
+	if *lostFound {
+		if err := ggit.WriteLostFound(result.Objects); err != nil {
+			fatal("%v", err)
+		}
+	}
+	os.Exit(result.Status)
+}
@@ -0,0 +1,168 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jamesr/ggit"
+)
+
+// neverExpire is an expiry time that every object is older than.
+var neverExpire = time.Unix(1<<40, 0)
+
+func gc(args []string) {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	auto := fs.Bool("auto", false, "only collect garbage if there is enough of it")
+	prune := fs.String("prune", "", "prune loose objects older than this")
+	noPrune := fs.Bool("no-prune", false, "do not prune any loose objects")
+	aggressive := fs.Bool("aggressive", false, "look harder for deltas")
+	quiet := fs.Bool("quiet", false, "do not report what is happening")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: ggit gc [--aggressive] [--auto] [--quiet] [--prune=<date> | --no-prune]")
+		os.Exit(129)
+	}
+
+	opts := ggit.GCOptions{
+		ReflogExpire:            expiryTime("", "gc.reflogExpire", "90.days.ago"),
+		ReflogExpireUnreachable: expiryTime("", "gc.reflogExpireUnreachable", "30.days.ago"),
+		PruneExpire:             expiryTime(*prune, "gc.pruneExpire", "2.weeks.ago"),
+	}
+	if *noPrune {
+		opts.PruneExpire = time.Time{}
+	}
+	var err error
+	if opts.Pack, err = ggit.DefaultPackOptions(); err != nil {
+		fatal("%v", err)
+	}
+	if *aggressive {
+		window, err := ggit.ConfigInt("gc.aggressiveWindow", 250)
+		if err != nil {
+			fatal("%v", err)
+		}
+		depth, err := ggit.ConfigInt("gc.aggressiveDepth", 50)
+		if err != nil {
+			fatal("%v", err)
+		}
+		opts.Pack.Window, opts.Pack.Depth, opts.Pack.NoReuseDelta = int(window), int(depth), true
+	}
+
+	if *auto {
+		tooManyLoose, tooManyPacks, err := ggit.GCNeeded()
+		if err != nil {
+			fatal("%v", err)
+		}
+		if !tooManyLoose && !tooManyPacks {
+			return
+		}
+		if !*quiet {
+			fmt.Fprintln(os.Stderr, "Auto packing the repository for optimum performance.")
+			fmt.Fprintln(os.Stderr, `See "ggit help gc" for manual housekeeping.`)
+		}
+		if _, err := ggit.GCAuto(opts); err != nil {
+			fatal("%v", err)
+		}
+		return
+	}
+	if err := ggit.GC(opts); err != nil {
+		fatal("%v", err)
+	}
+}
+
+func repack(args []string) {
+	fs := flag.NewFlagSet("repack", flag.ExitOnError)
+	all := fs.Bool("a", false, "pack everything into a single pack")
+	allLoosen := fs.Bool("A", false, "like -a, but keep unreachable objects from the old packs loose")
+	del := fs.Bool("d", false, "remove redundant packs and loose objects")
+	noReuseDelta := fs.Bool("f", false, "do not reuse deltas from existing packs")
+	quiet := fs.Bool("q", false, "do not report progress")
+	opts := ggit.RepackOptions{}
+	var err error
+	if opts.Pack, err = ggit.DefaultPackOptions(); err != nil {
+		fatal("%v", err)
+	}
+	if opts.WriteBitmap, err = ggit.ConfigBool("repack.writeBitmaps", false); err != nil {
+		fatal("%v", err)
+	}
+	fs.BoolVar(&opts.WriteBitmap, "b", opts.WriteBitmap, "write a reachability bitmap for the new pack")
+	fs.BoolVar(&opts.WriteBitmap, "write-bitmap-index", opts.WriteBitmap, "write a reachability bitmap for the new pack")
+	fs.IntVar(&opts.Pack.Window, "window", opts.Pack.Window, "how many objects to compare when looking for deltas")
+	fs.IntVar(&opts.Pack.Depth, "depth", opts.Pack.Depth, "the longest chain of deltas allowed")
+	fs.IntVar(&opts.Pack.Threads, "threads", opts.Pack.Threads, "goroutines to search for deltas with; 0 for one per CPU")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: ggit repack [-a] [-A] [-d] [-f] [-q] [-b] [--window=<n>] [--depth=<n>] [--threads=<n>]")
+		os.Exit(129)
+	}
+	opts.All, opts.Delete, opts.Unpack = *all || *allLoosen, *del, *allLoosen
+	opts.Pack.NoReuseDelta = *noReuseDelta
+
+	name, err := ggit.Repack(opts)
+	if err != nil {
+		fatal("%v", err)
+	}
+	if name == "" && !*quiet {
+		fmt.Println("Nothing new to pack.")
+	}
+}
+
+func pruneCmd(args []string) {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	dryRun := fs.Bool("n", false, "do not remove anything; just report what would be removed")
+	verbose := fs.Bool("v", false, "report all removed objects")
+	expire := fs.String("expire", "", "only expire loose objects older than this")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: ggit prune [-n] [-v] [--expire <time>]")
+		os.Exit(129)
+	}
+	before := neverExpire
+	if *expire != "" {
+		before = expiryTime(*expire, "", "")
+	}
+	pruned, err := ggit.Prune(before, *dryRun)
+	if err != nil {
+		fatal("%v", err)
+	}
+	if *dryRun || *verbose {
+		for _, o := range pruned {
+			fmt.Printf("%s %s\n", o.Hash, o.Type)
+		}
+	}
+	if _, err := ggit.PrunePacked(*dryRun); err != nil {
+		fatal("%v", err)
+	}
+}
+
+func prunePacked(args []string) {
+	fs := flag.NewFlagSet("prune-packed", flag.ExitOnError)
+	dryRun := fs.Bool("n", false, "do not remove anything; just list what would be removed")
+	_ = fs.Bool("q", false, "do not report progress")
+	fs.Parse(args)
+	pruned, err := ggit.PrunePacked(*dryRun)
+	if err != nil {
+		fatal("%v", err)
+	}
+	if *dryRun {
+		for _, hash := range pruned {
+			fmt.Printf("rm -f .git/objects/%s/%s\n", hash[:2], hash[2:])
+		}
+	}
+}
+
+func packRefs(args []string) {
+	fs := flag.NewFlagSet("pack-refs", flag.ExitOnError)
+	all := fs.Bool("all", false, "pack every ref, not just tags and refs already packed")
+	_ = fs.Bool("prune", true, "remove the loose refs after packing them")
+	fs.Parse(args)
+	if err := ggit.PackRefs(*all); err != nil {
+		fatal("%v", err)
+	}
+}
@@ -12,34 +12,103 @@ import (
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"strings"
 	"time"
+
+	"github.com/jamesr/ggit"
 )
 
+// command is a ggit subcommand. Commands that don't parse their options
+// with the flag package give their usage for ggit help.
+type command struct {
+	run     func(args []string)
+	summary string
+	usage   string
+}
+
+var commands = map[string]command{
+	"add":              {run: add, summary: "Add file contents to the index"},
+	"branch":           {run: branch, summary: "List, create, or delete branches"},
+	"cat-file":         {run: catFile, summary: "Provide content or type and size information for repository objects"},
+	"checkout":         {run: checkout, summary: "Switch branches or restore working tree files"},
+	"commit":           {run: commitCmd, summary: "Record changes to the repository"},
+	"commit-graph":     {run: commitGraph, summary: "Write and verify Git commit-graph files"},
+	"commit-tree":      {run: commitTree, summary: "Create a new commit object"},
+	"config":           {run: config, summary: "Get and set repository or global options"},
+	"describe":         {run: describe, summary: "Give an object a human readable name based on an available ref"},
+	"dump-index":       {run: dumpIndex, summary: "Print the entries of an index file", usage: "ggit dump-index [<index-file>]"},
+	"for-each-ref":     {run: forEachRef, summary: "Output information on each ref"},
+	"fsck":             {run: fsck, summary: "Verifies the connectivity and validity of the objects in the database"},
+	"gc":               {run: gc, summary: "Cleanup unnecessary files and optimize the local repository"},
+	"index-pack":       {run: indexPack, summary: "Build pack index file for an existing packed archive"},
+	"log":              {run: logCmd, summary: "Show commit logs"},
+	"ls-files":         {run: lsFiles, summary: "Show information about files in the index and the working tree"},
+	"ls-tree":          {run: lsTree, summary: "List the contents of a tree object"},
+	"merge-base":       {run: mergeBase, summary: "Find as good common ancestors as possible for a merge"},
+	"multi-pack-index": {run: multiPackIndex, summary: "Write and verify multi-pack-indexes"},
+	"mv":               {run: mv, summary: "Move or rename a file, a directory, or a symlink"},
+	"pack-objects":     {run: packObjects, summary: "Create a packed archive of objects"},
+	"pack-refs":        {run: packRefs, summary: "Pack heads and tags for efficient repository access"},
+	"prune":            {run: pruneCmd, summary: "Prune all unreachable objects from the object database"},
+	"prune-packed":     {run: prunePacked, summary: "Remove extra objects that are already in pack files"},
+	"reflog":           {run: reflog, summary: "Manage reflog information"},
+	"repack":           {run: repack, summary: "Pack unpacked objects in a repository"},
+	"reset":            {run: reset, summary: "Reset current HEAD to the specified state"},
+	"restore":          {run: restore, summary: "Restore working tree files"},
+	"rev-list":         {run: revList, summary: "Lists commit objects in reverse chronological order"},
+	"rm":               {run: rm, summary: "Remove files from the working tree and from the index"},
+	"show-index":       {run: showIndex, summary: "Show packed archive index"},
+	"show-ref":         {run: showRef, summary: "List references in a local repository"},
+	"status":           {run: status, summary: "Show the working tree status", usage: "ggit status"},
+	"switch":           {run: switchCmd, summary: "Switch branches"},
+	"tag":              {run: tag, summary: "Create, list, delete or verify a tag object"},
+	"update-ref":       {run: updateRef, summary: "Update the object name stored in a ref safely"},
+	"verify-pack":      {run: verifyPack, summary: "Validate packed Git archive files"},
+	"write-tree":       {run: writeTree, summary: "Create a tree object from the current index"},
+}
+
+func init() {
+	// help lists the commands, so it can't be in the table's initializer.
+	commands["help"] = command{run: help, summary: "Display help information about ggit"}
+}
+
+// runCommand runs cmd as git would: a builtin command first, then a
+// ggit-<cmd> program on the PATH, and then an alias.
 func runCommand(cmd string, args []string) {
-	switch cmd {
-	case "branch":
-		branch(args)
-	case "cat-file":
-		catFile(args)
-	case "dump-index":
-		dumpIndex(args)
-	case "ls-files":
-		lsFiles(args)
-	case "ls-tree":
-		lsTree(args)
-	case "rev-list":
-		revList(args)
-	case "status":
-		status(args)
-	default:
-		fmt.Fprintln(os.Stderr, "Unknown command:", cmd)
+	expanded := []string(nil)
+	for {
+		if c, ok := commands[cmd]; ok {
+			c.run(args)
+			return
+		}
+		runExternal(cmd, args)
+		alias, ok := lookupAlias(cmd)
+		if !ok {
+			unknownCommand(cmd)
+		}
+		if strings.HasPrefix(alias, "!") {
+			runShellAlias(cmd, alias[1:], args)
+		}
+		words, err := splitCommandLine(alias)
+		if err != nil {
+			fatal("bad alias.%s string: %v", cmd, err)
+		}
+		if len(words) == 0 {
+			fmt.Fprintf(os.Stderr, "expansion of alias '%s' failed; '' is not a ggit command\n", cmd)
+			os.Exit(1)
+		}
+		expanded = append(expanded, cmd)
+		checkAliasLoop(expanded, words[0])
+		cmd, args = words[0], append(words[1:], args...)
 	}
 }
+
 func main() {
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
 	memprofile := flag.String("memprofile", "", "write memory profile to file")
 	bench := flag.Bool("bench", false, "loop for benchtime seconds and report time taken")
 	benchtime := flag.Int("benchtime", 5, "time to loop for (seconds) when benchmarking")
+	deltaStats := flag.Bool("deltastats", false, "report how the delta base cache did")
 	flag.Parse()
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
@@ -56,6 +125,13 @@ func main() {
 	}
 	cmd := flag.Arg(0)
 	args := flag.Args()[1:]
+	if *deltaStats {
+		defer func() {
+			s := ggit.ReadDeltaBaseCacheStats()
+			fmt.Fprintf(os.Stderr, "delta base cache: %d hits, %d misses (%.1f%%), %d entries, %d of %d bytes\n",
+				s.Hits, s.Misses, 100*s.HitRate(), s.Entries, s.Bytes, s.Limit)
+		}()
+	}
 	start := time.Now()
 	count := 0
 	if *bench {
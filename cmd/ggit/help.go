@@ -0,0 +1,181 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// externalCommands returns the names of the ggit-<name> programs on the
+// PATH.
+func externalCommands() []string {
+	names := []string(nil)
+	seen := make(map[string]bool)
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fi := range files {
+			name := strings.TrimPrefix(fi.Name(), "ggit-")
+			if name == fi.Name() || fi.IsDir() || fi.Mode()&0111 == 0 || seen[name] {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// printColumns prints names and descriptions, lined up.
+func printColumns(names []string, desc func(string) string) {
+	width := 0
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	for _, name := range names {
+		fmt.Println(strings.TrimRight(fmt.Sprintf("   %-*s   %s", width, name, desc(name)), " "))
+	}
+}
+
+func help(args []string) {
+	fs := flag.NewFlagSet("help", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		name := fs.Arg(0)
+		if c, ok := commands[name]; ok {
+			if c.usage != "" {
+				fmt.Println("usage: " + c.usage)
+				return
+			}
+			c.run([]string{"-h"})
+			return
+		}
+		if alias, ok := lookupAlias(name); ok {
+			fmt.Printf("'%s' is aliased to '%s'\n", name, alias)
+			return
+		}
+		unknownCommand(name)
+	}
+
+	fmt.Println("usage: ggit <command> [<args>]")
+	fmt.Println()
+	fmt.Println("ggit commands")
+	names := []string(nil)
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	printColumns(names, func(name string) string { return commands[name].summary })
+	if external := externalCommands(); len(external) > 0 {
+		fmt.Println()
+		fmt.Println("Commands available from elsewhere on your $PATH")
+		printColumns(external, func(string) string { return "" })
+	}
+	if all := aliases(); len(all) > 0 {
+		names = nil
+		for name := range all {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println()
+		fmt.Println("Command aliases")
+		printColumns(names, func(name string) string { return all[name] })
+	}
+	fmt.Println()
+	fmt.Println("See 'ggit help <command>' for the options of a command.")
+}
+
+// levenshtein returns the cost of editing a into b with git's weights for
+// swapping two adjacent characters, substituting one, inserting one and
+// deleting one.
+func levenshtein(a, b string, swap, subst, insert, del int) int {
+	row0 := make([]int, len(b)+1)
+	row1 := make([]int, len(b)+1)
+	row2 := make([]int, len(b)+1)
+	for j := range row1 {
+		row1[j] = j * insert
+	}
+	for i := 0; i < len(a); i++ {
+		row2[0] = (i + 1) * del
+		for j := 0; j < len(b); j++ {
+			row2[j+1] = row1[j]
+			if a[i] != b[j] {
+				row2[j+1] += subst
+			}
+			if i > 0 && j > 0 && a[i-1] == b[j] && a[i] == b[j-1] && row2[j+1] > row0[j-1]+swap {
+				row2[j+1] = row0[j-1] + swap
+			}
+			if row2[j+1] > row1[j+1]+del {
+				row2[j+1] = row1[j+1] + del
+			}
+			if row2[j+1] > row2[j]+insert {
+				row2[j+1] = row2[j] + insert
+			}
+		}
+		row0, row1, row2 = row1, row2, row0
+	}
+	return row1[len(b)]
+}
+
+// similarCommands returns the commands and aliases closest to name, if any
+// are close enough to suggest. As in git, a command that name begins is
+// closest of all.
+func similarCommands(name string) []string {
+	scores := make(map[string]int)
+	for cmd := range commands {
+		if strings.HasPrefix(cmd, name) {
+			scores[cmd] = 0
+		} else {
+			scores[cmd] = levenshtein(name, cmd, 0, 2, 1, 3) + 1
+		}
+	}
+	others := externalCommands()
+	for alias := range aliases() {
+		others = append(others, alias)
+	}
+	for _, cmd := range others {
+		if _, ok := scores[cmd]; !ok {
+			scores[cmd] = levenshtein(name, cmd, 0, 2, 1, 3) + 1
+		}
+	}
+	best := []string(nil)
+	for cmd, score := range scores {
+		if len(best) == 0 || score < scores[best[0]] {
+			best = []string{cmd}
+		} else if score == scores[best[0]] {
+			best = append(best, cmd)
+		}
+	}
+	const similarityFloor = 7
+	if len(best) == 0 || scores[best[0]] >= similarityFloor {
+		return nil
+	}
+	sort.Strings(best)
+	return best
+}
+
+// unknownCommand reports that name isn't a command, suggesting similar ones,
+// and exits.
+func unknownCommand(name string) {
+	fmt.Fprintf(os.Stderr, "ggit: '%s' is not a ggit command. See 'ggit help'.\n", name)
+	if similar := similarCommands(name); len(similar) == 1 {
+		fmt.Fprintf(os.Stderr, "\nThe most similar command is\n\t%s\n", similar[0])
+	} else if len(similar) > 1 {
+		fmt.Fprintf(os.Stderr, "\nThe most similar commands are\n\t%s\n", strings.Join(similar, "\n\t"))
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,109 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/jamesr/ggit"
+)
+
+// indexPackFailed reports why a pack couldn't be indexed and exits.
+func indexPackFailed(err error) {
+	if _, ok := err.(*ggit.PackObjectError); ok {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		fatal("fsck error in packed object")
+	}
+	fatal("%v", err)
+}
+
+func indexPack(args []string) {
+	fs := flag.NewFlagSet("index-pack", flag.ExitOnError)
+	stdin := fs.Bool("stdin", false, "read the pack from standard input")
+	fixThin := fs.Bool("fix-thin", false, "add delta bases missing from a thin pack")
+	strict := fs.Bool("strict", false, "refuse broken objects and links")
+	out := fs.String("o", "", "write the index to this file")
+	threads := fs.Int("threads", 0, "goroutines resolving deltas; 0 for one per CPU")
+	_ = fs.Bool("v", false, "be verbose")
+	rest := parseInterspersed(fs, args)
+	if len(rest) > 1 || (len(rest) == 0 && !*stdin) {
+		fmt.Fprintln(os.Stderr, "usage: ggit index-pack [-o <index-file>] [--stdin [--fix-thin]] [--strict] [<pack-file>]")
+		os.Exit(129)
+	}
+	if *fixThin && !*stdin {
+		fatal("the option '--fix-thin' requires '--stdin'")
+	}
+	opts := ggit.IndexPackOptions{FixThin: *fixThin, Strict: *strict, Threads: *threads}
+
+	if len(rest) == 0 {
+		// The pack goes in the repository, named for its checksum.
+		dir := ".git/objects/pack"
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			fatal("%v", err)
+		}
+		f, err := ioutil.TempFile(dir, "tmp_pack_")
+		if err != nil {
+			fatal("unable to create temporary file: %v", err)
+		}
+		f.Close()
+		entries, sum, err := ggit.IndexPack(f.Name(), os.Stdin, opts)
+		if err != nil {
+			os.Remove(f.Name())
+			indexPackFailed(err)
+		}
+		name := fmt.Sprintf("%x", sum)
+		base := dir + "/pack-" + name
+		if err := os.Chmod(f.Name(), 0444); err != nil {
+			os.Remove(f.Name())
+			fatal("%v", err)
+		}
+		if err := os.Rename(f.Name(), base+".pack"); err != nil {
+			os.Remove(f.Name())
+			fatal("%v", err)
+		}
+		idx := base + ".idx"
+		if *out != "" {
+			idx = *out
+		}
+		if err := ggit.WritePackIndexFile(idx, entries, sum); err != nil {
+			fatal("%v", err)
+		}
+		fmt.Printf("pack\t%s\n", name)
+		return
+	}
+
+	path := rest[0]
+	idx := *out
+	if idx == "" {
+		if !strings.HasSuffix(path, ".pack") {
+			fatal("packfile name '%s' does not end with '.pack'", path)
+		}
+		idx = strings.TrimSuffix(path, ".pack") + ".idx"
+	}
+	var entries []ggit.PackEntry
+	var sum [20]byte
+	var err error
+	if *stdin {
+		entries, sum, err = ggit.IndexPack(path, os.Stdin, opts)
+	} else {
+		entries, sum, err = ggit.IndexPack(path, nil, opts)
+	}
+	if err != nil {
+		indexPackFailed(err)
+	}
+	if err := ggit.WritePackIndexFile(idx, entries, sum); err != nil {
+		fatal("%v", err)
+	}
+	if *stdin {
+		fmt.Printf("pack\t%x\n", sum)
+	} else {
+		fmt.Printf("%x\n", sum)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jamesr/ggit"
+)
+
+// logStarted is set once a commit has been printed, so that the next one is
+// separated from it by a blank line.
+var logStarted bool
+
+// printLogEntry prints commit hash in git's medium or oneline format, with
+// the reflog selector and message first if entry is not nil.
+func printLogEntry(hash string, oneline bool, selector string, entry *ggit.ReflogEntry) error {
+	c, err := ggit.ReadCommit(hash)
+	if err != nil {
+		return err
+	}
+	if oneline {
+		if entry != nil {
+			fmt.Printf("%s %s: %s\n", hash[:7], selector, entry.Message)
+		} else {
+			fmt.Printf("%s %s\n", hash[:7], subject(c.Message()))
+		}
+		return nil
+	}
+	if logStarted {
+		fmt.Println()
+	}
+	logStarted = true
+	s := strings.TrimSuffix(c.String(), "    \n")
+	if entry != nil {
+		nl := strings.IndexByte(s, '\n')
+		s = fmt.Sprintf("%s\nReflog: %s (%s <%s>)\nReflog message: %s%s",
+			s[:nl], selector, entry.Who.Name, entry.Who.Email, entry.Message, s[nl:])
+	}
+	fmt.Print(s)
+	return nil
+}
+
+// walkReflog prints the commits in ref's reflog, most recent first.
+func walkReflog(name string, max int, oneline bool) error {
+	entries, err := ggit.ReadReflog(fullRefName(name))
+	if err != nil {
+		return err
+	}
+	for i := len(entries) - 1; i >= 0 && max != 0; i-- {
+		e := entries[i]
+		sel := fmt.Sprintf("%s@{%d}", name, len(entries)-1-i)
+		if err := printLogEntry(e.New, oneline, sel, &e); err != nil {
+			return err
+		}
+		max--
+	}
+	return nil
+}
+
+// walkHistory prints the commits reachable from tips, newest first. If
+// filter is not nil, only commits that change its paths are printed.
+func walkHistory(tips []string, max int, oneline bool, filter *ggit.PathFilter) error {
+	type queued struct {
+		hash string
+		date int64
+	}
+	queue := []queued(nil)
+	seen := make(map[string]bool)
+	push := func(hash string) error {
+		if seen[hash] {
+			return nil
+		}
+		seen[hash] = true
+		c, err := ggit.LookupCommitInfo(hash)
+		if err != nil {
+			return err
+		}
+		queue = append(queue, queued{hash, c.Date.Unix()})
+		return nil
+	}
+	for _, tip := range tips {
+		if err := push(tip); err != nil {
+			return err
+		}
+	}
+	for len(queue) > 0 && max != 0 {
+		sort.SliceStable(queue, func(i, j int) bool { return queue[i].date > queue[j].date })
+		next := queue[0]
+		queue = queue[1:]
+		c, err := ggit.LookupCommitInfo(next.hash)
+		if err != nil {
+			return err
+		}
+		show, parents := true, c.Parents
+		if filter != nil {
+			if show, parents, err = filter.Simplify(c); err != nil {
+				return err
+			}
+		}
+		if show {
+			if err := printLogEntry(next.hash, oneline, "", nil); err != nil {
+				return err
+			}
+			max--
+		}
+		for _, p := range parents {
+			if err := push(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func logCmd(args []string) {
+	fs := flag.NewFlagSet("log", flag.ExitOnError)
+	walkReflogs := fs.Bool("g", false, "walk the reflog instead of the commit ancestry")
+	fs.BoolVar(walkReflogs, "walk-reflogs", false, "same as -g")
+	maxCount := fs.Int("n", -1, "limit the number of commits to output")
+	fs.IntVar(maxCount, "max-count", -1, "same as -n")
+	oneline := fs.Bool("oneline", false, "show each commit on one line")
+	paths, dashDash := []string(nil), false
+	for i, arg := range args {
+		if arg == "--" {
+			paths, args, dashDash = args[i+1:], args[:i], true
+			break
+		}
+	}
+	fs.Parse(args)
+
+	revs := fs.Args()
+	for i, rev := range revs {
+		if _, err := ggit.ResolveRevision(rev); err != nil && !dashDash {
+			if _, err := os.Lstat(rev); err == nil {
+				// Like git, take what follows the revisions as paths.
+				revs, paths = revs[:i], append(revs[i:], paths...)
+				break
+			}
+		}
+	}
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+	if *walkReflogs {
+		for _, name := range revs {
+			if err := walkReflog(name, *maxCount, *oneline); err != nil {
+				fmt.Fprintln(os.Stderr, "fatal:", err)
+				os.Exit(128)
+			}
+		}
+		return
+	}
+	tips := []string(nil)
+	for _, rev := range revs {
+		hash, err := ggit.ResolveRevision(rev + "^{commit}")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: bad revision '%s'\n", rev)
+			os.Exit(128)
+		}
+		tips = append(tips, hash)
+	}
+	var filter *ggit.PathFilter
+	if len(paths) > 0 {
+		ps, err := ggit.ParsePathspec(paths)
+		if err != nil {
+			fatal("%v", err)
+		}
+		filter = ggit.NewPathFilter(ps)
+	}
+	if err := walkHistory(tips, *maxCount, *oneline, filter); err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jamesr/ggit"
+)
+
+func mergeBase(args []string) {
+	fs := flag.NewFlagSet("merge-base", flag.ExitOnError)
+	all := fs.Bool("all", false, "output all common ancestors")
+	isAncestor := fs.Bool("is-ancestor", false, "exit 0 if the first commit is an ancestor of the second, 1 if not")
+	fs.Parse(args)
+	if fs.NArg() < 2 || *isAncestor && fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: ggit merge-base [-a | --all] <commit> <commit>...")
+		fmt.Fprintln(os.Stderr, "   or: ggit merge-base --is-ancestor <commit> <commit>")
+		os.Exit(129)
+	}
+	commits := []string(nil)
+	for _, rev := range fs.Args() {
+		hash, err := ggit.ResolveRevision(rev + "^{commit}")
+		if err != nil {
+			fatal("Not a valid object name %s", rev)
+		}
+		commits = append(commits, hash)
+	}
+
+	if *isAncestor {
+		ok, err := ggit.IsAncestor(commits[0], commits[1])
+		if err != nil {
+			fatal("%v", err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+	bases, err := ggit.MergeBases(commits[0], commits[1:]...)
+	if err != nil {
+		fatal("%v", err)
+	}
+	if len(bases) == 0 {
+		os.Exit(1)
+	}
+	if !*all {
+		bases = bases[:1]
+	}
+	for _, hash := range bases {
+		fmt.Println(hash)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jamesr/ggit"
+)
+
+const multiPackIndexUsage = `usage: ggit multi-pack-index write [--preferred-pack=<pack>] [--bitmap]
+   or: ggit multi-pack-index verify
+   or: ggit multi-pack-index expire
+   or: ggit multi-pack-index repack [--batch-size=<size>]`
+
+func multiPackIndex(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, multiPackIndexUsage)
+		os.Exit(129)
+	}
+	fs := flag.NewFlagSet("multi-pack-index "+args[0], flag.ExitOnError)
+	var preferred, batchSize *string
+	var bitmap *bool
+	switch args[0] {
+	case "write":
+		preferred = fs.String("preferred-pack", "", "pack to prefer when an object is in several packs")
+		bitmap = fs.Bool("bitmap", false, "write a reachability bitmap too")
+	case "repack":
+		batchSize = fs.String("batch-size", "0", "gather packs smaller than this into one about this size")
+	case "verify", "expire":
+	default:
+		fmt.Fprintf(os.Stderr, "error: unrecognized subcommand: %s\n", args[0])
+		fmt.Fprintln(os.Stderr, multiPackIndexUsage)
+		os.Exit(129)
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, multiPackIndexUsage)
+		os.Exit(129)
+	}
+
+	switch args[0] {
+	case "write":
+		if err := ggit.WriteMultiPackIndex(ggit.MultiPackIndexOptions{Preferred: *preferred, Bitmap: *bitmap}); err != nil {
+			fatal("%v", err)
+		}
+	case "verify":
+		if err := ggit.VerifyMultiPackIndex(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	case "expire":
+		if _, err := ggit.ExpireMultiPackIndex(); err != nil {
+			fatal("%v", err)
+		}
+	case "repack":
+		size, err := ggit.ParseMagnitude(*batchSize)
+		if err != nil {
+			fatal("invalid batch size '%s': %v", *batchSize, err)
+		}
+		opts, err := ggit.DefaultPackOptions()
+		if err != nil {
+			fatal("%v", err)
+		}
+		if _, err := ggit.RepackMultiPackIndex(size, opts); err != nil {
+			fatal("%v", err)
+		}
+	}
+}
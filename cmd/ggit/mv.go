@@ -0,0 +1,117 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/jamesr/ggit"
+)
+
+// mvCheck returns why src can't be moved to dst, or "" if it can.
+func mvCheck(idx *ggit.Index, src, dst string, force bool) string {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return "bad source"
+	}
+	if dst == src || strings.HasPrefix(dst, src+"/") {
+		return "can not move directory into itself"
+	}
+	if fi.IsDir() {
+		tracked := false
+		for _, e := range idx.Entries {
+			if strings.HasPrefix(string(e.Path), src+"/") {
+				tracked = true
+				break
+			}
+		}
+		if !tracked {
+			return "source directory is empty"
+		}
+	} else if e, ok := idx.Entry(src); !ok {
+		return "not under version control"
+	} else if e.Stage() != 0 {
+		return "conflicted"
+	}
+	if dfi, err := os.Lstat(dst); err == nil {
+		if !force || dfi.IsDir() || fi.IsDir() {
+			return "destination exists"
+		}
+	}
+	return ""
+}
+
+func mv(args []string) {
+	fs := flag.NewFlagSet("mv", flag.ExitOnError)
+	force := fs.Bool("f", false, "force renaming even if the destination exists")
+	fs.BoolVar(force, "force", false, "same as -f")
+	skipErrors := fs.Bool("k", false, "skip moves that would lead to an error")
+	dryRun := fs.Bool("n", false, "do nothing; only show what would happen")
+	fs.BoolVar(dryRun, "dry-run", false, "same as -n")
+	verbose := fs.Bool("v", false, "report the names of files as they are moved")
+	fs.BoolVar(verbose, "verbose", false, "same as -v")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "Usage: ggit mv [-f] [-k] [-n] [-v] <source>... <destination>")
+		os.Exit(1)
+	}
+	sources := fs.Args()[:fs.NArg()-1]
+	dest := path.Clean(fs.Arg(fs.NArg() - 1))
+	destIsDir := false
+	if fi, err := os.Stat(dest); err == nil && fi.IsDir() {
+		destIsDir = true
+	} else if len(sources) > 1 {
+		fmt.Fprintf(os.Stderr, "fatal: destination '%s' is not a directory\n", dest)
+		os.Exit(128)
+	}
+	idx, err := ggit.ReadIndex(".git/index")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error reading index:", err)
+		os.Exit(1)
+	}
+
+	for _, src := range sources {
+		src = path.Clean(src)
+		dst := dest
+		if destIsDir {
+			dst = path.Join(dest, path.Base(src))
+		}
+		if why := mvCheck(idx, src, dst, *force); why != "" {
+			if *skipErrors {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "fatal: %s, source=%s, destination=%s\n", why, src, dst)
+			os.Exit(128)
+		}
+		if *verbose || *dryRun {
+			fmt.Printf("Renaming %s to %s\n", src, dst)
+		}
+		if *dryRun {
+			continue
+		}
+		if err := os.MkdirAll(path.Dir(dst), 0777); err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		if err := os.Rename(src, dst); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: renaming '%s' failed: %v\n", src, err)
+			os.Exit(128)
+		}
+		idx.Remove(dst)
+		idx.Move(src, dst)
+	}
+	if *dryRun {
+		return
+	}
+	if err := idx.Write(".git/index"); err != nil {
+		fmt.Fprintln(os.Stderr, "error writing index:", err)
+		os.Exit(1)
+	}
+}
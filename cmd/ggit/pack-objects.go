@@ -0,0 +1,128 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jamesr/ggit"
+)
+
+// packRevisions resolves the revisions pack-objects --revs reads: a
+// revision to include on each line, or to exclude after "^" or "--not".
+func packRevisions(lines []string, all bool) (include, exclude []string) {
+	if all {
+		refs, err := ggit.ListRefs("refs/")
+		if err != nil {
+			fatal("%v", err)
+		}
+		for _, r := range refs {
+			include = append(include, r.Hash)
+		}
+		if hash, err := ggit.ResolveRevision("HEAD"); err == nil {
+			include = append(include, hash)
+		}
+	}
+	not := false
+	for _, line := range lines {
+		if line == "--not" {
+			not = !not
+			continue
+		}
+		if strings.HasPrefix(line, "-") {
+			fatal("not a rev '%s'", line)
+		}
+		excluded := not
+		if strings.HasPrefix(line, "^") {
+			line, excluded = line[1:], !excluded
+		}
+		hash, err := ggit.ResolveRevision(line)
+		if err != nil {
+			fatal("bad revision '%s'", line)
+		}
+		if excluded {
+			exclude = append(exclude, hash)
+		} else {
+			include = append(include, hash)
+		}
+	}
+	return include, exclude
+}
+
+func packObjects(args []string) {
+	fs := flag.NewFlagSet("pack-objects", flag.ExitOnError)
+	stdout := fs.Bool("stdout", false, "write the pack to standard output")
+	revs := fs.Bool("revs", false, "read revisions from standard input and pack the objects they reach")
+	all := fs.Bool("all", false, "with --revs, pack everything reachable from refs")
+	noReuseDelta := fs.Bool("no-reuse-delta", false, "do not reuse deltas from existing packs")
+	_ = fs.Bool("delta-base-offset", true, "deltas are always written as OFS_DELTA")
+	_ = fs.Bool("q", false, "do not report progress")
+	opts, err := ggit.DefaultPackOptions()
+	if err != nil {
+		fatal("%v", err)
+	}
+	fs.IntVar(&opts.Window, "window", opts.Window, "how many objects to compare when looking for deltas")
+	fs.IntVar(&opts.Depth, "depth", opts.Depth, "the longest chain of deltas allowed")
+	fs.IntVar(&opts.Threads, "threads", opts.Threads, "goroutines to search for deltas with; 0 for one per CPU")
+	rest := parseInterspersed(fs, args)
+	opts.NoReuseDelta = *noReuseDelta
+	if *stdout == (len(rest) == 1) || len(rest) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: ggit pack-objects [<options>] [< <ref-list> | < <object-list>] (--stdout | <base-name>)")
+		os.Exit(129)
+	}
+	if *all && !*revs {
+		fatal("--all needs --revs")
+	}
+
+	lines := []string(nil)
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() {
+		if line := s.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		fatal("%v", err)
+	}
+	objects := []ggit.ListedObject(nil)
+	if *revs {
+		include, exclude := packRevisions(lines, *all)
+		if objects, err = ggit.ListObjects(include, exclude); err != nil {
+			fatal("%v", err)
+		}
+	} else {
+		for _, line := range lines {
+			o := ggit.ListedObject{Hash: line}
+			if sp := strings.IndexByte(line, ' '); sp != -1 {
+				o = ggit.ListedObject{Hash: line[:sp], Name: line[sp+1:]}
+			}
+			if len(o.Hash) != 40 || !isHexString(o.Hash) {
+				fatal("expected object ID, got garbage:\n %s", line)
+			}
+			objects = append(objects, o)
+		}
+	}
+
+	if *stdout {
+		if _, _, err := ggit.WritePack(os.Stdout, objects, opts); err != nil {
+			fatal("%v", err)
+		}
+		return
+	}
+	name, err := ggit.WritePackFiles(rest[0], objects, opts)
+	if err != nil {
+		fatal("%v", err)
+	}
+	fmt.Println(name)
+}
+
+func isHexString(s string) bool {
+	return strings.Trim(s, "0123456789abcdef") == ""
+}
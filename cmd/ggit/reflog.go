@@ -0,0 +1,181 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamesr/ggit"
+)
+
+// fullRefName expands a short ref name such as "master" for reflog commands,
+// which also accept refs that only have a reflog.
+func fullRefName(name string) string {
+	if name == "HEAD" || strings.HasPrefix(name, "refs/") {
+		return name
+	}
+	if ref, _, err := ggit.DwimRef(name); err == nil && ref != "" {
+		return ref
+	}
+	for _, full := range []string{"refs/heads/" + name, "refs/tags/" + name, "refs/remotes/" + name} {
+		if ggit.ReflogExists(full) {
+			return full
+		}
+	}
+	return name
+}
+
+// splitSelector splits "ref@{n}" into the ref and n.
+func splitSelector(s string) (string, int, bool) {
+	at := strings.LastIndex(s, "@{")
+	if at == -1 || !strings.HasSuffix(s, "}") {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(s[at+2 : len(s)-1])
+	if err != nil {
+		return "", 0, false
+	}
+	ref := s[:at]
+	if ref == "" {
+		ref = "HEAD"
+	}
+	return ref, n, true
+}
+
+func reflogShow(args []string) {
+	fs := flag.NewFlagSet("reflog show", flag.ExitOnError)
+	maxCount := fs.Int("n", -1, "limit the number of entries to show")
+	fs.Parse(args)
+	name := "HEAD"
+	if fs.NArg() > 0 {
+		name = fs.Arg(0)
+	}
+	entries, err := ggit.ReadReflog(fullRefName(name))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	for i := len(entries) - 1; i >= 0 && *maxCount != 0; i-- {
+		e := entries[i]
+		fmt.Printf("%s %s@{%d}: %s\n", e.New[:7], name, len(entries)-1-i, e.Message)
+		*maxCount--
+	}
+}
+
+// expiryTime parses value, or if it is empty the value of config key or def,
+// as an expiry time.
+func expiryTime(value, key, def string) time.Time {
+	if value == "" {
+		value, _ = ggit.ConfigValue(key)
+	}
+	if value == "" {
+		value = def
+	}
+	t, err := ggit.ApproxiDate(value)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	return t
+}
+
+func reflogExpire(args []string) {
+	fs := flag.NewFlagSet("reflog expire", flag.ExitOnError)
+	expire := fs.String("expire", "", "prune entries older than this")
+	expireUnreachable := fs.String("expire-unreachable", "", "prune unreachable entries older than this")
+	all := fs.Bool("all", false, "process the reflogs of all refs")
+	dryRun := fs.Bool("n", false, "do not actually prune any entries")
+	fs.BoolVar(dryRun, "dry-run", false, "same as -n")
+	verbose := fs.Bool("verbose", false, "print extra information")
+	fs.Parse(args)
+	before := expiryTime(*expire, "gc.reflogExpire", "90.days.ago")
+	beforeUnreachable := expiryTime(*expireUnreachable, "gc.reflogExpireUnreachable", "30.days.ago")
+
+	refs := []string(nil)
+	for _, name := range fs.Args() {
+		refs = append(refs, fullRefName(name))
+	}
+	if *all {
+		var err error
+		if refs, err = ggit.ReflogRefs(); err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+	}
+	for _, ref := range refs {
+		n, err := ggit.ExpireReflog(ref, before, beforeUnreachable, *dryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: expiring reflog of %s: %v\n", ref, err)
+			os.Exit(1)
+		}
+		if *verbose || *dryRun {
+			fmt.Printf("%s: pruned %d entries\n", ref, n)
+		}
+	}
+}
+
+func reflogDelete(args []string) {
+	fs := flag.NewFlagSet("reflog delete", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "fatal: no reflog specified to delete")
+		os.Exit(128)
+	}
+	type selector struct {
+		ref string
+		n   int
+	}
+	sels := []selector(nil)
+	for _, arg := range fs.Args() {
+		ref, n, ok := splitSelector(arg)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "fatal: not a reflog: %s\n", arg)
+			os.Exit(128)
+		}
+		sels = append(sels, selector{fullRefName(ref), n})
+	}
+	// Delete the oldest entries first so earlier deletions don't shift the
+	// numbering of later ones.
+	sort.SliceStable(sels, func(i, j int) bool { return sels[i].n > sels[j].n })
+	for _, s := range sels {
+		if err := ggit.DeleteReflogEntry(s.ref, s.n); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+	}
+}
+
+func reflog(args []string) {
+	sub := "show"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "show", "expire", "delete", "exists":
+			sub, args = args[0], args[1:]
+		}
+	}
+	switch sub {
+	case "show":
+		reflogShow(args)
+	case "expire":
+		reflogExpire(args)
+	case "delete":
+		reflogDelete(args)
+	case "exists":
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, "Usage: ggit reflog exists <ref>")
+			os.Exit(1)
+		}
+		if !ggit.ReflogExists(fullRefName(args[0])) {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jamesr/ggit"
+)
+
+// printUnstaged lists the tracked files whose worktree contents differ from
+// the index, as git does after a mixed reset.
+func printUnstaged(idx *ggit.Index) {
+	header := false
+	for _, e := range idx.Entries {
+		if e.Stage() != 0 {
+			continue
+		}
+		p := string(e.Path)
+		status := ""
+		if fi, err := os.Lstat(p); err != nil {
+			status = "D"
+		} else if !e.StatMatches(fi) {
+			if hash, err := ggit.HashFile(p, fi, false); err != nil || hash != fmt.Sprintf("%x", e.Hash) {
+				status = "M"
+			}
+		}
+		if status == "" {
+			continue
+		}
+		if !header {
+			fmt.Println("Unstaged changes after reset:")
+			header = true
+		}
+		fmt.Printf("%s\t%s\n", status, p)
+	}
+}
+
+func reset(args []string) {
+	fs := flag.NewFlagSet("reset", flag.ExitOnError)
+	soft := fs.Bool("soft", false, "only move HEAD")
+	mixed := fs.Bool("mixed", false, "reset HEAD and the index (the default)")
+	hard := fs.Bool("hard", false, "reset HEAD, the index and the worktree")
+	keep := fs.Bool("keep", false, "reset HEAD and the index, keeping local changes")
+	quiet := fs.Bool("q", false, "only report errors")
+	fs.BoolVar(quiet, "quiet", false, "same as -q")
+	before, paths, dashDash := splitDashDash(args)
+	fs.Parse(before)
+
+	rev := "HEAD"
+	rest := fs.Args()
+	if len(rest) > 0 {
+		if _, err := ggit.ResolveRevision(rest[0] + "^{tree}"); err == nil || dashDash {
+			rev, rest = rest[0], rest[1:]
+		}
+	}
+	if dashDash && len(rest) > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: ggit reset [--soft|--mixed|--hard|--keep] [<commit>] | [<tree-ish>] -- <paths>...")
+		os.Exit(1)
+	}
+	paths = append(rest, paths...)
+
+	mode, modes := ggit.ResetMixed, 0
+	for _, m := range []struct {
+		set  bool
+		mode ggit.ResetMode
+		name string
+	}{{*soft, ggit.ResetSoft, "soft"}, {*mixed, ggit.ResetMixed, "mixed"},
+		{*hard, ggit.ResetHard, "hard"}, {*keep, ggit.ResetKeep, "keep"}} {
+		if !m.set {
+			continue
+		}
+		mode = m.mode
+		modes++
+		if len(paths) > 0 && m.mode != ggit.ResetMixed {
+			fmt.Fprintf(os.Stderr, "fatal: Cannot do %s reset with paths.\n", m.name)
+			os.Exit(128)
+		}
+	}
+	if modes > 1 {
+		fmt.Fprintln(os.Stderr, "fatal: only one of --soft, --mixed, --hard and --keep may be given")
+		os.Exit(128)
+	}
+
+	idx, err := ggit.ReadIndex(".git/index")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error reading index:", err)
+		os.Exit(1)
+	}
+	if len(paths) > 0 {
+		ps, err := ggit.ParsePathspec(paths)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		tree, err := ggit.ResolveRevision(rev + "^{tree}")
+		if err != nil {
+			if _, head, _ := ggit.ResolveRef("HEAD"); rev != "HEAD" || head != "" {
+				fmt.Fprintf(os.Stderr, "fatal: ambiguous argument '%s': unknown revision or path not in the working tree.\n", rev)
+				os.Exit(128)
+			}
+			// Resetting paths on an unborn branch unstages them.
+			tree = ""
+		}
+		if err := ggit.ResetIndex(idx, tree, ps); err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		if err := idx.Write(".git/index"); err != nil {
+			fmt.Fprintln(os.Stderr, "error writing index:", err)
+			os.Exit(1)
+		}
+		if !*quiet {
+			printUnstaged(idx)
+		}
+		return
+	}
+
+	commit, err := ggit.ResolveRevision(rev + "^{commit}")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: ambiguous argument '%s': unknown revision or path not in the working tree.\n", rev)
+		os.Exit(128)
+	}
+	err = ggit.Reset(ggit.DirWorktree("."), idx, mode, commit, ggit.ReflogSignature(), "reset: moving to "+rev)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		if _, ok := err.(*ggit.CheckoutError); ok {
+			fmt.Fprintf(os.Stderr, "fatal: Could not reset index file to revision '%s'.\n", rev)
+			os.Exit(128)
+		}
+		os.Exit(1)
+	}
+	if mode != ggit.ResetSoft {
+		if err := idx.Write(".git/index"); err != nil {
+			fmt.Fprintln(os.Stderr, "error writing index:", err)
+			os.Exit(1)
+		}
+	}
+	if *quiet {
+		return
+	}
+	switch mode {
+	case ggit.ResetHard:
+		c, err := ggit.ReadCommit(commit)
+		if err == nil {
+			fmt.Printf("HEAD is now at %s %s\n", commit[:7], subject(c.Message()))
+			c.Close()
+		}
+	case ggit.ResetMixed:
+		printUnstaged(idx)
+	}
+}
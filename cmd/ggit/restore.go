@@ -0,0 +1,75 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jamesr/ggit"
+)
+
+func restore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	source := fs.String("source", "", "restore from the given tree-ish")
+	fs.StringVar(source, "s", "", "same as --source")
+	staged := fs.Bool("staged", false, "restore the index")
+	fs.BoolVar(staged, "S", false, "same as --staged")
+	worktree := fs.Bool("worktree", false, "restore the worktree (the default)")
+	fs.BoolVar(worktree, "W", false, "same as --worktree")
+	before, paths, _ := splitDashDash(args)
+	fs.Parse(before)
+	paths = append(fs.Args(), paths...)
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "fatal: you must specify path(s) to restore")
+		os.Exit(128)
+	}
+	if !*staged {
+		*worktree = true
+	}
+
+	tree, unborn := "", false
+	rev := *source
+	if rev == "" && *staged {
+		rev = "HEAD"
+	}
+	if rev != "" {
+		hash, err := ggit.ResolveRevision(rev + "^{tree}")
+		if err != nil {
+			if _, head, _ := ggit.ResolveRef("HEAD"); rev != "HEAD" || head != "" {
+				fmt.Fprintf(os.Stderr, "fatal: could not resolve %s\n", rev)
+				os.Exit(128)
+			}
+			// With no commits yet, restoring the index just unstages.
+			unborn = true
+		}
+		tree = hash
+	}
+	ps, err := ggit.ParsePathspec(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	idx, err := ggit.ReadIndex(".git/index")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error reading index:", err)
+		os.Exit(1)
+	}
+	if unborn {
+		err = ggit.ResetIndex(idx, "", ps)
+	} else {
+		err = ggit.RestorePaths(ggit.DirWorktree("."), idx, tree, ps, *staged, *worktree)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	if err := idx.Write(".git/index"); err != nil {
+		fmt.Fprintln(os.Stderr, "error writing index:", err)
+		os.Exit(1)
+	}
+}
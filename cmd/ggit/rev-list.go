@@ -6,6 +6,7 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"os"
@@ -36,17 +37,17 @@ func printCommitChain(hash string) error {
 		fmt.Println(strings.Join(buf[:i], "\n"))
 		done <- nil
 	}()
+	hash = ggit.CommitishToHash(hash)
 	for {
-		c, err := ggit.ReadCommit(hash)
+		c, err := ggit.LookupCommitInfo(hash)
 		if err != nil {
 			return err
 		}
 		hashes <- c.Hash
-		if len(c.Parent) == 0 {
+		if len(c.Parents) == 0 {
 			break
 		}
-		hash = c.Parent[0]
-		c.Close()
+		hash = c.Parents[0]
 	}
 	close(hashes)
 	<-done
@@ -55,16 +56,64 @@ func printCommitChain(hash string) error {
 
 func revList(args []string) {
 	fs := flag.NewFlagSet("rev-list", flag.ExitOnError)
-	_ = fs.Bool("first-parent", false, "prints only the first parent")
-	fs.Parse(args)
-	if fs.NArg() < 1 {
-		fmt.Fprintf(os.Stderr, "Usage: ggit rev-list <hash>\n")
+	firstParent := fs.Bool("first-parent", false, "prints only the first parent")
+	objects := fs.Bool("objects", false, "list the tags, trees and blobs the commits reach too")
+	count := fs.Bool("count", false, "print how many objects would be listed instead")
+	useBitmap := fs.Bool("use-bitmap-index", false, "find the objects with the packs' reachability bitmap")
+	revs := parseInterspersed(fs, args)
+	if len(revs) < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: ggit rev-list [--objects] [--count] [--use-bitmap-index] <commit>... [^<commit>...]\n")
 		os.Exit(1)
 	}
-	hash := fs.Arg(0)
-	err := printCommitChain(hash)
+	if *firstParent {
+		if err := printCommitChain(revs[0]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	include, exclude := []string(nil), []string(nil)
+	for _, rev := range revs {
+		list := &include
+		if strings.HasPrefix(rev, "^") {
+			list, rev = &exclude, rev[1:]
+		}
+		hash, err := ggit.ResolveRevision(rev)
+		if err != nil {
+			fatal("bad revision '%s'", rev)
+		}
+		*list = append(*list, hash)
+	}
+	var listed []ggit.ListedObject
+	var err error
+	bitmapped := false
+	if *useBitmap {
+		listed, bitmapped, err = ggit.BitmapObjects(include, exclude)
+	}
+	if !bitmapped && err == nil {
+		listed, err = ggit.WalkObjects(include, exclude)
+	}
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		fatal("%v", err)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	n := 0
+	for _, o := range listed {
+		if o.Type != "commit" && !*objects {
+			continue
+		}
+		n++
+		switch {
+		case *count:
+		case o.Type == "commit" || bitmapped:
+			// Objects found through a bitmap have no names to print.
+			fmt.Fprintln(w, o.Hash)
+		default:
+			fmt.Fprintf(w, "%s %s\n", o.Hash, o.Name)
+		}
+	}
+	if *count {
+		fmt.Fprintln(w, n)
 	}
 }
@@ -0,0 +1,149 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/jamesr/ggit"
+)
+
+// removeEmptyParents removes the directories containing p that are left
+// empty, stopping at the top of the worktree.
+func removeEmptyParents(p string) {
+	for dir := path.Dir(p); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// rmCheck returns why p shouldn't be removed without --force, or "" if it
+// is safe to remove.
+func rmCheck(e ggit.Entry, headTree string, cached bool) string {
+	p := string(e.Path)
+	stagedDiffers := true
+	if headTree != "" {
+		_, hash, err := ggit.LookupPath(headTree, p)
+		stagedDiffers = err != nil || hash != fmt.Sprintf("%x", e.Hash)
+	}
+	worktreeDiffers := false
+	if fi, err := os.Lstat(p); err == nil && !e.StatMatches(fi) {
+		hash, err := ggit.HashFile(p, fi, false)
+		worktreeDiffers = err != nil || hash != fmt.Sprintf("%x", e.Hash)
+	}
+	switch {
+	case stagedDiffers && worktreeDiffers:
+		return "has staged content different from both the file and the HEAD"
+	case cached:
+		return ""
+	case stagedDiffers:
+		return "has changes staged in the index"
+	case worktreeDiffers:
+		return "has local modifications"
+	}
+	return ""
+}
+
+func rm(args []string) {
+	fs := flag.NewFlagSet("rm", flag.ExitOnError)
+	cached := fs.Bool("cached", false, "only remove from the index, keeping the worktree files")
+	recursive := fs.Bool("r", false, "allow recursive removal when a leading directory name is given")
+	force := fs.Bool("f", false, "override the up-to-date check")
+	fs.BoolVar(force, "force", false, "same as -f")
+	dryRun := fs.Bool("n", false, "don't actually remove any files, just show what would happen")
+	fs.BoolVar(dryRun, "dry-run", false, "same as -n")
+	quiet := fs.Bool("q", false, "don't print the removed files")
+	fs.BoolVar(quiet, "quiet", false, "same as -q")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: ggit rm [--cached] [-r] [-f] [-n] [-q] <pathspec>...")
+		os.Exit(1)
+	}
+	ps, err := ggit.ParsePathspec(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	idx, err := ggit.ReadIndex(".git/index")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error reading index:", err)
+		os.Exit(1)
+	}
+
+	matched := []ggit.Entry(nil)
+	paths := []string(nil)
+	for _, e := range idx.Entries {
+		p := string(e.Path)
+		if ps.Match(p) && (len(paths) == 0 || paths[len(paths)-1] != p) {
+			matched = append(matched, e)
+			paths = append(paths, p)
+		}
+	}
+	for _, p := range ps.Unmatched(paths) {
+		fmt.Fprintf(os.Stderr, "fatal: pathspec '%s' did not match any files\n", p)
+		os.Exit(128)
+	}
+	if !*recursive {
+		for _, p := range paths {
+			if ps.MatchesAsDirectory(p) {
+				fmt.Fprintf(os.Stderr, "fatal: not removing '%s' recursively without -r\n", path.Dir(p))
+				os.Exit(128)
+			}
+		}
+	}
+
+	if !*force {
+		headTree := ""
+		if _, head, err := ggit.ResolveRef("HEAD"); err == nil && head != "" {
+			if c, err := ggit.ReadCommit(head); err == nil {
+				headTree = c.Tree
+				c.Close()
+			}
+		}
+		failed := false
+		for _, e := range matched {
+			if e.Stage() != 0 {
+				continue
+			}
+			if why := rmCheck(e, headTree, *cached); why != "" {
+				fmt.Fprintf(os.Stderr, "error: '%s' %s\n", e.Path, why)
+				failed = true
+			}
+		}
+		if failed {
+			fmt.Fprintln(os.Stderr, "(use --cached to keep the file, or -f to force removal)")
+			os.Exit(1)
+		}
+	}
+
+	for _, p := range paths {
+		if !*quiet {
+			fmt.Printf("rm '%s'\n", p)
+		}
+		if *dryRun {
+			continue
+		}
+		idx.Remove(p)
+		if !*cached {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintln(os.Stderr, "fatal:", err)
+				os.Exit(128)
+			}
+			removeEmptyParents(p)
+		}
+	}
+	if *dryRun {
+		return
+	}
+	if err := idx.Write(".git/index"); err != nil {
+		fmt.Fprintln(os.Stderr, "error writing index:", err)
+		os.Exit(1)
+	}
+}
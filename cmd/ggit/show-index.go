@@ -0,0 +1,40 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/jamesr/ggit"
+)
+
+func showIndex(args []string) {
+	fs := flag.NewFlagSet("show-index", flag.ExitOnError)
+	_ = fs.String("object-format", "sha1", "the hash algorithm the index uses")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: ggit show-index [--object-format=<hash-algorithm>]")
+		os.Exit(129)
+	}
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fatal("%v", err)
+	}
+	idx, err := ggit.ReadPackIndex(data)
+	if err != nil {
+		fatal("%v", err)
+	}
+	for _, e := range idx.Entries {
+		if idx.Version == 1 {
+			fmt.Printf("%d %x\n", e.Offset, e.Hash)
+		} else {
+			fmt.Printf("%d %x (%08x)\n", e.Offset, e.Hash, e.CRC32)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jamesr/ggit"
+)
+
+func showRef(args []string) {
+	fs := flag.NewFlagSet("show-ref", flag.ExitOnError)
+	heads := fs.Bool("heads", false, "only show branches")
+	tags := fs.Bool("tags", false, "only show tags")
+	head := fs.Bool("head", false, "show HEAD too")
+	verify := fs.Bool("verify", false, "require each argument to be an exact ref name")
+	deref := fs.Bool("d", false, "also show what annotated tags point to")
+	fs.BoolVar(deref, "dereference", false, "same as -d")
+	hashOnly := fs.Bool("s", false, "only show object names")
+	fs.BoolVar(hashOnly, "hash", false, "same as -s")
+	quiet := fs.Bool("q", false, "don't print anything; just set the exit status")
+	fs.BoolVar(quiet, "quiet", false, "same as -q")
+	fs.Parse(args)
+
+	show := func(r ggit.Ref) {
+		if *quiet {
+			return
+		}
+		if *hashOnly {
+			fmt.Println(r.Hash)
+		} else {
+			fmt.Println(r.Hash, r.Name)
+		}
+		if !*deref {
+			return
+		}
+		if peeled, err := ggit.ResolveRevision(r.Hash + "^{}"); err == nil && peeled != r.Hash {
+			if *hashOnly {
+				fmt.Println(peeled)
+			} else {
+				fmt.Printf("%s %s^{}\n", peeled, r.Name)
+			}
+		}
+	}
+
+	if *verify {
+		if fs.NArg() == 0 {
+			fmt.Fprintln(os.Stderr, "fatal: --verify requires a reference")
+			os.Exit(128)
+		}
+		for _, name := range fs.Args() {
+			hash := ""
+			if name == "HEAD" || strings.HasPrefix(name, "refs/") {
+				_, hash, _ = ggit.ResolveRef(name)
+			}
+			if hash == "" {
+				if *quiet {
+					os.Exit(1)
+				}
+				fmt.Fprintf(os.Stderr, "fatal: '%s' - not a valid ref\n", name)
+				os.Exit(128)
+			}
+			show(ggit.Ref{Name: name, Hash: hash})
+		}
+		return
+	}
+
+	refs, err := ggit.ListRefs("refs/")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	if *head {
+		if _, hash, err := ggit.ResolveRef("HEAD"); err == nil && hash != "" {
+			refs = append([]ggit.Ref{{Name: "HEAD", Hash: hash}}, refs...)
+		}
+	}
+	found := false
+	for _, r := range refs {
+		if (*heads || *tags) && r.Name != "HEAD" &&
+			!(*heads && strings.HasPrefix(r.Name, "refs/heads/")) &&
+			!(*tags && strings.HasPrefix(r.Name, "refs/tags/")) {
+			continue
+		}
+		// Patterns match whole trailing components of the ref name.
+		matched := fs.NArg() == 0
+		for _, p := range fs.Args() {
+			matched = matched || r.Name == p || strings.HasSuffix(r.Name, "/"+p)
+		}
+		if !matched {
+			continue
+		}
+		found = true
+		show(r)
+	}
+	if !found {
+		os.Exit(1)
+	}
+}
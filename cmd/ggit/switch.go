@@ -0,0 +1,51 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jamesr/ggit"
+)
+
+func switchCmd(args []string) {
+	fs := flag.NewFlagSet("switch", flag.ExitOnError)
+	create := fs.String("c", "", "create a new branch at <start-point> and switch to it")
+	fs.StringVar(create, "create", "", "same as -c")
+	forceCreate := fs.String("C", "", "like -c, but reset the branch if it exists")
+	fs.StringVar(forceCreate, "force-create", "", "same as -C")
+	detach := fs.Bool("detach", false, "switch to a commit for inspection")
+	force := fs.Bool("f", false, "throw away local changes")
+	fs.BoolVar(force, "force", false, "same as -f")
+	fs.BoolVar(force, "discard-changes", false, "same as -f")
+	fs.Parse(args)
+
+	o := switchOptions{target: fs.Arg(0), detach: *detach, force: *force}
+	switch {
+	case *create != "" && *forceCreate != "":
+		fmt.Fprintln(os.Stderr, "fatal: -c and -C are mutually exclusive")
+		os.Exit(128)
+	case *create != "":
+		o.newBranch = *create
+	case *forceCreate != "":
+		o.newBranch, o.forceNew = *forceCreate, true
+	}
+	if o.newBranch != "" && *detach {
+		fmt.Fprintln(os.Stderr, "fatal: '--detach' cannot be used with '-c/-C'")
+		os.Exit(128)
+	}
+	if fs.NArg() > 1 || (o.newBranch == "" && fs.NArg() == 0) {
+		fmt.Fprintln(os.Stderr, "Usage: ggit switch [-c|-C <new-branch>] [--detach] [-f] <branch> [<start-point>]")
+		os.Exit(1)
+	}
+	if o.newBranch != "" && !ggit.ValidBranchName(o.newBranch) {
+		fmt.Fprintf(os.Stderr, "fatal: '%s' is not a valid branch name\n", o.newBranch)
+		os.Exit(128)
+	}
+	switchBranch("checkout", o)
+}
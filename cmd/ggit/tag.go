@@ -0,0 +1,272 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jamesr/ggit"
+)
+
+// splitLineCount pulls git's -n[<num>] option, whose argument must be
+// attached, out of args. It returns 0 if the option isn't given.
+func splitLineCount(args []string) ([]string, int) {
+	kept, lines := []string(nil), 0
+	for i, arg := range args {
+		if arg == "--" {
+			return append(kept, args[i:]...), lines
+		}
+		if arg == "-n" {
+			lines = 1
+			continue
+		}
+		if strings.HasPrefix(arg, "-n") {
+			if n, err := strconv.Atoi(arg[2:]); err == nil {
+				lines = n
+				continue
+			}
+		}
+		kept = append(kept, arg)
+	}
+	return kept, lines
+}
+
+// parseInterspersed parses args with fs, allowing options after the first
+// argument as git does, and returns the arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	args, after, _ := splitDashDash(args)
+	rest := []string(nil)
+	fs.Parse(args)
+	for fs.NArg() > 0 {
+		rest = append(rest, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+	return append(rest, after...)
+}
+
+// firstLines returns up to n lines of message, indenting all but the first as
+// git tag -n does.
+func firstLines(message string, n int) string {
+	lines := strings.SplitAfter(message, "\n")
+	if len(lines) > n {
+		lines = lines[:n]
+	}
+	return strings.Replace(strings.TrimSuffix(strings.Join(lines, ""), "\n"), "\n", "\n    ", -1)
+}
+
+func listTags(patterns, sorts []string, lines int) {
+	refs, err := ggit.ListRefs("refs/tags/")
+	if err != nil {
+		fatal("%v", err)
+	}
+	if len(sorts) == 0 {
+		if s, ok := ggit.ConfigValue("tag.sort"); ok {
+			sorts = []string{s}
+		}
+	}
+	f := ggit.NewRefFormatter()
+	if err := f.Sort(refs, sorts); err != nil {
+		fatal("%v", err)
+	}
+	for _, r := range refs {
+		name := r.Name[len("refs/tags/"):]
+		if len(patterns) > 0 && !matchesAny(patterns, name) {
+			continue
+		}
+		if lines == 0 {
+			fmt.Println(name)
+			continue
+		}
+		contents, err := f.Atom(r, "contents")
+		if err != nil {
+			fatal("%v", err)
+		}
+		fmt.Printf("%-15s %s\n", name, firstLines(contents, lines))
+	}
+}
+
+func deleteTags(names []string) {
+	failed := false
+	for _, name := range names {
+		ref := "refs/tags/" + name
+		_, hash, _ := ggit.ResolveRef(ref)
+		if hash == "" {
+			fmt.Fprintf(os.Stderr, "error: tag '%s' not found.\n", name)
+			failed = true
+			continue
+		}
+		t := ggit.NewRefTransaction()
+		err := t.Delete(ref, hash, "", true)
+		if err == nil {
+			err = t.Commit(ggit.ReflogSignature())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			failed = true
+			continue
+		}
+		fmt.Printf("Deleted tag '%s' (was %s)\n", name, hash[:7])
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// verifyTags checks the signatures of the named tags. Checking signatures
+// isn't supported, so this fails for signed tags too, but like git it shows
+// the contents of unsigned ones before saying they have no signature.
+func verifyTags(names []string) {
+	failed := false
+	for _, name := range names {
+		_, hash, _ := ggit.ResolveRef("refs/tags/" + name)
+		if hash == "" {
+			fmt.Fprintf(os.Stderr, "error: tag '%s' not found.\n", name)
+			failed = true
+			continue
+		}
+		o, err := ggit.LookupObject(hash)
+		if err != nil {
+			fatal("%v", err)
+		}
+		if o.ObjectType != "tag" {
+			fmt.Fprintf(os.Stderr, "error: %s: cannot verify a non-tag object of type %s.\n", name, o.ObjectType)
+			o.Close()
+			failed = true
+			continue
+		}
+		b := bytes.NewBuffer(nil)
+		_, err = io.Copy(b, o.Reader)
+		o.Close()
+		if err != nil {
+			fatal("%v", err)
+		}
+		failed = true
+		if strings.Contains(b.String(), "\n-----BEGIN PGP SIGNATURE-----\n") {
+			fmt.Fprintf(os.Stderr, "error: %s: cannot verify signatures\n", name)
+			continue
+		}
+		fmt.Print(b.String())
+		fmt.Fprintln(os.Stderr, "error: no signature found")
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// tagReflogMessage describes tagging target in a reflog as git does.
+func tagReflogMessage(target string) string {
+	what := "object of unknown type"
+	if o, err := ggit.LookupObject(target); err == nil {
+		what = o.ObjectType + " object"
+		if o.ObjectType == "tag" {
+			what = "other tag object"
+		}
+		o.Close()
+		if c, err := ggit.ReadCommit(target); o.ObjectType == "commit" && err == nil {
+			what = subject(c.Message()) + ", " + c.CommitDate().UTC().Format("2006-01-02")
+			c.Close()
+		}
+	}
+	return fmt.Sprintf("tag: tagging %s (%s)", target[:7], what)
+}
+
+func createTag(name, object string, force, annotate bool, messages []string, file string) {
+	if !ggit.ValidTagName(name) {
+		fatal("'%s' is not a valid tag name.", name)
+	}
+	target, err := ggit.ResolveRevision(object)
+	if err != nil {
+		fatal("Failed to resolve '%s' as a valid ref.", object)
+	}
+	ref := "refs/tags/" + name
+	_, old, _ := ggit.ResolveRef(ref)
+	if old != "" && !force {
+		fatal("tag '%s' already exists", name)
+	}
+	hash := target
+	if annotate || len(messages) > 0 || file != "" {
+		message := strings.Join(messages, "\n\n")
+		if file != "" {
+			b, err := []byte(nil), error(nil)
+			if file == "-" {
+				b, err = ioutil.ReadAll(os.Stdin)
+			} else {
+				b, err = ioutil.ReadFile(file)
+			}
+			if err != nil {
+				fatal("could not open or read '%s': %v", file, err)
+			}
+			message = string(b)
+		} else if len(messages) == 0 {
+			fatal("no tag message given; use -m or -F")
+		}
+		_, tagger := signatures()
+		if hash, err = ggit.WriteTag(target, name, tagger, cleanupMessage(message)); err != nil {
+			fatal("unable to write tag file: %v", err)
+		}
+	}
+	oldHash := ggit.ZeroHash
+	if force {
+		oldHash = ""
+	}
+	if err := ggit.UpdateRef(ref, hash, oldHash, ggit.ReflogSignature(), tagReflogMessage(target)); err != nil {
+		fatal("%v", err)
+	}
+	if old != "" && old != hash {
+		fmt.Printf("Updated tag '%s' (was %s)\n", name, old[:7])
+	}
+}
+
+func tag(args []string) {
+	args, lines := splitLineCount(args)
+	fs := flag.NewFlagSet("tag", flag.ExitOnError)
+	list := fs.Bool("l", false, "list tags matching the given patterns")
+	fs.BoolVar(list, "list", false, "same as -l")
+	del := fs.Bool("d", false, "delete tags")
+	fs.BoolVar(del, "delete", false, "same as -d")
+	verify := fs.Bool("v", false, "verify the signatures of tags")
+	fs.BoolVar(verify, "verify", false, "same as -v")
+	force := fs.Bool("f", false, "replace an existing tag")
+	fs.BoolVar(force, "force", false, "same as -f")
+	annotate := fs.Bool("a", false, "make an annotated tag")
+	fs.BoolVar(annotate, "annotate", false, "same as -a")
+	var messages, sorts stringList
+	fs.Var(&messages, "m", "use the given message for an annotated tag")
+	fs.Var(&messages, "message", "same as -m")
+	file := fs.String("F", "", "take the tag message from the file, or stdin for -")
+	fs.StringVar(file, "file", "", "same as -F")
+	fs.Var(&sorts, "sort", "field name to sort on; prefix - to reverse")
+	args = parseInterspersed(fs, args)
+
+	switch {
+	case *del:
+		deleteTags(args)
+	case *verify:
+		verifyTags(args)
+	case *list || lines > 0 || len(args) == 0:
+		// The last --sort given is the primary key.
+		keys := []string(nil)
+		for i := len(sorts) - 1; i >= 0; i-- {
+			keys = append(keys, sorts[i])
+		}
+		listTags(args, keys, lines)
+	case len(args) > 2:
+		fatal("too many arguments")
+	default:
+		object := "HEAD"
+		if len(args) == 2 {
+			object = args[1]
+		}
+		createTag(args[0], object, *force, *annotate, messages, *file)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jamesr/ggit"
+)
+
+// refValue resolves a value given to update-ref. An empty value is returned
+// as is, and so is the zero hash, which stands for a missing ref.
+func refValue(v string) (string, error) {
+	if v == "" || v == ggit.ZeroHash {
+		return v, nil
+	}
+	hash, err := ggit.ResolveRevision(v)
+	if err != nil {
+		return "", fmt.Errorf("invalid value '%s'", v)
+	}
+	return hash, nil
+}
+
+// stdinTransaction reads update-ref --stdin commands. Unless the input
+// contains "start", all of it forms a single transaction.
+type stdinTransaction struct {
+	r       *bufio.Reader
+	nul     bool
+	msg     string
+	noDeref bool
+	who     ggit.Signature
+	t       *ggit.RefTransaction
+	started bool // the input began the transaction explicitly
+}
+
+// next returns the next command line, or with -z the command and its first
+// argument, and io.EOF at the end of input.
+func (s *stdinTransaction) next() (string, error) {
+	delim := byte('\n')
+	if s.nul {
+		delim = 0
+	}
+	line, err := s.r.ReadString(delim)
+	if err == io.EOF && line != "" {
+		if !s.nul {
+			return line, nil
+		}
+		return "", fmt.Errorf("unterminated command: %s", line)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, string(delim)), nil
+}
+
+// args splits the arguments of a command. In -z mode the arguments after the
+// first are read as separate NUL-terminated fields; n is how many to read.
+func (s *stdinTransaction) args(rest string, n int) ([]string, error) {
+	if !s.nul {
+		return strings.Split(rest, " "), nil
+	}
+	args := []string{rest}
+	for i := 1; i < n; i++ {
+		field, err := s.r.ReadString(0)
+		if err != nil {
+			return nil, fmt.Errorf("missing argument")
+		}
+		args = append(args, strings.TrimSuffix(field, "\x00"))
+	}
+	return args, nil
+}
+
+func (s *stdinTransaction) command(line string) error {
+	cmd, rest := line, ""
+	if sp := strings.IndexByte(line, ' '); sp != -1 {
+		cmd, rest = line[:sp], line[sp+1:]
+	}
+	if s.t == nil {
+		s.t = ggit.NewRefTransaction()
+	}
+	want := map[string]int{"update": 3, "create": 2, "delete": 2, "verify": 2}[cmd]
+	args := []string(nil)
+	if want > 0 {
+		var err error
+		if args, err = s.args(rest, want); err != nil {
+			return fmt.Errorf("%s %s: %v", cmd, rest, err)
+		}
+		if !s.nul && (len(args) > want || (cmd != "delete" && cmd != "verify" && len(args) < want-1)) {
+			return fmt.Errorf("%s: extra input or missing arguments: %s", cmd, rest)
+		}
+		for len(args) < want {
+			args = append(args, "")
+		}
+		for i := 1; i < len(args); i++ {
+			v, err := refValue(args[i])
+			if err != nil {
+				return fmt.Errorf("%s %s: %v", cmd, args[0], err)
+			}
+			args[i] = v
+		}
+	}
+	noDeref := s.noDeref
+	err := error(nil)
+	switch cmd {
+	case "update":
+		if args[1] == "" {
+			return fmt.Errorf("update %s: missing <newvalue>", args[0])
+		}
+		err = s.t.Update(args[0], args[1], args[2], s.msg, noDeref)
+	case "create":
+		if args[1] == "" || args[1] == ggit.ZeroHash {
+			return fmt.Errorf("create %s: zero <newvalue>", args[0])
+		}
+		err = s.t.Create(args[0], args[1], s.msg)
+	case "delete":
+		err = s.t.Delete(args[0], args[1], s.msg, noDeref)
+	case "verify":
+		err = s.t.Verify(args[0], args[1])
+	case "option":
+		if rest != "no-deref" {
+			return fmt.Errorf("option unknown: %s", rest)
+		}
+		s.noDeref = true
+		return nil
+	case "start":
+		s.started = true
+		fmt.Println("start: ok")
+		return nil
+	case "prepare":
+		if err := s.t.Prepare(); err != nil {
+			return err
+		}
+		fmt.Println("prepare: ok")
+		return nil
+	case "commit":
+		if err := s.t.Commit(s.who); err != nil {
+			return err
+		}
+		s.t, s.started = nil, false
+		fmt.Println("commit: ok")
+		return nil
+	case "abort":
+		s.t.Abort()
+		s.t, s.started = nil, false
+		fmt.Println("abort: ok")
+		return nil
+	default:
+		return fmt.Errorf("unknown command: %s", line)
+	}
+	s.noDeref = false
+	return err
+}
+
+func updateRefStdin(msg string, nul bool) {
+	s := &stdinTransaction{r: bufio.NewReader(os.Stdin), nul: nul, msg: msg, who: ggit.ReflogSignature()}
+	for {
+		line, err := s.next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = s.command(line)
+		}
+		if err != nil {
+			if s.t != nil {
+				s.t.Abort()
+			}
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+	}
+	if s.t != nil && s.started {
+		// An explicit transaction that was never committed is dropped.
+		s.t.Abort()
+	} else if s.t != nil {
+		if err := s.t.Commit(s.who); err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+	}
+}
+
+func updateRef(args []string) {
+	fs := flag.NewFlagSet("update-ref", flag.ExitOnError)
+	msg := fs.String("m", "", "reason for the update, recorded in the reflog")
+	del := fs.Bool("d", false, "delete the ref")
+	noDeref := fs.Bool("no-deref", false, "update the symbolic ref itself rather than its target")
+	stdin := fs.Bool("stdin", false, "read updates from stdin as a transaction")
+	nul := fs.Bool("z", false, "with --stdin, read NUL-terminated input")
+	fs.Parse(args)
+
+	if *stdin {
+		if fs.NArg() > 0 {
+			fmt.Fprintln(os.Stderr, "fatal: --stdin takes no arguments")
+			os.Exit(128)
+		}
+		updateRefStdin(*msg, *nul)
+		return
+	}
+	if fs.NArg() < 1 || fs.NArg() > 3 || (*del && fs.NArg() > 2) || (!*del && fs.NArg() < 2) {
+		fmt.Fprintln(os.Stderr, "Usage: ggit update-ref [-m <reason>] [--no-deref] (-d <ref> [<old>] | <ref> <new> [<old>] | --stdin [-z])")
+		os.Exit(1)
+	}
+	values := []string{"", ""}
+	for i, v := range fs.Args()[1:] {
+		hash, err := refValue(v)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		values[i] = hash
+	}
+	t := ggit.NewRefTransaction()
+	err := error(nil)
+	if *del {
+		err = t.Delete(fs.Arg(0), values[0], *msg, *noDeref)
+	} else {
+		err = t.Update(fs.Arg(0), values[0], values[1], *msg, *noDeref)
+	}
+	if err == nil {
+		err = t.Commit(ggit.ReflogSignature())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+}
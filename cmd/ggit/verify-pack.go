@@ -0,0 +1,83 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jamesr/ggit"
+)
+
+// plural picks the singular or plural of a word, as git's messages do.
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// printPackStats prints the objects of a pack, unless statOnly is set, and
+// how many of them are at each depth of delta chain.
+func printPackStats(objects []ggit.PackedObject, statOnly bool) {
+	histogram := []int{0}
+	for _, o := range objects {
+		for len(histogram) <= o.Depth {
+			histogram = append(histogram, 0)
+		}
+		histogram[o.Depth]++
+		if statOnly {
+			continue
+		}
+		fmt.Printf("%x %-6s %d %d %d", o.Hash, o.Type, o.Size, o.PackedSize, o.Offset)
+		if o.Depth > 0 {
+			fmt.Printf(" %d %x", o.Depth, o.Base)
+		}
+		fmt.Println()
+	}
+	if n := histogram[0]; n > 0 {
+		fmt.Printf("non delta: %d %s\n", n, plural(n, "object", "objects"))
+	}
+	for depth, n := range histogram[1:] {
+		if n > 0 {
+			fmt.Printf("chain length = %d: %d %s\n", depth+1, n, plural(n, "object", "objects"))
+		}
+	}
+}
+
+func verifyPack(args []string) {
+	fs := flag.NewFlagSet("verify-pack", flag.ExitOnError)
+	verbose := fs.Bool("v", false, "list the objects in each pack")
+	statOnly := fs.Bool("s", false, "only show the histogram of delta chain lengths")
+	rest := parseInterspersed(fs, args)
+	if len(rest) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: ggit verify-pack [-v | --verbose] [-s | --stat-only] <pack>...")
+		os.Exit(129)
+	}
+	failed := false
+	for _, name := range rest {
+		base := strings.TrimSuffix(strings.TrimSuffix(name, ".idx"), ".pack")
+		objects, err := ggit.VerifyPack(base + ".idx")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			failed = true
+		}
+		if !*verbose && !*statOnly {
+			continue
+		}
+		printPackStats(objects, *statOnly)
+		if err != nil {
+			fmt.Printf("%s.pack: bad\n", base)
+		} else if !*statOnly {
+			fmt.Printf("%s.pack: ok\n", base)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
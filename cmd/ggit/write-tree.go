@@ -0,0 +1,33 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jamesr/ggit"
+)
+
+func writeTree(args []string) {
+	fs := flag.NewFlagSet("write-tree", flag.ExitOnError)
+	fs.Parse(args)
+	idx, err := ggit.ReadIndex(".git/index")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error reading index:", err)
+		os.Exit(1)
+	}
+	tree, err := ggit.WriteTree(idx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error writing tree:", err)
+		os.Exit(1)
+	}
+	// Save the updated cache tree so the next write-tree is cheaper. Failing to
+	// do so doesn't change the result.
+	_ = idx.Write(".git/index")
+	fmt.Println(tree)
+}
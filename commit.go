@@ -7,10 +7,12 @@ package ggit
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -91,7 +93,7 @@ type commit struct {
 	Parent                    []string
 	author, authorEmail       string
 	committer, committerEmail string
-	date                      time.Time
+	date, commitDate          time.Time
 	zone                      string
 	messageReader             *bufio.Reader
 	zlibReader                io.ReadCloser
@@ -140,7 +142,7 @@ func parseKnownFields(c *commit, r io.Reader) error {
 				return fmt.Errorf("author %v", err)
 			}
 		case strings.HasPrefix(line, "committer "):
-			c.committer, c.committerEmail, _, _, err = parsePersonLine(line, "committer")
+			c.committer, c.committerEmail, _, c.commitDate, err = parsePersonLine(line, "committer")
 			if err != nil {
 				return fmt.Errorf("committer %v", err)
 			}
@@ -174,6 +176,11 @@ func (c *commit) Close() {
 	}
 }
 
+// CommitDate returns when the commit was made, which orders history walks.
+func (c *commit) CommitDate() time.Time {
+	return c.commitDate
+}
+
 func (c *commit) Message() string {
 	if c.messageStr == nil {
 		b, err := ioutil.ReadAll(c.messageReader)
@@ -211,3 +218,119 @@ func showCommit(committish string) (string, error) {
 	}
 	return c.String(), nil
 }
+
+// Signature identifies who made a change and when, as recorded in the author
+// and committer lines of a commit.
+type Signature struct {
+	Name, Email string
+	When        time.Time
+}
+
+// String formats s the way it appears in an object, e.g.
+// "A U Thor <author@example.com> 1398979283 -0700".
+func (s Signature) String() string {
+	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.When.Unix(), s.When.Format("-0700"))
+}
+
+var dateFormats = []string{
+	time.RFC1123Z,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+}
+
+// parseZone parses a timezone offset of the form "+hhmm" or "-hhmm".
+func parseZone(zone string) (*time.Location, error) {
+	if len(zone) != 5 || (zone[0] != '+' && zone[0] != '-') {
+		return nil, fmt.Errorf("bad timezone %q", zone)
+	}
+	hours, err := strconv.Atoi(zone[1:3])
+	if err != nil {
+		return nil, fmt.Errorf("bad timezone %q", zone)
+	}
+	minutes, err := strconv.Atoi(zone[3:5])
+	if err != nil {
+		return nil, fmt.Errorf("bad timezone %q", zone)
+	}
+	offset := hours*3600 + minutes*60
+	if zone[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone("", offset), nil
+}
+
+// parseDate parses the date formats accepted in GIT_AUTHOR_DATE and
+// GIT_COMMITTER_DATE: git's internal "<seconds> <zone>" form, optionally with
+// a leading '@', RFC 2822 and ISO 8601.
+func parseDate(date string) (time.Time, error) {
+	date = strings.TrimSpace(date)
+	if fields := strings.Fields(strings.TrimPrefix(date, "@")); len(fields) == 1 || len(fields) == 2 {
+		if sec, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			t := time.Unix(sec, 0)
+			if len(fields) == 1 {
+				return t.UTC(), nil
+			}
+			loc, err := parseZone(fields[1])
+			if err != nil {
+				return time.Time{}, err
+			}
+			return t.In(loc), nil
+		}
+	}
+	for _, f := range dateFormats {
+		if t, err := time.ParseInLocation(f, date, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date format: %s", date)
+}
+
+// DefaultSignature returns the identity to record for role, which is
+// "author" or "committer". GIT_AUTHOR_NAME, GIT_AUTHOR_EMAIL and
+// GIT_AUTHOR_DATE (or the GIT_COMMITTER_ equivalents) take precedence over
+// user.name and user.email from the config; the time defaults to now in the
+// local timezone.
+func DefaultSignature(role string) (Signature, error) {
+	prefix := "GIT_" + strings.ToUpper(role) + "_"
+	s := Signature{
+		Name:  os.Getenv(prefix + "NAME"),
+		Email: os.Getenv(prefix + "EMAIL"),
+		When:  time.Now()}
+	if s.Name == "" {
+		s.Name, _ = ConfigValue("user.name")
+	}
+	if s.Email == "" {
+		s.Email, _ = ConfigValue("user.email")
+	}
+	if s.Email == "" {
+		s.Email = os.Getenv("EMAIL")
+	}
+	if s.Name == "" || s.Email == "" {
+		return Signature{}, fmt.Errorf("%s identity unknown: set user.name and user.email", role)
+	}
+	if date := os.Getenv(prefix + "DATE"); date != "" {
+		t, err := parseDate(date)
+		if err != nil {
+			return Signature{}, err
+		}
+		s.When = t
+	}
+	return s, nil
+}
+
+// CommitTree writes a commit object for tree with the given parents and
+// returns its name. message is stored as is.
+func CommitTree(tree string, parents []string, author, committer Signature, message string) (string, error) {
+	b := bytes.NewBuffer(nil)
+	fmt.Fprintf(b, "tree %s\n", tree)
+	for _, p := range parents {
+		fmt.Fprintf(b, "parent %s\n", p)
+	}
+	fmt.Fprintf(b, "author %s\n", author)
+	fmt.Fprintf(b, "committer %s\n", committer)
+	b.WriteString("\n")
+	b.WriteString(message)
+	return WriteObject("commit", b.Bytes())
+}
@@ -0,0 +1,852 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	commitGraphPath      = ".git/objects/info/commit-graph"
+	commitGraphsDir      = ".git/objects/info/commit-graphs"
+	commitGraphChainPath = commitGraphsDir + "/commit-graph-chain"
+)
+
+const (
+	graphParentNone     = 0x70000000 // in CDAT, for a missing parent
+	graphExtraEdges     = 0x80000000 // in CDAT, marks an index into EDGE; in EDGE, the last parent
+	graphLevelMax       = 0x3fffffff // the largest topological level CDAT can hold
+	graphOffsetOverflow = 0x80000000 // in GDA2, marks an index into GDO2
+	graphDataSize       = sha1.Size + 16
+)
+
+// generationInfinity is the generation of commits the commit-graph doesn't
+// have, which history walks have to assume may be anywhere.
+const generationInfinity = math.MaxUint64
+
+// commitGraphLayer is one commit-graph file: the whole graph, or a layer of
+// a chain of them.
+type commitGraphLayer struct {
+	data                       []byte
+	sum                        string // the checksum, which names the layers of a chain
+	fanOut                     []byte
+	oids                       []byte
+	commitData                 []byte
+	edges                      []byte
+	dateOffsets, dateOverflows []byte
+	bases                      []byte
+	bloomIndex, bloomData      []byte // the changed-path filters, if the layer has usable ones
+	numCommits                 int
+	offset                     int // the number of commits in the layers below
+}
+
+// parseCommitGraphLayer parses the chunks of a commit-graph file.
+func parseCommitGraphLayer(data []byte) (*commitGraphLayer, error) {
+	if len(data) < 8+sha1.Size || string(data[:4]) != "CGPH" {
+		return nil, errors.New("commit-graph signature does not match")
+	}
+	if data[4] != 1 {
+		return nil, fmt.Errorf("commit-graph version %d does not match version 1", data[4])
+	}
+	if data[5] != 1 {
+		return nil, fmt.Errorf("commit-graph hash version %d does not match version 1", data[5])
+	}
+	numChunks := int(data[6])
+	table := data[8:]
+	if len(table) < (numChunks+1)*12 {
+		return nil, errors.New("commit-graph chunk table is truncated")
+	}
+	chunks := make(map[string][]byte)
+	for i := 0; i < numChunks; i++ {
+		id := string(table[i*12 : i*12+4])
+		start := binary.BigEndian.Uint64(table[i*12+4:])
+		end := binary.BigEndian.Uint64(table[i*12+16:])
+		if start > end || end > uint64(len(data)-sha1.Size) {
+			return nil, fmt.Errorf("commit-graph chunk %s is out of bounds", id)
+		}
+		chunks[id] = data[start:end]
+	}
+	for _, id := range []string{"OIDF", "OIDL", "CDAT"} {
+		if chunks[id] == nil {
+			return nil, fmt.Errorf("commit-graph required %s chunk missing or corrupted", id)
+		}
+	}
+	l := &commitGraphLayer{data: data, sum: fmt.Sprintf("%x", data[len(data)-sha1.Size:])}
+	l.fanOut, l.oids, l.commitData = chunks["OIDF"], chunks["OIDL"], chunks["CDAT"]
+	if len(l.fanOut) != 256*4 {
+		return nil, errors.New("commit-graph OID fanout is of the wrong size")
+	}
+	l.numCommits = int(binary.BigEndian.Uint32(l.fanOut[255*4:]))
+	if len(l.oids) != l.numCommits*sha1.Size || len(l.commitData) != l.numCommits*graphDataSize {
+		return nil, errors.New("commit-graph OID lookup chunk is the wrong size")
+	}
+	l.edges, l.dateOffsets, l.dateOverflows = chunks["EDGE"], chunks["GDA2"], chunks["GDO2"]
+	if l.dateOffsets != nil && len(l.dateOffsets) != l.numCommits*4 {
+		return nil, errors.New("commit-graph generations chunk is the wrong size")
+	}
+	l.parseBloomChunks(chunks["BIDX"], chunks["BDAT"])
+	l.bases = chunks["BASE"]
+	if len(l.bases) != int(data[7])*sha1.Size {
+		return nil, errors.New("commit-graph base graphs chunk is the wrong size")
+	}
+	return l, nil
+}
+
+func (l *commitGraphLayer) hash(i int) []byte {
+	return l.oids[i*sha1.Size : (i+1)*sha1.Size]
+}
+
+// find returns the position of hash in the layer, or -1.
+func (l *commitGraphLayer) find(hash []byte) int {
+	lo := 0
+	if hash[0] > 0 {
+		lo = int(binary.BigEndian.Uint32(l.fanOut[(int(hash[0])-1)*4:]))
+	}
+	hi := int(binary.BigEndian.Uint32(l.fanOut[int(hash[0])*4:]))
+	i := sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(hash, l.hash(i+lo)) <= 0
+	}) + lo
+	if i == hi || !bytes.Equal(hash, l.hash(i)) {
+		return -1
+	}
+	return i
+}
+
+// commitGraph is the commit-graph, made of one layer or a chain of them.
+type commitGraph struct {
+	layers     []*commitGraphLayer // the base first
+	numCommits int
+	v2         bool // whether every layer has corrected commit dates
+}
+
+// graphCommit is a commit as the commit-graph records it.
+type graphCommit struct {
+	tree       []byte
+	parents    []int // positions in the graph
+	date       int64
+	level      uint32 // the topological level
+	generation uint64 // the corrected commit date, or the level without one
+}
+
+func newCommitGraph(layers []*commitGraphLayer) *commitGraph {
+	g := &commitGraph{layers: layers, v2: true}
+	for _, l := range layers {
+		l.offset = g.numCommits
+		g.numCommits += l.numCommits
+		g.v2 = g.v2 && l.dateOffsets != nil
+	}
+	return g
+}
+
+// find returns the position of hash in the graph, or -1.
+func (g *commitGraph) find(hash []byte) int {
+	for i := len(g.layers) - 1; i >= 0; i-- {
+		l := g.layers[i]
+		if j := l.find(hash); j != -1 {
+			return l.offset + j
+		}
+	}
+	return -1
+}
+
+// layerAt returns the layer holding the commit at pos and its position there.
+func (g *commitGraph) layerAt(pos int) (*commitGraphLayer, int) {
+	for _, l := range g.layers {
+		if pos < l.offset+l.numCommits {
+			return l, pos - l.offset
+		}
+	}
+	return nil, -1
+}
+
+func (g *commitGraph) hash(pos int) []byte {
+	l, i := g.layerAt(pos)
+	return l.hash(i)
+}
+
+// commit returns what the graph has on the commit at pos.
+func (g *commitGraph) commit(pos int) (graphCommit, error) {
+	l, i := g.layerAt(pos)
+	if l == nil {
+		return graphCommit{}, fmt.Errorf("invalid commit position %d; commit-graph is likely corrupt", pos)
+	}
+	d := l.commitData[i*graphDataSize:]
+	c := graphCommit{tree: d[:sha1.Size]}
+	for k, p := range []uint32{binary.BigEndian.Uint32(d[20:]), binary.BigEndian.Uint32(d[24:])} {
+		switch {
+		case p == graphParentNone:
+		case k == 1 && p&graphExtraEdges != 0:
+			for e := int(p &^ graphExtraEdges); ; e++ {
+				if (e+1)*4 > len(l.edges) {
+					return graphCommit{}, errors.New("commit-graph extra-edges pointer out of bounds")
+				}
+				v := binary.BigEndian.Uint32(l.edges[e*4:])
+				c.parents = append(c.parents, int(v&^graphExtraEdges))
+				if v&graphExtraEdges != 0 {
+					break
+				}
+			}
+		default:
+			c.parents = append(c.parents, int(p))
+		}
+	}
+	for _, p := range c.parents {
+		if p >= g.numCommits {
+			return graphCommit{}, fmt.Errorf("invalid parent position %d", p)
+		}
+	}
+	hi, lo := binary.BigEndian.Uint32(d[28:]), binary.BigEndian.Uint32(d[32:])
+	c.date = int64(hi&3)<<32 | int64(lo)
+	c.level = hi >> 2
+	c.generation = uint64(c.level)
+	if g.v2 {
+		offset := uint64(binary.BigEndian.Uint32(l.dateOffsets[i*4:]))
+		if offset&graphOffsetOverflow != 0 {
+			k := int(offset &^ graphOffsetOverflow)
+			if (k+1)*8 > len(l.dateOverflows) {
+				return graphCommit{}, errors.New("commit-graph overflow generation data is too small")
+			}
+			offset = binary.BigEndian.Uint64(l.dateOverflows[k*8:])
+		}
+		c.generation = uint64(c.date) + offset
+	}
+	return c, nil
+}
+
+// close unmaps the graph's files.
+func (g *commitGraph) close() {
+	for _, l := range g.layers {
+		_ = syscall.Munmap(l.data)
+	}
+}
+
+// mapCommitGraphLayer maps and parses the commit-graph file at path.
+func mapCommitGraphLayer(path string) (*commitGraphLayer, error) {
+	data, err := mmapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	l, err := parseCommitGraphLayer(data)
+	if err != nil {
+		_ = syscall.Munmap(data)
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return l, nil
+}
+
+// readCommitGraphChain returns the names of the layers in the commit-graph
+// chain, the base first.
+func readCommitGraphChain() ([]string, error) {
+	data, err := ioutil.ReadFile(commitGraphChainPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+func commitGraphLayerPath(sum string) string {
+	return commitGraphsDir + "/graph-" + sum + ".graph"
+}
+
+// openCommitGraph maps the commit-graph: the single file if there is one,
+// or else as much of the chain as can be read. It returns nil if there is
+// no commit-graph.
+func openCommitGraph() (*commitGraph, error) {
+	if _, err := os.Stat(commitGraphPath); err == nil {
+		l, err := mapCommitGraphLayer(commitGraphPath)
+		if err != nil {
+			return nil, err
+		}
+		return newCommitGraph([]*commitGraphLayer{l}), nil
+	}
+	chain, err := readCommitGraphChain()
+	if err != nil || len(chain) == 0 {
+		return nil, err
+	}
+	layers := []*commitGraphLayer(nil)
+	for i, sum := range chain {
+		l, err := mapCommitGraphLayer(commitGraphLayerPath(sum))
+		if err == nil && len(l.bases) != i*sha1.Size {
+			err = fmt.Errorf("commit-graph layer %s has the wrong number of bases", sum)
+		}
+		if err != nil {
+			if len(layers) == 0 {
+				return nil, err
+			}
+			break
+		}
+		layers = append(layers, l)
+	}
+	return newCommitGraph(layers), nil
+}
+
+var (
+	parsedCommitGraph *commitGraph
+	commitGraphLoaded bool
+)
+
+// loadCommitGraph returns the commit-graph, or nil if there isn't a usable
+// one. Like git, history walks ignore a commit-graph they can't read and
+// fall back to the commits themselves.
+func loadCommitGraph() *commitGraph {
+	if !commitGraphLoaded {
+		commitGraphLoaded = true
+		if use, err := ConfigBool("core.commitGraph", true); err == nil && use {
+			parsedCommitGraph, _ = openCommitGraph()
+		}
+	}
+	return parsedCommitGraph
+}
+
+// closeCommitGraph unmaps the commit-graph, so that the next lookup reads it
+// afresh.
+func closeCommitGraph() {
+	if parsedCommitGraph != nil {
+		parsedCommitGraph.close()
+	}
+	parsedCommitGraph, commitGraphLoaded = nil, false
+}
+
+// CommitInfo is what a history walk needs to know about a commit.
+type CommitInfo struct {
+	Hash, Tree string
+	Parents    []string
+	Date       time.Time // the committer date
+	// Generation never decreases from a commit to its children. It is the
+	// corrected commit date, or the topological level if the commit-graph
+	// has no dates, or math.MaxUint64 for commits without one.
+	Generation uint64
+}
+
+// LookupCommitInfo returns the parents, tree and date of the commit named by
+// the full hash, from the commit-graph if it has the commit, so that walks
+// needn't inflate the commit itself.
+func LookupCommitInfo(hash string) (CommitInfo, error) {
+	if g := loadCommitGraph(); g != nil && isHexHash(hash) {
+		if pos := g.find(hashToBytes(hash)); pos != -1 {
+			if info, err := g.info(pos); err == nil {
+				return info, nil
+			}
+		}
+	}
+	c, err := ReadCommit(hash)
+	if err != nil {
+		return CommitInfo{}, err
+	}
+	c.Close()
+	return CommitInfo{Hash: c.Hash, Tree: c.Tree, Parents: c.Parent, Date: c.CommitDate(), Generation: generationInfinity}, nil
+}
+
+// info returns the CommitInfo of the commit at pos.
+func (g *commitGraph) info(pos int) (CommitInfo, error) {
+	c, err := g.commit(pos)
+	if err != nil {
+		return CommitInfo{}, err
+	}
+	info := CommitInfo{
+		Hash:       fmt.Sprintf("%x", g.hash(pos)),
+		Tree:       fmt.Sprintf("%x", c.tree),
+		Date:       time.Unix(c.date, 0),
+		Generation: c.generation,
+	}
+	for _, p := range c.parents {
+		info.Parents = append(info.Parents, fmt.Sprintf("%x", g.hash(p)))
+	}
+	return info, nil
+}
+
+// CommitGraphOptions controls WriteCommitGraph.
+type CommitGraphOptions struct {
+	// Split adds the new commits to the commit-graph chain as a layer of
+	// their own, merged with the layers above that aren't more than twice
+	// its size, instead of rewriting the whole graph as a single file.
+	Split bool
+	// ChangedPaths writes a Bloom filter of the paths each commit changes,
+	// which lets path-limited walks skip commits without diffing them. It is
+	// implied if the layers kept already have filters.
+	ChangedPaths bool
+}
+
+// graphWriteCommit is a commit to be written to a commit-graph.
+type graphWriteCommit struct {
+	hash, tree string
+	parents    []string
+	date       int64
+	level      uint32
+	corrected  uint64
+}
+
+// commitGraphTips returns the commits HEAD and the refs point to.
+func commitGraphTips() ([]string, error) {
+	refs, err := ListRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	hashes := []string(nil)
+	if _, hash, err := ResolveRef("HEAD"); err == nil && hash != "" {
+		hashes = append(hashes, hash)
+	}
+	for _, r := range refs {
+		hashes = append(hashes, r.Hash)
+	}
+	tips := []string(nil)
+	for _, hash := range hashes {
+		hash, err := peel(hash, "")
+		if err != nil {
+			return nil, err
+		}
+		if objectType, _, err := readObject(hash); err != nil {
+			return nil, err
+		} else if objectType == "commit" {
+			tips = append(tips, hash)
+		}
+	}
+	return tips, nil
+}
+
+// WriteCommitGraph writes a commit-graph of the commits reachable from the
+// refs.
+func WriteCommitGraph(opts CommitGraphOptions) error {
+	tips, err := commitGraphTips()
+	if err != nil {
+		return err
+	}
+	closeCommitGraph()
+	defer closeCommitGraph()
+	old, err := openCommitGraph()
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		for _, l := range old.layers {
+			opts.ChangedPaths = opts.ChangedPaths || l.bloomIndex != nil
+		}
+		if opts.Split {
+			defer old.close()
+		} else {
+			old.close()
+			old = nil
+		}
+	}
+	inOld := func(hash string) bool {
+		return old != nil && old.find(hashToBytes(hash)) != -1
+	}
+
+	commits := make(map[string]*graphWriteCommit)
+	stack := append([]string(nil), tips...)
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if commits[hash] != nil || inOld(hash) {
+			continue
+		}
+		c, err := ReadCommit(hash)
+		if err != nil {
+			return err
+		}
+		c.Close()
+		commits[hash] = &graphWriteCommit{hash: hash, tree: c.Tree, parents: c.Parent, date: c.CommitDate().Unix()}
+		stack = append(stack, c.Parent...)
+	}
+
+	// Merge the new commits with the layers above that are no more than
+	// twice as big.
+	kept := []*commitGraphLayer(nil)
+	if old != nil {
+		kept = old.layers
+		for len(kept) > 0 && kept[len(kept)-1].numCommits <= 2*len(commits) {
+			top := kept[len(kept)-1]
+			for i := 0; i < top.numCommits; i++ {
+				pos := top.offset + i
+				info, err := old.info(pos)
+				if err != nil {
+					return err
+				}
+				commits[info.Hash] = &graphWriteCommit{hash: info.Hash, tree: info.Tree, parents: info.Parents, date: info.Date.Unix()}
+			}
+			kept = kept[:len(kept)-1]
+		}
+		if len(commits) == 0 {
+			return nil
+		}
+	}
+	base := newCommitGraph(kept)
+
+	if err := computeGenerations(commits, base); err != nil {
+		return err
+	}
+	sorted := make([]*graphWriteCommit, 0, len(commits))
+	for _, c := range commits {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].hash < sorted[j].hash })
+	filters := []bloomFilter(nil)
+	if opts.ChangedPaths {
+		for _, c := range sorted {
+			parentTree := ""
+			if len(c.parents) > 0 {
+				if p := commits[c.parents[0]]; p != nil {
+					parentTree = p.tree
+				} else if pos := base.find(hashToBytes(c.parents[0])); pos != -1 {
+					info, err := base.info(pos)
+					if err != nil {
+						return err
+					}
+					parentTree = info.Tree
+				}
+			}
+			paths, err := changedPaths(parentTree, c.tree, bloomMaxChanges)
+			if err != nil {
+				return err
+			}
+			filters = append(filters, newBloomFilter(paths))
+		}
+	}
+	data, err := commitGraphFile(sorted, base, filters)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(commitGraphPath), 0777); err != nil {
+		return err
+	}
+	if !opts.Split {
+		if err := writeFileLocked(commitGraphPath, data); err != nil {
+			return err
+		}
+		return removeCommitGraphLayers(nil)
+	}
+	if err := os.MkdirAll(commitGraphsDir, 0777); err != nil {
+		return err
+	}
+	chain := []string(nil)
+	for _, l := range kept {
+		if _, err := os.Stat(commitGraphLayerPath(l.sum)); os.IsNotExist(err) {
+			// The single commit-graph file becomes the base of the chain.
+			if err := writeFileLocked(commitGraphLayerPath(l.sum), l.data); err != nil {
+				return err
+			}
+		}
+		chain = append(chain, l.sum)
+	}
+	sum := fmt.Sprintf("%x", data[len(data)-sha1.Size:])
+	if err := writeFileLocked(commitGraphLayerPath(sum), data); err != nil {
+		return err
+	}
+	chain = append(chain, sum)
+	if err := writeFileLocked(commitGraphChainPath, []byte(strings.Join(chain, "\n")+"\n")); err != nil {
+		return err
+	}
+	if err := os.Remove(commitGraphPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return removeCommitGraphLayers(chain)
+}
+
+// writeFileLocked replaces the file at path with data.
+func writeFileLocked(path string, data []byte) error {
+	l, err := lock(path)
+	if err != nil {
+		return err
+	}
+	defer l.rollback()
+	if _, err := l.Write(data); err != nil {
+		return err
+	}
+	return l.commit()
+}
+
+// removeCommitGraphLayers deletes the commit-graph layers not in chain, and
+// the chain itself if it is empty.
+func removeCommitGraphLayers(chain []string) error {
+	keep := make(map[string]bool)
+	for _, sum := range chain {
+		keep[commitGraphLayerPath(sum)] = true
+	}
+	files, err := filepath.Glob(commitGraphsDir + "/graph-*.graph")
+	if err != nil {
+		return err
+	}
+	if len(chain) == 0 {
+		files = append(files, commitGraphChainPath)
+	}
+	for _, f := range files {
+		if keep[f] {
+			continue
+		}
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// computeGenerations sets the topological levels and corrected commit dates
+// of commits, whose parents are either among them or in base.
+func computeGenerations(commits map[string]*graphWriteCommit, base *commitGraph) error {
+	done := make(map[string]bool)
+	generation := func(hash string) (level uint32, corrected uint64, ok bool, err error) {
+		if c := commits[hash]; c != nil {
+			return c.level, c.corrected, done[hash], nil
+		}
+		pos := base.find(hashToBytes(hash))
+		if pos == -1 {
+			return 0, 0, false, fmt.Errorf("parent %s is not in the commit-graph", hash)
+		}
+		gc, err := base.commit(pos)
+		return gc.level, gc.generation, true, err
+	}
+	for hash := range commits {
+		stack := []string{hash}
+		for len(stack) > 0 {
+			c := commits[stack[len(stack)-1]]
+			if done[c.hash] {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			level, corrected, ready := uint32(0), uint64(0), true
+			for _, p := range c.parents {
+				pl, pc, ok, err := generation(p)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					stack, ready = append(stack, p), false
+					continue
+				}
+				if pl > level {
+					level = pl
+				}
+				if pc > corrected {
+					corrected = pc
+				}
+			}
+			if !ready {
+				continue
+			}
+			c.level = level + 1
+			if level >= graphLevelMax {
+				c.level = graphLevelMax
+			}
+			c.corrected = corrected + 1
+			if uint64(c.date) > corrected {
+				c.corrected = uint64(c.date)
+			}
+			done[c.hash] = true
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return nil
+}
+
+// commitGraphFile returns the contents of a commit-graph file for commits,
+// sorted by hash, as a layer above base, with their changed-path filters if
+// there are any.
+func commitGraphFile(commits []*graphWriteCommit, base *commitGraph, filters []bloomFilter) ([]byte, error) {
+	positions := make(map[string]int, len(commits))
+	for i, c := range commits {
+		positions[c.hash] = base.numCommits + i
+	}
+	position := func(hash string) (uint32, error) {
+		if pos, ok := positions[hash]; ok {
+			return uint32(pos), nil
+		}
+		if pos := base.find(hashToBytes(hash)); pos != -1 {
+			return uint32(pos), nil
+		}
+		return 0, fmt.Errorf("parent %s is not in the commit-graph", hash)
+	}
+
+	word := make([]byte, 8)
+	fanOut := make([]byte, 256*4)
+	n := 0
+	for i := 0; i < 256; i++ {
+		for n < len(commits) && int(hashToBytes(commits[n].hash)[0]) <= i {
+			n++
+		}
+		binary.BigEndian.PutUint32(fanOut[i*4:], uint32(n))
+	}
+	oids := make([]byte, 0, len(commits)*sha1.Size)
+	commitData := make([]byte, 0, len(commits)*graphDataSize)
+	edges, dateOffsets, dateOverflows := []byte(nil), make([]byte, 0, len(commits)*4), []byte(nil)
+	for _, c := range commits {
+		oids = append(oids, hashToBytes(c.hash)...)
+		commitData = append(commitData, hashToBytes(c.tree)...)
+		parents := []uint32{graphParentNone, graphParentNone}
+		for i, p := range c.parents {
+			pos, err := position(p)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case i < 2:
+				parents[i] = pos
+			case i == 2:
+				// An octopus merge lists its second parent onwards in EDGE.
+				binary.BigEndian.PutUint32(word, parents[1])
+				edges = append(edges, word[:4]...)
+				parents[1] = graphExtraEdges | uint32(len(edges)/4-1)
+				fallthrough
+			default:
+				if i == len(c.parents)-1 {
+					pos |= graphExtraEdges
+				}
+				binary.BigEndian.PutUint32(word, pos)
+				edges = append(edges, word[:4]...)
+			}
+		}
+		for _, p := range parents {
+			binary.BigEndian.PutUint32(word, p)
+			commitData = append(commitData, word[:4]...)
+		}
+		binary.BigEndian.PutUint32(word, c.level<<2|uint32(uint64(c.date)>>32&3))
+		binary.BigEndian.PutUint32(word[4:], uint32(c.date))
+		commitData = append(commitData, word...)
+
+		offset := c.corrected - uint64(c.date)
+		if offset >= graphOffsetOverflow {
+			binary.BigEndian.PutUint64(word, offset)
+			dateOverflows = append(dateOverflows, word...)
+			offset = graphOffsetOverflow | uint64(len(dateOverflows)/8-1)
+		}
+		binary.BigEndian.PutUint32(word, uint32(offset))
+		dateOffsets = append(dateOffsets, word[:4]...)
+	}
+
+	type chunk struct {
+		id   string
+		data []byte
+	}
+	chunks := []chunk{{"OIDF", fanOut}, {"OIDL", oids}, {"CDAT", commitData}}
+	if base.v2 {
+		chunks = append(chunks, chunk{"GDA2", dateOffsets})
+		if dateOverflows != nil {
+			chunks = append(chunks, chunk{"GDO2", dateOverflows})
+		}
+	}
+	if edges != nil {
+		chunks = append(chunks, chunk{"EDGE", edges})
+	}
+	if filters != nil {
+		index, data, err := bloomChunks(filters)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk{"BIDX", index}, chunk{"BDAT", data})
+	}
+	if len(base.layers) > 0 {
+		bases := []byte(nil)
+		for _, l := range base.layers {
+			bases = append(bases, hashToBytes(l.sum)...)
+		}
+		chunks = append(chunks, chunk{"BASE", bases})
+	}
+	b := bytes.NewBuffer(nil)
+	b.WriteString("CGPH")
+	b.Write([]byte{1, 1, byte(len(chunks)), byte(len(base.layers))})
+	offset := uint64(8 + (len(chunks)+1)*12)
+	for _, c := range chunks {
+		b.WriteString(c.id)
+		binary.BigEndian.PutUint64(word, offset)
+		b.Write(word)
+		offset += uint64(len(c.data))
+	}
+	b.Write([]byte{0, 0, 0, 0})
+	binary.BigEndian.PutUint64(word, offset)
+	b.Write(word)
+	for _, c := range chunks {
+		b.Write(c.data)
+	}
+	sum := sha1.Sum(b.Bytes())
+	b.Write(sum[:])
+	return b.Bytes(), nil
+}
+
+// VerifyCommitGraph checks the commit-graph's checksums and order, and that
+// what it says of each commit matches the commit itself.
+func VerifyCommitGraph() error {
+	closeCommitGraph()
+	g, err := openCommitGraph()
+	if err != nil || g == nil {
+		return err
+	}
+	defer g.close()
+	for _, l := range g.layers {
+		if sum := sha1.Sum(l.data[:len(l.data)-sha1.Size]); !bytes.Equal(sum[:], l.data[len(l.data)-sha1.Size:]) {
+			return errors.New("the commit-graph file has incorrect checksum and is likely corrupt")
+		}
+		for i := 1; i < l.numCommits; i++ {
+			if bytes.Compare(l.hash(i-1), l.hash(i)) >= 0 {
+				return fmt.Errorf("commit-graph has incorrect OID order: %x then %x", l.hash(i-1), l.hash(i))
+			}
+		}
+		for i := 0; i < 256; i++ {
+			want := sort.Search(l.numCommits, func(k int) bool { return int(l.hash(k)[0]) > i })
+			if got := int(binary.BigEndian.Uint32(l.fanOut[i*4:])); got != want {
+				return fmt.Errorf("commit-graph has incorrect fanout value: fanout[%d] = %d != %d", i, got, want)
+			}
+		}
+	}
+	for pos := 0; pos < g.numCommits; pos++ {
+		hash := fmt.Sprintf("%x", g.hash(pos))
+		gc, err := g.commit(pos)
+		if err != nil {
+			return err
+		}
+		c, err := ReadCommit(hash)
+		if err != nil {
+			return fmt.Errorf("failed to parse commit %s from object database for commit-graph", hash)
+		}
+		c.Close()
+		if tree := fmt.Sprintf("%x", gc.tree); tree != c.Tree {
+			return fmt.Errorf("root tree OID for commit %s in commit-graph is %s != %s", hash, tree, c.Tree)
+		}
+		maxGeneration := uint64(0)
+		for i, p := range gc.parents {
+			if i >= len(c.Parent) {
+				return fmt.Errorf("commit-graph parent list for commit %s is too long", hash)
+			}
+			if parent := fmt.Sprintf("%x", g.hash(p)); parent != c.Parent[i] {
+				return fmt.Errorf("commit-graph parent for %s is %s != %s", hash, parent, c.Parent[i])
+			}
+			pc, err := g.commit(p)
+			if err != nil {
+				return err
+			}
+			if pc.generation > maxGeneration {
+				maxGeneration = pc.generation
+			}
+		}
+		if len(gc.parents) < len(c.Parent) {
+			return fmt.Errorf("commit-graph parent list for commit %s terminates early", hash)
+		}
+		if !g.v2 && maxGeneration == graphLevelMax {
+			maxGeneration--
+		}
+		if gc.generation < maxGeneration+1 {
+			return fmt.Errorf("commit-graph generation for commit %s is %d < %d", hash, gc.generation, maxGeneration+1)
+		}
+		if date := c.CommitDate().Unix(); gc.date != date {
+			return fmt.Errorf("commit date for commit %s in commit-graph is %d != %d", hash, gc.date, date)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"crypto/sha1"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommitGraph(t *testing.T) {
+	defer withTempRepo(t)()
+
+	tree := makeTree(t, map[string]string{"a": "a\n"})
+	commitAt := func(when int64, parents ...string) string {
+		t.Helper()
+		who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(when, 0).UTC()}
+		hash, err := CommitTree(tree, parents, who, who, "commit\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	root := commitAt(1000000000)
+	b := commitAt(1000000100, root)
+	skewed := commitAt(999999000, root) // made on a clock running behind
+	octopus := commitAt(1000000200, b, skewed, root)
+	if err := UpdateRef("refs/heads/master", octopus, "", Signature{Name: "A U Thor", Email: "author@example.com"}, "commit"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteCommitGraph(CommitGraphOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyCommitGraph(); err != nil {
+		t.Errorf("VerifyCommitGraph: %v", err)
+	}
+	if g := loadCommitGraph(); g == nil || g.numCommits != 4 || len(g.layers[0].edges) != 8 {
+		t.Fatalf("commit-graph not loaded with 4 commits and an octopus: %+v", g)
+	}
+	info, err := LookupCommitInfo(octopus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{b, skewed, root}; !reflect.DeepEqual(info.Parents, want) || info.Tree != tree {
+		t.Errorf("octopus has parents %v and tree %s, want %v and %s", info.Parents, info.Tree, want, tree)
+	}
+	if info, err := LookupCommitInfo(skewed); err != nil || info.Generation != 1000000001 || info.Date.Unix() != 999999000 {
+		t.Errorf("skewed commit has generation %d and date %v (%v), want its parent's date plus one", info.Generation, info.Date, err)
+	}
+
+	// A new commit goes into a layer of its own, above the old graph.
+	tip := commitAt(1000000300, octopus)
+	if err := UpdateRef("refs/heads/master", tip, octopus, Signature{Name: "A U Thor", Email: "author@example.com"}, "commit"); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCommitGraph(CommitGraphOptions{Split: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(commitGraphPath); !os.IsNotExist(err) {
+		t.Errorf("single commit-graph file left behind: %v", err)
+	}
+	chain, _ := readCommitGraphChain()
+	g := loadCommitGraph()
+	if len(chain) != 2 || g == nil || len(g.layers) != 2 || g.layers[1].numCommits != 1 {
+		t.Fatalf("commit-graph chain %v not loaded as two layers: %+v", chain, g)
+	}
+	if info, err := LookupCommitInfo(tip); err != nil || !reflect.DeepEqual(info.Parents, []string{octopus}) || info.Generation == generationInfinity {
+		t.Errorf("LookupCommitInfo(%s) = %+v, %v", tip, info, err)
+	}
+	if err := VerifyCommitGraph(); err != nil {
+		t.Errorf("VerifyCommitGraph of the chain: %v", err)
+	}
+
+	path := commitGraphLayerPath(chain[1])
+	data, _ := ioutil.ReadFile(path)
+	data[len(data)-sha1.Size-1] ^= 1
+	os.Chmod(path, 0666)
+	ioutil.WriteFile(path, data, 0666)
+	if err := VerifyCommitGraph(); err == nil || !strings.Contains(err.Error(), "incorrect checksum") {
+		t.Errorf("VerifyCommitGraph of a corrupt layer: %v", err)
+	}
+}
@@ -107,6 +107,7 @@ Merge git://bogomips.org/git-svn
 		committer:      "Junio C Hamano",
 		committerEmail: "gitster@pobox.com",
 		date:           time.Unix(1398102789, 0),
+		commitDate:     time.Unix(1398102789, 0),
 		zone:           "-0700",
 		messageStr:     &s}
 
@@ -117,6 +118,60 @@ Merge git://bogomips.org/git-svn
 	}
 }
 
+func TestSignatureString(t *testing.T) {
+	s := Signature{
+		Name:  "James Robinson",
+		Email: "jamesr@chromium.org",
+		When:  time.Unix(1398979283, 0).In(time.FixedZone("", -7*3600))}
+	expected := "James Robinson <jamesr@chromium.org> 1398979283 -0700"
+	if s.String() != expected {
+		t.Errorf("expected %q got %q", expected, s.String())
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	cases := []struct {
+		date string
+		unix int64
+		zone string
+	}{
+		{"1398979283 -0700", 1398979283, "-0700"},
+		{"@1398979283 +0530", 1398979283, "+0530"},
+		{"Thu, 1 May 2014 14:21:23 -0700", 1398979283, "-0700"},
+		{"2014-05-01T14:21:23-07:00", 1398979283, "-0700"},
+		{"2014-05-01 14:21:23 -0700", 1398979283, "-0700"},
+	}
+	for _, c := range cases {
+		d, err := parseDate(c.date)
+		if err != nil {
+			t.Errorf("%q: %v", c.date, err)
+			continue
+		}
+		if d.Unix() != c.unix || d.Format("-0700") != c.zone {
+			t.Errorf("%q: got %d %s", c.date, d.Unix(), d.Format("-0700"))
+		}
+	}
+	if _, err := parseDate("yesterday-ish"); err == nil {
+		t.Error("expected error for bad date")
+	}
+}
+
+func TestCommitTree(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{
+		Name:  "James Robinson",
+		Email: "jamesr@chromium.org",
+		When:  time.Unix(1398372819, 0).In(time.FixedZone("", -7*3600))}
+	hash, err := CommitTree("fbe461fb502beff7c0075f7179fe168599502491", nil, who, who, "Add readme\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != "9072f9473cd87dcc76b213853cce7acd380b689f" {
+		t.Errorf("unexpected commit hash %s", hash)
+	}
+}
+
 func BenchmarkParsePersonLine(b *testing.B) {
 	lines := []string{"author Junio C Hamano <gitster@pobox.com> 1398106469 -0700",
 		"author Elia Pinto <gitter.spiros@gmail.com> 1397669398 -0700",
@@ -0,0 +1,893 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ConfigEntry is one value set in a config file. Key is written
+// "section.name" or "section.subsection.name", with the section and name in
+// lower case.
+type ConfigEntry struct {
+	Key   string
+	Value string
+	// NoValue is set for a key given without "= value", which as a boolean
+	// means true.
+	NoValue bool
+	File    string // the file the value was read from
+	Scope   string // "system", "global", "local", "worktree" or "command"
+
+	// begin and end are the offsets of the entry's text in its file, end
+	// just past the newline ending it. An entry on the same line as its
+	// section header begins where the header ends.
+	begin, end int
+	midLine    bool
+}
+
+// configSection is a "[section]" or "[section "subsection"]" header.
+type configSection struct {
+	name       string // as in ConfigEntry.Key
+	begin, end int    // offsets of the header's text
+}
+
+// configFile is a parsed config file, kept along with its text for editing.
+type configFile struct {
+	path     string
+	data     []byte
+	entries  []ConfigEntry
+	sections []configSection
+	// owner gives the index in sections of each entry's section, or -1 for
+	// entries before the first header.
+	owner []int
+}
+
+// configParser reads git's config syntax a character at a time, following
+// config.c closely so that odd files mean the same thing to both.
+type configParser struct {
+	data []byte
+	pos  int
+	eof  bool
+}
+
+// next returns the next character, treating "\r\n" as "\n" and the end of
+// the file as a final "\n".
+func (p *configParser) next() byte {
+	if p.pos >= len(p.data) {
+		p.eof = true
+		return '\n'
+	}
+	c := p.data[p.pos]
+	p.pos++
+	if c == '\r' && p.pos < len(p.data) && p.data[p.pos] == '\n' {
+		c = '\n'
+		p.pos++
+	}
+	return c
+}
+
+func (p *configParser) error(path string) error {
+	// The line of the last character read, which counts a newline as the
+	// end of its line.
+	line := 1 + bytes.Count(p.data[:p.pos], []byte("\n"))
+	if p.pos > 0 && p.data[p.pos-1] == '\n' {
+		line--
+	}
+	return fmt.Errorf("bad config line %d in file %s", line, path)
+}
+
+func isConfigSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isKeyChar(c byte) bool {
+	return isAlpha(c) || isDigit(c) || c == '-'
+}
+
+// sectionHeader reads a section header after its '['. A "[section.sub]"
+// header is an old spelling of "[section "sub"]" that ignores case.
+func (p *configParser) sectionHeader() (string, bool) {
+	b := []byte(nil)
+	for {
+		c := p.next()
+		switch {
+		case p.eof:
+			return "", false
+		case c == ']':
+			return string(b), len(b) > 0
+		case isConfigSpace(c):
+			return p.subsection(string(b), c)
+		case !isKeyChar(c) && c != '.':
+			return "", false
+		}
+		b = append(b, foldByte(c))
+	}
+}
+
+// subsection reads the quoted part of a "[section "subsection"]" header, c
+// being the space after the section name.
+func (p *configParser) subsection(section string, c byte) (string, bool) {
+	for isConfigSpace(c) {
+		if c == '\n' {
+			return "", false
+		}
+		c = p.next()
+	}
+	if c != '"' {
+		return "", false
+	}
+	b := []byte(section + ".")
+	for {
+		c := p.next()
+		if c == '\n' {
+			return "", false
+		}
+		if c == '"' {
+			break
+		}
+		if c == '\\' {
+			if c = p.next(); c == '\n' {
+				return "", false
+			}
+		}
+		b = append(b, c)
+	}
+	return string(b), p.next() == ']' && section != ""
+}
+
+// value reads a value after its '='. Whitespace outside quotes is trimmed at
+// the ends and each character of it is a space within, '#' and ';' outside
+// quotes start a comment, and a backslash escapes a newline to continue the
+// value on the next line or starts one of the escapes \t, \b, \n, \\ or \".
+func (p *configParser) value() (string, bool) {
+	b := []byte(nil)
+	quote, comment, space := false, false, 0
+	for {
+		c := p.next()
+		if c == '\n' {
+			return string(b), !quote
+		}
+		if comment {
+			continue
+		}
+		if isConfigSpace(c) && !quote {
+			if len(b) > 0 {
+				space++
+			}
+			continue
+		}
+		if !quote && (c == ';' || c == '#') {
+			comment = true
+			continue
+		}
+		for ; space > 0; space-- {
+			b = append(b, ' ')
+		}
+		switch c {
+		case '\\':
+			switch c = p.next(); c {
+			case '\n':
+				continue
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'n':
+				c = '\n'
+			case '\\', '"':
+			default:
+				return "", false
+			}
+		case '"':
+			quote = !quote
+			continue
+		}
+		b = append(b, c)
+	}
+}
+
+// parseConfig parses the text of the config file at path.
+func parseConfig(path string, data []byte) (*configFile, error) {
+	f := &configFile{path: path, data: data}
+	p := &configParser{data: data}
+	if bytes.HasPrefix(data, []byte("\xef\xbb\xbf")) {
+		p.pos = 3
+	}
+	section, comment, lineStart, afterHeader := "", false, p.pos, -1
+	for {
+		start := p.pos
+		c := p.next()
+		if c == '\n' {
+			if p.eof {
+				return f, nil
+			}
+			comment, lineStart, afterHeader = false, p.pos, -1
+			continue
+		}
+		switch {
+		case comment || isConfigSpace(c):
+			continue
+		case c == '#' || c == ';':
+			comment = true
+			continue
+		case c == '[':
+			name, ok := p.sectionHeader()
+			if !ok {
+				return nil, p.error(path)
+			}
+			section, afterHeader = name, p.pos
+			f.sections = append(f.sections, configSection{name: name, begin: start, end: p.pos})
+			continue
+		case !isAlpha(c):
+			return nil, p.error(path)
+		}
+
+		name := []byte{foldByte(c)}
+		for {
+			c = p.next()
+			if p.eof || !isKeyChar(c) {
+				break
+			}
+			name = append(name, foldByte(c))
+		}
+		for c == ' ' || c == '\t' {
+			c = p.next()
+		}
+		e := ConfigEntry{Key: string(name), File: path, begin: lineStart}
+		if section != "" {
+			e.Key = section + "." + e.Key
+		}
+		if afterHeader != -1 {
+			e.begin, e.midLine = afterHeader, true
+		}
+		switch {
+		case c == '\n':
+			e.NoValue = true
+		case c != '=':
+			return nil, p.error(path)
+		default:
+			v, ok := p.value()
+			if !ok {
+				return nil, p.error(path)
+			}
+			e.Value = v
+		}
+		e.end = p.pos
+		f.entries = append(f.entries, e)
+		f.owner = append(f.owner, len(f.sections)-1)
+		if p.eof {
+			return f, nil
+		}
+		comment, lineStart, afterHeader = false, p.pos, -1
+	}
+}
+
+// readConfigFile reads and parses the config file at path. A missing file is
+// empty.
+func readConfigFile(path string) (*configFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &configFile{path: path}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(path, b)
+}
+
+// Config is a set of config values in the order they were read, so that
+// later ones override earlier ones.
+type Config struct {
+	Entries []ConfigEntry
+}
+
+// maxIncludeDepth limits how deeply config files may include one another.
+const maxIncludeDepth = 10
+
+// configReader collects the entries of config files, following includes.
+type configReader struct {
+	config   Config
+	includes bool
+	depth    int
+}
+
+func (r *configReader) read(path, scope string) error {
+	f, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	for _, e := range f.entries {
+		e.Scope = scope
+		r.config.Entries = append(r.config.Entries, e)
+		if !r.includes {
+			continue
+		}
+		include, err := includedFile(e)
+		if err != nil {
+			return err
+		}
+		if include == "" {
+			continue
+		}
+		if r.depth == maxIncludeDepth {
+			return fmt.Errorf("exceeded maximum include depth (%d) while including\n\t%s\nfrom\n\t%s\n"+
+				"This might be due to circular includes.", maxIncludeDepth, include, path)
+		}
+		r.depth++
+		err = r.read(include, scope)
+		r.depth--
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// includedFile returns the file that e, if it is include.path or an
+// includeIf.<condition>.path whose condition holds, includes. A relative
+// path is relative to the directory of the file e is in.
+func includedFile(e ConfigEntry) (string, error) {
+	switch {
+	case e.Key == "include.path":
+	case strings.HasPrefix(e.Key, "includeif.") && strings.HasSuffix(e.Key, ".path"):
+		ok, err := includeConditionHolds(e.Key[len("includeif."):len(e.Key)-len(".path")], e.File)
+		if err != nil || !ok {
+			return "", err
+		}
+	default:
+		return "", nil
+	}
+	path, err := e.Path()
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(e.File), path)
+	}
+	return path, nil
+}
+
+// includeConditionHolds evaluates the condition of an includeIf section for
+// the config file from: "gitdir:" or the case-insensitive "gitdir/i:" with a
+// pattern for the repository's .git directory, or "onbranch:" with a pattern
+// for the current branch.
+func includeConditionHolds(cond, from string) (bool, error) {
+	switch {
+	case strings.HasPrefix(cond, "gitdir:"):
+		return gitdirMatches(cond[len("gitdir:"):], from, 0)
+	case strings.HasPrefix(cond, "gitdir/i:"):
+		return gitdirMatches(cond[len("gitdir/i:"):], from, wmCasefold)
+	case strings.HasPrefix(cond, "onbranch:"):
+		// The ref backend depends on config, so HEAD is read from its
+		// file directly.
+		head, err := filesBackend{}.readRef("HEAD")
+		if err != nil || !strings.HasPrefix(head, refPrefix+"refs/heads/") {
+			return false, err
+		}
+		pattern := cond[len("onbranch:"):]
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+		return wildmatch(pattern, head[len(refPrefix+"refs/heads/"):], wmPathname), nil
+	}
+	return false, nil
+}
+
+func gitdirMatches(pattern, from string, flags int) (bool, error) {
+	prefix := ""
+	switch {
+	case strings.HasPrefix(pattern, "~/"):
+		home, err := expandUserPath("~")
+		if err != nil {
+			return false, err
+		}
+		pattern = home + pattern[1:]
+	case strings.HasPrefix(pattern, "./"):
+		dir, err := filepath.Abs(filepath.Dir(from))
+		if err != nil {
+			return false, err
+		}
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = real
+		}
+		prefix = dir + "/"
+		pattern = prefix + pattern[2:]
+	case !filepath.IsAbs(pattern):
+		pattern = "**/" + pattern
+	}
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	gitDir, err := filepath.Abs(".git")
+	if err != nil {
+		return false, err
+	}
+	// Try the path with symlinks resolved, then as it is, so that a
+	// pattern naming a symlinked directory still matches.
+	dirs := []string{gitDir}
+	if real, err := filepath.EvalSymlinks(gitDir); err == nil {
+		dirs = []string{real, gitDir}
+	}
+	for _, dir := range dirs {
+		if !strings.HasPrefix(dir, prefix) && !(flags&wmCasefold != 0 && strings.HasPrefix(strings.ToLower(dir), strings.ToLower(prefix))) {
+			continue
+		}
+		if wildmatch(pattern[len(prefix):], dir[len(prefix):], wmPathname|flags) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ReadConfigFile reads the config file at path, following includes if
+// includes is set. A missing file has no values.
+func ReadConfigFile(path string, includes bool) (*Config, error) {
+	r := &configReader{includes: includes}
+	if err := r.read(path, "command"); err != nil {
+		return nil, err
+	}
+	return &r.config, nil
+}
+
+// SystemConfigFile returns the system-wide config file, or "" if
+// GIT_CONFIG_NOSYSTEM says not to read it.
+func SystemConfigFile() string {
+	if b, err := parseBool(os.Getenv("GIT_CONFIG_NOSYSTEM")); err == nil && b {
+		return ""
+	}
+	if path := os.Getenv("GIT_CONFIG_SYSTEM"); path != "" {
+		return path
+	}
+	return "/etc/gitconfig"
+}
+
+// GlobalConfigFiles returns the user's config files: the XDG one and then
+// ~/.gitconfig, unless GIT_CONFIG_GLOBAL names another.
+func GlobalConfigFiles() []string {
+	if path := os.Getenv("GIT_CONFIG_GLOBAL"); path != "" {
+		return []string{path}
+	}
+	files := []string(nil)
+	home := os.Getenv("HOME")
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		files = append(files, filepath.Join(xdg, "git", "config"))
+	} else if home != "" {
+		files = append(files, filepath.Join(home, ".config", "git", "config"))
+	}
+	if home != "" {
+		files = append(files, filepath.Join(home, ".gitconfig"))
+	}
+	return files
+}
+
+// WorktreeConfigFile returns the file that worktree-specific config goes in,
+// which is the repository's own config unless extensions.worktreeConfig is
+// set.
+func WorktreeConfigFile() string {
+	f, err := readConfigFile(".git/config")
+	if err != nil {
+		return ".git/config"
+	}
+	c := &Config{Entries: f.entries}
+	if e, ok := c.Get("extensions.worktreeconfig"); ok {
+		if b, err := e.Bool(); err == nil && b {
+			return ".git/config.worktree"
+		}
+	}
+	return ".git/config"
+}
+
+// ReadConfig reads the configuration of the repository in the current
+// directory: the system, global, local and worktree config files in turn,
+// following includes, and then any values the environment sets with
+// GIT_CONFIG_COUNT, GIT_CONFIG_KEY_<n> and GIT_CONFIG_VALUE_<n>.
+func ReadConfig() (*Config, error) {
+	r := &configReader{includes: true}
+	if path := SystemConfigFile(); path != "" {
+		if err := r.read(path, "system"); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range GlobalConfigFiles() {
+		if err := r.read(path, "global"); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.read(".git/config", "local"); err != nil {
+		return nil, err
+	}
+	if path := WorktreeConfigFile(); path != ".git/config" {
+		if err := r.read(path, "worktree"); err != nil {
+			return nil, err
+		}
+	}
+	if count := os.Getenv("GIT_CONFIG_COUNT"); count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bogus count in GIT_CONFIG_COUNT")
+		}
+		for i := 0; i < n; i++ {
+			key, ok := os.LookupEnv(fmt.Sprintf("GIT_CONFIG_KEY_%d", i))
+			if !ok {
+				return nil, fmt.Errorf("missing config key GIT_CONFIG_KEY_%d", i)
+			}
+			value, ok := os.LookupEnv(fmt.Sprintf("GIT_CONFIG_VALUE_%d", i))
+			if !ok {
+				return nil, fmt.Errorf("missing config value GIT_CONFIG_VALUE_%d", i)
+			}
+			canonical, err := CanonicalConfigKey(key)
+			if err != nil {
+				return nil, err
+			}
+			r.config.Entries = append(r.config.Entries, ConfigEntry{Key: canonical, Value: value, Scope: "command"})
+		}
+	}
+	return &r.config, nil
+}
+
+// Get returns the last value of key, which need not be in canonical form.
+func (c *Config) Get(key string) (ConfigEntry, bool) {
+	all := c.GetAll(key)
+	if len(all) == 0 {
+		return ConfigEntry{}, false
+	}
+	return all[len(all)-1], true
+}
+
+// GetAll returns every value of key in order.
+func (c *Config) GetAll(key string) []ConfigEntry {
+	key, err := CanonicalConfigKey(key)
+	if err != nil {
+		return nil
+	}
+	all := []ConfigEntry(nil)
+	for _, e := range c.Entries {
+		if e.Key == key {
+			all = append(all, e)
+		}
+	}
+	return all
+}
+
+// splitConfigKey splits a key into its section, subsection and name, as
+// written, reporting the problem as git does if it isn't a valid key.
+func splitConfigKey(key string) (section, subsection, name string, err error) {
+	first, last := strings.IndexByte(key, '.'), strings.LastIndexByte(key, '.')
+	switch {
+	case first <= 0:
+		return "", "", "", fmt.Errorf("key does not contain a section: %s", key)
+	case last == len(key)-1:
+		return "", "", "", fmt.Errorf("key does not contain variable name: %s", key)
+	}
+	section, name = key[:first], key[last+1:]
+	if first != last {
+		subsection = key[first+1 : last]
+	}
+	for i := 0; i < len(section); i++ {
+		if !isKeyChar(section[i]) {
+			return "", "", "", fmt.Errorf("invalid key: %s", key)
+		}
+	}
+	for i := 0; i < len(name); i++ {
+		if !isKeyChar(name[i]) || i == 0 && !isAlpha(name[i]) {
+			return "", "", "", fmt.Errorf("invalid key: %s", key)
+		}
+	}
+	if strings.IndexByte(subsection, '\n') != -1 {
+		return "", "", "", fmt.Errorf("invalid key (newline): %s", key)
+	}
+	return section, subsection, name, nil
+}
+
+// CanonicalConfigKey lower-cases the section and name of key, leaving any
+// subsection as it is, or reports why key is invalid.
+func CanonicalConfigKey(key string) (string, error) {
+	section, subsection, name, err := splitConfigKey(key)
+	if err != nil {
+		return "", err
+	}
+	if subsection != "" {
+		return strings.ToLower(section) + "." + subsection + "." + strings.ToLower(name), nil
+	}
+	return strings.ToLower(section) + "." + strings.ToLower(name), nil
+}
+
+// errMissingValue is the error for a key given without a value where one is
+// needed.
+func (e ConfigEntry) errMissingValue() error {
+	return fmt.Errorf("missing value for '%s'", e.Key)
+}
+
+// parseBool parses git's boolean words, reporting an error for anything
+// else.
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off", "":
+		return false, nil
+	}
+	return false, errors.New("not a boolean")
+}
+
+// Bool interprets the value as a boolean: true, yes, on or a non-zero number
+// for true and false, no, off, 0 or nothing for false. A key with no value is
+// true.
+func (e ConfigEntry) Bool() (bool, error) {
+	if e.NoValue {
+		return true, nil
+	}
+	if b, err := parseBool(e.Value); err == nil {
+		return b, nil
+	}
+	if n, err := parseConfigInt(e.Value); err == nil {
+		return n != 0, nil
+	}
+	return false, fmt.Errorf("bad boolean config value '%s' for '%s'", e.Value, e.Key)
+}
+
+// ParseMagnitude parses a size such as 512k or 2g the way config values are
+// parsed.
+func ParseMagnitude(s string) (int64, error) {
+	return parseConfigInt(s)
+}
+
+// parseConfigInt parses a number with an optional k, m or g suffix for
+// kibi-, mebi- or gibi-.
+func parseConfigInt(s string) (int64, error) {
+	digits, factor := s, int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			factor = 1 << 10
+		case 'm', 'M':
+			factor = 1 << 20
+		case 'g', 'G':
+			factor = 1 << 30
+		}
+	}
+	if factor != 1 {
+		digits = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(digits), 0, 64)
+	if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+		return 0, errors.New("out of range")
+	}
+	if err != nil || digits == "" {
+		return 0, errors.New("invalid unit")
+	}
+	if n > math.MaxInt64/factor || n < math.MinInt64/factor {
+		return 0, errors.New("out of range")
+	}
+	return n * factor, nil
+}
+
+// Int interprets the value as a whole number, which may end in k, m or g to
+// multiply it by 1024, 1024² or 1024³.
+func (e ConfigEntry) Int() (int64, error) {
+	if e.NoValue {
+		return 0, e.errMissingValue()
+	}
+	n, err := parseConfigInt(e.Value)
+	if err != nil {
+		if e.File == "" {
+			return 0, fmt.Errorf("bad numeric config value '%s' for '%s': %v", e.Value, e.Key, err)
+		}
+		return 0, fmt.Errorf("bad numeric config value '%s' for '%s' in file %s: %v", e.Value, e.Key, e.File, err)
+	}
+	return n, nil
+}
+
+// BoolOrInt interprets the value as a boolean word, or else as a number. It
+// reports whether the value was a boolean.
+func (e ConfigEntry) BoolOrInt() (n int64, isBool bool, err error) {
+	if e.NoValue {
+		return 1, true, nil
+	}
+	if b, err := parseBool(e.Value); err == nil {
+		if b {
+			return 1, true, nil
+		}
+		return 0, true, nil
+	}
+	n, err = e.Int()
+	return n, false, err
+}
+
+// expandUserPath expands a leading "~" or "~user" to a home directory.
+func expandUserPath(path string) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+	name, rest := path[1:], ""
+	if slash := strings.IndexByte(name, '/'); slash != -1 {
+		name, rest = name[:slash], name[slash:]
+	}
+	if name == "" {
+		if home := os.Getenv("HOME"); home != "" {
+			return home + rest, nil
+		}
+	}
+	u, err := user.Current()
+	if name != "" {
+		u, err = user.Lookup(name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to expand user dir in: '%s'", path)
+	}
+	return u.HomeDir + rest, nil
+}
+
+// Path interprets the value as a file name, expanding a leading "~/" to the
+// home directory and "~user/" to user's.
+func (e ConfigEntry) Path() (string, error) {
+	if e.NoValue {
+		return "", e.errMissingValue()
+	}
+	return expandUserPath(e.Value)
+}
+
+// Color interprets the value as a color, such as "bold red" or "#ff0000 ul",
+// and returns the ANSI escape sequence that selects it.
+func (e ConfigEntry) Color() (string, error) {
+	if e.NoValue {
+		return "", e.errMissingValue()
+	}
+	return ParseColor(e.Value)
+}
+
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// parseColorWord parses a color as ANSI parameters for the foreground; bg
+// converts them for the background. It reports false for anything but a
+// color.
+func parseColorWord(word string) (fg, bg string, ok bool) {
+	lower := strings.ToLower(word)
+	switch {
+	case lower == "normal":
+		return "", "", true
+	case lower == "default":
+		return "39", "49", true
+	case len(word) == 7 && word[0] == '#':
+		rgb, err := strconv.ParseUint(word[1:], 16, 32)
+		if err != nil {
+			return "", "", false
+		}
+		v := fmt.Sprintf("8;2;%d;%d;%d", rgb>>16, rgb>>8&0xff, rgb&0xff)
+		return "3" + v, "4" + v, true
+	}
+	for i, name := range colorNames {
+		switch lower {
+		case name:
+			return strconv.Itoa(30 + i), strconv.Itoa(40 + i), true
+		case "bright" + name:
+			return strconv.Itoa(90 + i), strconv.Itoa(100 + i), true
+		}
+	}
+	n, err := strconv.Atoi(word)
+	switch {
+	case err != nil || n < -1:
+		return "", "", false
+	case n == -1:
+		return "", "", true
+	case n < 8:
+		return strconv.Itoa(30 + n), strconv.Itoa(40 + n), true
+	case n < 16:
+		return strconv.Itoa(90 + n - 8), strconv.Itoa(100 + n - 8), true
+	case n < 256:
+		return fmt.Sprintf("38;5;%d", n), fmt.Sprintf("48;5;%d", n), true
+	}
+	return "", "", false
+}
+
+// colorAttributes gives the ANSI parameter of each attribute and, with a
+// "no" or "no-" prefix, of its negation.
+var colorAttributes = map[string][2]int{
+	"bold":    {1, 22},
+	"dim":     {2, 22},
+	"italic":  {3, 23},
+	"ul":      {4, 24},
+	"blink":   {5, 25},
+	"reverse": {7, 27},
+	"strike":  {9, 29},
+}
+
+// ParseColor converts a color specification, made of optional "reset", a
+// foreground and background color and any attributes, into the ANSI escape
+// sequence that selects it. An empty specification gives an empty sequence.
+func ParseColor(spec string) (string, error) {
+	reset := false
+	fg, bg := []string(nil), []string(nil)
+	var attrs uint
+	for _, word := range strings.Fields(spec) {
+		if strings.ToLower(word) == "reset" {
+			reset = true
+			continue
+		}
+		if f, b, ok := parseColorWord(word); ok {
+			switch {
+			case fg == nil:
+				fg = []string{f}
+			case bg == nil:
+				bg = []string{b}
+			default:
+				return "", fmt.Errorf("invalid color value: %s", spec)
+			}
+			continue
+		}
+		attr, negate := strings.ToLower(word), 0
+		if strings.HasPrefix(attr, "no") {
+			attr, negate = strings.TrimPrefix(attr[2:], "-"), 1
+		}
+		codes, ok := colorAttributes[attr]
+		if !ok {
+			return "", fmt.Errorf("invalid color value: %s", spec)
+		}
+		attrs |= 1 << uint(codes[negate])
+	}
+	if !reset && attrs == 0 && fg == nil && bg == nil {
+		return "", nil
+	}
+	params := []string(nil)
+	if reset {
+		params = append(params, "")
+	}
+	for i := uint(0); attrs != 0; i++ {
+		if attrs&(1<<i) != 0 {
+			params = append(params, strconv.Itoa(int(i)))
+			attrs &^= 1 << i
+		}
+	}
+	params = append(append(params, fg...), bg...)
+	return "\x1b[" + strings.Join(params, ";") + "m", nil
+}
+
+// ConfigValue returns the value of key in the repository's configuration,
+// the last one if it is set more than once. It reports false if key isn't
+// set or the configuration can't be read.
+func ConfigValue(key string) (string, bool) {
+	c, err := ReadConfig()
+	if err != nil {
+		return "", false
+	}
+	e, ok := c.Get(key)
+	return e.Value, ok
+}
+
+// ConfigBool returns the value of key as a boolean, or def if it isn't set.
+func ConfigBool(key string, def bool) (bool, error) {
+	c, err := ReadConfig()
+	if err != nil {
+		return def, err
+	}
+	e, ok := c.Get(key)
+	if !ok {
+		return def, nil
+	}
+	return e.Bool()
+}
+
+// ConfigInt returns the value of key as a number, or def if it isn't set.
+func ConfigInt(key string, def int64) (int64, error) {
+	c, err := ReadConfig()
+	if err != nil {
+		return def, err
+	}
+	e, ok := c.Get(key)
+	if !ok {
+		return def, nil
+	}
+	return e.Int()
+}
@@ -0,0 +1,293 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrMultipleConfigValues is returned when a change meant for a single value
+// would affect several.
+var ErrMultipleConfigValues = errors.New("cannot overwrite multiple values with a single value")
+
+// configValueText writes name = value as git does, quoting the value if
+// spaces at its ends or a comment character would otherwise be lost.
+func configValueText(name, value string) string {
+	quote := ""
+	if strings.HasPrefix(value, " ") || strings.HasSuffix(value, " ") || strings.ContainsAny(value, "#;") {
+		quote = "\""
+	}
+	r := strings.NewReplacer("\n", "\\n", "\t", "\\t", "\"", "\\\"", "\\", "\\\\")
+	return "\t" + name + " = " + quote + r.Replace(value) + quote + "\n"
+}
+
+// configSectionText writes the header of section, with any subsection
+// quoted.
+func configSectionText(section, subsection string) string {
+	if subsection == "" {
+		return "[" + section + "]\n"
+	}
+	r := strings.NewReplacer("\"", "\\\"", "\\", "\\\\")
+	return "[" + section + " \"" + r.Replace(subsection) + "\"]\n"
+}
+
+// configEdit replaces the text between begin and end of a config file.
+type configEdit struct {
+	begin, end int
+	text       string
+}
+
+// writeConfigFile applies edits, sorted by position, to f and replaces the
+// file with the result under its lock.
+func writeConfigFile(f *configFile, l *lockFile, edits []configEdit) error {
+	out := []byte(nil)
+	pos := 0
+	for _, e := range edits {
+		out = append(append(out, f.data[pos:e.begin]...), e.text...)
+		pos = e.end
+	}
+	out = append(out, f.data[pos:]...)
+	if _, err := l.Write(out); err != nil {
+		return err
+	}
+	return l.commit()
+}
+
+// lockConfigFile locks the config file at path, creating its directory if
+// need be, and parses it.
+func lockConfigFile(path string) (*configFile, *lockFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, nil, err
+	}
+	l, err := lock(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := readConfigFile(path)
+	if err != nil {
+		l.rollback()
+		return nil, nil, err
+	}
+	return f, l, nil
+}
+
+// removeEntryEdit removes entry i of f, leaving the line of an entry that
+// follows its section header.
+func removeEntryEdit(f *configFile, i int) configEdit {
+	e := f.entries[i]
+	if e.midLine {
+		return configEdit{e.begin, e.end, "\n"}
+	}
+	return configEdit{e.begin, e.end, ""}
+}
+
+// matchingEntries returns the indexes of the entries of f for key whose
+// values match accepts, or all of them if match is nil.
+func matchingEntries(f *configFile, key string, match func(string) bool) []int {
+	found := []int(nil)
+	for i, e := range f.entries {
+		if e.Key == key && (match == nil || match(e.Value)) {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+// SetConfigFileValue sets key to value in the config file at path, creating
+// the file if need be. The values of key that match accepts, or all of them
+// if match is nil, are replaced; unless replaceAll is set it is an error if
+// there are more than one. If none match the value is added after the last
+// value in key's section, or in a new section at the end of the file. The
+// rest of the file is left as it was.
+func SetConfigFileValue(path, key, value string, match func(string) bool, replaceAll bool) error {
+	section, subsection, name, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+	canonical, _ := CanonicalConfigKey(key)
+	f, l, err := lockConfigFile(path)
+	if err != nil {
+		return err
+	}
+	defer l.rollback()
+
+	found := matchingEntries(f, canonical, match)
+	if len(found) > 1 && !replaceAll {
+		return ErrMultipleConfigValues
+	}
+	text := configValueText(name, value)
+	if len(found) > 0 {
+		// Like git, put the new value where the last old one was.
+		edits := []configEdit(nil)
+		for _, i := range found[:len(found)-1] {
+			edits = append(edits, removeEntryEdit(f, i))
+		}
+		last := f.entries[found[len(found)-1]]
+		if last.midLine {
+			text = "\n" + text
+		}
+		return writeConfigFile(f, l, append(edits, configEdit{last.begin, last.end, text}))
+	}
+
+	// The new value goes at the end of the last of key's sections.
+	sectionName := canonical[:len(canonical)-len(name)-1]
+	at := -1
+	for _, s := range f.sections {
+		if s.name == sectionName {
+			at = lineEnd(f.data, s.end)
+		}
+	}
+	for i, e := range f.entries {
+		if f.owner[i] != -1 && f.sections[f.owner[i]].name == sectionName && e.end > at {
+			at = e.end
+		}
+	}
+	if at == -1 {
+		at = len(f.data)
+		text = configSectionText(section, subsection) + text
+	}
+	if at > 0 && f.data[at-1] != '\n' {
+		text = "\n" + text
+	}
+	return writeConfigFile(f, l, []configEdit{{at, at, text}})
+}
+
+// lineEnd returns the offset just past the end of the line containing pos.
+func lineEnd(data []byte, pos int) int {
+	for pos < len(data) && data[pos] != '\n' {
+		pos++
+	}
+	if pos < len(data) {
+		pos++
+	}
+	return pos
+}
+
+// UnsetConfigFileValue removes the values of key in the config file at path
+// that match accepts, or all of them if match is nil. Unless all is set it is
+// an error if more than one match. It returns how many values were removed.
+func UnsetConfigFileValue(path, key string, match func(string) bool, all bool) (int, error) {
+	canonical, err := CanonicalConfigKey(key)
+	if err != nil {
+		return 0, err
+	}
+	f, l, err := lockConfigFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer l.rollback()
+	found := matchingEntries(f, canonical, match)
+	if len(found) > 1 && !all {
+		return 0, ErrMultipleConfigValues
+	}
+	if len(found) == 0 {
+		return 0, nil
+	}
+	edits := []configEdit(nil)
+	for _, i := range found {
+		edits = append(edits, removeEntryEdit(f, i))
+	}
+	return len(found), writeConfigFile(f, l, edits)
+}
+
+// RenameConfigFileSection renames section, such as "branch.topic", to
+// newName in the config file at path, or removes it with all its values if
+// newName is empty. As in git, section must match the headers as written,
+// case and all. It reports whether the section was there.
+func RenameConfigFileSection(path, section, newName string) (bool, error) {
+	header := ""
+	if newName != "" {
+		s, sub, _, err := splitConfigKey(newName + ".x")
+		if err != nil {
+			return false, err
+		}
+		header = strings.TrimSuffix(configSectionText(s, sub), "\n")
+	}
+	f, l, err := lockConfigFile(path)
+	if err != nil {
+		return false, err
+	}
+	defer l.rollback()
+	edits := []configEdit(nil)
+	for i, s := range f.sections {
+		if writtenSectionName(f.data[s.begin:s.end]) != section {
+			continue
+		}
+		if newName != "" {
+			edits = append(edits, configEdit{s.begin, s.end, header})
+			continue
+		}
+		// Remove the header's line, up to the next section.
+		end := len(f.data)
+		if i+1 < len(f.sections) {
+			end = f.sections[i+1].begin
+		}
+		edits = append(edits, configEdit{lineStart(f.data, s.begin), lineStart(f.data, end), ""})
+	}
+	if len(edits) == 0 {
+		return false, nil
+	}
+	return true, writeConfigFile(f, l, edits)
+}
+
+// writtenSectionName returns the name in a section header as written, with
+// the space before any subsection made a dot and its quoting removed.
+func writtenSectionName(header []byte) string {
+	b := []byte(nil)
+	dot := false
+	for i := 1; i < len(header) && header[i] != ']'; i++ {
+		c := header[i]
+		switch {
+		case !dot && isConfigSpace(c):
+			dot = true
+			b = append(b, '.')
+			for i+1 < len(header) && isConfigSpace(header[i+1]) {
+				i++
+			}
+			i++ // the opening quote
+		case dot && c == '\\' && i+1 < len(header):
+			i++
+			b = append(b, header[i])
+		case dot && c == '"':
+			return string(b)
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+// lineStart returns the offset of the start of the line containing pos.
+func lineStart(data []byte, pos int) int {
+	for pos > 0 && data[pos-1] != '\n' {
+		pos--
+	}
+	return pos
+}
+
+// SetConfigValue sets key to value in the repository's config file,
+// replacing any values it had there.
+func SetConfigValue(key, value string) error {
+	return SetConfigFileValue(".git/config", key, value, nil, true)
+}
+
+// UnsetConfigValue removes every value of key from the repository's config
+// file.
+func UnsetConfigValue(key string) error {
+	_, err := UnsetConfigFileValue(".git/config", key, nil, true)
+	return err
+}
+
+// RenameConfigSection renames the section, such as "branch.topic", in the
+// repository's config file to newName, or removes it with all its values if
+// newName is empty.
+func RenameConfigSection(section, newName string) error {
+	_, err := RenameConfigFileSection(".git/config", section, newName)
+	return err
+}
@@ -0,0 +1,215 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	text := "# comment\n" +
+		"[Core]\n" +
+		"\tBare = false ; trailing comment\n" +
+		"\tflag\n" +
+		"[remote \"Origin\"] url = a\\\\b\n" +
+		"[Old.Style]\n" +
+		"\tquoted = \" two  spaces \" \\t tab\n" +
+		"\tlong = one \\\n" +
+		"two\n" +
+		"\tescapes = \"a\\\"b\\nc\"\n" +
+		"\tspaces = x   y # z\n"
+	f, err := parseConfig("config", []byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ConfigEntry{
+		{Key: "core.bare", Value: "false"},
+		{Key: "core.flag", NoValue: true},
+		{Key: "remote.Origin.url", Value: "a\\b"},
+		{Key: "old.style.quoted", Value: " two  spaces  \t tab"},
+		{Key: "old.style.long", Value: "one two"},
+		{Key: "old.style.escapes", Value: "a\"b\nc"},
+		{Key: "old.style.spaces", Value: "x   y"},
+	}
+	if len(f.entries) != len(want) {
+		t.Fatalf("got %d entries %+v, want %d", len(f.entries), f.entries, len(want))
+	}
+	for i, e := range f.entries {
+		if e.Key != want[i].Key || e.Value != want[i].Value || e.NoValue != want[i].NoValue {
+			t.Errorf("entry %d is %q = %q (%v), want %q = %q (%v)", i, e.Key, e.Value, e.NoValue,
+				want[i].Key, want[i].Value, want[i].NoValue)
+		}
+	}
+
+	for _, test := range []struct{ text, err string }{
+		{"[a]\n\tx = \"open\n", "bad config line 2 in file config"},
+		{"[a\n", "bad config line 1 in file config"},
+		{"[a]\n\n\t1x = y\n", "bad config line 3 in file config"},
+		{"[a \"b]\n", "bad config line 1 in file config"},
+	} {
+		if _, err := parseConfig("config", []byte(test.text)); err == nil || err.Error() != test.err {
+			t.Errorf("parsing %q gave error %v, want %q", test.text, err, test.err)
+		}
+	}
+}
+
+func TestConfigTypes(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  bool
+	}{{"yes", true}, {"On", true}, {"1", true}, {"off", false}, {"0", false}, {"", false}} {
+		if b, err := (ConfigEntry{Key: "a.b", Value: test.value}).Bool(); err != nil || b != test.want {
+			t.Errorf("Bool(%q) = %v, %v, want %v", test.value, b, err, test.want)
+		}
+	}
+	if b, err := (ConfigEntry{Key: "a.b", NoValue: true}).Bool(); err != nil || !b {
+		t.Errorf("a key with no value isn't true")
+	}
+	if _, err := (ConfigEntry{Key: "a.b", Value: "maybe"}).Bool(); err == nil ||
+		err.Error() != "bad boolean config value 'maybe' for 'a.b'" {
+		t.Errorf("Bool(\"maybe\") gave error %v", err)
+	}
+
+	for _, test := range []struct {
+		value string
+		want  int64
+		err   string
+	}{
+		{"12", 12, ""},
+		{"-1k", -1024, ""},
+		{"2M", 2 << 20, ""},
+		{"0x10", 16, ""},
+		{"1g", 1 << 30, ""},
+		{"1x", 0, "bad numeric config value '1x' for 'a.b' in file f: invalid unit"},
+		{"9999999999g", 0, "bad numeric config value '9999999999g' for 'a.b' in file f: out of range"},
+	} {
+		n, err := ConfigEntry{Key: "a.b", Value: test.value, File: "f"}.Int()
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("Int(%q) gave error %v, want %q", test.value, err, test.err)
+			}
+		} else if err != nil || n != test.want {
+			t.Errorf("Int(%q) = %d, %v, want %d", test.value, n, err, test.want)
+		}
+	}
+
+	for _, test := range []struct{ spec, want string }{
+		{"", ""},
+		{"red", "\x1b[31m"},
+		{"bold red", "\x1b[1;31m"},
+		{"red blue ul", "\x1b[4;31;44m"},
+		{"brightgreen", "\x1b[92m"},
+		{"#ff0000 nobold", "\x1b[22;38;2;255;0;0m"},
+		{"reset 7", "\x1b[;37m"},
+		{"208", "\x1b[38;5;208m"},
+	} {
+		if got, err := ParseColor(test.spec); err != nil || got != test.want {
+			t.Errorf("ParseColor(%q) = %q, %v, want %q", test.spec, got, err, test.want)
+		}
+	}
+	if _, err := ParseColor("red blue green"); err == nil {
+		t.Errorf("parsed three colors")
+	}
+}
+
+func TestConfigIncludes(t *testing.T) {
+	defer withTempRepo(t)()
+	wd, _ := os.Getwd()
+	os.MkdirAll(".git/inc", 0777)
+	ioutil.WriteFile(".git/inc/a", []byte("[x]\n\ta = included\n"), 0666)
+	ioutil.WriteFile(".git/inc/b", []byte("[x]\n\tb = gitdir\n"), 0666)
+	ioutil.WriteFile(".git/inc/c", []byte("[x]\n\tc = branch\n"), 0666)
+	ioutil.WriteFile(".git/inc/d", []byte("[x]\n\td = other\n"), 0666)
+	ioutil.WriteFile(".git/inc/loop", []byte("[include]\n\tpath = loop\n"), 0666)
+	ioutil.WriteFile(".git/config", []byte("[x]\n\ta = local\n"+
+		"[include]\n\tpath = inc/a\n"+
+		"[includeIf \"gitdir:"+filepath.Base(wd)+"/\"]\n\tpath = inc/b\n"+
+		"[includeIf \"onbranch:mas*\"]\n\tpath = inc/c\n"+
+		"[includeIf \"onbranch:main\"]\n\tpath = inc/d\n"), 0666)
+
+	c, err := ReadConfigFile(".git/config", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := c.Get("x.a"); e.Value != "included" || e.File != ".git/inc/a" {
+		t.Errorf("x.a = %q from %s, want the included value", e.Value, e.File)
+	}
+	if all := c.GetAll("X.A"); len(all) != 2 {
+		t.Errorf("x.a has %d values, want 2", len(all))
+	}
+	for key, want := range map[string]bool{"x.b": true, "x.c": true, "x.d": false} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("%s set is %v, want %v", key, ok, want)
+		}
+	}
+	if c, _ := ReadConfigFile(".git/config", false); len(c.GetAll("x.a")) != 1 {
+		t.Errorf("includes followed when not asked for")
+	}
+	if _, err := ReadConfigFile(".git/inc/loop", true); err == nil ||
+		!strings.HasPrefix(err.Error(), "exceeded maximum include depth") {
+		t.Errorf("circular include gave error %v", err)
+	}
+}
+
+func TestEditConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ggit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config")
+	ioutil.WriteFile(path, []byte("# keep me\n[a] x = 1\n[b \"Sub\"]\n\tk = one ; note\n\tk = two\n[Sec.Tion]\n\tv = 1\n"), 0666)
+
+	check := func(want string) {
+		t.Helper()
+		if b, _ := ioutil.ReadFile(path); string(b) != want {
+			t.Errorf("config is\n%s\nwant\n%s", b, want)
+		}
+	}
+	if err := SetConfigFileValue(path, "a.x", "2", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	check("# keep me\n[a]\n\tx = 2\n[b \"Sub\"]\n\tk = one ; note\n\tk = two\n[Sec.Tion]\n\tv = 1\n")
+	if err := SetConfigFileValue(path, "b.Sub.k", "three", nil, false); err != ErrMultipleConfigValues {
+		t.Errorf("replacing two values gave error %v", err)
+	}
+	one := func(v string) bool { return v == "one" }
+	if err := SetConfigFileValue(path, "b.Sub.k", "#1", one, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfigFileValue(path, "a.New", " pad", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	check("# keep me\n[a]\n\tx = 2\n\tNew = \" pad\"\n[b \"Sub\"]\n\tk = \"#1\"\n\tk = two\n[Sec.Tion]\n\tv = 1\n")
+	if err := SetConfigFileValue(path, "b.Sub.k", "all", nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfigFileValue(path, "c.d.e", "tab\there", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	check("# keep me\n[a]\n\tx = 2\n\tNew = \" pad\"\n[b \"Sub\"]\n\tk = all\n[Sec.Tion]\n\tv = 1\n[c \"d\"]\n\te = tab\\there\n")
+
+	if n, err := UnsetConfigFileValue(path, "a.new", nil, false); n != 1 || err != nil {
+		t.Errorf("unset removed %d values, %v", n, err)
+	}
+	if n, err := UnsetConfigFileValue(path, "a.missing", nil, false); n != 0 || err != nil {
+		t.Errorf("unset of a missing key removed %d values, %v", n, err)
+	}
+	if ok, err := RenameConfigFileSection(path, "b.Sub", "z.Y"); !ok || err != nil {
+		t.Errorf("rename found %v, %v", ok, err)
+	}
+	if ok, _ := RenameConfigFileSection(path, "sec.tion", ""); ok {
+		t.Errorf("removed a section named with different case")
+	}
+	if ok, err := RenameConfigFileSection(path, "Sec.Tion", ""); !ok || err != nil {
+		t.Errorf("remove found %v, %v", ok, err)
+	}
+	check("# keep me\n[a]\n\tx = 2\n[z \"Y\"]\n\tk = all\n[c \"d\"]\n\te = tab\\there\n")
+}
@@ -8,6 +8,7 @@ package ggit
 import (
 	"bytes"
 	"crypto/sha1"
+	"fmt"
 	"os"
 	"sort"
 	"strings"
@@ -25,8 +26,10 @@ import (
 
 type pack struct {
 	p              *packFile
-	idx            packIndexFile
+	idx            packIndexFile // once loadIndex has read it
 	baseFileName   string
+	inMIDX         bool     // whether the multi-pack-index covers the pack
+	order          []uint32 // index positions in pack order, once needed
 	pFile, idxFile *os.File
 }
 
@@ -35,10 +38,30 @@ func (p pack) Close() {
 		_ = syscall.Munmap(p.p.data)
 		_ = p.pFile.Close()
 	}
-	_ = syscall.Munmap(p.idx.data)
+	if p.idx.data != nil {
+		_ = syscall.Munmap(p.idx.data)
+	}
 	_ = p.idxFile.Close()
 }
 
+// loadIndex maps and parses the pack's index the first time it is called.
+func (p *pack) loadIndex() error {
+	if p.idx.data != nil {
+		return nil
+	}
+	data, err := mmapFile(".git/objects/pack/" + p.baseFileName + ".idx")
+	if err != nil {
+		return err
+	}
+	idx, err := parsePackIndexFile(data)
+	if err != nil {
+		_ = syscall.Munmap(data)
+		return fmt.Errorf("%s.idx: %v", p.baseFileName, err)
+	}
+	p.idx = idx
+	return nil
+}
+
 func (p *pack) parsePackFile() error {
 	data, err := mmapFile(".git/objects/pack/" + p.baseFileName + ".pack")
 	if err != nil {
@@ -52,7 +75,9 @@ func (p *pack) parsePackFile() error {
 	return nil
 }
 
-func (p *pack) findHash(hash []byte) *Object {
+// find returns the position of hash in the pack's index, or -1 if the pack
+// doesn't have it.
+func (p *pack) find(hash []byte) int {
 	if len(hash) > sha1.Size {
 		hash = hash[:sha1.Size]
 	}
@@ -65,63 +90,187 @@ func (p *pack) findHash(hash []byte) *Object {
 		return bytes.Compare(hash, p.idx.hash(i + lo)[:len(hash)]) <= 0
 	}) + lo
 	if idx == hi {
-		return nil
+		return -1
 	}
 	cmp := bytes.Compare(hash, p.idx.hash(idx)[:len(hash)])
 	if cmp != 0 {
-		return nil
+		return -1
+	}
+	return idx
+}
+
+// findHash extracts the object named hash, returning nil if the pack
+// doesn't have it.
+func (p *pack) findHash(hash []byte) (*Object, error) {
+	idx := p.find(hash)
+	if idx == -1 {
+		return nil, nil
 	}
+	return p.objectAt(p.idx.offset(idx))
+}
+
+// objectAt extracts the object at offset in the pack file.
+func (p *pack) objectAt(offset uint64) (*Object, error) {
 	if p.p == nil {
-		err := p.parsePackFile()
-		if err != nil {
-			panic(err)
+		if err := p.parsePackFile(); err != nil {
+			return nil, err
 		}
 	}
-	o, err := p.p.extractObject(p.idx.offset(idx))
+	o, err := p.p.extractObject(offset)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("reading %s at offset %d: %v", p.baseFileName, offset, err)
 	}
-	return &o
+	return &o, nil
 }
 
 var parsedPackFiles = []*pack(nil) // nil means not yet checked, empty means no pack files
 
-func findHash(hash []byte) (*Object, error) {
-	if parsedPackFiles == nil {
-		f, err := os.Open(".git/objects/pack")
-		if err != nil {
-			return nil, err
-		}
-		names, err := f.Readdirnames(0)
-		if err != nil {
-			return nil, err
+var parsedMIDX *multiPackIndex // the multi-pack-index, if there is one and all its packs are present
+
+// loadPacks finds the pack files in .git/objects/pack the first time it is
+// called, parsing the indices of those the multi-pack-index doesn't cover.
+func loadPacks() error {
+	if parsedPackFiles != nil {
+		return nil
+	}
+	f, err := os.Open(".git/objects/pack")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(0)
+	if err != nil {
+		return err
+	}
+	packs := []*pack{}
+	for _, n := range names {
+		if !strings.HasPrefix(n, "pack-") || !strings.HasSuffix(n, ".idx") {
+			continue
 		}
-		for _, n := range names {
-			if !strings.HasPrefix(n, "pack-") {
-				continue
+		packs = append(packs, &pack{baseFileName: n[:len(n)-len(".idx")]})
+	}
+	parsedPackFiles = packs
+	if err := loadMultiPackIndex(); err != nil {
+		return err
+	}
+	for _, p := range packs {
+		if !p.inMIDX {
+			if err := p.loadIndex(); err != nil {
+				return err
 			}
-			if strings.HasSuffix(n, ".idx") {
-				data, err := mmapFile(".git/objects/pack/" + n)
-				idx, err := parsePackIndexFile(data)
-				if err != nil {
-					return nil, err
-				}
-				parsedPackFiles = append(parsedPackFiles,
-					&pack{p: nil,
-						pFile:        nil,
-						baseFileName: n[:len(n)-len(".idx")],
-						idx:          idx,
-						idxFile:      f})
+		}
+	}
+	return nil
+}
+
+// openPacks parses every pack file and index, so that objects can then be
+// read from several goroutines.
+func openPacks() error {
+	if err := loadPacks(); err != nil {
+		return err
+	}
+	for _, p := range parsedPackFiles {
+		if err := p.loadIndex(); err != nil {
+			return err
+		}
+		if p.p == nil {
+			if err := p.parsePackFile(); err != nil {
+				return err
 			}
 		}
 	}
+	return nil
+}
+
+// closePacks unmaps the pack files, so that the next lookup finds the packs
+// in .git/objects/pack afresh.
+func closePacks() {
+	for _, p := range parsedPackFiles {
+		p.Close()
+	}
+	parsedPackFiles = nil
+	if parsedMIDX != nil {
+		parsedMIDX.Close()
+		parsedMIDX = nil
+	}
+	closeBitmap()
+	deltaBases.clear()
+}
 
+// isPacked reports whether one of the pack files has the object hash.
+func isPacked(hash string) (bool, error) {
+	if err := loadPacks(); err != nil {
+		return false, err
+	}
+	if parsedMIDX != nil && parsedMIDX.find(hashToBytes(hash)) != -1 {
+		return true, nil
+	}
 	for _, p := range parsedPackFiles {
-		o := p.findHash(hash)
-		if o != nil {
-			return o, nil
+		if !p.inMIDX && p.find(hashToBytes(hash)) != -1 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func findHash(hash []byte) (*Object, error) {
+	if err := loadPacks(); err != nil {
+		return nil, err
+	}
+	if m := parsedMIDX; m != nil {
+		if i := m.find(hash); i != -1 {
+			p, offset, err := m.packOffset(i)
+			if err != nil {
+				return nil, err
+			}
+			return p.objectAt(offset)
+		}
+	}
+	for _, p := range parsedPackFiles {
+		if p.inMIDX {
+			continue
+		}
+		if o, err := p.findHash(hash); o != nil || err != nil {
+			return o, err
 		}
 	}
 
 	return nil, nil
 }
+
+// findPackedPrefix returns the names of packed objects starting with the hex
+// string prefix.
+func findPackedPrefix(prefix string) ([]string, error) {
+	if err := loadPacks(); err != nil {
+		return nil, err
+	}
+	found := []string(nil)
+	search := func(n int, fanOut func(int) int, hash func(int) []byte) {
+		lo, hi := 0, n
+		if len(prefix) >= 2 {
+			first := int(hashToBytes(prefix[:2])[0])
+			if first > 0 {
+				lo = fanOut(first - 1)
+			}
+			hi = fanOut(first)
+		}
+		for i := lo; i < hi; i++ {
+			h := fmt.Sprintf("%x", hash(i))
+			if strings.HasPrefix(h, prefix) {
+				found = append(found, h)
+			}
+		}
+	}
+	if m := parsedMIDX; m != nil {
+		search(m.numObjects, m.fanOutAt, m.hash)
+	}
+	for _, p := range parsedPackFiles {
+		if !p.inMIDX {
+			search(p.idx.numEntries, func(i int) int { return p.idx.fanOut[i] }, p.idx.hash)
+		}
+	}
+	return found, nil
+}
@@ -13,9 +13,18 @@ import (
 	"io/ioutil"
 )
 
+// compressedDeltaReader applies a chain of deltas from a pack to their base
+// when first read. The base and the results part way down the chain go in
+// the delta base cache.
 type compressedDeltaReader struct {
+	pack             *packFile
+	objectType       string
+	baseOffset       uint64
 	baseCompressed   []byte
+	baseData         []byte  // the base, if it was cached
+	baseObject       *Object // the base, if found outside the pack
 	deltasCompressed [][]byte
+	deltaOffsets     []uint64  // where each delta is in the pack
 	r                io.Reader // Lazily set on first access
 }
 
@@ -30,13 +39,22 @@ func readAllBytes(compressed []byte) ([]byte, error) {
 
 func (d *compressedDeltaReader) Read(b []byte) (int, error) {
 	if d.r == nil {
-		base, err := readAllBytes(d.baseCompressed)
+		base := d.baseData
+		var err error
+		if d.baseObject != nil {
+			base, err = ioutil.ReadAll(d.baseObject.Reader)
+			d.baseObject.Close()
+		} else if base == nil {
+			if base, err = readAllBytes(d.baseCompressed); err == nil {
+				deltaBases.add(d.pack, d.baseOffset, d.objectType, base)
+			}
+		}
 		if err != nil {
 			return 0, fmt.Errorf("error decompressing base: %v", err)
 		}
 		patched := base
-		for i := range d.deltasCompressed {
-			delta, err := readAllBytes(d.deltasCompressed[len(d.deltasCompressed)-i-1])
+		for i := len(d.deltasCompressed) - 1; i >= 0; i-- {
+			delta, err := readAllBytes(d.deltasCompressed[i])
 			if err != nil {
 				return 0, fmt.Errorf("error decompressing delta: %v", err)
 			}
@@ -44,12 +62,34 @@ func (d *compressedDeltaReader) Read(b []byte) (int, error) {
 			if err != nil {
 				return 0, fmt.Errorf("error applying delta: %v", err)
 			}
+			if i > 0 {
+				// A base for the rest of the chain.
+				deltaBases.add(d.pack, d.deltaOffsets[i], d.objectType, patched)
+			}
 		}
 		d.r = bytes.NewReader(patched)
 	}
 	return d.r.Read(b)
 }
 
+// deltaResultSize returns the size of the object the compressed delta
+// produces.
+func deltaResultSize(compressed []byte) (int, error) {
+	r, err := getZlibReader(bytes.NewReader(compressed))
+	if err != nil {
+		return 0, err
+	}
+	defer returnZlibReader(r)
+	header := make([]byte, 20)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, fmt.Errorf("error decompressing delta: %v", err)
+	}
+	_, used := deltaHeaderSize(header[:n])
+	size, _ := deltaHeaderSize(header[used:n])
+	return int(size), nil
+}
+
 func deltaHeaderSize(delta []byte) (uint32, int) {
 	used := 0
 	size := uint32(0)
@@ -129,3 +169,152 @@ func patchDelta(base, delta []byte) ([]byte, error) {
 	}
 	return result, nil
 }
+
+// deltaBlockSize is the length of the runs of the base a delta index records,
+// and so the shortest copy createDelta looks for.
+const deltaBlockSize = 16
+
+// deltaHashLimit caps how many places a delta index remembers for one run of
+// bytes, so that repetitive bases don't make matching quadratic.
+const deltaHashLimit = 64
+
+const deltaHashPrime = 16777619
+
+// deltaHashOut undoes the contribution of the byte leaving the rolling hash.
+var deltaHashOut = func() uint32 {
+	h := uint32(1)
+	for i := 0; i < deltaBlockSize-1; i++ {
+		h *= deltaHashPrime
+	}
+	return h
+}()
+
+func deltaHash(b []byte) uint32 {
+	h := uint32(0)
+	for _, c := range b[:deltaBlockSize] {
+		h = h*deltaHashPrime + uint32(c)
+	}
+	return h
+}
+
+// deltaIndex records where each block of a base starts, for finding copies
+// from it.
+type deltaIndex struct {
+	base   []byte
+	blocks map[uint32][]int
+}
+
+func newDeltaIndex(base []byte) *deltaIndex {
+	idx := &deltaIndex{base: base, blocks: make(map[uint32][]int, len(base)/deltaBlockSize)}
+	for i := 0; i+deltaBlockSize <= len(base); i += deltaBlockSize {
+		h := deltaHash(base[i:])
+		if len(idx.blocks[h]) < deltaHashLimit {
+			idx.blocks[h] = append(idx.blocks[h], i)
+		}
+	}
+	return idx
+}
+
+func appendDeltaSize(b []byte, size int) []byte {
+	for size >= 0x80 {
+		b = append(b, byte(size)|0x80)
+		size >>= 7
+	}
+	return append(b, byte(size))
+}
+
+// appendDeltaInsert adds instructions inserting data.
+func appendDeltaInsert(b, data []byte) []byte {
+	for len(data) > 0 {
+		n := len(data)
+		if n > 0x7f {
+			n = 0x7f
+		}
+		b = append(append(b, byte(n)), data[:n]...)
+		data = data[n:]
+	}
+	return b
+}
+
+// appendDeltaCopy adds instructions copying size bytes of the base from
+// offset, in pieces of at most 64k as git writes them.
+func appendDeltaCopy(b []byte, offset, size int) []byte {
+	for size > 0 {
+		n := size
+		if n > 0x10000 {
+			n = 0x10000
+		}
+		cmd := len(b)
+		b = append(b, 0x80)
+		for i := uint(0); i < 4; i++ {
+			if c := byte(offset >> (8 * i)); c != 0 {
+				b[cmd] |= 1 << i
+				b = append(b, c)
+			}
+		}
+		if n != 0x10000 {
+			for i := uint(0); i < 3; i++ {
+				if c := byte(n >> (8 * i)); c != 0 {
+					b[cmd] |= 0x10 << i
+					b = append(b, c)
+				}
+			}
+		}
+		offset += n
+		size -= n
+	}
+	return b
+}
+
+// createDelta returns a delta turning idx's base into target, or nil if the
+// delta would be longer than maxSize.
+func createDelta(idx *deltaIndex, target []byte, maxSize int) []byte {
+	base := idx.base
+	delta := appendDeltaSize(appendDeltaSize(nil, len(base)), len(target))
+	pending := 0
+	i := 0
+	h := uint32(0)
+	if len(target) >= deltaBlockSize {
+		h = deltaHash(target)
+	}
+	for i+deltaBlockSize <= len(target) {
+		bestOffset, bestSize := 0, 0
+		for _, off := range idx.blocks[h] {
+			n := 0
+			for off+n < len(base) && i+n < len(target) && base[off+n] == target[i+n] {
+				n++
+			}
+			if n > bestSize {
+				bestOffset, bestSize = off, n
+			}
+		}
+		if bestSize < deltaBlockSize {
+			if i+deltaBlockSize < len(target) {
+				h = (h-uint32(target[i])*deltaHashOut)*deltaHashPrime + uint32(target[i+deltaBlockSize])
+			}
+			i++
+			continue
+		}
+		// Take back as much of the pending insert as also matches.
+		for i > pending && bestOffset > 0 && base[bestOffset-1] == target[i-1] {
+			i--
+			bestOffset--
+			bestSize++
+		}
+		delta = appendDeltaInsert(delta, target[pending:i])
+		delta = appendDeltaCopy(delta, bestOffset, bestSize)
+		if len(delta) > maxSize {
+			return nil
+		}
+		i += bestSize
+		pending = i
+		if i+deltaBlockSize <= len(target) {
+			h = deltaHash(target[i:])
+		}
+	}
+	delta = appendDeltaInsert(delta, target[pending:])
+	if len(delta) > maxSize {
+		return nil
+	}
+	return delta
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"container/list"
+	"sync"
+)
+
+// defaultDeltaBaseCacheLimit is git's default for core.deltaBaseCacheLimit.
+const defaultDeltaBaseCacheLimit = 96 << 20
+
+// deltaBaseCache holds the inflated objects that deltas in packs were
+// applied to, along with the results part way down a chain, so that objects
+// sharing them, like successive versions of a file, don't inflate the
+// whole chain again. It drops the least recently used objects to keep them
+// within core.deltaBaseCacheLimit bytes.
+type deltaBaseCache struct {
+	mu      sync.Mutex
+	limit   int64 // -1 until read from the config
+	size    int64
+	lru     *list.List // of *deltaBase, most recently used first
+	entries map[deltaBaseKey]*list.Element
+	hits    int64
+	misses  int64
+}
+
+type deltaBaseKey struct {
+	pack   *packFile
+	offset uint64
+}
+
+type deltaBase struct {
+	key        deltaBaseKey
+	objectType string
+	data       []byte // shared by readers, so never modified
+}
+
+var deltaBases = newDeltaBaseCache()
+
+func newDeltaBaseCache() *deltaBaseCache {
+	return &deltaBaseCache{limit: -1, lru: list.New(), entries: make(map[deltaBaseKey]*list.Element)}
+}
+
+// get returns the object at offset in p if it is cached.
+func (c *deltaBaseCache) get(p *packFile, offset uint64) (string, []byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[deltaBaseKey{p, offset}]
+	if !ok {
+		c.misses++
+		return "", nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(e)
+	b := e.Value.(*deltaBase)
+	return b.objectType, b.data, true
+}
+
+// add caches data as the object at offset in p, unless it is larger than
+// the whole cache may be.
+func (c *deltaBaseCache) add(p *packFile, offset uint64, objectType string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.limit == -1 {
+		c.limit = defaultDeltaBaseCacheLimit
+		if limit, err := ConfigInt("core.deltaBaseCacheLimit", defaultDeltaBaseCacheLimit); err == nil {
+			c.limit = limit
+		}
+	}
+	key := deltaBaseKey{p, offset}
+	if _, ok := c.entries[key]; ok || int64(len(data)) > c.limit {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&deltaBase{key, objectType, data})
+	c.size += int64(len(data))
+	for c.size > c.limit {
+		oldest := c.lru.Remove(c.lru.Back()).(*deltaBase)
+		delete(c.entries, oldest.key)
+		c.size -= int64(len(oldest.data))
+	}
+}
+
+// clear empties the cache, leaving its statistics alone. The limit is read
+// afresh when next needed.
+func (c *deltaBaseCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limit, c.size = -1, 0
+	c.lru.Init()
+	c.entries = make(map[deltaBaseKey]*list.Element)
+}
+
+// DeltaBaseCacheStats describes how the cache of delta bases has done.
+type DeltaBaseCacheStats struct {
+	Hits, Misses int64 // lookups of delta bases
+	Entries      int
+	Bytes        int64
+	Limit        int64 // -1 if nothing has been cached yet
+}
+
+// HitRate returns the fraction of lookups that found their base cached.
+func (s DeltaBaseCacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// ReadDeltaBaseCacheStats returns the statistics of the cache of delta
+// bases since the program started.
+func ReadDeltaBaseCacheStats() DeltaBaseCacheStats {
+	c := deltaBases
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return DeltaBaseCacheStats{Hits: c.hits, Misses: c.misses, Entries: len(c.entries), Bytes: c.size, Limit: c.limit}
+}
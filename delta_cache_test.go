@@ -0,0 +1,82 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDeltaBaseCacheEviction(t *testing.T) {
+	c := newDeltaBaseCache()
+	c.limit = 10
+	p := &packFile{}
+	c.add(p, 1, "blob", []byte("aaaa"))
+	c.add(p, 2, "blob", []byte("bbbb"))
+	if _, _, ok := c.get(p, 1); !ok {
+		t.Fatal("1 not cached")
+	}
+	// 2 is now the least recently used, so it makes way for 3.
+	c.add(p, 3, "blob", []byte("cccc"))
+	if _, _, ok := c.get(p, 2); ok {
+		t.Error("2 still cached")
+	}
+	if typ, data, ok := c.get(p, 3); !ok || typ != "blob" || string(data) != "cccc" {
+		t.Errorf("get(3) = %q, %q, %v", typ, data, ok)
+	}
+	c.add(p, 4, "blob", []byte("too big to cache"))
+	if _, _, ok := c.get(p, 4); ok {
+		t.Error("an object over the limit was cached")
+	}
+	if c.size != 8 || len(c.entries) != 2 || c.hits != 2 || c.misses != 2 {
+		t.Errorf("size %d, %d entries, %d hits, %d misses; want 8, 2, 2, 2", c.size, len(c.entries), c.hits, c.misses)
+	}
+}
+
+func TestDeltaBaseCache(t *testing.T) {
+	defer withTempRepo(t)()
+
+	// Versions of a file that the pack stores as a chain of deltas.
+	objects := []ListedObject(nil)
+	contents := make(map[string]string)
+	text := bytes.Repeat([]byte("a line that stays the same\n"), 50)
+	for i := 0; i < 10; i++ {
+		text = append(text, fmt.Sprintf("line %d\n", i)...)
+		hash, _ := WriteObject("blob", text)
+		objects = append(objects, ListedObject{Hash: hash, Name: "f"})
+		contents[hash] = string(text)
+	}
+	if _, err := WritePackFiles(".git/objects/pack/pack", objects, PackOptions{Window: 10, Depth: 50}); err != nil {
+		t.Fatal(err)
+	}
+	closePacks()
+	if err := loadPacks(); err != nil {
+		t.Fatal(err)
+	}
+	p := parsedPackFiles[0]
+	before := ReadDeltaBaseCacheStats()
+	for round := 0; round < 2; round++ {
+		for hash, want := range contents {
+			o, err := p.findHash(hashToBytes(hash))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(o.Reader)
+			if err != nil || string(data) != want {
+				t.Fatalf("reading %s: %v", hash, err)
+			}
+		}
+	}
+	after := ReadDeltaBaseCacheStats()
+	if after.Hits == before.Hits {
+		t.Errorf("no delta bases were found in the cache: %+v", after)
+	}
+	if after.Entries == 0 || after.Bytes == 0 {
+		t.Errorf("nothing was cached: %+v", after)
+	}
+}
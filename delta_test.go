@@ -6,7 +6,9 @@
 package ggit
 
 import (
+	"bytes"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -56,3 +58,30 @@ Signed-off-by: Junio C Hamano <gitster@pobox.com>
 		}
 	}
 }
+
+func TestCreateDelta(t *testing.T) {
+	base := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 2000))
+	targets := [][]byte{
+		base,
+		[]byte("prefix\n" + string(base[:40000]) + "changed line\n" + string(base[40000:]) + "suffix"),
+		[]byte(string(base[1000:5000]) + string(base[:1000])),
+		[]byte("nothing in common"),
+		nil,
+	}
+	idx := newDeltaIndex(base)
+	for i, target := range targets {
+		delta := createDelta(idx, target, 1<<30)
+		got, err := patchDelta(base, delta)
+		if err != nil {
+			t.Errorf("applying delta %d: %v", i, err)
+		} else if !bytes.Equal(got, target) {
+			t.Errorf("delta %d gives the wrong result", i)
+		}
+	}
+	if delta := createDelta(idx, targets[1], 1<<30); len(delta) > 100 {
+		t.Errorf("delta for a small change is %d bytes", len(delta))
+	}
+	if delta := createDelta(idx, targets[3], 10); delta != nil {
+		t.Errorf("delta longer than the limit was made")
+	}
+}
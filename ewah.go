@@ -0,0 +1,169 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// bitset is an uncompressed bitmap.
+type bitset []uint64
+
+func (b *bitset) set(i int) {
+	for len(*b) <= i/64 {
+		*b = append(*b, 0)
+	}
+	(*b)[i/64] |= 1 << uint(i%64)
+}
+
+func (b bitset) get(i int) bool {
+	return i/64 < len(b) && b[i/64]&(1<<uint(i%64)) != 0
+}
+
+// or sets the bits that are set in c.
+func (b *bitset) or(c bitset) {
+	for len(*b) < len(c) {
+		*b = append(*b, 0)
+	}
+	for i, w := range c {
+		(*b)[i] |= w
+	}
+}
+
+// xor flips the bits that are set in c.
+func (b *bitset) xor(c bitset) {
+	for len(*b) < len(c) {
+		*b = append(*b, 0)
+	}
+	for i, w := range c {
+		(*b)[i] ^= w
+	}
+}
+
+// andNot clears the bits that are set in c.
+func (b bitset) andNot(c bitset) {
+	for i := range b {
+		if i < len(c) {
+			b[i] &^= c[i]
+		}
+	}
+}
+
+// and clears the bits that aren't set in c.
+func (b bitset) and(c bitset) {
+	for i := range b {
+		if i < len(c) {
+			b[i] &= c[i]
+		} else {
+			b[i] = 0
+		}
+	}
+}
+
+func (b bitset) count() int {
+	n := 0
+	for _, w := range b {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// each calls fn with each bit that is set, in order.
+func (b bitset) each(fn func(i int)) {
+	for i, w := range b {
+		for w != 0 {
+			fn(i*64 + bits.TrailingZeros64(w))
+			w &= w - 1
+		}
+	}
+}
+
+// The fields of an EWAH run-length word: the bit the run repeats, how many
+// words of it there are, and how many literal words follow.
+const (
+	ewahRunningBits = 32
+	ewahLiteralBits = 64 - 1 - ewahRunningBits
+	ewahMaxRun      = 1<<ewahRunningBits - 1
+	ewahMaxLiterals = 1<<ewahLiteralBits - 1
+)
+
+// readEWAH decodes an EWAH-compressed bitmap as git stores them, returning
+// the bitmap and the data after it.
+func readEWAH(data []byte) (bitset, []byte, error) {
+	if len(data) < 8 {
+		return nil, nil, errors.New("bitmap is truncated")
+	}
+	numWords := int(binary.BigEndian.Uint32(data[4:]))
+	if len(data) < 8+numWords*8+4 {
+		return nil, nil, errors.New("bitmap is truncated")
+	}
+	words := data[8 : 8+numWords*8]
+	b := bitset(nil)
+	for i := 0; i < numWords; {
+		rlw := binary.BigEndian.Uint64(words[i*8:])
+		run := int(rlw >> 1 & ewahMaxRun)
+		literals := int(rlw >> (1 + ewahRunningBits))
+		fill := uint64(0)
+		if rlw&1 != 0 {
+			fill = ^uint64(0)
+		}
+		for k := 0; k < run; k++ {
+			b = append(b, fill)
+		}
+		i++
+		if i+literals > numWords {
+			return nil, nil, errors.New("bitmap is corrupt")
+		}
+		for k := 0; k < literals; k++ {
+			b = append(b, binary.BigEndian.Uint64(words[(i+k)*8:]))
+		}
+		i += literals
+	}
+	return b, data[8+numWords*8+4:], nil
+}
+
+// appendEWAH appends b, compressed as git stores bitmaps.
+func appendEWAH(out []byte, b bitset) []byte {
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	words := []uint64(nil)
+	lastRLW := 0
+	for i := 0; i < len(b); {
+		rlw, run := uint64(0), 0
+		if w := b[i]; w == 0 || w == ^uint64(0) {
+			for i < len(b) && b[i] == w && run < ewahMaxRun {
+				i++
+				run++
+			}
+			if w != 0 {
+				rlw = 1
+			}
+		}
+		start := i
+		for i < len(b) && b[i] != 0 && b[i] != ^uint64(0) && i-start < ewahMaxLiterals {
+			i++
+		}
+		lastRLW = len(words)
+		words = append(words, rlw|uint64(run)<<1|uint64(i-start)<<(1+ewahRunningBits))
+		words = append(words, b[start:i]...)
+	}
+	if len(words) == 0 {
+		words = append(words, 0)
+	}
+	word := make([]byte, 8)
+	binary.BigEndian.PutUint32(word, uint32(len(b)*64))
+	binary.BigEndian.PutUint32(word[4:], uint32(len(words)))
+	out = append(out, word...)
+	for _, w := range words {
+		binary.BigEndian.PutUint64(word, w)
+		out = append(out, word...)
+	}
+	binary.BigEndian.PutUint32(word, uint32(lastRLW))
+	return append(out, word[:4]...)
+}
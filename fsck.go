@@ -0,0 +1,731 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severities of the problems CheckObject finds. git fsck reports errors and
+// warnings and keeps quiet about the rest, and --strict checks treat
+// warnings as errors.
+const (
+	FsckError = iota
+	FsckWarning
+	FsckInfo
+)
+
+// ObjectProblem is something wrong with an object, named by the message ID
+// git fsck uses for it.
+type ObjectProblem struct {
+	ID       string
+	Message  string
+	Severity int
+}
+
+func (p ObjectProblem) Error() string {
+	return p.ID + ": " + p.Message
+}
+
+func isHexHash(s string) bool {
+	return len(s) == 2*sha1.Size && isHex(s)
+}
+
+// objectChecker gathers the problems with an object.
+type objectChecker struct {
+	problems []ObjectProblem
+}
+
+func (c *objectChecker) report(severity int, id, format string, args ...interface{}) {
+	c.problems = append(c.problems, ObjectProblem{ID: id, Message: fmt.Sprintf(format, args...), Severity: severity})
+}
+
+// checkIdent checks an author, committer or tagger line after the keyword,
+// "Name <email> 1234567890 +0000".
+func (c *objectChecker) checkIdent(ident string) bool {
+	const prefix = "invalid author/committer line - "
+	lt := strings.IndexByte(ident, '<')
+	switch {
+	case lt == 0:
+		c.report(FsckError, "missingNameBeforeEmail", prefix+"missing space before email")
+		return false
+	case lt == -1:
+		c.report(FsckError, "missingEmail", prefix+"missing email")
+		return false
+	case ident[lt-1] != ' ':
+		c.report(FsckError, "missingSpaceBeforeEmail", prefix+"missing space before email")
+		return false
+	case strings.ContainsAny(ident[:lt], "<>\n"):
+		c.report(FsckError, "badName", prefix+"bad name")
+		return false
+	}
+	gt := strings.IndexByte(ident[lt:], '>')
+	if gt == -1 || strings.ContainsAny(ident[lt+1:lt+gt], "<\n") {
+		c.report(FsckError, "badEmail", prefix+"bad email")
+		return false
+	}
+	rest := ident[lt+gt+1:]
+	if !strings.HasPrefix(rest, " ") {
+		c.report(FsckError, "missingSpaceBeforeDate", prefix+"missing space before date")
+		return false
+	}
+	fields := strings.Split(rest[1:], " ")
+	date := fields[0]
+	if date == "" || strings.Trim(date, "0123456789") != "" {
+		c.report(FsckError, "badDate", prefix+"bad date")
+		return false
+	}
+	if len(date) > 1 && date[0] == '0' {
+		c.report(FsckError, "zeroPaddedDate", prefix+"zero-padded date")
+		return false
+	}
+	if _, err := strconv.ParseUint(date, 10, 64); err != nil {
+		c.report(FsckError, "badDateOverflow", prefix+"date causes integer overflow")
+		return false
+	}
+	if len(fields) != 2 || len(fields[1]) != 5 || (fields[1][0] != '+' && fields[1][0] != '-') ||
+		strings.Trim(fields[1][1:], "0123456789") != "" {
+		c.report(FsckError, "badTimezone", prefix+"bad time zone")
+		return false
+	}
+	return true
+}
+
+// headerLines splits the lines before the message of a commit or tag.
+func headerLines(data []byte) []string {
+	end := bytes.Index(data, []byte("\n\n"))
+	if end == -1 {
+		end = len(data)
+	}
+	return strings.Split(string(data[:end]), "\n")
+}
+
+func (c *objectChecker) checkCommit(data []byte) {
+	lines := headerLines(data)
+	i := 0
+	if !strings.HasPrefix(lines[0], "tree ") {
+		c.report(FsckError, "missingTree", "invalid format - expected 'tree' line")
+		return
+	}
+	if !isHexHash(lines[0][len("tree "):]) {
+		c.report(FsckError, "badTreeSha1", "invalid 'tree' line format - bad sha1")
+		return
+	}
+	for i = 1; i < len(lines) && strings.HasPrefix(lines[i], "parent "); i++ {
+		if !isHexHash(lines[i][len("parent "):]) {
+			c.report(FsckError, "badParentSha1", "invalid 'parent' line format - bad sha1")
+			return
+		}
+	}
+	authors := 0
+	for ; i < len(lines) && strings.HasPrefix(lines[i], "author "); i++ {
+		authors++
+		if !c.checkIdent(lines[i][len("author "):]) {
+			return
+		}
+	}
+	if authors == 0 {
+		c.report(FsckError, "missingAuthor", "invalid format - expected 'author' line")
+		return
+	}
+	if authors > 1 {
+		c.report(FsckError, "multipleAuthors", "invalid format - multiple 'author' lines")
+		return
+	}
+	if i == len(lines) || !strings.HasPrefix(lines[i], "committer ") {
+		c.report(FsckError, "missingCommitter", "invalid format - expected 'committer' line")
+		return
+	}
+	c.checkIdent(lines[i][len("committer "):])
+}
+
+func (c *objectChecker) checkTag(data []byte) {
+	lines := append(headerLines(data), "")
+	if !strings.HasPrefix(lines[0], "object ") {
+		c.report(FsckError, "missingObject", "invalid format - expected 'object' line")
+		return
+	}
+	if !isHexHash(lines[0][len("object "):]) {
+		c.report(FsckError, "badObjectSha1", "invalid 'object' line format - bad sha1")
+		return
+	}
+	if !strings.HasPrefix(lines[1], "type ") {
+		c.report(FsckError, "missingTypeEntry", "invalid format - expected 'type' line")
+		return
+	}
+	switch lines[1][len("type "):] {
+	case "commit", "tree", "blob", "tag":
+	default:
+		c.report(FsckError, "badType", "invalid 'type' value")
+		return
+	}
+	if !strings.HasPrefix(lines[2], "tag ") {
+		c.report(FsckError, "missingTagEntry", "invalid format - expected 'tag' line")
+		return
+	}
+	if name := lines[2][len("tag "):]; !validRefName("refs/tags/" + name) {
+		c.report(FsckInfo, "badTagName", "invalid 'tag' name: %s", name)
+	}
+	if !strings.HasPrefix(lines[3], "tagger ") {
+		c.report(FsckInfo, "missingTaggerEntry", "invalid format - expected 'tagger' line")
+		return
+	}
+	c.checkIdent(lines[3][len("tagger "):])
+}
+
+func (c *objectChecker) checkTree(data []byte) {
+	entries, err := parseTreeEntries(Object{Reader: bytes.NewReader(data)})
+	if err != nil {
+		c.report(FsckError, "badTree", "cannot be parsed as a tree")
+		return
+	}
+	seen := make(map[string]bool)
+	found := func(id string) bool {
+		if seen[id] {
+			return true
+		}
+		seen[id] = true
+		return false
+	}
+	for i, e := range entries {
+		switch e.mode {
+		case "100644", "100755", "120000", "040000", "160000":
+		default:
+			if !found("badFilemode") {
+				c.report(FsckInfo, "badFilemode", "contains bad file modes")
+			}
+		}
+		if e.hash == [sha1.Size]byte{} && !found("nullSha1") {
+			c.report(FsckWarning, "nullSha1", "contains entries pointing to null sha1")
+		}
+		switch {
+		case e.name == "":
+			if !found("emptyName") {
+				c.report(FsckWarning, "emptyName", "contains empty pathname")
+			}
+		case strings.Contains(e.name, "/"):
+			if !found("fullPathname") {
+				c.report(FsckWarning, "fullPathname", "contains full pathnames")
+			}
+		case e.name == ".":
+			if !found("hasDot") {
+				c.report(FsckWarning, "hasDot", "contains '.'")
+			}
+		case e.name == "..":
+			if !found("hasDotdot") {
+				c.report(FsckWarning, "hasDotdot", "contains '..'")
+			}
+		case strings.EqualFold(e.name, ".git"):
+			if !found("hasDotgit") {
+				c.report(FsckWarning, "hasDotgit", "contains '.git'")
+			}
+		}
+		if i == 0 {
+			continue
+		}
+		prev := entries[i-1]
+		if prev.name == e.name {
+			if !found("duplicateEntries") {
+				c.report(FsckError, "duplicateEntries", "contains duplicate file entries")
+			}
+		} else if treeEntryName(prev) >= treeEntryName(e) && !found("treeNotSorted") {
+			c.report(FsckError, "treeNotSorted", "not properly sorted")
+		}
+	}
+	// The entries were parsed, so each has a mode, a name and a hash.
+	for rest := data; len(rest) > 0; {
+		if rest[0] == '0' {
+			c.report(FsckWarning, "zeroPaddedFilemode", "contains zero-padded file modes")
+			break
+		}
+		nul := bytes.IndexByte(rest, 0)
+		rest = rest[nul+1+sha1.Size:]
+	}
+}
+
+// treeEntryName is the name a tree entry sorts by: directories sort as if
+// their names ended in "/".
+func treeEntryName(e treeEntry) string {
+	if e.mode == "040000" {
+		return e.name + "/"
+	}
+	return e.name
+}
+
+// CheckObject looks for the problems git fsck finds in an object of the
+// given type and contents.
+func CheckObject(objectType string, data []byte) []ObjectProblem {
+	c := &objectChecker{}
+	switch objectType {
+	case "commit":
+		c.checkCommit(data)
+	case "tag":
+		c.checkTag(data)
+	case "tree":
+		c.checkTree(data)
+	}
+	return c.problems
+}
+
+// objectLink is a reference from one object to another of the given type.
+type objectLink struct {
+	hash, objectType string
+}
+
+func objectLinks(objectType string, data []byte) ([]objectLink, error) {
+	links := []objectLink(nil)
+	switch objectType {
+	case "commit":
+		for _, line := range headerLines(data) {
+			if strings.HasPrefix(line, "tree ") {
+				links = append(links, objectLink{line[len("tree "):], "tree"})
+			} else if strings.HasPrefix(line, "parent ") {
+				links = append(links, objectLink{line[len("parent "):], "commit"})
+			}
+		}
+	case "tag":
+		hash, targetType, err := tagTarget(data)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, objectLink{hash, targetType})
+	case "tree":
+		entries, err := parseTreeEntries(Object{Reader: bytes.NewReader(data)})
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			switch e.mode {
+			case "160000":
+			case "040000":
+				links = append(links, objectLink{fmt.Sprintf("%x", e.hash), "tree"})
+			default:
+				links = append(links, objectLink{fmt.Sprintf("%x", e.hash), "blob"})
+			}
+		}
+	}
+	return links, nil
+}
+
+// ObjectLinks returns the names of the objects an object refers to: a
+// commit's tree and parents, a tag's target and the entries of a tree other
+// than submodules.
+func ObjectLinks(objectType string, data []byte) ([]string, error) {
+	links, err := objectLinks(objectType, data)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(links))
+	for i, l := range links {
+		names[i] = l.hash
+	}
+	return names, nil
+}
+
+// Bits of git fsck's exit status, for the kinds of problem Fsck finds.
+const (
+	FsckObjectErrors = 1 << iota
+	FsckReachabilityErrors
+	FsckPackErrors
+	FsckRefErrors
+)
+
+// FsckOptions controls Fsck.
+type FsckOptions struct {
+	ConnectivityOnly bool // only check that the reachable objects are present
+	Unreachable      bool // report every unreachable object, not just the dangling ones
+	NoReflogs        bool // don't count the objects in reflogs as reachable
+}
+
+// FsckObject is an object Fsck reports: one that is "missing", "dangling"
+// (unreachable, and not referred to by another unreachable object) or, with
+// FsckOptions.Unreachable, "unreachable".
+type FsckObject struct {
+	State, Type, Hash string
+}
+
+// FsckBrokenLink is a link from a reachable object to a missing one. Only
+// the first link found to each missing object is reported.
+type FsckBrokenLink struct {
+	FromType, From string
+	ToType, To     string
+}
+
+// FsckResult is what Fsck finds.
+type FsckResult struct {
+	Messages    []string         // errors, warnings and notices, as git fsck writes them to standard error
+	BrokenLinks []FsckBrokenLink // in the order they were found
+	Objects     []FsckObject     // sorted by name
+	Status      int              // the Fsck*Errors bits for the problems found
+}
+
+// fscker checks a repository.
+type fscker struct {
+	opts   FsckOptions
+	result FsckResult
+	types  map[string]string       // the objects present, and their types once known
+	links  map[string][]objectLink // the links of the objects read so far
+}
+
+func (f *fscker) report(status int, format string, args ...interface{}) {
+	f.result.Messages = append(f.result.Messages, fmt.Sprintf(format, args...))
+	f.result.Status |= status
+}
+
+func (f *fscker) present(hash string) bool {
+	_, ok := f.types[hash]
+	return ok
+}
+
+// typeOf returns the type of an object that is present.
+func (f *fscker) typeOf(hash string) string {
+	if t := f.types[hash]; t != "" {
+		return t
+	}
+	if o, err := LookupObject(hash); err == nil {
+		f.types[hash] = o.ObjectType
+		o.Close()
+	}
+	return f.types[hash]
+}
+
+// linksOf returns the links of an object that is present.
+func (f *fscker) linksOf(hash string) []objectLink {
+	if links, ok := f.links[hash]; ok {
+		return links
+	}
+	if f.typeOf(hash) == "blob" {
+		return nil
+	}
+	objectType, data, err := readObject(hash)
+	if err != nil {
+		f.report(FsckObjectErrors, "error: %s: object corrupt or missing", hash)
+		f.links[hash] = nil
+		return nil
+	}
+	f.types[hash] = objectType
+	f.links[hash], _ = objectLinks(objectType, data)
+	return f.links[hash]
+}
+
+// check looks for problems in the contents of an object.
+func (f *fscker) check(hash, objectType string, data []byte) {
+	f.types[hash] = objectType
+	for _, p := range CheckObject(objectType, data) {
+		switch p.Severity {
+		case FsckError:
+			f.report(FsckObjectErrors, "error in %s %s: %v", objectType, hash, p)
+		case FsckWarning:
+			f.report(0, "warning in %s %s: %v", objectType, hash, p)
+		}
+	}
+	f.links[hash], _ = objectLinks(objectType, data)
+}
+
+// checkLoose checks the loose object stored at path.
+func (f *fscker) checkLoose(hash, path string) {
+	if f.opts.ConnectivityOnly {
+		f.types[hash] = ""
+		return
+	}
+	compressed, err := ioutil.ReadFile(path)
+	if err != nil {
+		f.report(FsckObjectErrors, "error: %s: object corrupt or missing: %s", hash, path)
+		return
+	}
+	data, err := inflate(compressed)
+	nul := bytes.IndexByte(data, 0)
+	if err != nil || nul == -1 {
+		f.report(FsckObjectErrors, "error: unable to unpack header of %s", path)
+		f.report(FsckObjectErrors, "error: %s: object corrupt or missing: %s", hash, path)
+		return
+	}
+	header := strings.Split(string(data[:nul]), " ")
+	data = data[nul+1:]
+	if len(header) != 2 || header[1] != strconv.Itoa(len(data)) {
+		f.report(FsckObjectErrors, "error: %s: object corrupt or missing: %s", hash, path)
+		return
+	}
+	if sum := fmt.Sprintf("%x", hashObject(header[0], data)); sum != hash {
+		f.report(FsckObjectErrors, "error: %s: hash-path mismatch, found at: %s", sum, path)
+		return
+	}
+	f.check(hash, header[0], data)
+}
+
+// inflate returns all of the zlib data compressed.
+func inflate(compressed []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+// checkObjects checks the loose objects and then the packs.
+func (f *fscker) checkObjects() error {
+	dirs, err := ioutil.ReadDir(".git/objects")
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if len(d.Name()) != 2 || !isHex(d.Name()) {
+			continue
+		}
+		files, err := ioutil.ReadDir(".git/objects/" + d.Name())
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if hash := d.Name() + file.Name(); isHexHash(hash) {
+				f.checkLoose(hash, ".git/objects/"+d.Name()+"/"+file.Name())
+			}
+		}
+	}
+
+	if err := loadPacks(); err != nil {
+		return err
+	}
+	for _, p := range parsedPackFiles {
+		var objects []PackedObject
+		if !f.opts.ConnectivityOnly {
+			var problems []error
+			objects, problems = verifyPack(".git/objects/pack/" + p.baseFileName + ".idx")
+			for _, err := range problems {
+				f.report(FsckPackErrors, "error: %v", err)
+			}
+		}
+		if objects == nil {
+			if err := p.loadIndex(); err != nil {
+				return err
+			}
+			for i := 0; i < p.idx.numEntries; i++ {
+				if hash := fmt.Sprintf("%x", p.idx.hash(i)); !f.present(hash) {
+					f.types[hash] = ""
+				}
+			}
+			continue
+		}
+		for _, o := range objects {
+			hash := fmt.Sprintf("%x", o.Hash)
+			if _, ok := f.links[hash]; ok {
+				continue
+			}
+			if o.Type == "blob" {
+				f.types[hash] = o.Type
+				f.links[hash] = nil
+				continue
+			}
+			_, data, err := readObject(hash)
+			if err != nil {
+				f.report(FsckPackErrors, "error: %s: object corrupt or missing", hash)
+				continue
+			}
+			f.check(hash, o.Type, data)
+		}
+	}
+	return nil
+}
+
+// roots returns the objects reachable without following links: the targets
+// of refs, the objects in reflogs and the index's entries and cached trees.
+func (f *fscker) roots() ([]objectLink, error) {
+	roots := []objectLink(nil)
+	head, hash, err := ResolveRef("HEAD")
+	if err != nil {
+		return nil, err
+	}
+	if hash == "" {
+		f.report(0, "notice: HEAD points to an unborn branch (%s)", strings.TrimPrefix(head, "refs/heads/"))
+	} else if !f.present(hash) {
+		f.report(FsckRefErrors, "error: HEAD: invalid sha1 pointer %s", hash)
+	} else {
+		roots = append(roots, objectLink{hash, ""})
+	}
+	refs, err := ListRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 {
+		f.report(0, "notice: No default references")
+	}
+	for _, r := range refs {
+		if !f.present(r.Hash) {
+			f.report(FsckRefErrors, "error: %s: invalid sha1 pointer %s", r.Name, r.Hash)
+			continue
+		}
+		roots = append(roots, objectLink{r.Hash, ""})
+	}
+
+	if !f.opts.NoReflogs {
+		logs, err := ReflogRefs()
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range logs {
+			entries, err := ReadReflog(ref)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				for _, hash := range []string{e.Old, e.New} {
+					if hash == ZeroHash {
+						continue
+					}
+					if !f.present(hash) {
+						f.report(FsckReachabilityErrors, "error: %s: invalid reflog entry %s", ref, hash)
+						continue
+					}
+					roots = append(roots, objectLink{hash, ""})
+				}
+			}
+		}
+	}
+
+	idx, err := ReadIndex(".git/index")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range idx.Entries {
+		if e.Mode&0170000 != 0160000 {
+			roots = append(roots, objectLink{fmt.Sprintf("%x", e.Hash), "blob"})
+		}
+	}
+	trees := []*cacheTree(nil)
+	if ct := idx.cacheTree(); ct != nil {
+		trees = append(trees, ct)
+	}
+	for len(trees) > 0 {
+		ct := trees[len(trees)-1]
+		trees = append(trees[:len(trees)-1], ct.subtrees...)
+		if ct.entryCount < 0 {
+			continue
+		}
+		hash := fmt.Sprintf("%x", ct.hash)
+		if !f.present(hash) {
+			f.report(FsckRefErrors, "error: %s: invalid sha1 pointer in cache-tree", hash)
+			continue
+		}
+		roots = append(roots, objectLink{hash, "tree"})
+	}
+	return roots, nil
+}
+
+// Fsck checks the integrity of the repository's objects and that every
+// object reachable from its refs, reflogs and index is present, and finds
+// the objects that aren't reachable.
+func Fsck(opts FsckOptions) (FsckResult, error) {
+	f := &fscker{opts: opts, types: make(map[string]string), links: make(map[string][]objectLink)}
+	if err := f.checkObjects(); err != nil {
+		return f.result, err
+	}
+	roots, err := f.roots()
+	if err != nil {
+		return f.result, err
+	}
+
+	reached := make(map[string]bool)
+	missing := make(map[string]string)
+	for len(roots) > 0 {
+		l := roots[len(roots)-1]
+		roots = roots[:len(roots)-1]
+		if reached[l.hash] {
+			continue
+		}
+		reached[l.hash] = true
+		if !f.present(l.hash) {
+			missing[l.hash] = l.objectType
+			continue
+		}
+		if l.objectType == "blob" {
+			continue
+		}
+		for _, link := range f.linksOf(l.hash) {
+			if f.present(link.hash) || reached[link.hash] {
+				roots = append(roots, link)
+				continue
+			}
+			reached[link.hash] = true
+			missing[link.hash] = link.objectType
+			f.result.BrokenLinks = append(f.result.BrokenLinks,
+				FsckBrokenLink{f.typeOf(l.hash), l.hash, typeOrUnknown(link.objectType), link.hash})
+		}
+	}
+
+	used := make(map[string]bool)
+	for hash := range f.types {
+		if !reached[hash] {
+			for _, l := range f.linksOf(hash) {
+				used[l.hash] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(f.types)+len(missing))
+	for hash := range f.types {
+		names = append(names, hash)
+	}
+	for hash := range missing {
+		names = append(names, hash)
+	}
+	sort.Strings(names)
+	for _, hash := range names {
+		if objectType, ok := missing[hash]; ok {
+			f.result.Objects = append(f.result.Objects, FsckObject{"missing", typeOrUnknown(objectType), hash})
+			f.result.Status |= FsckReachabilityErrors
+		} else if !reached[hash] && opts.Unreachable {
+			f.result.Objects = append(f.result.Objects, FsckObject{"unreachable", f.typeOf(hash), hash})
+		} else if !reached[hash] && !used[hash] {
+			f.result.Objects = append(f.result.Objects, FsckObject{"dangling", f.typeOf(hash), hash})
+		}
+	}
+	return f.result, nil
+}
+
+// typeOrUnknown returns objectType, or "unknown" if it isn't known.
+func typeOrUnknown(objectType string) string {
+	if objectType == "" {
+		return "unknown"
+	}
+	return objectType
+}
+
+// WriteLostFound saves the dangling objects fsck found in .git/lost-found:
+// the names of commits in commit/, and the contents of blobs and the names of
+// other objects in other/.
+func WriteLostFound(objects []FsckObject) error {
+	for _, o := range objects {
+		if o.State != "dangling" {
+			continue
+		}
+		dir := ".git/lost-found/other"
+		if o.Type == "commit" {
+			dir = ".git/lost-found/commit"
+		}
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+		data := []byte(o.Hash + "\n")
+		if o.Type == "blob" {
+			var err error
+			if _, data, err = readObject(o.Hash); err != nil {
+				return err
+			}
+		}
+		if err := ioutil.WriteFile(dir+"/"+o.Hash, data, 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckObject(t *testing.T) {
+	const tree = "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n"
+	for _, test := range []struct {
+		objectType, data string
+		want             []string
+	}{
+		{"commit", tree + "author A <a@b> 1 +0000\ncommitter A <a@b> 1 +0000\n\nok\n", nil},
+		{"commit", "parent 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n", []string{"missingTree"}},
+		{"commit", tree + "committer A <a@b> 1 +0000\n", []string{"missingAuthor"}},
+		{"commit", tree + "author A <a@b> 1 +0000\nauthor A <a@b> 1 +0000\n", []string{"multipleAuthors"}},
+		{"commit", tree + "author A a@b> 1 +0000\n", []string{"missingEmail"}},
+		{"commit", tree + "author A <a@b> x +0000\n", []string{"badDate"}},
+		{"commit", tree + "author A <a@b> 1 0000\n", []string{"badTimezone"}},
+		{"tag", "object 4b825dc642cb6eb9a060e54bf8d69288fbee4904\ntype tree\ntag v1\ntagger A <a@b> 1 +0000\n", nil},
+		{"tag", "object 4b825dc642cb6eb9a060e54bf8d69288fbee4904\ntype frog\n", []string{"badType"}},
+		{"tag", "object 4b825dc642cb6eb9a060e54bf8d69288fbee4904\ntype tree\ntag v1\n", []string{"missingTaggerEntry"}},
+		{"tree", "100644 b\x0001234567890123456789100644 a\x0001234567890123456789", []string{"treeNotSorted"}},
+		{"tree", "100644 a\x0001234567890123456789100644 a\x0001234567890123456789", []string{"duplicateEntries"}},
+		{"tree", "040000 .git\x0001234567890123456789", []string{"hasDotgit", "zeroPaddedFilemode"}},
+		{"tree", "100664 a\x0001234567890123456789", []string{"badFilemode"}},
+		{"tree", "100644 a", []string{"badTree"}},
+	} {
+		got := []string(nil)
+		for _, p := range CheckObject(test.objectType, []byte(test.data)) {
+			got = append(got, p.ID)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("CheckObject(%s, %q) = %v, want %v", test.objectType, test.data, got, test.want)
+		}
+	}
+}
+
+func TestFsck(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	tree := makeTree(t, map[string]string{"a": "a\n", "d/b": "b\n"})
+	commit, err := CommitTree(tree, nil, who, who, "commit\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRef("refs/heads/master", commit, "", who, "commit"); err != nil {
+		t.Fatal(err)
+	}
+	dangling, _ := WriteObject("blob", []byte("dangling\n"))
+	unreachableTree := makeTree(t, map[string]string{"c": "c\n"})
+	blob, _ := WriteObject("blob", []byte("c\n"))
+
+	result, err := Fsck(FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FsckObject{{"dangling", "blob", dangling}, {"dangling", "tree", unreachableTree}}
+	if dangling > unreachableTree {
+		want[0], want[1] = want[1], want[0]
+	}
+	if !reflect.DeepEqual(result.Objects, want) || result.Status != 0 || len(result.Messages) != 0 {
+		t.Errorf("Fsck() = %+v, want objects %v", result, want)
+	}
+	result, err = Fsck(FsckOptions{Unreachable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Objects) != 3 {
+		t.Errorf("Fsck() found unreachable %v, want 3 objects", result.Objects)
+	}
+
+	// Replace a reachable blob with another object's contents.
+	_, b, err := LookupPath(tree, "d/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := ".git/objects/" + b[:2] + "/" + b[2:]
+	os.Chmod(path, 0666)
+	contents, err := ioutil.ReadFile(".git/objects/" + blob[:2] + "/" + blob[2:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, contents, 0666); err != nil {
+		t.Fatal(err)
+	}
+	result, err = Fsck(FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantMessages := []string{"error: " + blob + ": hash-path mismatch, found at: " + path}
+	if !reflect.DeepEqual(result.Messages, wantMessages) {
+		t.Errorf("Fsck() gave messages %q, want %q", result.Messages, wantMessages)
+	}
+	if result.Status != FsckObjectErrors|FsckReachabilityErrors {
+		t.Errorf("Fsck() status is %d", result.Status)
+	}
+	found := false
+	for _, o := range result.Objects {
+		found = found || o == FsckObject{"missing", "blob", b}
+	}
+	if !found {
+		t.Errorf("Fsck() didn't find %s missing in %v", b, result.Objects)
+	}
+	_, d, _ := LookupPath(tree, "d")
+	wantLinks := []FsckBrokenLink{{"tree", d, "blob", b}}
+	if !reflect.DeepEqual(result.BrokenLinks, wantLinks) {
+		t.Errorf("Fsck() found broken links %v, want %v", result.BrokenLinks, wantLinks)
+	}
+}
+
+func TestFsckPack(t *testing.T) {
+	defer withTempRepo(t)()
+
+	objects := []ListedObject(nil)
+	for i := 0; i < 3; i++ {
+		hash, _ := WriteObject("blob", []byte(fmt.Sprintf("blob %d\n", i)))
+		objects = append(objects, ListedObject{Hash: hash})
+	}
+	if _, err := WritePackFiles(".git/objects/pack/pack", objects, PackOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	closePacks()
+	idxs, _ := filepath.Glob(".git/objects/pack/*.idx")
+	idx, err := ReadPackIndex(mustReadFile(t, idxs[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packPath := strings.TrimSuffix(idxs[0], ".idx") + ".pack"
+	data := mustReadFile(t, packPath)
+	e := idx.Entries[1]
+	data[e.Offset+3] ^= 0xff
+	os.Chmod(packPath, 0666)
+	if err := ioutil.WriteFile(packPath, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Fsck(FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"error: " + packPath + " pack checksum mismatch",
+		fmt.Sprintf("error: index CRC mismatch for object %x from %s at offset %d", e.Hash, packPath, e.Offset)}
+	if len(result.Messages) < 2 || !reflect.DeepEqual(result.Messages[:2], want) {
+		t.Errorf("Fsck() gave messages %q, want them to start %q", result.Messages, want)
+	}
+	if result.Status&FsckPackErrors == 0 {
+		t.Errorf("Fsck() status is %d", result.Status)
+	}
+}
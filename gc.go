@@ -0,0 +1,472 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gcRoots returns the objects garbage collection keeps along with all they
+// reach: what HEAD and the refs point to, the objects in reflogs and the
+// index's blobs and cached trees.
+func gcRoots() ([]string, error) {
+	roots := []string(nil)
+	if _, hash, err := ResolveRef("HEAD"); err != nil {
+		return nil, err
+	} else if hash != "" {
+		roots = append(roots, hash)
+	}
+	refs, err := ListRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range refs {
+		roots = append(roots, r.Hash)
+	}
+	logs, err := ReflogRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range logs {
+		entries, err := ReadReflog(ref)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			for _, hash := range []string{e.Old, e.New} {
+				if hash != ZeroHash && objectExists(hash) {
+					roots = append(roots, hash)
+				}
+			}
+		}
+	}
+	idx, err := ReadIndex(".git/index")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range idx.Entries {
+		if e.Mode&0170000 != 0160000 {
+			roots = append(roots, fmt.Sprintf("%x", e.Hash))
+		}
+	}
+	trees := []*cacheTree(nil)
+	if ct := idx.cacheTree(); ct != nil {
+		trees = append(trees, ct)
+	}
+	for len(trees) > 0 {
+		ct := trees[len(trees)-1]
+		trees = append(trees[:len(trees)-1], ct.subtrees...)
+		if hash := fmt.Sprintf("%x", ct.hash); ct.entryCount >= 0 && objectExists(hash) {
+			roots = append(roots, hash)
+		}
+	}
+	return roots, nil
+}
+
+// reachableObjects returns the names of the objects garbage collection keeps.
+func reachableObjects() ([]ListedObject, error) {
+	roots, err := gcRoots()
+	if err != nil {
+		return nil, err
+	}
+	return ListObjects(roots, nil)
+}
+
+// looseObjects calls fn with the name and path of each loose object.
+func looseObjects(fn func(hash, path string, fi os.FileInfo) error) error {
+	dirs, err := ioutil.ReadDir(".git/objects")
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if len(d.Name()) != 2 || !isHex(d.Name()) {
+			continue
+		}
+		dir := ".git/objects/" + d.Name()
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, fi := range files {
+			if hash := d.Name() + fi.Name(); isHexHash(hash) {
+				if err := fn(hash, dir+"/"+fi.Name(), fi); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// removeLoose deletes a loose object, and its directory if that leaves it
+// empty.
+func removeLoose(path string) error {
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	os.Remove(filepath.Dir(path))
+	return nil
+}
+
+// LooseObject names a loose object and gives its type.
+type LooseObject struct {
+	Hash, Type string
+}
+
+// looseObject describes the loose object hash, which is about to go.
+func looseObject(hash string) LooseObject {
+	o, err := LookupObject(hash)
+	if err != nil {
+		return LooseObject{Hash: hash, Type: "unknown"}
+	}
+	o.Close()
+	return LooseObject{Hash: hash, Type: o.ObjectType}
+}
+
+// PrunePacked deletes the loose objects that are also in a pack, returning
+// their names. With dryRun nothing is deleted.
+func PrunePacked(dryRun bool) ([]string, error) {
+	pruned := []string(nil)
+	err := looseObjects(func(hash, path string, fi os.FileInfo) error {
+		packed, err := isPacked(hash)
+		if err != nil || !packed {
+			return err
+		}
+		pruned = append(pruned, hash)
+		if dryRun {
+			return nil
+		}
+		return removeLoose(path)
+	})
+	return pruned, err
+}
+
+// Prune deletes the loose objects that nothing reachable refers to and
+// which are older than expire, and returns them. It also deletes temporary
+// files older than expire left by interrupted writes. With dryRun nothing is
+// deleted.
+func Prune(expire time.Time, dryRun bool) ([]LooseObject, error) {
+	objects, err := reachableObjects()
+	if err != nil {
+		return nil, err
+	}
+	reachable := make(map[string]bool, len(objects))
+	for _, o := range objects {
+		reachable[o.Hash] = true
+	}
+	pruned := []LooseObject(nil)
+	err = looseObjects(func(hash, path string, fi os.FileInfo) error {
+		if reachable[hash] || !fi.ModTime().Before(expire) {
+			return nil
+		}
+		pruned = append(pruned, looseObject(hash))
+		if dryRun {
+			return nil
+		}
+		return removeLoose(path)
+	})
+	if err != nil || dryRun {
+		return pruned, err
+	}
+	temps, err := filepath.Glob(".git/objects/??/tmp_obj_*")
+	if err != nil {
+		return pruned, err
+	}
+	for _, t := range temps {
+		if fi, err := os.Stat(t); err == nil && fi.ModTime().Before(expire) {
+			removeLoose(t)
+		}
+	}
+	return pruned, nil
+}
+
+// RepackOptions controls Repack.
+type RepackOptions struct {
+	All    bool // pack every reachable object, not just the loose ones
+	Delete bool // delete the packs and loose objects the new pack makes redundant
+	// Unpack keeps the unreachable objects of packs Delete removes, as loose
+	// objects that Prune can expire, unless their pack is older than
+	// UnpackExpire.
+	Unpack       bool
+	UnpackExpire time.Time
+	// WriteBitmap writes a reachability bitmap for the new pack. It needs
+	// All, so that the pack has everything its commits reach.
+	WriteBitmap bool
+	Pack        PackOptions
+}
+
+// Repack writes the repository's reachable objects to a new pack, or with
+// All unset just those that aren't packed yet, and returns its name. It
+// returns "" if there was nothing to pack.
+func Repack(opts RepackOptions) (string, error) {
+	if opts.WriteBitmap && !opts.All {
+		return "", errors.New("Incremental repacks are incompatible with bitmap indexes")
+	}
+	objects, err := reachableObjects()
+	if err != nil {
+		return "", err
+	}
+	closePacks()
+	if err := openPacks(); err != nil {
+		return "", err
+	}
+	if !opts.All {
+		unpacked := objects[:0]
+		for _, o := range objects {
+			if packed, err := isPacked(o.Hash); err != nil {
+				return "", err
+			} else if !packed {
+				unpacked = append(unpacked, o)
+			}
+		}
+		objects = unpacked
+	}
+	name := ""
+	if len(objects) > 0 {
+		if name, err = WritePackFiles(".git/objects/pack/pack", objects, opts.Pack); err != nil {
+			return "", err
+		}
+		if opts.WriteBitmap {
+			if err := writePackBitmap(name, objects); err != nil {
+				return "", err
+			}
+		}
+	}
+	if opts.All && opts.Delete {
+		kept := make(map[string]bool, len(objects))
+		removedMIDXPack := false
+		for _, o := range objects {
+			kept[o.Hash] = true
+		}
+		for _, p := range parsedPackFiles {
+			if p.baseFileName == "pack-"+name {
+				continue
+			}
+			if _, err := os.Stat(".git/objects/pack/" + p.baseFileName + ".keep"); err == nil {
+				continue
+			}
+			if err := p.remove(kept, opts); err != nil {
+				return "", err
+			}
+			removedMIDXPack = removedMIDXPack || p.inMIDX
+		}
+		if removedMIDXPack {
+			if err := os.Remove(midxPath); err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+			if err := removeMIDXFiles(nil); err != nil {
+				return "", err
+			}
+		}
+	}
+	closePacks()
+	if opts.Delete {
+		if _, err := PrunePacked(false); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
+
+// remove deletes a pack made redundant by a new one holding the objects in
+// kept, first loosening the objects that aren't there if opts asks.
+func (p *pack) remove(kept map[string]bool, opts RepackOptions) error {
+	base := ".git/objects/pack/" + p.baseFileName
+	fi, err := os.Stat(base + ".pack")
+	if err != nil {
+		return err
+	}
+	if opts.Unpack && fi.ModTime().After(opts.UnpackExpire) {
+		if err := p.loadIndex(); err != nil {
+			return err
+		}
+		for i := 0; i < p.idx.numEntries; i++ {
+			hash := fmt.Sprintf("%x", p.idx.hash(i))
+			if kept[hash] {
+				continue
+			}
+			objectType, data, err := readObject(hash)
+			if err != nil {
+				return err
+			}
+			if err := writeLooseObject(hash, objectType, data); err != nil {
+				return err
+			}
+			path := ".git/objects/" + hash[:2] + "/" + hash[2:]
+			if err := os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+				return err
+			}
+		}
+	}
+	return removePackFiles(base)
+}
+
+// removePackFiles deletes the pack whose files are named base plus their
+// extension, along with its bitmap and reverse index if it has them.
+func removePackFiles(base string) error {
+	for _, ext := range []string{".pack", ".idx", ".bitmap", ".rev"} {
+		if err := os.Remove(base + ext); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// PackRefs moves refs into a single store that is quicker to read: for the
+// files backend, it moves the tags, or with all every ref, into packed-refs
+// and deletes the loose files; a reftable stack is compacted into one table.
+func PackRefs(all bool) error {
+	return refStore().packRefs(all)
+}
+
+func (filesBackend) packRefs(all bool) error {
+	l, err := lock(".git/packed-refs")
+	if err != nil {
+		return err
+	}
+	defer l.rollback()
+	packed, err := readPackedRefs()
+	if err != nil {
+		return err
+	}
+	wasPacked := make(map[string]bool)
+	for _, r := range packed {
+		wasPacked[r.name] = true
+	}
+	refs, err := filesBackend{}.listRefs()
+	if err != nil {
+		return err
+	}
+	out := []byte("# pack-refs with: peeled fully-peeled sorted \n")
+	loose := []refRecord(nil)
+	for _, r := range refs {
+		if strings.HasPrefix(r.value, refPrefix) {
+			continue
+		}
+		if !all && !strings.HasPrefix(r.name, "refs/tags/") && !wasPacked[r.name] {
+			continue
+		}
+		out = append(out, r.value+" "+r.name+"\n"...)
+		if peeled, err := peel(r.value, ""); err == nil && peeled != r.value {
+			out = append(out, "^"+peeled+"\n"...)
+		}
+		if _, err := os.Stat(".git/" + r.name); err == nil {
+			loose = append(loose, r)
+		}
+	}
+	if _, err := l.Write(out); err != nil {
+		return err
+	}
+	if err := l.commit(); err != nil {
+		return err
+	}
+
+	// Delete the loose refs that haven't changed since they were packed.
+	for _, r := range loose {
+		rl, err := lock(".git/" + r.name)
+		if err != nil {
+			continue
+		}
+		if b, err := readFile(".git/" + r.name); err == nil && strings.TrimSpace(string(b)) == r.value {
+			os.Remove(".git/" + r.name)
+			removeEmptyRefDirs(r.name)
+		}
+		rl.rollback()
+	}
+	return nil
+}
+
+// GCOptions controls GC.
+type GCOptions struct {
+	ReflogExpire, ReflogExpireUnreachable time.Time
+	PruneExpire                           time.Time
+	Pack                                  PackOptions
+}
+
+// GCNeeded reports whether git gc --auto would do anything: whether there
+// are more than gc.auto loose objects, estimated from those in
+// .git/objects/17, or more than gc.autoPackLimit packs. A gc.auto of 0
+// turns automatic collection off.
+func GCNeeded() (tooManyLoose, tooManyPacks bool, err error) {
+	limit, err := ConfigInt("gc.auto", 6700)
+	if err != nil || limit <= 0 {
+		return false, false, err
+	}
+	files, err := ioutil.ReadDir(".git/objects/17")
+	if err != nil && !os.IsNotExist(err) {
+		return false, false, err
+	}
+	loose := 0
+	for _, fi := range files {
+		if isHexHash("17" + fi.Name()) {
+			loose++
+		}
+	}
+	tooManyLoose = int64(loose) > (limit+255)/256
+
+	packLimit, err := ConfigInt("gc.autoPackLimit", 50)
+	if err != nil {
+		return false, false, err
+	}
+	packs, err := filepath.Glob(".git/objects/pack/pack-*.pack")
+	if err != nil {
+		return false, false, err
+	}
+	kept := 0
+	for _, p := range packs {
+		if _, err := os.Stat(strings.TrimSuffix(p, ".pack") + ".keep"); err == nil {
+			kept++
+		}
+	}
+	tooManyPacks = packLimit > 0 && int64(len(packs)-kept) > packLimit
+	return tooManyLoose, tooManyPacks, nil
+}
+
+// GC cleans up the repository as git gc does: it packs refs, expires
+// reflogs, repacks every reachable object into one pack, loosening the
+// unreachable objects of the old packs, and prunes the unreachable loose
+// objects older than opts.PruneExpire.
+func GC(opts GCOptions) error {
+	return gc(opts, true)
+}
+
+// GCAuto runs the parts of GC that GCNeeded says are needed: a full GC if
+// there are too many packs, or if there are just too many loose objects a
+// repack of those alone. It reports whether it did anything.
+func GCAuto(opts GCOptions) (bool, error) {
+	tooManyLoose, tooManyPacks, err := GCNeeded()
+	if err != nil || (!tooManyLoose && !tooManyPacks) {
+		return false, err
+	}
+	return true, gc(opts, tooManyPacks)
+}
+
+func gc(opts GCOptions, full bool) error {
+	if err := PackRefs(true); err != nil {
+		return err
+	}
+	logs, err := ReflogRefs()
+	if err != nil {
+		return err
+	}
+	for _, ref := range logs {
+		if _, err := ExpireReflog(ref, opts.ReflogExpire, opts.ReflogExpireUnreachable, false); err != nil {
+			return err
+		}
+	}
+	repack := RepackOptions{All: full, Delete: true, Unpack: true, UnpackExpire: opts.PruneExpire, Pack: opts.Pack}
+	if _, err := Repack(repack); err != nil {
+		return err
+	}
+	_, err = Prune(opts.PruneExpire, false)
+	return err
+}
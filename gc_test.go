@@ -0,0 +1,155 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRepackAndPrune(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	tree := makeTree(t, map[string]string{"a": "a\n", "d/b": "b\n"})
+	commit, err := CommitTree(tree, nil, who, who, "commit\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRef("refs/heads/master", commit, "", who, "commit"); err != nil {
+		t.Fatal(err)
+	}
+	unreachable, _ := WriteObject("blob", []byte("unreachable\n"))
+
+	// An incremental repack packs the reachable loose objects.
+	name, err := Repack(RepackOptions{Delete: true, Pack: PackOptions{Window: 10, Depth: 50}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name == "" {
+		t.Fatal("nothing was packed")
+	}
+	loose := []string(nil)
+	looseObjects(func(hash, path string, fi os.FileInfo) error {
+		loose = append(loose, hash)
+		return nil
+	})
+	if !reflect.DeepEqual(loose, []string{unreachable}) {
+		t.Errorf("loose objects after repack: %v, want just %s", loose, unreachable)
+	}
+	if name, err := Repack(RepackOptions{Delete: true}); err != nil || name != "" {
+		t.Errorf("second repack gave %q, %v", name, err)
+	}
+
+	// Pack the unreachable blob too, then lose it from the refs' point of
+	// view with a full repack that keeps it loose.
+	packedBlob, err := WritePackFiles(".git/objects/pack/pack", []ListedObject{{Hash: unreachable}}, PackOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(".git/objects/" + unreachable[:2] + "/" + unreachable[2:])
+	if _, err := Repack(RepackOptions{All: true, Delete: true, Unpack: true}); err != nil {
+		t.Fatal(err)
+	}
+	packs, _ := filepath.Glob(".git/objects/pack/*.pack")
+	if len(packs) != 1 || filepath.Base(packs[0]) == "pack-"+packedBlob+".pack" {
+		t.Errorf("packs after full repack: %v", packs)
+	}
+	if !objectExists(unreachable) || !objectExists(commit) {
+		t.Errorf("objects lost by full repack")
+	}
+
+	pruned, err := Prune(time.Now().Add(-time.Hour), false)
+	if err != nil || len(pruned) != 0 {
+		t.Errorf("Prune with a grace period removed %v, %v", pruned, err)
+	}
+	pruned, err = Prune(time.Now().Add(time.Hour), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []LooseObject{{unreachable, "blob"}}; !reflect.DeepEqual(pruned, want) {
+		t.Errorf("Prune removed %v, want %v", pruned, want)
+	}
+	if objectExists(unreachable) {
+		t.Errorf("%s survived Prune", unreachable)
+	}
+	if _, err := ReadCommit(commit); err != nil {
+		t.Errorf("reading commit after prune: %v", err)
+	}
+}
+
+func TestPackRefs(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	commit, err := CommitTree(makeTree(t, map[string]string{"a": "a\n"}), nil, who, who, "commit\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := WriteTag(commit, "v1", who, "tag\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ref, hash := range map[string]string{"refs/heads/master": commit, "refs/tags/v1": tag} {
+		if err := UpdateRef(ref, hash, "", who, "create"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := PackRefs(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(".git/refs/tags/v1"); !os.IsNotExist(err) {
+		t.Errorf("loose tag survived packing: %v", err)
+	}
+	if _, err := os.Stat(".git/refs/heads/master"); err != nil {
+		t.Errorf("branch was packed without --all: %v", err)
+	}
+	if err := PackRefs(true); err != nil {
+		t.Fatal(err)
+	}
+	want := "# pack-refs with: peeled fully-peeled sorted \n" +
+		commit + " refs/heads/master\n" + tag + " refs/tags/v1\n^" + commit + "\n"
+	if b, err := readFile(".git/packed-refs"); err != nil || string(b) != want {
+		t.Errorf("packed-refs is %q, %v, want %q", b, err, want)
+	}
+	for ref, hash := range map[string]string{"refs/heads/master": commit, "refs/tags/v1": tag} {
+		if _, got, err := ResolveRef(ref); err != nil || got != hash {
+			t.Errorf("%s is %s, %v after packing, want %s", ref, got, err, hash)
+		}
+	}
+}
+
+func TestGCNeeded(t *testing.T) {
+	defer withTempRepo(t)()
+
+	for i := 0; i < 3; i++ {
+		os.MkdirAll(".git/objects/17", 0777)
+		f, err := os.Create(".git/objects/17/" + string(rune('a'+i)) + strings.Repeat("0", 37))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	if loose, packs, err := GCNeeded(); err != nil || loose || packs {
+		t.Errorf("GCNeeded() = %v, %v, %v with the default limits", loose, packs, err)
+	}
+	if err := SetConfigValue("gc.auto", "512"); err != nil {
+		t.Fatal(err)
+	}
+	if loose, _, err := GCNeeded(); err != nil || !loose {
+		t.Errorf("GCNeeded() = %v, %v with gc.auto 512", loose, err)
+	}
+	if err := SetConfigValue("gc.auto", "0"); err != nil {
+		t.Fatal(err)
+	}
+	if loose, _, err := GCNeeded(); err != nil || loose {
+		t.Errorf("GCNeeded() = %v, %v with gc.auto 0", loose, err)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// Hook is a run of one of the repository's hooks, with the arguments, input
+// and environment git gives it.
+type Hook struct {
+	Name  string
+	Args  []string
+	Stdin []byte   // the hook's input; nil to give it none
+	Env   []string // "NAME=value" settings added to the environment
+}
+
+// HookError is returned when a hook exits with a non-zero status.
+type HookError struct {
+	Name   string
+	Status int
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook exited with status %d", e.Name, e.Status)
+}
+
+// HooksDir returns the directory hooks are run from: core.hooksPath if it is
+// set, and .git/hooks otherwise.
+func HooksDir() string {
+	if c, err := ReadConfig(); err == nil {
+		if e, ok := c.Get("core.hooksPath"); ok {
+			if path, err := e.Path(); err == nil && path != "" {
+				return path
+			}
+		}
+	}
+	return filepath.Join(".git", "hooks")
+}
+
+// FindHook returns the path of the hook called name, or "" if there is none.
+// Like git it ignores a hook that isn't executable, saying so unless
+// advice.ignoredHook is false.
+func FindHook(name string) string {
+	path := filepath.Join(HooksDir(), name)
+	fi, err := os.Stat(path)
+	if err != nil || fi.IsDir() {
+		return ""
+	}
+	if fi.Mode()&0111 == 0 {
+		if advise, err := ConfigBool("advice.ignoredHook", true); err != nil || advise {
+			fmt.Fprintf(os.Stderr, "hint: The '%s' hook was ignored because it's not set as executable.\n"+
+				"hint: You can disable this warning with `git config advice.ignoredHook false`.\n", path)
+		}
+		return ""
+	}
+	return path
+}
+
+// Run runs the hook if the repository has it. As in git the hook's output
+// all goes to standard error, and it runs at the top of the worktree with
+// standard input empty unless h.Stdin says otherwise. GIT_DIR is set to the
+// absolute path of the repository, so that the hook finds it wherever it
+// goes. A hook that fails returns a *HookError.
+func (h Hook) Run() error {
+	path := FindHook(h.Name)
+	if path == "" {
+		return nil
+	}
+	gitDir, err := filepath.Abs(".git")
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(path, h.Args...)
+	if h.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(h.Stdin)
+	}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(append(os.Environ(), "GIT_DIR="+gitDir), h.Env...)
+	err = cmd.Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		status := 1
+		if ws, ok := exit.Sys().(syscall.WaitStatus); ok && ws.Exited() {
+			status = ws.ExitStatus()
+		}
+		return &HookError{h.Name, status}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot run %s: %v", path, err)
+	}
+	return nil
+}
+
+// RunHook runs the hook called name with args, if the repository has it.
+func RunHook(name string, args ...string) error {
+	return Hook{Name: name, Args: args}.Run()
+}
+
+// CommitHook returns a run of one of the hooks git commit runs, pre-commit,
+// prepare-commit-msg, commit-msg or post-commit, which are told the index in
+// use and that no editor will be opened.
+func CommitHook(name string, args ...string) Hook {
+	return Hook{Name: name, Args: args, Env: []string{"GIT_INDEX_FILE=.git/index", "GIT_EDITOR=:"}}
+}
+
+// PostCheckoutHook returns a run of the post-checkout hook after HEAD moved
+// from oldHash to newHash, either of which may be empty for an unborn
+// branch. branch is set when switching branches and clear when checking out
+// files.
+func PostCheckoutHook(oldHash, newHash string, branch bool) Hook {
+	if oldHash == "" {
+		oldHash = ZeroHash
+	}
+	if newHash == "" {
+		newHash = ZeroHash
+	}
+	flag := "0"
+	if branch {
+		flag = "1"
+	}
+	return Hook{Name: "post-checkout", Args: []string{oldHash, newHash, flag}}
+}
+
+// PostMergeHook returns a run of the post-merge hook after a merge, squash
+// being set if it was a squash merge.
+func PostMergeHook(squash bool) Hook {
+	flag := "0"
+	if squash {
+		flag = "1"
+	}
+	return Hook{Name: "post-merge", Args: []string{flag}}
+}
+
+// PushUpdate is a ref a push is about to change on the remote.
+type PushUpdate struct {
+	LocalRef, LocalHash   string // "(delete)" and ZeroHash when deleting
+	RemoteRef, RemoteHash string // ZeroHash if the remote ref is new
+}
+
+// PrePushHook returns a run of the pre-push hook before pushing updates to
+// the remote of the given name and URL; url is also given for the name when
+// pushing to a URL directly. The hook reads a line for each update.
+func PrePushHook(remote, url string, updates []PushUpdate) Hook {
+	b := bytes.NewBuffer(nil)
+	for _, u := range updates {
+		fmt.Fprintf(b, "%s %s %s %s\n", u.LocalRef, u.LocalHash, u.RemoteRef, u.RemoteHash)
+	}
+	return Hook{Name: "pre-push", Args: []string{remote, url}, Stdin: b.Bytes()}
+}
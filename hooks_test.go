@@ -0,0 +1,102 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	defer withTempRepo(t)()
+
+	os.MkdirAll("hooks", 0777)
+	ioutil.WriteFile(".git/config", []byte("[core]\n\thooksPath = hooks\n[advice]\n\tignoredHook = false\n"), 0666)
+	ioutil.WriteFile("hooks/fails", []byte("#!/bin/sh\nexit 3\n"), 0777)
+	ioutil.WriteFile("hooks/off", []byte("#!/bin/sh\nexit 1\n"), 0666)
+	ioutil.WriteFile("hooks/args", []byte("#!/bin/sh\necho \"$*\" \"$GIT_INDEX_FILE\" >out\n"), 0777)
+
+	if err := RunHook("missing"); err != nil {
+		t.Errorf("missing hook gave error %v", err)
+	}
+	if err := RunHook("off"); err != nil {
+		t.Errorf("hook that isn't executable gave error %v", err)
+	}
+	if err, ok := RunHook("fails").(*HookError); !ok || err.Status != 3 {
+		t.Errorf("failing hook gave error %v, want status 3", err)
+	}
+	if err := CommitHook("args", "a", "b").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile("out"); string(b) != "a b .git/index\n" {
+		t.Errorf("hook was run with %q", b)
+	}
+
+	// Every hook is told where the repository is, even one that moves away.
+	ioutil.WriteFile("hooks/gitdir", []byte("#!/bin/sh\ncd / && echo \"$GIT_DIR\" >\"$OLDPWD/out\"\n"), 0777)
+	os.Setenv("GIT_DIR", "elsewhere")
+	defer os.Unsetenv("GIT_DIR")
+	if err := RunHook("gitdir"); err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	if b, _ := ioutil.ReadFile("out"); string(b) != filepath.Join(wd, ".git")+"\n" {
+		t.Errorf("hook saw GIT_DIR %q, want %q", b, filepath.Join(wd, ".git"))
+	}
+}
+
+func TestReferenceTransactionHook(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	tree := makeTree(t, map[string]string{"a": "a\n"})
+	one, _ := CommitTree(tree, nil, who, who, "one\n")
+	two, _ := CommitTree(tree, []string{one}, who, who, "two\n")
+	os.MkdirAll(".git/hooks", 0777)
+	ioutil.WriteFile(".git/hooks/reference-transaction", []byte("#!/bin/sh\n"+
+		"echo $1 >>log\ncat >>log\ntest \"$1\" != prepared || ! grep -q refs/tags/no log\n"), 0777)
+	check := func(want string) {
+		t.Helper()
+		b, _ := ioutil.ReadFile("log")
+		if string(b) != want {
+			t.Errorf("hook saw\n%s\nwant\n%s", b, want)
+		}
+		os.Remove("log")
+	}
+
+	if err := UpdateRef("HEAD", one, ZeroHash, who, "commit"); err != nil {
+		t.Fatal(err)
+	}
+	line := ZeroHash + " " + one + " "
+	check("prepared\n" + line + "HEAD\n" + line + "refs/heads/master\n" +
+		"committed\n" + line + "HEAD\n" + line + "refs/heads/master\n")
+
+	if err := UpdateRef("refs/heads/master", two, "", who, "move"); err != nil {
+		t.Fatal(err)
+	}
+	line = ZeroHash + " " + two + " "
+	check("prepared\n" + line + "refs/heads/master\n" + line + "HEAD\n" +
+		"committed\n" + line + "refs/heads/master\n" + line + "HEAD\n")
+
+	if err := SetHEAD("refs/heads/other", who, "switch"); err != nil {
+		t.Fatal(err)
+	}
+	check("")
+
+	tx := NewRefTransaction()
+	tx.Create("refs/tags/no", one, "")
+	if err := tx.Commit(who); err == nil {
+		t.Errorf("transaction committed although the hook refused it")
+	}
+	line = ZeroHash + " " + one + " refs/tags/no\n"
+	check("prepared\n" + line + "aborted\n" + line)
+	if _, hash, _ := ResolveRef("refs/tags/no"); hash != "" {
+		t.Errorf("refused update was made")
+	}
+}
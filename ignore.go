@@ -0,0 +1,160 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type ignorePattern struct {
+	pattern                   string
+	base                      string // directory of the .gitignore holding the pattern, "" for the top
+	negate, dirOnly, anchored bool
+}
+
+func parseIgnorePatterns(filename, base string) []ignorePattern {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	patterns := []ignorePattern(nil)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		// Trailing spaces are ignored unless escaped with a backslash.
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		p := ignorePattern{base: base}
+		if line[0] == '!' {
+			p.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.IndexByte(line, '/') != -1 {
+			p.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if len(line) == 0 {
+			continue
+		}
+		p.pattern = line
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// match reports whether the pattern selects p, a path relative to the top of
+// the worktree.
+func (ip ignorePattern) match(p string, isDir bool) bool {
+	if ip.dirOnly && !isDir {
+		return false
+	}
+	if ip.base != "" {
+		if !strings.HasPrefix(p, ip.base+"/") {
+			return false
+		}
+		p = p[len(ip.base)+1:]
+	}
+	if !ip.anchored {
+		return wildmatch(ip.pattern, path.Base(p), wmPathname)
+	}
+	return wildmatch(ip.pattern, p, wmPathname)
+}
+
+// Ignorer decides whether untracked paths are ignored according to the
+// worktree's .gitignore files, .git/info/exclude and core.excludesFile.
+type Ignorer struct {
+	global []ignorePattern
+	perDir map[string][]ignorePattern // loaded lazily, keyed by directory
+}
+
+// NewIgnorer returns an Ignorer for the worktree in the current directory.
+func NewIgnorer() *Ignorer {
+	ig := &Ignorer{perDir: make(map[string][]ignorePattern)}
+	excludesFile := ""
+	if c, err := ReadConfig(); err == nil {
+		if e, ok := c.Get("core.excludesFile"); ok {
+			excludesFile, _ = e.Path()
+		}
+	}
+	if excludesFile == "" {
+		if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+			excludesFile = filepath.Join(xdg, "git", "ignore")
+		} else if home := os.Getenv("HOME"); home != "" {
+			excludesFile = filepath.Join(home, ".config", "git", "ignore")
+		}
+	}
+	if excludesFile != "" {
+		ig.global = parseIgnorePatterns(excludesFile, "")
+	}
+	ig.global = append(ig.global, parseIgnorePatterns(".git/info/exclude", "")...)
+	return ig
+}
+
+func (ig *Ignorer) dirPatterns(dir string) []ignorePattern {
+	patterns, ok := ig.perDir[dir]
+	if !ok {
+		name := ".gitignore"
+		if dir != "" {
+			name = dir + "/.gitignore"
+		}
+		patterns = parseIgnorePatterns(name, dir)
+		ig.perDir[dir] = patterns
+	}
+	return patterns
+}
+
+// ignoredSelf applies the patterns that can match p without considering
+// whether one of its parent directories is ignored. Patterns from deeper
+// .gitignore files take precedence, and within a file the last match wins.
+func (ig *Ignorer) ignoredSelf(p string, isDir bool) bool {
+	dirs := []string{""}
+	for i := 0; i < len(p); i++ {
+		if p[i] == '/' {
+			dirs = append(dirs, p[:i])
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		patterns := ig.dirPatterns(dirs[i])
+		for j := len(patterns) - 1; j >= 0; j-- {
+			if patterns[j].match(p, isDir) {
+				return !patterns[j].negate
+			}
+		}
+	}
+	for j := len(ig.global) - 1; j >= 0; j-- {
+		if ig.global[j].match(p, isDir) {
+			return !ig.global[j].negate
+		}
+	}
+	return false
+}
+
+// Ignored reports whether p, relative to the top of the worktree, is
+// ignored. As in git, a file can't be re-included if a directory containing
+// it is ignored.
+func (ig *Ignorer) Ignored(p string, isDir bool) bool {
+	for i := 0; i < len(p); i++ {
+		if p[i] == '/' && ig.ignoredSelf(p[:i], true) {
+			return true
+		}
+	}
+	return ig.ignoredSelf(p, isDir)
+}
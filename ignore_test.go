@@ -0,0 +1,62 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestIgnored(t *testing.T) {
+	defer withTempRepo(t)()
+	os.Setenv("XDG_CONFIG_HOME", ".")
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+
+	files := map[string]string{
+		".gitignore":        "# comment\n*.o\n/top.txt\nbuild/\n!keep.o\nlogs/*.log\n\\#hash\n",
+		"sub/.gitignore":    "*.tmp\n!important.tmp\nkeep.o\n",
+		".git/info/exclude": "secret\n",
+	}
+	os.MkdirAll("sub", 0777)
+	os.MkdirAll(".git/info", 0777)
+	for name, contents := range files {
+		if err := ioutil.WriteFile(name, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"a.o", false, true},
+		{"dir/a.o", false, true},
+		{"keep.o", false, false},
+		{"sub/keep.o", false, true},
+		{"top.txt", false, true},
+		{"sub/top.txt", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"build/x.c", false, true},
+		{"sub/build/x.c", false, true},
+		{"logs/a.log", false, true},
+		{"logs/deep/a.log", false, false},
+		{"sub/x.tmp", false, true},
+		{"sub/important.tmp", false, false},
+		{"x.tmp", false, false},
+		{"secret", false, true},
+		{"#hash", false, true},
+		{"main.c", false, false},
+	}
+	ig := NewIgnorer()
+	for _, c := range cases {
+		if ig.Ignored(c.path, c.isDir) != c.ignored {
+			t.Errorf("Ignored(%q, %v) = %v", c.path, c.isDir, !c.ignored)
+		}
+	}
+}
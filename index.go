@@ -6,11 +6,14 @@
 package ggit
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"syscall"
 	"time"
 )
@@ -31,6 +34,23 @@ type Entry struct {
 	Path                     []byte
 }
 
+const (
+	entryAssumeValid = 0x8000
+	entryExtended    = 0x4000
+	entryStageMask   = 0x3000
+	entryNameMask    = 0x0fff
+
+	// Bits of ExtendedFlags.
+	entrySkipWorktree = 0x4000
+	entryIntentToAdd  = 0x2000
+)
+
+// Stage returns the merge stage of the entry, which is 0 for entries that are
+// not part of a conflict.
+func (e Entry) Stage() int {
+	return int(e.Flags&entryStageMask) >> 12
+}
+
 func (e Entry) String() string {
 	const layout = "Jan 2 15:04"
 	s := fmt.Sprintf("ctime %s mtime %s ", e.Ctime.Format(layout), e.Mtime.Format(layout))
@@ -70,7 +90,11 @@ func parseEntry(data []byte) (e Entry, length uint32, err error) {
 	e.Size = binary.BigEndian.Uint32(consume(4))
 	copy(e.Hash[:], consume(sha1.Size))
 	e.Flags = binary.BigEndian.Uint16(consume(2))
-	// TODO(jamesr): If version >= 3, 16 bit extended flags
+	// Version 3 and later have 16 bits of extended flags when the extended bit
+	// is set.
+	if e.Flags&entryExtended != 0 {
+		e.ExtendedFlags = binary.BigEndian.Uint16(consume(2))
+	}
 	// data now points to the first byte of the path. In versions <= 3, this is a
 	// NUL-terminated string followed by 0-7 bytes of additional padding to round
 	// the length out to a multiple of 8 bytes.
@@ -122,6 +146,7 @@ func parseEntries(data []byte, numEntries uint32) ([]Entry, uint32, error) {
 type extension struct {
 	Signature []byte
 	Size      uint32
+	Data      []byte
 }
 
 func parseExtensions(data []byte) ([]extension, error) {
@@ -137,6 +162,7 @@ func parseExtensions(data []byte) ([]extension, error) {
 			return nil, fmt.Errorf("Not enough bytes for extension data, expecting %v but only have %v",
 				e.Size, len(data)-8)
 		}
+		e.Data = data[8 : 8+e.Size]
 		data = data[8+e.Size:]
 		extensions = append(extensions, e)
 	}
@@ -156,8 +182,8 @@ var mmapFile = func(path string) ([]byte, error) {
 	if length < 12+sha1.Size { // 12 byte header at start, SHA-1 checksum at end.
 		return nil, fmt.Errorf("Index file too small, %d", length)
 	}
-	flags := 0
-	return syscall.Mmap(int(file.Fd()), 0, length, syscall.PROT_READ, flags)
+	defer file.Close()
+	return syscall.Mmap(int(file.Fd()), 0, length, syscall.PROT_READ, syscall.MAP_PRIVATE)
 }
 
 func MapIndexFile(filename string) (version uint32, entries []Entry, extensions []extension, data []byte, err error) {
@@ -182,3 +208,160 @@ func MapIndexFile(filename string) (version uint32, entries []Entry, extensions
 	}
 	return
 }
+
+// Index is a copy of an index file that, unlike the slices returned by
+// MapIndexFile, stays valid once the file is unmapped and can be modified and
+// written back.
+type Index struct {
+	Version    uint32
+	Entries    []Entry
+	Extensions []extension
+
+	tree       *cacheTree // parsed from the TREE extension on first use
+	treeLoaded bool
+}
+
+// ReadIndex reads the index file at filename. A missing file is treated as an
+// empty index, as in a repository with nothing staged yet.
+func ReadIndex(filename string) (*Index, error) {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return &Index{Version: 2}, nil
+	}
+	version, entries, extensions, data, err := MapIndexFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Munmap(data)
+	for i := range entries {
+		entries[i].Path = append([]byte(nil), entries[i].Path...)
+	}
+	for i := range extensions {
+		extensions[i].Signature = append([]byte(nil), extensions[i].Signature...)
+		extensions[i].Data = append([]byte(nil), extensions[i].Data...)
+	}
+	return &Index{Version: version, Entries: entries, Extensions: extensions}, nil
+}
+
+func (idx *Index) sort() {
+	sort.SliceStable(idx.Entries, func(i, j int) bool {
+		c := bytes.Compare(idx.Entries[i].Path, idx.Entries[j].Path)
+		if c != 0 {
+			return c < 0
+		}
+		return idx.Entries[i].Stage() < idx.Entries[j].Stage()
+	})
+}
+
+func marshalTime(b []byte, t time.Time) {
+	binary.BigEndian.PutUint32(b[:4], uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[4:8], uint32(t.Nanosecond()))
+}
+
+func marshalEntry(w *bufio.Writer, e Entry, version uint32) {
+	var b [64]byte
+	marshalTime(b[0:8], e.Ctime)
+	marshalTime(b[8:16], e.Mtime)
+	binary.BigEndian.PutUint32(b[16:20], e.Dev)
+	binary.BigEndian.PutUint32(b[20:24], e.Ino)
+	binary.BigEndian.PutUint32(b[24:28], e.Mode)
+	binary.BigEndian.PutUint32(b[28:32], e.Uid)
+	binary.BigEndian.PutUint32(b[32:36], e.Gid)
+	binary.BigEndian.PutUint32(b[36:40], e.Size)
+	copy(b[40:60], e.Hash[:])
+	flags := e.Flags &^ (entryExtended | entryNameMask)
+	if len(e.Path) < entryNameMask {
+		flags |= uint16(len(e.Path))
+	} else {
+		flags |= entryNameMask
+	}
+	length := 62
+	if version >= 3 && e.ExtendedFlags != 0 {
+		flags |= entryExtended
+		binary.BigEndian.PutUint16(b[62:64], e.ExtendedFlags)
+		length = 64
+	}
+	binary.BigEndian.PutUint16(b[60:62], flags)
+	w.Write(b[:length])
+	w.Write(e.Path)
+	// Pad with 1-8 NUL bytes to a multiple of 8, matching parseEntry.
+	length += len(e.Path)
+	padded := ((length / 8) + 1) * 8
+	w.Write(make([]byte, padded-length))
+}
+
+// Write stores the index in filename, replacing any existing file atomically.
+func (idx *Index) Write(filename string) error {
+	idx.sort()
+	idx.marshalCacheTree()
+	version := idx.Version
+	if version < 2 || version > 3 {
+		version = 2
+	}
+	for _, e := range idx.Entries {
+		if e.ExtendedFlags != 0 {
+			version = 3
+		}
+	}
+	idx.Version = version
+
+	l, err := lock(filename)
+	if err != nil {
+		return err
+	}
+	defer l.rollback()
+	h := sha1.New()
+	w := bufio.NewWriter(io.MultiWriter(l, h))
+	var header [12]byte
+	copy(header[:4], "DIRC")
+	binary.BigEndian.PutUint32(header[4:8], version)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(idx.Entries)))
+	w.Write(header[:])
+	for _, e := range idx.Entries {
+		marshalEntry(w, e, version)
+	}
+	for _, e := range idx.Extensions {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(e.Data)))
+		w.Write(e.Signature)
+		w.Write(size[:])
+		w.Write(e.Data)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if _, err := l.Write(h.Sum(nil)); err != nil {
+		return err
+	}
+	return l.commit()
+}
+
+func (idx *Index) extension(signature string) *extension {
+	for i := range idx.Extensions {
+		if string(idx.Extensions[i].Signature) == signature {
+			return &idx.Extensions[i]
+		}
+	}
+	return nil
+}
+
+// setExtension replaces the extension with the given signature, adding it if
+// it isn't present. A nil data removes the extension.
+func (idx *Index) setExtension(signature string, data []byte) {
+	for i := range idx.Extensions {
+		if string(idx.Extensions[i].Signature) == signature {
+			if data == nil {
+				idx.Extensions = append(idx.Extensions[:i], idx.Extensions[i+1:]...)
+			} else {
+				idx.Extensions[i].Data = data
+				idx.Extensions[i].Size = uint32(len(data))
+			}
+			return
+		}
+	}
+	if data != nil {
+		idx.Extensions = append(idx.Extensions, extension{
+			Signature: []byte(signature),
+			Size:      uint32(len(data)),
+			Data:      data})
+	}
+}
@@ -0,0 +1,544 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// IndexPackOptions controls IndexPack.
+type IndexPackOptions struct {
+	FixThin bool // add the delta bases a thin pack lacks from the repository
+	Strict  bool // refuse objects git fsck complains about and links to missing objects
+	Threads int  // goroutines resolving deltas; 0 means one per CPU
+}
+
+// PackObjectError is a problem --strict found with an object in a pack.
+type PackObjectError struct {
+	Hash    string
+	Problem ObjectProblem
+}
+
+func (e *PackObjectError) Error() string {
+	return fmt.Sprintf("object %s: %v", e.Hash, e.Problem)
+}
+
+// packStream reads a pack as it arrives, copying and checksumming the bytes
+// taken from it. It reads a byte at a time where asked, so that zlib takes
+// no more than each entry's data.
+type packStream struct {
+	r       *bufio.Reader
+	w       io.Writer // where to copy the pack, if anywhere
+	sum     hash.Hash
+	crc     hash.Hash32
+	pending []byte
+	offset  uint64 // the offset of the first pending byte
+	err     error  // the first error writing the copy
+	eof     bool   // the pack ended
+}
+
+func (s *packStream) flush() {
+	s.sum.Write(s.pending)
+	s.crc.Write(s.pending)
+	if s.w != nil && s.err == nil {
+		_, s.err = s.w.Write(s.pending)
+	}
+	s.offset += uint64(len(s.pending))
+	s.pending = s.pending[:0]
+}
+
+func (s *packStream) took(b []byte) {
+	s.pending = append(s.pending, b...)
+	if len(s.pending) >= 64<<10 {
+		s.flush()
+	}
+}
+
+func (s *packStream) pos() uint64 {
+	return s.offset + uint64(len(s.pending))
+}
+
+func (s *packStream) ReadByte() (byte, error) {
+	c, err := s.r.ReadByte()
+	if err == nil {
+		s.took([]byte{c})
+	}
+	s.eof = s.eof || err == io.EOF
+	return c, err
+}
+
+func (s *packStream) Read(b []byte) (int, error) {
+	n, err := s.r.Read(b)
+	s.took(b[:n])
+	s.eof = s.eof || err == io.EOF
+	return n, err
+}
+
+// bad describes a failure to read the entry at offset as git does.
+func (s *packStream) bad(err error, offset uint64) error {
+	if s.eof {
+		return errors.New("early EOF")
+	}
+	return badEntry(err, offset)
+}
+
+func badEntry(err error, offset uint64) error {
+	return fmt.Errorf("pack has bad object at offset %d: inflate returned %v", offset, err)
+}
+
+// indexedObject is an entry of the pack being indexed.
+type indexedObject struct {
+	offset, dataStart uint64
+	entryType         byte
+	objectType        byte
+	size              int
+	baseOffset        uint64
+	baseHash          [sha1.Size]byte
+	crc               uint32
+	hash              [sha1.Size]byte
+	resolved          bool
+	depth, base       int // the length of a delta's chain and its base's index
+}
+
+// packIndexer works out the names of the objects in a pack.
+type packIndexer struct {
+	opts    IndexPackOptions
+	objects []indexedObject
+	data    []byte // the pack, once it has all been read
+
+	ofsChildren map[int][]int
+
+	mu          sync.Mutex
+	refChildren map[[sha1.Size]byte][]int // taken by the first object resolved with the name
+	links       map[string]bool
+	err         error
+}
+
+func (ix *packIndexer) fail(err error) {
+	ix.mu.Lock()
+	if ix.err == nil {
+		ix.err = err
+	}
+	ix.mu.Unlock()
+}
+
+// check runs the --strict checks on an object.
+func (ix *packIndexer) check(o *indexedObject, data []byte) {
+	if !ix.opts.Strict {
+		return
+	}
+	objectType := objectTypeStrings[o.objectType]
+	for _, p := range CheckObject(objectType, data) {
+		if p.Severity != FsckInfo {
+			ix.fail(&PackObjectError{fmt.Sprintf("%x", o.hash), p})
+			return
+		}
+	}
+	links, err := ObjectLinks(objectType, data)
+	if err != nil {
+		ix.fail(err)
+		return
+	}
+	ix.mu.Lock()
+	for _, l := range links {
+		ix.links[l] = true
+	}
+	ix.mu.Unlock()
+}
+
+// readEntries reads the pack from s, noting where each entry is and naming
+// the objects that aren't deltas. It ends by checking the pack's checksum.
+func (ix *packIndexer) readEntries(s *packStream) error {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(s, header); err != nil {
+		return s.bad(err, 0)
+	}
+	if string(header[:4]) != "PACK" {
+		return errors.New("pack signature mismatch")
+	}
+	if v := binary.BigEndian.Uint32(header[4:]); v != 2 && v != 3 {
+		return fmt.Errorf("pack version %d unsupported", v)
+	}
+	ix.objects = make([]indexedObject, binary.BigEndian.Uint32(header[8:]))
+	for i := range ix.objects {
+		s.flush()
+		s.crc.Reset()
+		o := &ix.objects[i]
+		o.offset = s.pos()
+		c, err := s.ReadByte()
+		if err != nil {
+			return s.bad(err, o.offset)
+		}
+		o.entryType = (c >> 4) & 7
+		o.size = int(c & 0x0f)
+		for shift := uint(4); c&0x80 != 0; shift += 7 {
+			if c, err = s.ReadByte(); err != nil {
+				return s.bad(err, o.offset)
+			}
+			o.size += int(c&0x7f) << shift
+		}
+		switch o.entryType {
+		case OBJ_OFS_DELTA:
+			if c, err = s.ReadByte(); err != nil {
+				return s.bad(err, o.offset)
+			}
+			ofs := uint64(c & 0x7f)
+			for c&0x80 != 0 {
+				if c, err = s.ReadByte(); err != nil {
+					return s.bad(err, o.offset)
+				}
+				ofs = (ofs+1)<<7 | uint64(c&0x7f)
+			}
+			if ofs == 0 || ofs > o.offset {
+				return fmt.Errorf("delta base offset is out of bound for object at %d", o.offset)
+			}
+			o.baseOffset = o.offset - ofs
+		case OBJ_REF_DELTA:
+			if _, err := io.ReadFull(s, o.baseHash[:]); err != nil {
+				return s.bad(err, o.offset)
+			}
+		case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
+			o.objectType, o.resolved = o.entryType, true
+		default:
+			return fmt.Errorf("unknown object type %d at offset %d", o.entryType, o.offset)
+		}
+		o.dataStart = s.pos()
+
+		// Like git, stop inflating at one byte more than the entry says, so
+		// that bad data is caught before it runs on into the next entry.
+		zr, err := getZlibReader(s)
+		if err != nil {
+			return s.bad(err, o.offset)
+		}
+		var n int64
+		if o.resolved {
+			h := sha1.New()
+			fmt.Fprintf(h, "%s %d\x00", objectTypeStrings[o.objectType], o.size)
+			var contents *bytes.Buffer
+			w := io.Writer(h)
+			if ix.opts.Strict {
+				contents = bytes.NewBuffer(nil)
+				w = io.MultiWriter(h, contents)
+			}
+			n, err = io.Copy(w, io.LimitReader(zr, int64(o.size)+1))
+			copy(o.hash[:], h.Sum(nil))
+			if err == nil && contents != nil {
+				ix.check(o, contents.Bytes())
+			}
+		} else {
+			n, err = io.Copy(ioutil.Discard, io.LimitReader(zr, int64(o.size)+1))
+		}
+		if err != nil {
+			return s.bad(err, o.offset)
+		}
+		returnZlibReader(zr)
+		if n != int64(o.size) {
+			return badEntry(fmt.Errorf("%d bytes, not %d", n, o.size), o.offset)
+		}
+		s.flush()
+		o.crc = s.crc.Sum32()
+	}
+	s.flush()
+	sum := s.sum.Sum(nil)
+	trailer := make([]byte, sha1.Size)
+	if _, err := io.ReadFull(s.r, trailer); err != nil {
+		return s.bad(err, s.pos())
+	}
+	if s.w != nil && s.err == nil {
+		_, s.err = s.w.Write(trailer)
+	}
+	if !bytes.Equal(sum, trailer) {
+		return errors.New("pack is corrupted (SHA1 mismatch)")
+	}
+	return s.err
+}
+
+// resolve names the deltas based on object i, whose contents are data, and
+// then those based on them.
+func (ix *packIndexer) resolve(i int, data []byte) {
+	o := &ix.objects[i]
+	ix.mu.Lock()
+	children := append(ix.ofsChildren[i], ix.refChildren[o.hash]...)
+	delete(ix.refChildren, o.hash)
+	ix.mu.Unlock()
+	for _, k := range children {
+		c := &ix.objects[k]
+		delta, err := readAllBytes(ix.data[c.dataStart:])
+		if err != nil {
+			ix.fail(badEntry(err, c.offset))
+			return
+		}
+		contents, err := patchDelta(data, delta)
+		if err != nil {
+			ix.fail(fmt.Errorf("failed to apply delta at offset %d: %v", c.offset, err))
+			return
+		}
+		c.objectType = o.objectType
+		c.depth, c.base = o.depth+1, i
+		c.hash = hashObject(objectTypeStrings[c.objectType], contents)
+		c.resolved = true
+		ix.check(c, contents)
+		ix.resolve(k, contents)
+	}
+}
+
+// resolveDeltas names every delta whose base is in the pack, following the
+// chains from each object that isn't a delta in parallel.
+func (ix *packIndexer) resolveDeltas() error {
+	offsets := make(map[uint64]int, len(ix.objects))
+	for i, o := range ix.objects {
+		offsets[o.offset] = i
+	}
+	for i, o := range ix.objects {
+		switch o.entryType {
+		case OBJ_OFS_DELTA:
+			base, ok := offsets[o.baseOffset]
+			if !ok {
+				return fmt.Errorf("delta base offset %d of object at %d is not an object", o.baseOffset, o.offset)
+			}
+			ix.ofsChildren[base] = append(ix.ofsChildren[base], i)
+		case OBJ_REF_DELTA:
+			ix.refChildren[o.baseHash] = append(ix.refChildren[o.baseHash], i)
+		}
+	}
+
+	threads := ix.opts.Threads
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	roots := make(chan int)
+	var wg sync.WaitGroup
+	for t := 0; t < threads; t++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range roots {
+				o := &ix.objects[i]
+				ix.mu.Lock()
+				waiting := len(ix.ofsChildren[i]) > 0 || len(ix.refChildren[o.hash]) > 0
+				ix.mu.Unlock()
+				if !waiting {
+					continue
+				}
+				data, err := readAllBytes(ix.data[o.dataStart:])
+				if err != nil {
+					ix.fail(badEntry(err, o.offset))
+					continue
+				}
+				ix.resolve(i, data)
+			}
+		}()
+	}
+	// The workers are filling in other fields of the objects, so only the
+	// entry types are read here.
+	for i := range ix.objects {
+		if t := ix.objects[i].entryType; t >= OBJ_COMMIT && t <= OBJ_TAG {
+			roots <- i
+		}
+	}
+	close(roots)
+	wg.Wait()
+	return ix.err
+}
+
+// fixThin appends the bases of the pack's unresolved deltas from the
+// repository to the pack file at path and resolves the deltas.
+func (ix *packIndexer) fixThin(path string) error {
+	missing := [][sha1.Size]byte(nil)
+	for h := range ix.refChildren {
+		missing = append(missing, h)
+	}
+	sort.Slice(missing, func(i, j int) bool { return bytes.Compare(missing[i][:], missing[j][:]) < 0 })
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	end := fi.Size() - sha1.Size
+	contents := make(map[int][]byte)
+	for _, h := range missing {
+		objectType, data, err := readObject(fmt.Sprintf("%x", h))
+		if err != nil {
+			continue
+		}
+		o := indexedObject{offset: uint64(end), hash: h, resolved: true}
+		for t, name := range objectTypeStrings {
+			if name == objectType {
+				o.objectType, o.entryType = byte(t), byte(t)
+			}
+		}
+		compressed, err := compress(data)
+		if err != nil {
+			return err
+		}
+		entry := append(appendPackHeader(nil, o.entryType, len(data)), compressed...)
+		o.dataStart = o.offset + uint64(len(entry)-len(compressed))
+		o.size, o.crc = len(data), crc32.ChecksumIEEE(entry)
+		if _, err := f.WriteAt(entry, end); err != nil {
+			return err
+		}
+		end += int64(len(entry))
+		contents[len(ix.objects)] = data
+		ix.objects = append(ix.objects, o)
+	}
+	if len(contents) == 0 {
+		return nil
+	}
+	if err := f.Truncate(end); err != nil {
+		return err
+	}
+	count := make([]byte, 4)
+	binary.BigEndian.PutUint32(count, uint32(len(ix.objects)))
+	if _, err := f.WriteAt(count, 8); err != nil {
+		return err
+	}
+	sum := sha1.New()
+	if _, err := io.Copy(sum, io.NewSectionReader(f, 0, end)); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(sum.Sum(nil), end); err != nil {
+		return err
+	}
+	for i, data := range contents {
+		ix.resolve(i, data)
+	}
+	return ix.err
+}
+
+// indexPack reads the pack at path, or from in into a new file at path if
+// in isn't nil, and names its objects.
+func indexPack(path string, in io.Reader, opts IndexPackOptions) (*packIndexer, error) {
+	ix := &packIndexer{opts: opts, ofsChildren: make(map[int][]int),
+		refChildren: make(map[[sha1.Size]byte][]int), links: make(map[string]bool)}
+	s := &packStream{sum: sha1.New(), crc: crc32.NewIEEE()}
+	if in != nil {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0444)
+		if err != nil {
+			return nil, err
+		}
+		w := bufio.NewWriter(f)
+		s.r, s.w = bufio.NewReader(in), w
+		err = ix.readEntries(s)
+		if err == nil {
+			err = w.Flush()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if opts.FixThin {
+			return nil, errors.New("--fix-thin cannot be used without --stdin")
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		s.r = bufio.NewReader(f)
+		err = ix.readEntries(s)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ix.err != nil {
+		return nil, ix.err
+	}
+
+	data, err := mmapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Munmap(data)
+	ix.data = data
+	if err := ix.resolveDeltas(); err != nil {
+		return nil, err
+	}
+	if len(ix.refChildren) > 0 && opts.FixThin {
+		if err := ix.fixThin(path); err != nil {
+			return nil, err
+		}
+	}
+	ix.data = nil
+	unresolved := 0
+	for _, o := range ix.objects {
+		if !o.resolved {
+			unresolved++
+		}
+	}
+	if unresolved == 1 {
+		return nil, errors.New("pack has 1 unresolved delta")
+	} else if unresolved > 0 {
+		return nil, fmt.Errorf("pack has %d unresolved deltas", unresolved)
+	}
+	return ix, nil
+}
+
+// packTrailer returns the checksum at the end of the pack file at path.
+func packTrailer(path string) ([sha1.Size]byte, error) {
+	var sum [sha1.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(-sha1.Size, io.SeekEnd); err != nil {
+		return sum, err
+	}
+	_, err = io.ReadFull(f, sum[:])
+	return sum, err
+}
+
+// IndexPack reads the pack at path, or from in into a new file at path if in
+// isn't nil, and works out what an index of it holds: the name, offset and
+// CRC-32 of each object, sorted by name, and the pack's checksum. Thin packs
+// can only be fixed when read from in.
+func IndexPack(path string, in io.Reader, opts IndexPackOptions) ([]PackEntry, [sha1.Size]byte, error) {
+	var sum [sha1.Size]byte
+	ix, err := indexPack(path, in, opts)
+	if err != nil {
+		return nil, sum, err
+	}
+	entries := make([]PackEntry, len(ix.objects))
+	names := make(map[string]bool, len(ix.objects))
+	for i, o := range ix.objects {
+		entries[i] = PackEntry{Hash: o.hash, Offset: o.offset, CRC32: o.crc}
+		names[fmt.Sprintf("%x", o.hash)] = true
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Hash[:], entries[j].Hash[:]) < 0
+	})
+	for link := range ix.links {
+		if !names[link] && !objectExists(link) {
+			return nil, sum, fmt.Errorf("did not receive expected object %s", link)
+		}
+	}
+	sum, err = packTrailer(path)
+	if err != nil {
+		return nil, sum, err
+	}
+	return entries, sum, nil
+}
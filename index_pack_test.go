@@ -0,0 +1,132 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndexPack(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	text := strings.Repeat("some text to make deltas of\n", 50)
+	parent := []string(nil)
+	for i := 0; i < 5; i++ {
+		tree := makeTree(t, map[string]string{"f": fmt.Sprintf("%s%d\n", text, i)})
+		hash, err := CommitTree(tree, parent, who, who, "commit\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent = []string{hash}
+	}
+	objects, err := ListObjects(parent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pack := bytes.NewBuffer(nil)
+	want, wantSum, err := WritePack(pack, objects, PackOptions{Window: 10, Depth: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, sum, err := IndexPack(".git/received.pack", bytes.NewReader(pack.Bytes()), IndexPackOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != wantSum || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("indexing gave %x %v, want %x %v", sum, got, wantSum, want)
+	}
+	if _, _, err := IndexPack(".git/received.pack", nil, IndexPackOptions{}); err != nil {
+		t.Errorf("indexing the received pack in place: %v", err)
+	}
+
+	corrupt := append([]byte(nil), pack.Bytes()...)
+	corrupt[len(corrupt)-1] ^= 1
+	if _, _, err := IndexPack(".git/bad.pack", bytes.NewReader(corrupt), IndexPackOptions{}); err == nil ||
+		err.Error() != "pack is corrupted (SHA1 mismatch)" {
+		t.Errorf("bad checksum gave error %v", err)
+	}
+	if _, _, err := IndexPack(".git/bad.pack", bytes.NewReader(pack.Bytes()[:100]), IndexPackOptions{}); err == nil ||
+		err.Error() != "early EOF" {
+		t.Errorf("truncated pack gave error %v", err)
+	}
+
+	// A thin pack holding a blob as a delta against one it leaves out.
+	base := []byte(text + "0\n")
+	baseHash, _ := WriteObject("blob", base)
+	target := []byte(text + "changed\n")
+	delta, _ := compress(createDelta(newDeltaIndex(base), target, len(target)))
+	thin := bytes.NewBuffer(nil)
+	thin.WriteString("PACK\x00\x00\x00\x02\x00\x00\x00\x01")
+	thin.Write(appendPackHeader(nil, OBJ_REF_DELTA, len(createDelta(newDeltaIndex(base), target, len(target)))))
+	thin.Write(hashToBytes(baseHash))
+	thin.Write(delta)
+	thinSum := sha1.Sum(thin.Bytes())
+	thin.Write(thinSum[:])
+	if _, _, err := IndexPack(".git/thin.pack", bytes.NewReader(thin.Bytes()), IndexPackOptions{}); err == nil ||
+		err.Error() != "pack has 1 unresolved delta" {
+		t.Errorf("thin pack gave error %v", err)
+	}
+	fixed, _, err := IndexPack(".git/thin.pack", bytes.NewReader(thin.Bytes()), IndexPackOptions{FixThin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetHash := hashObject("blob", target)
+	if len(fixed) != 2 || (fixed[0].Hash != targetHash && fixed[1].Hash != targetHash) {
+		t.Errorf("fixed thin pack holds %v", fixed)
+	}
+	if again, _, err := IndexPack(".git/thin.pack", nil, IndexPackOptions{}); err != nil || fmt.Sprint(again) != fmt.Sprint(fixed) {
+		t.Errorf("fixed pack indexes as %v, %v", again, err)
+	}
+}
+
+func TestIndexPackStrict(t *testing.T) {
+	defer withTempRepo(t)()
+
+	commit := []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\ncommitter A <a@b> 1 +0000\n\nno author\n")
+	pack := bytes.NewBuffer(nil)
+	pack.WriteString("PACK\x00\x00\x00\x02\x00\x00\x00\x01")
+	pack.Write(appendPackHeader(nil, OBJ_COMMIT, len(commit)))
+	compressed, _ := compress(commit)
+	pack.Write(compressed)
+	sum := sha1.Sum(pack.Bytes())
+	pack.Write(sum[:])
+	_, _, err := IndexPack(".git/strict.pack", bytes.NewReader(pack.Bytes()), IndexPackOptions{Strict: true})
+	if e, ok := err.(*PackObjectError); !ok || e.Problem.ID != "missingAuthor" {
+		t.Errorf("commit without an author gave error %v", err)
+	}
+	if _, _, err := IndexPack(".git/strict.pack", bytes.NewReader(pack.Bytes()), IndexPackOptions{}); err != nil {
+		t.Errorf("commit without an author was refused without --strict: %v", err)
+	}
+}
+
+func TestWritePackIndexLargeOffsets(t *testing.T) {
+	entries := []PackEntry{{Hash: [sha1.Size]byte{1}, Offset: 12}, {Hash: [sha1.Size]byte{2}, Offset: 5 << 30}}
+	b := bytes.NewBuffer(nil)
+	if err := WritePackIndex(b, entries, [sha1.Size]byte{}); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := parsePackIndexFile(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.offset(0) != 12 {
+		t.Errorf("small offset is %d", idx.offset(0))
+	}
+	large := binary.BigEndian.Uint32(idx.smallByteOffsets[4:])
+	if large != 1<<31 {
+		t.Errorf("large offset is marked %x", large)
+	}
+	if got := binary.BigEndian.Uint64(b.Bytes()[8+256*4+2*(sha1.Size+8):]); got != 5<<30 {
+		t.Errorf("large offset table holds %d", got)
+	}
+}
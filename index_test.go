@@ -146,3 +146,52 @@ func TestValidIndexHeader(t *testing.T) {
 		}
 	}
 }
+
+func TestWriteIndex(t *testing.T) {
+	defer withTempRepo(t)()
+
+	idx := &Index{Version: 2}
+	for i, p := range []string{"b", "a/long/path/name.txt", "c"} {
+		e := Entry{
+			Ctime: time.Unix(int64(i), 1),
+			Mtime: time.Unix(int64(i), 2),
+			Mode:  0100644,
+			Size:  uint32(i),
+			Path:  []byte(p)}
+		e.Hash[0] = byte(i)
+		idx.Entries = append(idx.Entries, e)
+	}
+	idx.Entries[2].ExtendedFlags = entryIntentToAdd
+	idx.setExtension("TREE", []byte("\x00-1 0\n"))
+	if err := idx.Write(".git/index"); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadIndex(".git/index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Version != 3 {
+		t.Errorf("expected version 3 for extended flags, got %d", read.Version)
+	}
+	if len(read.Entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(read.Entries))
+	}
+	for i, p := range []string{"a/long/path/name.txt", "b", "c"} {
+		e := read.Entries[i]
+		if string(e.Path) != p {
+			t.Errorf("entry %d: expected path %s got %s", i, p, e.Path)
+		}
+		if int(e.Flags&entryNameMask) != len(p) {
+			t.Errorf("entry %d: bad name length in flags %x", i, e.Flags)
+		}
+	}
+	if read.Entries[2].ExtendedFlags != entryIntentToAdd {
+		t.Errorf("extended flags not preserved: %x", read.Entries[2].ExtendedFlags)
+	}
+	if !read.Entries[0].Mtime.Equal(time.Unix(1, 2)) {
+		t.Errorf("mtime not preserved: %v", read.Entries[0].Mtime)
+	}
+	if ct := read.cacheTree(); ct == nil || ct.entryCount != -1 {
+		t.Errorf("cache tree not preserved: %+v", ct)
+	}
+}
@@ -6,55 +6,324 @@
 package ggit
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha1"
 	"fmt"
 	"io/ioutil"
+	"strconv"
+	"strings"
 )
 
 var readFile = ioutil.ReadFile
 
-func CommitishToHash(committish string) string {
-	if len(committish) <= sha1.Size*2 {
-		allHex := true
-		for _, c := range committish {
-			if c < '0' && c > '9' && c < 'a' && c > 'f' {
-				allHex = false
-				break
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// expandHash returns the full name of the single object whose name starts
+// with the hex string prefix.
+func expandHash(prefix string) (string, error) {
+	found := make(map[string]bool)
+	if infos, err := ioutil.ReadDir(".git/objects/" + prefix[:2]); err == nil {
+		for _, fi := range infos {
+			if strings.HasPrefix(fi.Name(), prefix[2:]) && len(fi.Name()) == 2*sha1.Size-2 {
+				found[prefix[:2]+fi.Name()] = true
 			}
 		}
-		if allHex {
-			return committish
+	}
+	packed, err := findPackedPrefix(prefix)
+	if err != nil {
+		return "", err
+	}
+	for _, h := range packed {
+		found[h] = true
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("unknown revision %s", prefix)
+	case 1:
+		for h := range found {
+			return h, nil
+		}
+	}
+	return "", fmt.Errorf("short object ID %s is ambiguous", prefix)
+}
+
+// refSearchPath lists the places a short ref name is looked for, in order.
+var refSearchPath = []string{
+	"%s",
+	"refs/%s",
+	"refs/tags/%s",
+	"refs/heads/%s",
+	"refs/remotes/%s",
+	"refs/remotes/%s/HEAD",
+}
+
+// DwimRef expands a short ref name such as "master" or "origin/topic" to the
+// full name of the ref it refers to and the object name stored there.
+func DwimRef(name string) (ref, hash string, err error) {
+	if name == "@" {
+		name = "HEAD"
+	}
+	if !validRefName(name) {
+		return "", "", fmt.Errorf("invalid ref name %s", name)
+	}
+	for _, format := range refSearchPath {
+		full := fmt.Sprintf(format, name)
+		if full == name && name != "HEAD" && !strings.HasPrefix(name, "refs/") &&
+			!strings.HasSuffix(name, "_HEAD") {
+			continue
+		}
+		_, hash, err := ResolveRef(full)
+		if err != nil {
+			return "", "", err
+		}
+		if hash != "" {
+			return full, hash, nil
+		}
+	}
+	return "", "", nil
+}
+
+// indexOutsideBraces is strings.IndexAny, ignoring characters inside the
+// braces of @{...} and ^{...}.
+func indexOutsideBraces(s, chars string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '{':
+			depth++
+		case s[i] == '}' && depth > 0:
+			depth--
+		case depth == 0 && strings.IndexByte(chars, s[i]) != -1:
+			return i
+		}
+	}
+	return -1
+}
+
+// resolveReflogSelector resolves ref@{sel}. An empty ref means the current
+// branch and @{-n} names the nth previously checked out branch.
+func resolveReflogSelector(ref, sel string) (string, error) {
+	if ref == "" && strings.HasPrefix(sel, "-") {
+		n, err := strconv.Atoi(sel[1:])
+		if err != nil || n <= 0 {
+			return "", fmt.Errorf("bad revision @{%s}", sel)
+		}
+		prev, err := PreviousBranch(n)
+		if err != nil {
+			return "", err
+		}
+		return resolveBase(prev)
+	}
+	full := ""
+	if ref == "" {
+		head, _, err := ResolveRef("HEAD")
+		if err != nil {
+			return "", err
+		}
+		full = head
+	} else {
+		var err error
+		if full, _, err = DwimRef(ref); err != nil {
+			return "", err
+		}
+		if full == "" {
+			return "", fmt.Errorf("unknown revision %s", ref)
+		}
+	}
+	return resolveReflog(full, sel)
+}
+
+// resolveBase resolves a revision without any suffix operators.
+func resolveBase(base string) (string, error) {
+	if at := strings.Index(base, "@{"); at != -1 && strings.HasSuffix(base, "}") {
+		return resolveReflogSelector(base[:at], base[at+2:len(base)-1])
+	}
+	if len(base) == 2*sha1.Size && isHex(base) {
+		return base, nil
+	}
+	_, hash, err := DwimRef(base)
+	if err != nil {
+		return "", err
+	}
+	if hash != "" {
+		return hash, nil
+	}
+	if len(base) >= 4 && len(base) < 2*sha1.Size && isHex(base) {
+		return expandHash(base)
+	}
+	return "", fmt.Errorf("unknown revision %s", base)
+}
+
+// tagTarget returns the name and type of the object a tag object points to.
+func tagTarget(data []byte) (string, string, error) {
+	hash, objectType := "", ""
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() && len(s.Text()) > 0 {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "object "):
+			hash = line[len("object "):]
+		case strings.HasPrefix(line, "type "):
+			objectType = line[len("type "):]
+		}
+	}
+	if hash == "" {
+		return "", "", fmt.Errorf("tag has no object line")
+	}
+	return hash, objectType, nil
+}
+
+// peel dereferences hash until it reaches an object of type want, following
+// tags and going from commits to their trees. An empty want peels tags only.
+func peel(hash, want string) (string, error) {
+	for {
+		objectType, data, err := readObject(hash)
+		if err != nil {
+			return "", err
 		}
+		switch {
+		case objectType == want || (want == "" && objectType != "tag"):
+			return hash, nil
+		case objectType == "tag":
+			hash, _, err = tagTarget(data)
+			if err != nil {
+				return "", err
+			}
+		case objectType == "commit" && want == "tree":
+			c := commit{}
+			if err := parseKnownFields(&c, bytes.NewReader(data)); err != nil {
+				return "", err
+			}
+			c.Close()
+			return c.Tree, nil
+		default:
+			return "", fmt.Errorf("%s is a %s, not a %s", hash, objectType, want)
+		}
+	}
+}
+
+func parentOf(hash string, n int) (string, error) {
+	c, err := ReadCommit(hash)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	if n == 0 {
+		return hash, nil
 	}
-	if committish == "HEAD" {
-		h, err := readFile(".git/HEAD")
+	if n > len(c.Parent) {
+		return "", fmt.Errorf("%s has no parent %d", hash, n)
+	}
+	return c.Parent[n-1], nil
+}
+
+// ResolveRevision turns a revision such as "HEAD", "master~2", "v1.0^{tree}",
+// "HEAD:src/main.go" or an abbreviated object name into a full object name.
+func ResolveRevision(rev string) (string, error) {
+	if colon := indexOutsideBraces(rev, ":"); colon > 0 {
+		tree, err := ResolveRevision(rev[:colon] + "^{tree}")
 		if err != nil {
-			panic(err)
-		}
-		if bytes.HasPrefix(h, []byte(refPrefix)) {
-			ref := h[len(refPrefix):]
-			if bytes.HasPrefix(ref, []byte("refs/heads/")) {
-				ref = ref[len("refs/heads/"):]
-				branches, err := ReadBranches()
-				if err != nil {
-					panic(err)
-				}
-				for _, b := range branches {
-					if bytes.HasPrefix(ref, []byte(b.Name)) {
-						return b.Hash
-					}
-				}
-				panic(fmt.Sprintf("unknown ref %s", string(ref)))
-			} else {
-				panic(fmt.Sprintf("no idea how to handle ref %s", string(ref)))
+			return "", err
+		}
+		if rev[colon+1:] == "" {
+			return tree, nil
+		}
+		_, hash, err := LookupPath(tree, rev[colon+1:])
+		if err != nil {
+			return "", err
+		}
+		if hash == "" {
+			return "", fmt.Errorf("path '%s' does not exist in '%s'", rev[colon+1:], rev[:colon])
+		}
+		return hash, nil
+	}
+	end := indexOutsideBraces(rev, "~^")
+	if end == -1 {
+		end = len(rev)
+	}
+	hash, err := resolveBase(rev[:end])
+	if err != nil {
+		return "", err
+	}
+	for ops := rev[end:]; len(ops) > 0; {
+		op := ops[0]
+		ops = ops[1:]
+		if op == '^' && strings.HasPrefix(ops, "{") {
+			brace := strings.IndexByte(ops, '}')
+			if brace == -1 {
+				return "", fmt.Errorf("bad revision %s", rev)
 			}
+			hash, err = peel(hash, ops[1:brace])
+			if err != nil {
+				return "", err
+			}
+			ops = ops[brace+1:]
+			continue
+		}
+		digits := 0
+		for digits < len(ops) && ops[digits] >= '0' && ops[digits] <= '9' {
+			digits++
+		}
+		n := 1
+		if digits > 0 {
+			n, _ = strconv.Atoi(ops[:digits])
+			ops = ops[digits:]
+		}
+		if hash, err = peel(hash, "commit"); err != nil {
+			return "", err
+		}
+		if op == '^' {
+			hash, err = parentOf(hash, n)
+		} else {
+			for i := 0; i < n && err == nil; i++ {
+				hash, err = parentOf(hash, 1)
+			}
+		}
+		if err != nil {
+			return "", err
 		}
-		if len(h) < sha1.Size*2 {
-			panic(fmt.Sprintf(".git/HEAD too short: %d", len(h)))
+	}
+	return hash, nil
+}
+
+// CommitishToHash resolves committish with ResolveRevision. Names that can't
+// be resolved are returned unchanged so that looking them up reports the
+// error.
+func CommitishToHash(committish string) string {
+	hash, err := ResolveRevision(committish)
+	if err != nil {
+		return committish
+	}
+	return hash
+}
+
+func validRefName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") ||
+		strings.HasSuffix(name, ".lock") || strings.HasSuffix(name, ".") {
+		return false
+	}
+	for _, bad := range []string{"..", "//", "@{", "\\"} {
+		if strings.Contains(name, bad) {
+			return false
 		}
-		return string(h[:sha1.Size*2])
 	}
+	for _, c := range name {
+		if c < ' ' || c == 0x7f || strings.ContainsRune(" ~^:?*[", c) {
+			return false
+		}
+	}
+	return !strings.HasPrefix(name, "-") && !strings.Contains(name, "/.") && !strings.HasPrefix(name, ".")
+}
 
-	return ""
+// ValidBranchName reports whether name can be used for a new branch.
+func ValidBranchName(name string) bool {
+	return name != "HEAD" && !strings.HasPrefix(name, "-") && validRefName("refs/heads/"+name)
 }
@@ -0,0 +1,60 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestResolveRevision(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	blob, _ := WriteObject("blob", []byte("hello\n"))
+	idx := &Index{Version: 2}
+	e := Entry{Mode: 0100644, Path: []byte("dir/hello")}
+	h, _ := hex.DecodeString(blob)
+	copy(e.Hash[:], h)
+	idx.Add(e)
+	tree, err := WriteTree(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := CommitTree(tree, nil, who, who, "first\n")
+	second, _ := CommitTree(tree, []string{first}, who, who, "second\n")
+	third, _ := CommitTree(tree, []string{second}, who, who, "third\n")
+	if err := UpdateRef("refs/heads/master", third, "", who, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct{ rev, want string }{
+		{"HEAD", third},
+		{"master", third},
+		{"refs/heads/master", third},
+		{"@", third},
+		{"master^", second},
+		{"HEAD~2", first},
+		{"master^^", first},
+		{"master~1^1", first},
+		{"HEAD^0", third},
+		{third[:7], third},
+		{"master^{tree}", tree},
+		{"HEAD:dir/hello", blob},
+		{"HEAD~1:", tree},
+	} {
+		got, err := ResolveRevision(c.rev)
+		if err != nil || got != c.want {
+			t.Errorf("ResolveRevision(%q) = %s, %v, want %s", c.rev, got, err, c.want)
+		}
+	}
+	for _, rev := range []string{"nope", "HEAD~3", "HEAD:missing", "master^{blob}", "a..b"} {
+		if got, err := ResolveRevision(rev); err == nil {
+			t.Errorf("ResolveRevision(%q) = %s, want an error", rev, got)
+		}
+	}
+}
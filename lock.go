@@ -0,0 +1,56 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockFile guards an update to a file the way git does: the new contents are
+// written to "<path>.lock", which is created exclusively so that a concurrent
+// writer fails, and then renamed over path.
+type lockFile struct {
+	path string
+	f    *os.File
+}
+
+func lock(path string) (*lockFile, error) {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("unable to create '%s.lock': File exists; another ggit process seems to be running", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lockFile{path: path, f: f}, nil
+}
+
+func (l *lockFile) Write(b []byte) (int, error) {
+	return l.f.Write(b)
+}
+
+// commit replaces path with the contents written to the lock file.
+func (l *lockFile) commit() error {
+	f := l.f
+	l.f = nil
+	if err := f.Close(); err != nil {
+		_ = os.Remove(l.path + ".lock")
+		return err
+	}
+	return os.Rename(l.path+".lock", l.path)
+}
+
+// rollback abandons the update. It is a no-op after commit, so it is safe to
+// defer.
+func (l *lockFile) rollback() {
+	if l.f == nil {
+		return
+	}
+	_ = l.f.Close()
+	_ = os.Remove(l.path + ".lock")
+	l.f = nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+// Flags paintDownToCommon leaves on the commits it walks.
+const (
+	paintParent1 = 1 << iota // reachable from one
+	paintParent2             // reachable from one of the others
+	paintStale               // reachable from a common ancestor already found
+	paintResult              // a common ancestor found
+)
+
+// generationQueue is a queue of commits that pops the highest generation
+// first, then the newest. Commits that tie are popped in the order they
+// were pushed.
+type generationQueue []CommitInfo // in reverse order
+
+func (q *generationQueue) push(c CommitInfo) {
+	i := len(*q)
+	for i > 0 && !poppedAfter((*q)[i-1], c) {
+		i--
+	}
+	*q = append(*q, CommitInfo{})
+	copy((*q)[i+1:], (*q)[i:])
+	(*q)[i] = c
+}
+
+func (q *generationQueue) pop() CommitInfo {
+	c := (*q)[len(*q)-1]
+	*q = (*q)[:len(*q)-1]
+	return c
+}
+
+// poppedAfter reports whether a has a lower generation than b, or the same
+// generation and an older date.
+func poppedAfter(a, b CommitInfo) bool {
+	if a.Generation != b.Generation {
+		return a.Generation < b.Generation
+	}
+	return a.Date.Before(b.Date)
+}
+
+// paintDownToCommon walks back from one and twos, flagging what each
+// reaches, and returns the commits both reach that no other such commit
+// reaches, newest first. Commits with generations below minGeneration are
+// not walked, which with a commit-graph can stop the walk early.
+func paintDownToCommon(one string, twos []string, minGeneration uint64) ([]string, map[string]uint, error) {
+	flags := make(map[string]uint)
+	queue := generationQueue(nil)
+	add := func(hash string, f uint) error {
+		c, err := LookupCommitInfo(hash)
+		if err != nil {
+			return err
+		}
+		flags[hash] |= f
+		queue.push(c)
+		return nil
+	}
+	if err := add(one, paintParent1); err != nil {
+		return nil, nil, err
+	}
+	for _, two := range twos {
+		if err := add(two, paintParent2); err != nil {
+			return nil, nil, err
+		}
+	}
+	result := []string(nil)
+	for len(queue) > 0 {
+		stale := true
+		for _, c := range queue {
+			stale = stale && flags[c.Hash]&paintStale != 0
+		}
+		if stale {
+			break
+		}
+		c := queue.pop()
+		if c.Generation < minGeneration {
+			break
+		}
+		f := flags[c.Hash] & (paintParent1 | paintParent2 | paintStale)
+		if f == paintParent1|paintParent2 {
+			if flags[c.Hash]&paintResult == 0 {
+				flags[c.Hash] |= paintResult
+				result = append(result, c.Hash)
+			}
+			f |= paintStale
+		}
+		for _, p := range c.Parents {
+			if flags[p]&f == f {
+				continue
+			}
+			if err := add(p, f); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return result, flags, nil
+}
+
+// IsAncestor reports whether the commit ancestor is reachable from the
+// commit descendant, or is it.
+func IsAncestor(ancestor, descendant string) (bool, error) {
+	if ancestor == descendant {
+		return true, nil
+	}
+	a, err := LookupCommitInfo(ancestor)
+	if err != nil {
+		return false, err
+	}
+	d, err := LookupCommitInfo(descendant)
+	if err != nil {
+		return false, err
+	}
+	if a.Generation != generationInfinity && a.Generation > d.Generation {
+		return false, nil
+	}
+	minGeneration := a.Generation
+	if minGeneration == generationInfinity {
+		minGeneration = 0
+	}
+	_, flags, err := paintDownToCommon(ancestor, []string{descendant}, minGeneration)
+	if err != nil {
+		return false, err
+	}
+	return flags[ancestor]&paintParent2 != 0, nil
+}
+
+// MergeBases returns the best common ancestors of the commit one and a
+// merge of the commits in others, as git merge-base --all does: common
+// ancestors that aren't ancestors of another, newest first.
+func MergeBases(one string, others ...string) ([]string, error) {
+	for _, two := range others {
+		if one == two {
+			return []string{one}, nil
+		}
+	}
+	bases, _, err := paintDownToCommon(one, others, 0)
+	if err != nil || len(bases) <= 1 {
+		return bases, err
+	}
+	redundant := make([]bool, len(bases))
+	for i := range bases {
+		for j := range bases {
+			if i == j || redundant[j] {
+				continue
+			}
+			if ok, err := IsAncestor(bases[i], bases[j]); err != nil {
+				return nil, err
+			} else if ok {
+				redundant[i] = true
+				break
+			}
+		}
+	}
+	best := []string(nil)
+	for i, hash := range bases {
+		if !redundant[i] {
+			best = append(best, hash)
+		}
+	}
+	return best, nil
+}
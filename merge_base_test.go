@@ -0,0 +1,71 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMergeBases(t *testing.T) {
+	defer withTempRepo(t)()
+
+	tree := makeTree(t, map[string]string{"a": "a\n"})
+	commitAt := func(when int64, parents ...string) string {
+		t.Helper()
+		who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(when, 0).UTC()}
+		hash, err := CommitTree(tree, parents, who, who, "commit\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	// A criss-cross merge: x and y each merge b and c.
+	root := commitAt(1000000000)
+	b := commitAt(1000000100, root)
+	c := commitAt(1000000200, root)
+	x := commitAt(1000000300, b, c)
+	y := commitAt(1000000400, c, b)
+	side := commitAt(1000000500, b)
+	who := Signature{Name: "A U Thor", Email: "author@example.com"}
+	for ref, hash := range map[string]string{"refs/heads/x": x, "refs/heads/y": y, "refs/heads/side": side} {
+		if err := UpdateRef(ref, hash, "", who, "commit"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(graph string) {
+		t.Helper()
+		for _, test := range []struct {
+			one, two string
+			want     []string
+		}{
+			{x, y, []string{c, b}},
+			{x, side, []string{b}},
+			{c, side, []string{root}},
+			{root, x, []string{root}},
+			{x, x, []string{x}},
+		} {
+			if got, err := MergeBases(test.one, test.two); err != nil || !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s: MergeBases(%s, %s) = %v, %v; want %v", graph, test.one, test.two, got, err, test.want)
+			}
+		}
+		for _, test := range []struct {
+			ancestor, descendant string
+			want                 bool
+		}{{root, x, true}, {c, y, true}, {x, root, false}, {c, side, false}, {side, side, true}} {
+			if got, err := IsAncestor(test.ancestor, test.descendant); err != nil || got != test.want {
+				t.Errorf("%s: IsAncestor(%s, %s) = %v, %v", graph, test.ancestor, test.descendant, got, err)
+			}
+		}
+	}
+	check("without a commit-graph")
+	if err := WriteCommitGraph(CommitGraphOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	check("with a commit-graph")
+}
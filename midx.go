@@ -0,0 +1,633 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const midxPath = ".git/objects/pack/multi-pack-index"
+
+// midxLargeOffset marks an offset in the OOFF chunk that is really an index
+// into the LOFF chunk.
+const midxLargeOffset = 1 << 31
+
+// multiPackIndex is a parsed multi-pack-index file, which maps object names
+// to packs and offsets across several packs at once.
+type multiPackIndex struct {
+	data         []byte
+	packNames    []string // the .idx names of the packs, sorted
+	fanOut       []byte
+	oids         []byte
+	offsets      []byte // pairs of pack number and offset
+	largeOffsets []byte
+	revIndex     []byte // the position of each object in pack order, if there is a RIDX chunk
+	numObjects   int
+	packs        []*pack // the packs named, once loadPacks has matched them up
+}
+
+// parseMultiPackIndex parses the chunks of a multi-pack-index file.
+func parseMultiPackIndex(data []byte) (*multiPackIndex, error) {
+	if len(data) < 12+sha1.Size || string(data[:4]) != "MIDX" {
+		return nil, errors.New("multi-pack-index signature mismatch")
+	}
+	if data[4] != 1 {
+		return nil, fmt.Errorf("multi-pack-index version %d not recognized", data[4])
+	}
+	if data[5] != 1 {
+		return nil, fmt.Errorf("multi-pack-index hash version %d does not match", data[5])
+	}
+	numChunks, numPacks := int(data[6]), int(binary.BigEndian.Uint32(data[8:]))
+	m := &multiPackIndex{data: data}
+	chunks := make(map[string][]byte)
+	table := data[12:]
+	if len(table) < (numChunks+1)*12 {
+		return nil, errors.New("multi-pack-index chunk table is truncated")
+	}
+	for i := 0; i < numChunks; i++ {
+		id := string(table[i*12 : i*12+4])
+		start := binary.BigEndian.Uint64(table[i*12+4:])
+		end := binary.BigEndian.Uint64(table[i*12+16:])
+		if start > end || end > uint64(len(data)-sha1.Size) {
+			return nil, fmt.Errorf("multi-pack-index chunk %s is out of bounds", id)
+		}
+		chunks[id] = data[start:end]
+	}
+	for _, id := range []string{"PNAM", "OIDF", "OIDL", "OOFF"} {
+		if chunks[id] == nil {
+			return nil, fmt.Errorf("multi-pack-index required %s chunk missing or corrupted", id)
+		}
+	}
+	names := strings.Split(strings.TrimRight(string(chunks["PNAM"]), "\x00"), "\x00")
+	if len(names) != numPacks {
+		return nil, fmt.Errorf("multi-pack-index names %d packs, not %d", len(names), numPacks)
+	}
+	m.packNames = names
+	m.fanOut = chunks["OIDF"]
+	if len(m.fanOut) != 256*4 {
+		return nil, errors.New("multi-pack-index OID fanout is of the wrong size")
+	}
+	m.numObjects = int(binary.BigEndian.Uint32(m.fanOut[255*4:]))
+	m.oids, m.offsets, m.largeOffsets = chunks["OIDL"], chunks["OOFF"], chunks["LOFF"]
+	if len(m.oids) != m.numObjects*sha1.Size || len(m.offsets) != m.numObjects*8 {
+		return nil, errors.New("multi-pack-index OID lookup chunk is the wrong size")
+	}
+	for i := 0; i < m.numObjects; i++ {
+		packNum := binary.BigEndian.Uint32(m.offsets[i*8:])
+		offset := binary.BigEndian.Uint32(m.offsets[i*8+4:])
+		if int(packNum) >= numPacks {
+			return nil, fmt.Errorf("multi-pack-index has bad pack number %d", packNum)
+		}
+		if offset&midxLargeOffset != 0 && int(offset&^midxLargeOffset) >= len(m.largeOffsets)/8 {
+			return nil, errors.New("multi-pack-index large offset out of bounds")
+		}
+	}
+	if rev := chunks["RIDX"]; len(rev) == m.numObjects*4 {
+		m.revIndex = rev
+	}
+	return m, nil
+}
+
+func (m *multiPackIndex) hash(i int) []byte {
+	return m.oids[i*sha1.Size : (i+1)*sha1.Size]
+}
+
+// location returns the number of the pack holding object i and its offset
+// there.
+func (m *multiPackIndex) location(i int) (int, uint64) {
+	packNum := int(binary.BigEndian.Uint32(m.offsets[i*8:]))
+	offset := binary.BigEndian.Uint32(m.offsets[i*8+4:])
+	if offset&midxLargeOffset != 0 {
+		k := int(offset &^ midxLargeOffset)
+		return packNum, binary.BigEndian.Uint64(m.largeOffsets[k*8:])
+	}
+	return packNum, uint64(offset)
+}
+
+// packOffset returns the pack holding object i and its offset there.
+func (m *multiPackIndex) packOffset(i int) (*pack, uint64, error) {
+	packNum, offset := m.location(i)
+	if packNum >= len(m.packs) {
+		return nil, 0, fmt.Errorf("object %x is in %s, which isn't loaded", m.hash(i), m.packNames[packNum])
+	}
+	return m.packs[packNum], offset, nil
+}
+
+// fanOutAt returns the number of objects whose first byte is at most b.
+func (m *multiPackIndex) fanOutAt(b int) int {
+	return int(binary.BigEndian.Uint32(m.fanOut[b*4:]))
+}
+
+// find returns the position of hash in the multi-pack-index, or -1.
+func (m *multiPackIndex) find(hash []byte) int {
+	if len(hash) > sha1.Size {
+		hash = hash[:sha1.Size]
+	}
+	lo := 0
+	if hash[0] > 0 {
+		lo = int(binary.BigEndian.Uint32(m.fanOut[(int(hash[0])-1)*4:]))
+	}
+	hi := int(binary.BigEndian.Uint32(m.fanOut[int(hash[0])*4:]))
+	i := sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(hash, m.hash(i + lo)[:len(hash)]) <= 0
+	}) + lo
+	if i == hi || !bytes.Equal(hash, m.hash(i)[:len(hash)]) {
+		return -1
+	}
+	return i
+}
+
+// Close unmaps the file.
+func (m *multiPackIndex) Close() {
+	_ = syscall.Munmap(m.data)
+}
+
+// midxPack is a pack a multi-pack-index is being written for.
+type midxPack struct {
+	name    string // of the .idx
+	entries []PackEntry
+	mtime   int64
+}
+
+// readMIDXPacks reads the indices of the packs in .git/objects/pack, sorted
+// by name, leaving out those named in skip.
+func readMIDXPacks(skip map[string]bool) ([]midxPack, error) {
+	names, err := filepath.Glob(".git/objects/pack/pack-*.idx")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	packs := []midxPack(nil)
+	for _, path := range names {
+		name := filepath.Base(path)
+		if skip[name] {
+			continue
+		}
+		fi, err := os.Stat(strings.TrimSuffix(path, ".idx") + ".pack")
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := ReadPackIndex(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		packs = append(packs, midxPack{name, idx.Entries, fi.ModTime().UnixNano()})
+	}
+	return packs, nil
+}
+
+// writeMultiPackIndex writes a multi-pack-index for packs. Where several
+// packs have an object, the preferred one is chosen, then the newest, then
+// the first. With bitmap set it also writes a reachability bitmap, for
+// which, as git does, it prefers the oldest pack if none is named.
+func writeMultiPackIndex(packs []midxPack, preferred string, bitmap bool) error {
+	if bitmap && preferred == "" {
+		oldest := -1
+		for i, p := range packs {
+			if len(p.entries) > 0 && (oldest == -1 || p.mtime/1e9 < packs[oldest].mtime/1e9) {
+				oldest = i
+			}
+		}
+		if oldest != -1 {
+			preferred = packs[oldest].name
+		}
+	}
+	type midxEntry struct {
+		hash      [sha1.Size]byte
+		packNum   int
+		offset    uint64
+		preferred bool
+		mtime     int64
+	}
+	entries := []midxEntry(nil)
+	for i, p := range packs {
+		isPreferred := preferred != "" && strings.TrimSuffix(p.name, ".idx") == strings.TrimSuffix(strings.TrimSuffix(preferred, ".pack"), ".idx")
+		for _, e := range p.entries {
+			entries = append(entries, midxEntry{e.Hash, i, e.Offset, isPreferred, p.mtime})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := &entries[i], &entries[j]
+		if c := bytes.Compare(a.hash[:], b.hash[:]); c != 0 {
+			return c < 0
+		}
+		if a.preferred != b.preferred {
+			return a.preferred
+		}
+		if a.mtime != b.mtime {
+			return a.mtime > b.mtime
+		}
+		return a.packNum < b.packNum
+	})
+	unique := entries[:0]
+	for _, e := range entries {
+		if len(unique) == 0 || unique[len(unique)-1].hash != e.hash {
+			unique = append(unique, e)
+		}
+	}
+	entries = unique
+
+	word := make([]byte, 8)
+	names := []byte(nil)
+	for _, p := range packs {
+		names = append(append(names, p.name...), 0)
+	}
+	for len(names)%4 != 0 {
+		names = append(names, 0)
+	}
+	fanOut := make([]byte, 256*4)
+	n := 0
+	for i := 0; i < 256; i++ {
+		for n < len(entries) && int(entries[n].hash[0]) <= i {
+			n++
+		}
+		binary.BigEndian.PutUint32(fanOut[i*4:], uint32(n))
+	}
+	oids := make([]byte, 0, len(entries)*sha1.Size)
+	for _, e := range entries {
+		oids = append(oids, e.hash[:]...)
+	}
+	needLarge := false
+	for _, e := range entries {
+		needLarge = needLarge || e.offset > 0xffffffff
+	}
+	offsets, large := make([]byte, len(entries)*8), []byte(nil)
+	for i, e := range entries {
+		binary.BigEndian.PutUint32(offsets[i*8:], uint32(e.packNum))
+		if needLarge && e.offset >= midxLargeOffset {
+			binary.BigEndian.PutUint32(offsets[i*8+4:], midxLargeOffset|uint32(len(large)/8))
+			binary.BigEndian.PutUint64(word, e.offset)
+			large = append(large, word...)
+		} else {
+			binary.BigEndian.PutUint32(offsets[i*8+4:], uint32(e.offset))
+		}
+	}
+
+	type chunk struct {
+		id   string
+		data []byte
+	}
+	chunks := []chunk{{"PNAM", names}, {"OIDF", fanOut}, {"OIDL", oids}, {"OOFF", offsets}}
+	if large != nil {
+		chunks = append(chunks, chunk{"LOFF", large})
+	}
+	if bitmap {
+		// The order of the objects in the packs, the preferred pack first,
+		// which the bitmap's bits follow.
+		order := make([]int, len(entries))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(i, j int) bool {
+			a, b := &entries[order[i]], &entries[order[j]]
+			if a.preferred != b.preferred {
+				return a.preferred
+			}
+			if a.packNum != b.packNum {
+				return a.packNum < b.packNum
+			}
+			return a.offset < b.offset
+		})
+		rev := make([]byte, len(order)*4)
+		for i, pos := range order {
+			binary.BigEndian.PutUint32(rev[i*4:], uint32(pos))
+		}
+		chunks = append(chunks, chunk{"RIDX", rev})
+	}
+	b := bytes.NewBuffer(nil)
+	b.WriteString("MIDX")
+	b.Write([]byte{1, 1, byte(len(chunks)), 0})
+	binary.BigEndian.PutUint32(word, uint32(len(packs)))
+	b.Write(word[:4])
+	offset := uint64(12 + (len(chunks)+1)*12)
+	for _, c := range chunks {
+		b.WriteString(c.id)
+		binary.BigEndian.PutUint64(word, offset)
+		b.Write(word)
+		offset += uint64(len(c.data))
+	}
+	b.Write([]byte{0, 0, 0, 0})
+	binary.BigEndian.PutUint64(word, offset)
+	b.Write(word)
+	for _, c := range chunks {
+		b.Write(c.data)
+	}
+	sum := sha1.Sum(b.Bytes())
+	b.Write(sum[:])
+
+	l, err := lock(midxPath)
+	if err != nil {
+		return err
+	}
+	defer l.rollback()
+	if _, err := l.Write(b.Bytes()); err != nil {
+		return err
+	}
+	if err := l.commit(); err != nil {
+		return err
+	}
+	closePacks()
+	if err := removeMIDXFiles(sum[:]); err != nil {
+		return err
+	}
+	if bitmap {
+		return writeMIDXBitmap()
+	}
+	return nil
+}
+
+// removeMIDXFiles deletes the bitmaps and reverse indexes of any
+// multi-pack-index but the one with the checksum keep, which may be nil.
+func removeMIDXFiles(keep []byte) error {
+	for _, ext := range []string{".bitmap", ".rev"} {
+		names, err := filepath.Glob(midxPath + "-*" + ext)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if keep != nil && name == midxFilesPath(keep)+ext {
+				continue
+			}
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MultiPackIndexOptions controls WriteMultiPackIndex.
+type MultiPackIndexOptions struct {
+	// Preferred names the .pack or .idx file of the pack whose objects win
+	// over copies in other packs.
+	Preferred string
+	// Bitmap writes a reachability bitmap for the commits the refs reach,
+	// which must all be in the packs.
+	Bitmap bool
+}
+
+// WriteMultiPackIndex writes a multi-pack-index covering every pack in
+// .git/objects/pack.
+func WriteMultiPackIndex(opts MultiPackIndexOptions) error {
+	packs, err := readMIDXPacks(nil)
+	if err != nil {
+		return err
+	}
+	preferred := opts.Preferred
+	if preferred != "" {
+		found := false
+		for _, p := range packs {
+			found = found || strings.TrimSuffix(p.name, ".idx") == strings.TrimSuffix(strings.TrimSuffix(preferred, ".pack"), ".idx")
+		}
+		if !found {
+			return fmt.Errorf("unknown preferred pack: '%s'", preferred)
+		}
+	}
+	return writeMultiPackIndex(packs, preferred, opts.Bitmap)
+}
+
+// loadMultiPackIndex maps the multi-pack-index for lookups once loadPacks
+// has found the packs. A multi-pack-index naming a pack that is gone is
+// ignored.
+func loadMultiPackIndex() error {
+	if _, err := os.Stat(midxPath); os.IsNotExist(err) {
+		return nil
+	}
+	data, err := mmapFile(midxPath)
+	if err != nil {
+		return err
+	}
+	m, err := parseMultiPackIndex(data)
+	if err != nil {
+		_ = syscall.Munmap(data)
+		return err
+	}
+	byName := make(map[string]*pack, len(parsedPackFiles))
+	for _, p := range parsedPackFiles {
+		byName[p.baseFileName+".idx"] = p
+	}
+	for _, name := range m.packNames {
+		p := byName[name]
+		if p == nil {
+			m.Close()
+			return nil
+		}
+		m.packs = append(m.packs, p)
+	}
+	for _, p := range m.packs {
+		p.inMIDX = true
+	}
+	parsedMIDX = m
+	return nil
+}
+
+// readMultiPackIndex reads and parses the multi-pack-index, returning nil if
+// there isn't one.
+func readMultiPackIndex() (*multiPackIndex, error) {
+	data, err := ioutil.ReadFile(midxPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseMultiPackIndex(data)
+}
+
+// VerifyMultiPackIndex checks the multi-pack-index: its checksum, the order
+// of its object names and that each object is where it says in its pack.
+func VerifyMultiPackIndex() error {
+	m, err := readMultiPackIndex()
+	if err != nil || m == nil {
+		return err
+	}
+	if sum := sha1.Sum(m.data[:len(m.data)-sha1.Size]); !bytes.Equal(sum[:], m.data[len(m.data)-sha1.Size:]) {
+		return errors.New("incorrect checksum")
+	}
+	for i := 1; i < 256; i++ {
+		prev, cur := binary.BigEndian.Uint32(m.fanOut[(i-1)*4:]), binary.BigEndian.Uint32(m.fanOut[i*4:])
+		if prev > cur {
+			return fmt.Errorf("oid fanout out of order: fanout[%d] = %x > %x = fanout[%d]", i-1, prev, cur, i)
+		}
+	}
+	for i := 1; i < m.numObjects; i++ {
+		if bytes.Compare(m.hash(i-1), m.hash(i)) >= 0 {
+			return fmt.Errorf("oid lookup out of order: oid[%d] = %x >= %x = oid[%d]", i-1, m.hash(i-1), m.hash(i), i)
+		}
+	}
+	packs := make([]map[[sha1.Size]byte]uint64, len(m.packNames))
+	for i, name := range m.packNames {
+		data, err := ioutil.ReadFile(".git/objects/pack/" + name)
+		if err != nil {
+			return fmt.Errorf("failed to load pack in position %d", i)
+		}
+		idx, err := ReadPackIndex(data)
+		if err != nil {
+			return fmt.Errorf("failed to load pack in position %d", i)
+		}
+		packs[i] = make(map[[sha1.Size]byte]uint64, len(idx.Entries))
+		for _, e := range idx.Entries {
+			packs[i][e.Hash] = e.Offset
+		}
+	}
+	for i := 0; i < m.numObjects; i++ {
+		packNum, offset := m.location(i)
+		if packNum >= len(packs) {
+			return fmt.Errorf("bad pack-int-id: %d (%d total packs)", packNum, len(packs))
+		}
+		var hash [sha1.Size]byte
+		copy(hash[:], m.hash(i))
+		if want, ok := packs[packNum][hash]; !ok || want != offset {
+			return fmt.Errorf("incorrect object offset for oid[%d] = %x: %x != %x", i, hash, offset, want)
+		}
+	}
+	return nil
+}
+
+// use counts the objects the multi-pack-index takes from each of its
+// packs.
+func (m *multiPackIndex) use() []int {
+	counts := make([]int, len(m.packNames))
+	for i := 0; i < m.numObjects; i++ {
+		packNum, _ := m.location(i)
+		if packNum < len(counts) {
+			counts[packNum]++
+		}
+	}
+	return counts
+}
+
+// isKept reports whether the pack with the given .idx name has a .keep file.
+func isKept(idxName string) bool {
+	_, err := os.Stat(".git/objects/pack/" + strings.TrimSuffix(idxName, ".idx") + ".keep")
+	return err == nil
+}
+
+// ExpireMultiPackIndex deletes the packs that the multi-pack-index takes no
+// objects from, unless they have a .keep file, and rewrites it without
+// them. It returns the names of the deleted packs.
+func ExpireMultiPackIndex() ([]string, error) {
+	m, err := readMultiPackIndex()
+	if err != nil || m == nil {
+		return nil, err
+	}
+	expired := []string(nil)
+	skip := make(map[string]bool)
+	for i, n := range m.use() {
+		if n == 0 && !isKept(m.packNames[i]) {
+			expired = append(expired, m.packNames[i])
+			skip[m.packNames[i]] = true
+		}
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+	packs, err := readMIDXPacks(skip)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMultiPackIndex(packs, "", false); err != nil {
+		return nil, err
+	}
+	for _, name := range expired {
+		if err := removePackFiles(".git/objects/pack/" + strings.TrimSuffix(name, ".idx")); err != nil {
+			return nil, err
+		}
+	}
+	return expired, nil
+}
+
+// RepackMultiPackIndex gathers the objects the multi-pack-index takes from
+// its smaller packs into a new pack, and adds that to the multi-pack-index.
+// Starting with the oldest, it picks packs whose share of objects in use
+// would take up less than batchSize bytes until together they reach
+// batchSize; a batchSize of 0 picks every pack. Nothing happens unless at
+// least two packs are picked. It returns the name of the new pack, if any.
+// ExpireMultiPackIndex can then delete the packs that were picked.
+func RepackMultiPackIndex(batchSize int64, opts PackOptions) (string, error) {
+	m, err := readMultiPackIndex()
+	if err != nil || m == nil {
+		return "", err
+	}
+	use := m.use()
+	type candidate struct {
+		packNum      int
+		mtime        int64
+		expectedSize int64
+	}
+	candidates := []candidate(nil)
+	for i, name := range m.packNames {
+		if isKept(name) {
+			continue
+		}
+		fi, err := os.Stat(".git/objects/pack/" + strings.TrimSuffix(name, ".idx") + ".pack")
+		if err != nil {
+			return "", err
+		}
+		data, err := ioutil.ReadFile(".git/objects/pack/" + name)
+		if err != nil {
+			return "", err
+		}
+		total := int(binary.BigEndian.Uint32(data[8+255*4:]))
+		expected := int64(0)
+		if total > 0 {
+			expected = fi.Size() * int64(use[i]) / int64(total)
+		}
+		candidates = append(candidates, candidate{i, fi.ModTime().UnixNano(), expected})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].mtime < candidates[j].mtime })
+	picked := make(map[int]bool)
+	if batchSize == 0 {
+		for _, c := range candidates {
+			picked[c.packNum] = true
+		}
+	} else {
+		total := int64(0)
+		for _, c := range candidates {
+			if c.expectedSize >= batchSize {
+				continue
+			}
+			picked[c.packNum] = true
+			if total += c.expectedSize; total >= batchSize {
+				break
+			}
+		}
+		if total < batchSize {
+			return "", nil
+		}
+	}
+	if len(picked) < 2 {
+		return "", nil
+	}
+
+	objects := []ListedObject(nil)
+	for i := 0; i < m.numObjects; i++ {
+		if packNum, _ := m.location(i); picked[packNum] {
+			objects = append(objects, ListedObject{Hash: fmt.Sprintf("%x", m.hash(i))})
+		}
+	}
+	name, err := WritePackFiles(".git/objects/pack/pack", objects, opts)
+	if err != nil {
+		return "", err
+	}
+	return name, WriteMultiPackIndex(MultiPackIndexOptions{})
+}
@@ -0,0 +1,139 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMultiPackIndex(t *testing.T) {
+	defer withTempRepo(t)()
+
+	a, _ := WriteObject("blob", []byte("a\n"))
+	b, _ := WriteObject("blob", []byte("b\n"))
+	c, _ := WriteObject("blob", []byte("c\n"))
+	first, err := WritePackFiles(".git/objects/pack/pack", []ListedObject{{Hash: a}, {Hash: b}}, PackOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := WritePackFiles(".git/objects/pack/pack", []ListedObject{{Hash: b}, {Hash: c}}, PackOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loose, _ := filepath.Glob(".git/objects/??")
+	for _, dir := range loose {
+		os.RemoveAll(dir)
+	}
+
+	if err := WriteMultiPackIndex(MultiPackIndexOptions{Preferred: "pack-" + second + ".pack"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMultiPackIndex(); err != nil {
+		t.Errorf("VerifyMultiPackIndex: %v", err)
+	}
+	closePacks()
+	if err := loadPacks(); err != nil {
+		t.Fatal(err)
+	}
+	if parsedMIDX == nil || parsedMIDX.numObjects != 3 {
+		t.Fatalf("multi-pack-index not loaded: %+v", parsedMIDX)
+	}
+	for _, p := range parsedPackFiles {
+		if !p.inMIDX {
+			t.Errorf("pack %s not covered by the multi-pack-index", p.baseFileName)
+		}
+	}
+	packNum, _ := parsedMIDX.location(parsedMIDX.find(hashToBytes(b)))
+	if got := parsedMIDX.packs[packNum].baseFileName; got != "pack-"+second {
+		t.Errorf("b taken from %s, want the preferred pack-%s", got, second)
+	}
+	for hash, want := range map[string]string{a: "a\n", b: "b\n", c: "c\n"} {
+		if _, data, err := readObject(hash); err != nil || string(data) != want {
+			t.Errorf("readObject(%s) = %q, %v; want %q", hash, data, err, want)
+		}
+	}
+	if found, err := findPackedPrefix(c[:6]); err != nil || !reflect.DeepEqual(found, []string{c}) {
+		t.Errorf("findPackedPrefix(%s) = %v, %v", c[:6], found, err)
+	}
+	// Reading through the multi-pack-index leaves the packs' own indices
+	// alone.
+	for _, p := range parsedPackFiles {
+		if p.idx.data != nil {
+			t.Errorf("index of %s read although the multi-pack-index covers it", p.baseFileName)
+		}
+	}
+
+	// With a new pack holding everything, the old ones expire once the
+	// multi-pack-index takes nothing from them.
+	if _, err := RepackMultiPackIndex(0, PackOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expired, err := ExpireMultiPackIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"pack-" + first + ".idx", "pack-" + second + ".idx"}
+	if first > second {
+		want[0], want[1] = want[1], want[0]
+	}
+	if !reflect.DeepEqual(expired, want) {
+		t.Errorf("expired %v, want %v", expired, want)
+	}
+	if err := VerifyMultiPackIndex(); err != nil {
+		t.Errorf("VerifyMultiPackIndex after expire: %v", err)
+	}
+	for _, hash := range []string{a, b, c} {
+		if !objectExists(hash) {
+			t.Errorf("%s lost by expire", hash)
+		}
+	}
+}
+
+func TestMultiPackIndexLargeOffset(t *testing.T) {
+	defer withTempRepo(t)()
+
+	a, _ := WriteObject("blob", []byte("a\n"))
+	if _, err := WritePackFiles(".git/objects/pack/pack", []ListedObject{{Hash: a}}, PackOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(".git/objects/" + a[:2])
+	if err := WriteMultiPackIndex(MultiPackIndexOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	closePacks()
+	if err := loadPacks(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Move the object's offset into the LOFF chunk, where it would be were
+	// the pack past 4GiB.
+	m := parsedMIDX
+	i := m.find(hashToBytes(a))
+	_, offset := m.location(i)
+	m.offsets = append([]byte(nil), m.offsets...)
+	binary.BigEndian.PutUint32(m.offsets[i*8+4:], midxLargeOffset)
+	m.largeOffsets = make([]byte, 8)
+	binary.BigEndian.PutUint64(m.largeOffsets, offset)
+	if p, got, err := m.packOffset(i); err != nil || p != m.packs[0] || got != offset {
+		t.Errorf("packOffset(%d) = %v, %d, %v; want offset %d", i, p, got, err, offset)
+	}
+	if _, data, err := readObject(a); err != nil || string(data) != "a\n" {
+		t.Errorf("readObject(%s) = %q, %v", a, data, err)
+	}
+
+	// An offset past 4GiB is followed, not truncated back into the pack.
+	binary.BigEndian.PutUint64(m.largeOffsets, 1<<32+offset)
+	if _, got, err := m.packOffset(i); err != nil || got != 1<<32+offset {
+		t.Errorf("packOffset(%d) = %d, %v; want %d", i, got, err, 1<<32+offset)
+	}
+	if _, data, err := readObject(a); err == nil {
+		t.Errorf("read %q from past the end of the pack", data)
+	}
+}
@@ -7,11 +7,13 @@ package ggit
 
 import (
 	"bufio"
+	"compress/zlib"
 	"crypto/sha1"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -37,18 +39,17 @@ func objectType(buf *bufio.Reader) (string, error) {
 	return "", fmt.Errorf("Invalid object type %s", t)
 }
 
-func objectSize(buf *bufio.Reader) (uint32, error) {
+func objectSize(buf *bufio.Reader) (int64, error) {
 	s, err := buf.ReadString(0)
 	if err != nil {
 		return 0, nil
 	}
-	size, err := strconv.ParseUint(s[:len(s)-1], 10, 32)
-	return uint32(size), err
+	return strconv.ParseInt(s[:len(s)-1], 10, 64)
 }
 
 type Object struct {
 	ObjectType string
-	Size       uint32
+	Size       int64
 	file       *os.File
 	zlibReader io.ReadCloser
 	Reader     io.Reader
@@ -111,7 +112,10 @@ func hashToBytes(name string) []byte {
 	return h
 }
 
-var LookupObject = func(hash string) (Object, error) {
+// LookupObject finds the object named by hash. Tests may replace it.
+var LookupObject = lookupObject
+
+func lookupObject(hash string) (Object, error) {
 	if len(hash) == 0 {
 		return Object{}, fmt.Errorf("invalid hash %s\n", hash)
 	}
@@ -139,3 +143,131 @@ var LookupObject = func(hash string) (Object, error) {
 	o.file = file
 	return *o, nil
 }
+
+// hashObject computes the name git gives an object of type objectType holding
+// data.
+func hashObject(objectType string, data []byte) [sha1.Size]byte {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", objectType, len(data))
+	h.Write(data)
+	var sum [sha1.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// objectExists reports whether the object named by hash is present either as
+// a loose object or in one of the pack files.
+func objectExists(hash string) bool {
+	if _, err := os.Stat(".git/objects/" + hash[:2] + "/" + hash[2:]); err == nil {
+		return true
+	}
+	o, err := findHash(hashToBytes(hash))
+	return err == nil && o != nil
+}
+
+// WriteObject stores data as a loose object of the given type and returns its
+// name. Objects that are already in the database are not written again.
+func WriteObject(objectType string, data []byte) (string, error) {
+	sum := hashObject(objectType, data)
+	hash := fmt.Sprintf("%x", sum)
+	if objectExists(hash) {
+		return hash, nil
+	}
+	return hash, writeLooseObject(hash, objectType, data)
+}
+
+// writeLooseObject stores data as the loose object hash, even if a pack has
+// it already.
+func writeLooseObject(hash, objectType string, data []byte) error {
+	dir := ".git/objects/" + hash[:2]
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "tmp_obj_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	zw := zlib.NewWriter(f)
+	fmt.Fprintf(zw, "%s %d\x00", objectType, len(data))
+	if _, err := zw.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0444); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, hash[2:]))
+}
+
+// hashObjectFrom is hashObject for the size bytes read from r.
+func hashObjectFrom(objectType string, r io.Reader, size int64) (string, error) {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", objectType, size)
+	if n, err := io.Copy(h, r); err != nil {
+		return "", err
+	} else if n != size {
+		return "", fmt.Errorf("read %d bytes, expected %d", n, size)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// writeObjectFrom is WriteObject for the size bytes read from r, which are
+// hashed and deflated as they are read rather than held in memory.
+func writeObjectFrom(objectType string, r io.Reader, size int64) (string, error) {
+	f, err := ioutil.TempFile(".git/objects", "tmp_obj_")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	h := sha1.New()
+	zw := zlib.NewWriter(f)
+	w := io.MultiWriter(h, zw)
+	fmt.Fprintf(w, "%s %d\x00", objectType, size)
+	n, err := io.Copy(w, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("read %d bytes, expected %d", n, size)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	hash := fmt.Sprintf("%x", h.Sum(nil))
+	if objectExists(hash) {
+		return hash, nil
+	}
+	dir := ".git/objects/" + hash[:2]
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(f.Name(), 0444); err != nil {
+		return "", err
+	}
+	return hash, os.Rename(f.Name(), filepath.Join(dir, hash[2:]))
+}
+
+// readObject returns the type and full contents of the object named by hash.
+func readObject(hash string) (string, []byte, error) {
+	o, err := LookupObject(hash)
+	if err != nil {
+		return "", nil, err
+	}
+	defer o.Close()
+	data, err := ioutil.ReadAll(o.Reader)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading object %s: %v", hash, err)
+	}
+	return o.ObjectType, data, nil
+}
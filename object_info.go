@@ -0,0 +1,179 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// ObjectInfo describes an object as it is stored.
+type ObjectInfo struct {
+	Hash      string
+	Type      string
+	Size      int64  // the size of the contents
+	DiskSize  int64  // the bytes the object takes up in its pack or loose file
+	DeltaBase string // the object a packed delta applies to, "" if it isn't one
+}
+
+// StatObject describes the object named by hash without reading its
+// contents.
+func StatObject(hash string) (ObjectInfo, error) {
+	if err := loadPacks(); err != nil {
+		return ObjectInfo{}, err
+	}
+	p, offset, err := findPacked(hashToBytes(hash))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if p != nil {
+		return p.stat(hash, offset)
+	}
+	path, err := NameToPath(hash)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	o, err := lookupObject(hash)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	closeUnread(o)
+	return ObjectInfo{Hash: hash, Type: o.ObjectType, Size: o.Size, DiskSize: fi.Size()}, nil
+}
+
+// closeUnread closes o, whose contents weren't wanted, handing its inflater
+// back to the pool since nothing else will.
+func closeUnread(o Object) {
+	if o.zlibReader != nil {
+		returnZlibReader(o.zlibReader)
+	}
+	o.Close()
+}
+
+// findPacked returns the pack holding hash and the object's offset there,
+// looking in the same order as findHash.
+func findPacked(hash []byte) (*pack, uint64, error) {
+	if m := parsedMIDX; m != nil {
+		if i := m.find(hash); i != -1 {
+			return m.packOffset(i)
+		}
+	}
+	for _, p := range parsedPackFiles {
+		if p.inMIDX {
+			continue
+		}
+		if i := p.find(hash); i != -1 {
+			return p, p.idx.offset(i), nil
+		}
+	}
+	return nil, 0, nil
+}
+
+// stat describes the object hash at offset in the pack.
+func (p *pack) stat(hash string, offset uint64) (ObjectInfo, error) {
+	if err := p.loadIndex(); err != nil {
+		return ObjectInfo{}, err
+	}
+	if p.p == nil {
+		if err := p.parsePackFile(); err != nil {
+			return ObjectInfo{}, err
+		}
+	}
+	if p.order == nil {
+		p.order = p.idx.packOrder()
+	}
+	o, err := p.p.extractObject(offset)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer closeUnread(o)
+	info := ObjectInfo{Hash: hash, Type: o.ObjectType, Size: o.Size}
+	k := p.orderOf(offset)
+	if k == -1 {
+		return ObjectInfo{}, fmt.Errorf("no entry at offset %d of %s", offset, p.baseFileName)
+	}
+	end := uint64(len(p.p.data) - sha1.Size)
+	if k+1 < len(p.order) {
+		end = p.idx.offset(int(p.order[k+1]))
+	}
+	info.DiskSize = int64(end - offset)
+	_, _, _, baseOffset, baseHash, err := p.p.entryHeader(offset)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	switch {
+	case baseHash != nil:
+		info.DeltaBase = fmt.Sprintf("%x", baseHash)
+	case baseOffset != 0:
+		b := p.orderOf(baseOffset)
+		if b == -1 {
+			return ObjectInfo{}, fmt.Errorf("no delta base at offset %d of %s", baseOffset, p.baseFileName)
+		}
+		info.DeltaBase = fmt.Sprintf("%x", p.idx.hash(int(p.order[b])))
+	}
+	return info, nil
+}
+
+// orderOf returns where the entry at offset comes in p.order, or -1.
+func (p *pack) orderOf(offset uint64) int {
+	k := sort.Search(len(p.order), func(k int) bool { return p.idx.offset(int(p.order[k])) >= offset })
+	if k == len(p.order) || p.idx.offset(int(p.order[k])) != offset {
+		return -1
+	}
+	return k
+}
+
+// ForEachObject calls fn with the name of each object in the repository,
+// loose or packed, once each and in order.
+func ForEachObject(fn func(hash string) error) error {
+	if err := loadPacks(); err != nil {
+		return err
+	}
+	hashes := [][sha1.Size]byte(nil)
+	if m := parsedMIDX; m != nil {
+		for i := 0; i < m.numObjects; i++ {
+			var h [sha1.Size]byte
+			copy(h[:], m.hash(i))
+			hashes = append(hashes, h)
+		}
+	}
+	for _, p := range parsedPackFiles {
+		if p.inMIDX {
+			continue
+		}
+		for i := 0; i < p.idx.numEntries; i++ {
+			var h [sha1.Size]byte
+			copy(h[:], p.idx.hash(i))
+			hashes = append(hashes, h)
+		}
+	}
+	err := looseObjects(func(hash, path string, fi os.FileInfo) error {
+		var h [sha1.Size]byte
+		copy(h[:], hashToBytes(hash))
+		hashes = append(hashes, h)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+	for i, h := range hashes {
+		if i > 0 && h == hashes[i-1] {
+			continue
+		}
+		if err := fn(fmt.Sprintf("%x", h)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
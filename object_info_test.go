@@ -0,0 +1,81 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestStatObject(t *testing.T) {
+	defer withTempRepo(t)()
+
+	objects := []ListedObject(nil)
+	sizes := make(map[string]int64)
+	text := bytes.Repeat([]byte("a line that stays the same\n"), 50)
+	for i := 0; i < 5; i++ {
+		text = append(text, fmt.Sprintf("line %d\n", i)...)
+		hash, _ := WriteObject("blob", text)
+		objects = append(objects, ListedObject{Hash: hash, Name: "f"})
+		sizes[hash] = int64(len(text))
+	}
+	if _, err := WritePackFiles(".git/objects/pack/pack", objects, PackOptions{Window: 10, Depth: 50}); err != nil {
+		t.Fatal(err)
+	}
+	closePacks()
+	loose, _ := WriteObject("blob", []byte("loose\n"))
+
+	idxs, _ := filepath.Glob(".git/objects/pack/*.idx")
+	packed, err := VerifyPack(idxs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	deltas := 0
+	for _, o := range packed {
+		hash := fmt.Sprintf("%x", o.Hash)
+		want := ObjectInfo{Hash: hash, Type: "blob", Size: sizes[hash], DiskSize: int64(o.PackedSize)}
+		if o.Depth > 0 {
+			want.DeltaBase = fmt.Sprintf("%x", o.Base)
+			deltas++
+		}
+		if got, err := StatObject(hash); err != nil || got != want {
+			t.Errorf("StatObject(%s) = %+v, %v, want %+v", hash, got, err, want)
+		}
+	}
+	if deltas == 0 {
+		t.Error("the pack has no deltas")
+	}
+	fi, _ := os.Stat(".git/objects/" + loose[:2] + "/" + loose[2:])
+	want := ObjectInfo{Hash: loose, Type: "blob", Size: 6, DiskSize: fi.Size()}
+	if got, err := StatObject(loose); err != nil || got != want {
+		t.Errorf("StatObject(%s) = %+v, %v, want %+v", loose, got, err, want)
+	}
+	if _, err := StatObject("0123456789012345678901234567890123456789"); err == nil {
+		t.Error("StatObject found a missing object")
+	}
+
+	// The loose copies of the packed objects are listed only once.
+	all := []string{loose}
+	for _, o := range objects {
+		all = append(all, o.Hash)
+	}
+	sort.Strings(all)
+	got := []string(nil)
+	if err := ForEachObject(func(hash string) error {
+		got = append(got, hash)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, all) {
+		t.Errorf("ForEachObject gave %v, want %v", got, all)
+	}
+}
@@ -9,13 +9,15 @@ import (
 	"bufio"
 	"bytes"
 	"compress/zlib"
+	"io/ioutil"
+	"os"
 	"testing"
 )
 
 type testCase struct {
 	data        []byte
 	objectType  string
-	size        uint32
+	size        int64
 	prettyPrint string
 }
 
@@ -57,3 +59,63 @@ func TestObjectParsing(t *testing.T) {
 		}
 	}
 }
+
+// withTempRepo runs the test from a new directory containing an empty .git,
+// returning a function that restores the working directory and removes it.
+func withTempRepo(t *testing.T) func() {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "ggit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{".git/objects/pack", ".git/refs/heads"} {
+		if err := os.MkdirAll(d, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(".git/HEAD", []byte("ref: refs/heads/master\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	parsedPackFiles, parsedMIDX, bigFileThreshold = nil, nil, -1
+	closeBitmap()
+	closeCommitGraph()
+	invalidateBranches()
+	return func() {
+		parsedPackFiles, parsedMIDX, bigFileThreshold = nil, nil, -1
+		closeBitmap()
+		closeCommitGraph()
+		invalidateBranches()
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWriteObject(t *testing.T) {
+	defer withTempRepo(t)()
+
+	hash, err := WriteObject("blob", []byte("test content\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = "d670460b4b4aece5915caf5c68d12f560a9fe3e4"
+	if hash != expected {
+		t.Errorf("expected hash %s, got %s", expected, hash)
+	}
+	objectType, data, err := readObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objectType != "blob" || string(data) != "test content\n" {
+		t.Errorf("read back %s %q", objectType, data)
+	}
+	// Writing the same object again is a no-op.
+	if _, err := WriteObject("blob", []byte("test content\n")); err != nil {
+		t.Error(err)
+	}
+}
@@ -11,6 +11,9 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
 )
 
 type packFile struct {
@@ -22,6 +25,7 @@ type packIndexFile struct {
 	fanOut                           []int
 	numEntries                       int
 	hashes, crc32s, smallByteOffsets []byte
+	largeByteOffsets                 []byte // the 8-byte offsets of entries past 2GiB
 	data                             []byte
 }
 
@@ -39,8 +43,11 @@ const (
 	OBJ_MAX
 )
 
-func (p packFile) parseHeader(offset uint32) (byte, int, uint32, error) {
-	used := uint32(0)
+func (p packFile) parseHeader(offset uint64) (byte, int, uint64, error) {
+	if offset >= uint64(len(p.data)) {
+		return 0, 0, 0, fmt.Errorf("offset %d is past the end of the pack", offset)
+	}
+	used := uint64(0)
 	c := p.data[offset]
 	used++
 	t := (c >> 4) & 7
@@ -49,7 +56,7 @@ func (p packFile) parseHeader(offset uint32) (byte, int, uint32, error) {
 	shift := uint(4)
 
 	for (c & 0x80) != 0 {
-		if used+offset >= uint32(len(p.data)) {
+		if used+offset >= uint64(len(p.data)) {
 			return 0, 0, 0, errors.New("bad object header")
 		}
 		c = p.data[used+offset]
@@ -81,44 +88,103 @@ func parsePackFile(data []byte) (packFile, error) {
 	return packFile{numObjects: numObjects, data: data}, nil
 }
 
-func (p packFile) extractObject(offset uint32) (Object, error) {
+// entryHeader parses the header of the pack entry at offset, giving the
+// entry's type and inflated size, where its compressed data starts and, for
+// deltas, the offset or name of the base.
+func (p packFile) entryHeader(offset uint64) (t byte, size int, dataStart, baseOffset uint64, baseHash []byte, err error) {
 	t, size, used, err := p.parseHeader(offset)
 	if err != nil {
-		return Object{}, err
+		return 0, 0, 0, 0, nil, err
 	}
-
-	deltasCompressed := [][]byte{}
-	for t == OBJ_OFS_DELTA {
+	switch t {
+	case OBJ_OFS_DELTA:
+		if offset+used >= uint64(len(p.data)) {
+			return 0, 0, 0, 0, nil, errors.New("bad object header")
+		}
 		c := p.data[offset+used]
 		used++
-		deltaOffset := uint32(c & 0x7f)
+		deltaOffset := uint64(c & 0x7f)
 		for (c & 0x80) != 0 {
+			if offset+used >= uint64(len(p.data)) {
+				return 0, 0, 0, 0, nil, errors.New("bad object header")
+			}
 			deltaOffset++
 			c = p.data[offset+used]
 			used++
-			deltaOffset = (deltaOffset << 7) + uint32(c&0x7f)
+			deltaOffset = (deltaOffset << 7) + uint64(c&0x7f)
 		}
 		if deltaOffset > offset {
-			return Object{}, fmt.Errorf("bad object header delta offset %d %d", deltaOffset, offset)
+			return 0, 0, 0, 0, nil, fmt.Errorf("bad object header delta offset %d %d", deltaOffset, offset)
+		}
+		baseOffset = offset - deltaOffset
+	case OBJ_REF_DELTA:
+		if offset+used+sha1.Size > uint64(len(p.data)) {
+			return 0, 0, 0, 0, nil, errors.New("bad object header")
 		}
-		// at this point, next size bytes are a delta against base. store it for use in constructing the
-		// object's reader later on
-		deltasCompressed = append(deltasCompressed, p.data[offset+used:offset+used+uint32(size)+8]) // TODO: +8 is a hack, figure out
-		t, size, used, err = p.parseHeader(offset - deltaOffset)
-		offset -= deltaOffset
+		baseHash = p.data[offset+used : offset+used+sha1.Size]
+		used += sha1.Size
+	}
+	return t, size, offset + used, baseOffset, baseHash, nil
+}
+
+func (p *packFile) extractObject(offset uint64) (Object, error) {
+	t, size, start, baseOffset, baseHash, err := p.entryHeader(offset)
+	if err != nil {
+		return Object{}, err
 	}
 
-	if t < OBJ_COMMIT || t > OBJ_BLOB {
+	// The compressed data of each entry runs on to the end of the pack; zlib
+	// stops at the end of the entry's stream.
+	deltasCompressed := [][]byte{}
+	deltaOffsets := []uint64{}
+	resultSize := -1
+	for t == OBJ_OFS_DELTA || t == OBJ_REF_DELTA {
+		// at this point, the data is a delta against base. store it for use in
+		// constructing the object's reader later on
+		deltasCompressed = append(deltasCompressed, p.data[start:])
+		deltaOffsets = append(deltaOffsets, offset)
+		if resultSize == -1 {
+			if resultSize, err = deltaResultSize(p.data[start:]); err != nil {
+				return Object{}, err
+			}
+		}
+		if baseHash != nil {
+			base, err := lookupObject(fmt.Sprintf("%x", baseHash))
+			if err != nil {
+				return Object{}, fmt.Errorf("delta base %x: %v", baseHash, err)
+			}
+			return Object{ObjectType: base.ObjectType, Size: int64(resultSize),
+				Reader: &compressedDeltaReader{pack: p, objectType: base.ObjectType, baseObject: &base,
+					deltasCompressed: deltasCompressed, deltaOffsets: deltaOffsets}}, nil
+		}
+		if objectType, base, ok := deltaBases.get(p, baseOffset); ok {
+			return Object{ObjectType: objectType, Size: int64(resultSize),
+				Reader: &compressedDeltaReader{pack: p, objectType: objectType, baseData: base,
+					deltasCompressed: deltasCompressed, deltaOffsets: deltaOffsets}}, nil
+		}
+		offset = baseOffset
+		t, size, start, baseOffset, baseHash, err = p.entryHeader(offset)
+		if err != nil {
+			return Object{}, err
+		}
+	}
+
+	if t < OBJ_COMMIT || t > OBJ_TAG {
 		return Object{}, fmt.Errorf("unsupported type %d", t)
 	}
-	o := Object{ObjectType: objectTypeStrings[t], Size: uint32(size), file: nil}
+	o := Object{ObjectType: objectTypeStrings[t], Size: int64(size), file: nil}
 	if len(deltasCompressed) != 0 {
+		o.Size = int64(resultSize)
 		o.Reader = &compressedDeltaReader{
-			baseCompressed:   p.data[offset+used : offset+used+uint32(size)],
-			deltasCompressed: deltasCompressed}
+			pack:             p,
+			objectType:       o.ObjectType,
+			baseOffset:       offset,
+			baseCompressed:   p.data[start:],
+			deltasCompressed: deltasCompressed,
+			deltaOffsets:     deltaOffsets}
 
 	} else {
-		br := bytes.NewReader(p.data[offset+used : offset+used+uint32(size)])
+		br := bytes.NewReader(p.data[start:])
 		zr, err := getZlibReader(br)
 		if err != nil {
 			return Object{}, err
@@ -129,6 +195,21 @@ func (p packFile) extractObject(offset uint32) (Object, error) {
 	return o, nil
 }
 
+// compressedLength returns how many bytes of data the zlib stream at its
+// start takes up.
+func compressedLength(data []byte) (int, error) {
+	br := bytes.NewReader(data)
+	zr, err := getZlibReader(br)
+	if err != nil {
+		return 0, err
+	}
+	defer returnZlibReader(zr)
+	if _, err := io.Copy(ioutil.Discard, zr); err != nil {
+		return 0, err
+	}
+	return len(data) - br.Len(), nil
+}
+
 var verifyPackChecksum = false
 
 func parsePackIndexFile(data []byte) (packIndexFile, error) {
@@ -158,6 +239,9 @@ func parsePackIndexFile(data []byte) (packIndexFile, error) {
 	crc32TableOffset := hashesTableOffset + numEntries*sha1.Size
 	smallByteOffsetTableOffset := crc32TableOffset + numEntries*4
 	largeByteOffsetTableOffset := smallByteOffsetTableOffset + numEntries*4
+	if len(data) < largeByteOffsetTableOffset+2*sha1.Size {
+		return idx, errors.New("index file is truncated")
+	}
 
 	p := packIndexFile{
 		fanOut:           fanOut,
@@ -165,7 +249,16 @@ func parsePackIndexFile(data []byte) (packIndexFile, error) {
 		hashes:           data[hashesTableOffset:crc32TableOffset],
 		crc32s:           data[crc32TableOffset:smallByteOffsetTableOffset],
 		smallByteOffsets: data[smallByteOffsetTableOffset:largeByteOffsetTableOffset],
+		largeByteOffsets: data[largeByteOffsetTableOffset : len(data)-2*sha1.Size],
 		data:             data}
+	// Check the references to the large offset table now so that offset
+	// needn't.
+	for i := 0; i < numEntries; i++ {
+		o := binary.BigEndian.Uint32(p.smallByteOffsets[i*4:])
+		if o&(1<<31) != 0 && int(o&^(1<<31)) >= len(p.largeByteOffsets)/8 {
+			return idx, fmt.Errorf("large offset %d of entry %d is missing", o&^(1<<31), i)
+		}
+	}
 	return p, nil
 }
 
@@ -173,10 +266,24 @@ func (idx *packIndexFile) hash(i int) []byte {
 	return idx.hashes[i*sha1.Size : (i+1)*sha1.Size]
 }
 
-func (idx *packIndexFile) offset(i int) uint32 {
+// offset returns where the i'th entry of the index is in the pack, looking
+// in the large offset table for those with the top bit set.
+func (idx *packIndexFile) offset(i int) uint64 {
 	smallByteOffset := binary.BigEndian.Uint32(idx.smallByteOffsets[i*4 : (i+1)*4])
 	if (smallByteOffset & (1 << 31)) != 0 {
-		panic("do not support large byte offsets")
+		k := int(smallByteOffset &^ (1 << 31))
+		return binary.BigEndian.Uint64(idx.largeByteOffsets[k*8:])
+	}
+	return uint64(smallByteOffset)
+}
+
+// packOrder returns the index positions of the pack's entries sorted by
+// their offsets in the pack.
+func (idx *packIndexFile) packOrder() []uint32 {
+	order := make([]uint32, idx.numEntries)
+	for i := range order {
+		order[i] = uint32(i)
 	}
-	return smallByteOffset
+	sort.Slice(order, func(i, j int) bool { return idx.offset(int(order[i])) < idx.offset(int(order[j])) })
+	return order
 }
@@ -5,7 +5,12 @@
 
 package ggit
 
-import "testing"
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"testing"
+)
 
 func TestParseHeader(t *testing.T) {
 	type testcase struct {
@@ -222,3 +227,34 @@ var testIndex = []byte{0xff, 0x74, 0x4f, 0x63, 0x00, 0x00, 0x00, 0x02, 0x00,
 	0xa0, 0x5c, 0xfd, 0xf1, 0x0b, 0x25, 0xf1, 0x5f, 0x26, 0x11, 0x2a, 0x1a, 0x1d,
 	0xb8, 0x4f, 0x87, 0x78, 0x44, 0xe9, 0x41, 0x87, 0x02, 0xb3, 0xed, 0xcc, 0x92,
 	0x2b, 0x63, 0x9b, 0x0a, 0x31, 0xec, 0xd2, 0xf1, 0x7a, 0xd9, 0x2e}
+
+func TestPackIndexLargeOffsets(t *testing.T) {
+	entries := []PackEntry{{Hash: [sha1.Size]byte{1}, Offset: 12}, {Hash: [sha1.Size]byte{2}, Offset: 6 << 30},
+		{Hash: [sha1.Size]byte{3}, Offset: 1<<31 + 5}}
+	b := bytes.NewBuffer(nil)
+	if err := WritePackIndex(b, entries, [sha1.Size]byte{}); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := parsePackIndexFile(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range entries {
+		if got := idx.offset(i); got != e.Offset {
+			t.Errorf("offset(%d) = %d, want %d", i, got, e.Offset)
+		}
+	}
+
+	// Reading past the end of the pack is an error rather than a panic.
+	p := &pack{p: &packFile{numObjects: 7, data: testPack}, idx: idx, baseFileName: "pack-test"}
+	if o, err := p.findHash(entries[1].Hash[:]); err == nil {
+		t.Errorf("found %+v at offset %d of a small pack", o, entries[1].Offset)
+	}
+
+	// An entry referring past the end of the large offset table is refused.
+	data := append([]byte(nil), b.Bytes()...)
+	binary.BigEndian.PutUint32(data[8+256*4+len(entries)*(sha1.Size+4)+2*4:], 1<<31|2)
+	if _, err := parsePackIndexFile(data); err == nil {
+		t.Error("index with a missing large offset was accepted")
+	}
+}
@@ -0,0 +1,624 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"unicode"
+)
+
+// PackOptions controls how WritePack stores objects.
+type PackOptions struct {
+	Window       int  // how many objects are compared with each other when looking for deltas
+	Depth        int  // the longest chain of deltas allowed
+	Threads      int  // goroutines searching for deltas; 0 means one per CPU
+	NoReuseDelta bool // look for every delta instead of copying those in existing packs
+	// BigFileThreshold is the size above which objects are neither stored
+	// as deltas nor used as delta bases, and are compressed as they are
+	// read rather than held in memory. 0 means no limit.
+	BigFileThreshold int64
+}
+
+// defaultBigFileThreshold is git's default for core.bigFileThreshold.
+const defaultBigFileThreshold = 512 << 20
+
+// DefaultPackOptions returns the options set by pack.window, pack.depth,
+// pack.threads and core.bigFileThreshold, with git's defaults.
+func DefaultPackOptions() (PackOptions, error) {
+	window, err := ConfigInt("pack.window", 10)
+	if err != nil {
+		return PackOptions{}, err
+	}
+	depth, err := ConfigInt("pack.depth", 50)
+	if err != nil {
+		return PackOptions{}, err
+	}
+	threads, err := ConfigInt("pack.threads", 0)
+	if err != nil {
+		return PackOptions{}, err
+	}
+	bigFileThreshold, err := ConfigInt("core.bigFileThreshold", defaultBigFileThreshold)
+	if err != nil {
+		return PackOptions{}, err
+	}
+	return PackOptions{Window: int(window), Depth: int(depth), Threads: int(threads), BigFileThreshold: bigFileThreshold}, nil
+}
+
+// PackEntry is where an object is in a pack, as the pack's index records it.
+type PackEntry struct {
+	Hash   [sha1.Size]byte
+	Offset uint64
+	CRC32  uint32
+}
+
+// packObject is an object on its way into a pack.
+type packObject struct {
+	hash       [sha1.Size]byte
+	objectType byte
+	size       int
+	nameHash   uint32
+
+	// The object's entry in an existing pack, copied rather than compressed
+	// again where possible.
+	src      *pack
+	srcStart uint64 // where the compressed data starts
+	srcType  byte
+	srcSize  int
+	srcBase  *packObject // the base of a delta reused from src
+
+	base       *packObject // the object is stored as a delta against base
+	delta      []byte      // the compressed delta, when found by searching
+	deltaSize  int
+	depth      int // how many deltas must be applied to get the object
+	childDepth int // the longest chain of reused deltas based on the object
+
+	written bool
+	offset  uint64
+	crc     uint32
+}
+
+// packNameHash is git's hash of the path an object was found at. It sorts
+// objects with the same name, and then the same ending, together.
+func packNameHash(name string) uint32 {
+	h := uint32(0)
+	for _, c := range []byte(name) {
+		if unicode.IsSpace(rune(c)) {
+			continue
+		}
+		h = (h >> 2) + uint32(c)<<24
+	}
+	return h
+}
+
+// packWriter writes a pack, keeping count of the bytes and their checksum.
+type packWriter struct {
+	w      *bufio.Writer
+	sum    hash.Hash
+	offset uint64
+	opts   PackOptions
+}
+
+func (w *packWriter) Write(b []byte) (int, error) {
+	w.sum.Write(b)
+	w.offset += uint64(len(b))
+	return w.w.Write(b)
+}
+
+func appendPackHeader(b []byte, t byte, size int) []byte {
+	c := t<<4 | byte(size&0x0f)
+	size >>= 4
+	for size > 0 {
+		b = append(b, c|0x80)
+		c = byte(size & 0x7f)
+		size >>= 7
+	}
+	return append(b, c)
+}
+
+// appendPackOffset adds the distance back to an OFS_DELTA's base, in which
+// each continuation byte also adds one.
+func appendPackOffset(b []byte, ofs uint64) []byte {
+	var buf [10]byte
+	pos := len(buf) - 1
+	buf[pos] = byte(ofs & 0x7f)
+	for ofs >>= 7; ofs != 0; ofs >>= 7 {
+		ofs--
+		pos--
+		buf[pos] = 0x80 | byte(ofs&0x7f)
+	}
+	return append(b, buf[pos:]...)
+}
+
+func compress(data []byte) ([]byte, error) {
+	b := bytes.NewBuffer(nil)
+	zw := zlib.NewWriter(b)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// readPackObject returns the contents of o.
+func readPackObject(o *packObject) ([]byte, error) {
+	_, data, err := readObject(fmt.Sprintf("%x", o.hash))
+	return data, err
+}
+
+// packedEntries maps the offsets of a pack's entries to their positions in
+// its index.
+func packedEntries(p *pack) map[uint64]int {
+	entries := make(map[uint64]int, p.idx.numEntries)
+	for i := 0; i < p.idx.numEntries; i++ {
+		entries[p.idx.offset(i)] = i
+	}
+	return entries
+}
+
+// prepareObjects looks up the objects to be packed, noting which are in existing
+// packs.
+func prepareObjects(listed []ListedObject, opts PackOptions) ([]*packObject, error) {
+	if err := openPacks(); err != nil {
+		return nil, err
+	}
+	objects := make([]*packObject, 0, len(listed))
+	byHash := make(map[[sha1.Size]byte]*packObject, len(listed))
+	for _, l := range listed {
+		o := &packObject{nameHash: packNameHash(l.Name)}
+		if l.Name == "" {
+			o.nameHash = l.NameHash
+		}
+		copy(o.hash[:], hashToBytes(l.Hash))
+		if _, ok := byHash[o.hash]; ok || len(l.Hash) != 2*sha1.Size {
+			continue
+		}
+		obj, err := LookupObject(l.Hash)
+		if err != nil {
+			return nil, err
+		}
+		obj.Close()
+		for i, t := range objectTypeStrings {
+			if t == obj.ObjectType {
+				o.objectType = byte(i)
+			}
+		}
+		o.size = int(obj.Size)
+		byHash[o.hash] = o
+		objects = append(objects, o)
+	}
+
+	offsets := make(map[*pack]map[uint64]int)
+	for _, o := range objects {
+		for _, p := range parsedPackFiles {
+			i := p.find(o.hash[:])
+			if i == -1 {
+				continue
+			}
+			t, size, start, baseOffset, baseHash, err := p.p.entryHeader(p.idx.offset(i))
+			if err != nil {
+				return nil, err
+			}
+			o.src, o.srcStart, o.srcType, o.srcSize = p, start, t, size
+			if t == OBJ_OFS_DELTA {
+				if offsets[p] == nil {
+					offsets[p] = packedEntries(p)
+				}
+				if j, ok := offsets[p][baseOffset]; ok {
+					baseHash = p.idx.hash(j)
+				}
+			}
+			if baseHash != nil && !opts.NoReuseDelta {
+				var h [sha1.Size]byte
+				copy(h[:], baseHash)
+				o.srcBase = byHash[h]
+			}
+			break
+		}
+	}
+	return objects, nil
+}
+
+// reusedDepth sets how long o's chain of reused deltas is, giving up reuse
+// where the chain would be too long or go round in a circle. It returns -1
+// for an object whose depth is still being worked out.
+func reusedDepth(o *packObject, maxDepth int, state map[*packObject]int) int {
+	switch state[o] {
+	case 1:
+		return -1
+	case 2:
+		return o.depth
+	}
+	if o.srcBase == nil {
+		state[o] = 2
+		return 0
+	}
+	state[o] = 1
+	d := reusedDepth(o.srcBase, maxDepth, state)
+	if d < 0 || d+1 > maxDepth {
+		o.srcBase = nil
+	} else {
+		o.base, o.depth = o.srcBase, d+1
+	}
+	state[o] = 2
+	return o.depth
+}
+
+// deltaSlot is an object in the delta search window.
+type deltaSlot struct {
+	o    *packObject
+	data []byte
+	idx  *deltaIndex
+}
+
+// tryDelta makes target a delta against s's object if that is smaller than
+// how target is stored so far.
+func tryDelta(target *packObject, data []byte, s *deltaSlot, maxDepth int) {
+	base := s.o
+	if base.objectType != target.objectType || base.depth >= maxDepth ||
+		base.depth+1+target.childDepth > maxDepth {
+		return
+	}
+	maxSize, refDepth := target.size/2-sha1.Size, 1
+	if target.base != nil {
+		maxSize, refDepth = target.deltaSize-1, target.depth
+	}
+	maxSize = maxSize * (maxDepth - base.depth) / (maxDepth - refDepth + 1)
+	if maxSize <= 0 {
+		return
+	}
+	if sizeDiff := target.size - base.size; sizeDiff >= maxSize || target.size < base.size/32 {
+		return
+	}
+	if s.idx == nil {
+		s.idx = newDeltaIndex(s.data)
+	}
+	delta := createDelta(s.idx, data, maxSize)
+	if delta == nil {
+		return
+	}
+	target.base, target.delta, target.deltaSize, target.depth = base, delta, len(delta), base.depth+1
+}
+
+// findDeltas looks for a delta for each of objects against those just before
+// it.
+func findDeltas(objects []*packObject, opts PackOptions) error {
+	window := []*deltaSlot(nil)
+	for _, o := range objects {
+		data, err := readPackObject(o)
+		if err != nil {
+			return err
+		}
+		for j := len(window) - 1; j >= 0; j-- {
+			tryDelta(o, data, window[j], opts.Depth)
+		}
+		if o.base != nil {
+			if o.delta, err = compress(o.delta); err != nil {
+				return err
+			}
+		}
+		window = append(window, &deltaSlot{o: o, data: data})
+		if len(window) >= opts.Window {
+			window = window[1:]
+		}
+	}
+	return nil
+}
+
+// searchDeltas runs findDeltas over objects sorted so that likely bases are
+// together, splitting them between opts.Threads goroutines.
+func searchDeltas(objects []*packObject, opts PackOptions) error {
+	list := []*packObject(nil)
+	for _, o := range objects {
+		// Objects with reused deltas are left alone, as git does.
+		if o.base == nil && o.size >= 50 && !opts.isBig(o) {
+			list = append(list, o)
+		}
+	}
+	if opts.Window <= 1 || opts.Depth <= 0 || len(list) < 2 {
+		return nil
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.objectType != b.objectType {
+			return a.objectType > b.objectType
+		}
+		if a.nameHash != b.nameHash {
+			return a.nameHash > b.nameHash
+		}
+		return a.size > b.size
+	})
+
+	threads := opts.Threads
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	chunk := (len(list) + threads - 1) / threads
+	if chunk < 2*opts.Window {
+		chunk = 2 * opts.Window
+	}
+	errs := make(chan error, threads)
+	var wg sync.WaitGroup
+	for start := 0; start < len(list); start += chunk {
+		end := start + chunk
+		if end > len(list) {
+			end = len(list)
+		}
+		wg.Add(1)
+		go func(objects []*packObject) {
+			defer wg.Done()
+			if err := findDeltas(objects, opts); err != nil {
+				errs <- err
+			}
+		}(list[start:end])
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// isBig reports whether o is over the big file threshold.
+func (opts PackOptions) isBig(o *packObject) bool {
+	return opts.BigFileThreshold > 0 && int64(o.size) > opts.BigFileThreshold
+}
+
+// writeStreamed writes o to the pack, compressing it as it is read.
+func (w *packWriter) writeStreamed(o *packObject) error {
+	obj, err := LookupObject(fmt.Sprintf("%x", o.hash))
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	crc := crc32.NewIEEE()
+	out := io.MultiWriter(w, crc)
+	if _, err := out.Write(appendPackHeader(nil, o.objectType, o.size)); err != nil {
+		return err
+	}
+	zw := zlib.NewWriter(out)
+	if n, err := io.Copy(zw, obj.Reader); err != nil {
+		return err
+	} else if n != int64(o.size) {
+		return fmt.Errorf("%x has %d bytes, not %d", o.hash, n, o.size)
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	o.crc = crc.Sum32()
+	return nil
+}
+
+// writeObject writes o to the pack, after its delta base.
+func (w *packWriter) writeObject(o *packObject) error {
+	if o.written {
+		return nil
+	}
+	if o.base != nil {
+		if err := w.writeObject(o.base); err != nil {
+			return err
+		}
+	}
+	o.written, o.offset = true, w.offset
+	var header, data []byte
+	switch {
+	case o.base != nil && o.base == o.srcBase:
+		n, err := compressedLength(o.src.p.data[o.srcStart:])
+		if err != nil {
+			return fmt.Errorf("reading %x from %s: %v", o.hash, o.src.baseFileName, err)
+		}
+		header = appendPackHeader(nil, OBJ_OFS_DELTA, o.srcSize)
+		data = o.src.p.data[o.srcStart : o.srcStart+uint64(n)]
+	case o.base != nil:
+		header = appendPackHeader(nil, OBJ_OFS_DELTA, o.deltaSize)
+		data = o.delta
+	case o.src != nil && o.srcType >= OBJ_COMMIT && o.srcType <= OBJ_TAG:
+		n, err := compressedLength(o.src.p.data[o.srcStart:])
+		if err != nil {
+			return fmt.Errorf("reading %x from %s: %v", o.hash, o.src.baseFileName, err)
+		}
+		header = appendPackHeader(nil, o.srcType, o.srcSize)
+		data = o.src.p.data[o.srcStart : o.srcStart+uint64(n)]
+	case w.opts.isBig(o):
+		return w.writeStreamed(o)
+	default:
+		contents, err := readPackObject(o)
+		if err != nil {
+			return err
+		}
+		if data, err = compress(contents); err != nil {
+			return err
+		}
+		header = appendPackHeader(nil, o.objectType, len(contents))
+	}
+	if o.base != nil {
+		header = appendPackOffset(header, o.offset-o.base.offset)
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(header)
+	crc.Write(data)
+	o.crc = crc.Sum32()
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	o.delta = nil
+	return err
+}
+
+// WritePack writes a version 2 pack of objects to w, storing objects as
+// deltas against each other where that saves space. It returns the pack's
+// entries, sorted by name, and its checksum.
+func WritePack(w io.Writer, objects []ListedObject, opts PackOptions) ([]PackEntry, [sha1.Size]byte, error) {
+	var sum [sha1.Size]byte
+	packed, err := prepareObjects(objects, opts)
+	if err != nil {
+		return nil, sum, err
+	}
+	state := make(map[*packObject]int)
+	for _, o := range packed {
+		reusedDepth(o, opts.Depth, state)
+	}
+	for _, o := range packed {
+		// Deltas on o reused from other packs make the chains through it
+		// longer.
+		for b, d := o.base, 1; b != nil; b, d = b.base, d+1 {
+			if b.base == nil && d > b.childDepth {
+				b.childDepth = d
+			}
+		}
+	}
+	if err := searchDeltas(packed, opts); err != nil {
+		return nil, sum, err
+	}
+
+	pw := &packWriter{w: bufio.NewWriter(w), sum: sha1.New(), opts: opts}
+	header := make([]byte, 12)
+	copy(header, "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(packed)))
+	if _, err := pw.Write(header); err != nil {
+		return nil, sum, err
+	}
+	for _, o := range packed {
+		if err := pw.writeObject(o); err != nil {
+			return nil, sum, err
+		}
+	}
+	copy(sum[:], pw.sum.Sum(nil))
+	if _, err := pw.w.Write(sum[:]); err != nil {
+		return nil, sum, err
+	}
+	if err := pw.w.Flush(); err != nil {
+		return nil, sum, err
+	}
+
+	entries := make([]PackEntry, len(packed))
+	for i, o := range packed {
+		entries[i] = PackEntry{Hash: o.hash, Offset: o.offset, CRC32: o.crc}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Hash[:], entries[j].Hash[:]) < 0
+	})
+	return entries, sum, nil
+}
+
+// WritePackIndex writes a version 2 index of the pack with the given
+// checksum holding entries, which must be sorted by name. Offsets past 2GB
+// go in the index's table of large offsets.
+func WritePackIndex(w io.Writer, entries []PackEntry, packSum [sha1.Size]byte) error {
+	sum := sha1.New()
+	bw := bufio.NewWriter(io.MultiWriter(w, sum))
+	word := make([]byte, 8)
+	put32 := func(n uint32) {
+		binary.BigEndian.PutUint32(word, n)
+		bw.Write(word[:4])
+	}
+	bw.WriteString("\377tOc")
+	put32(2)
+	n := 0
+	for i := 0; i < 256; i++ {
+		for n < len(entries) && int(entries[n].Hash[0]) <= i {
+			n++
+		}
+		put32(uint32(n))
+	}
+	for _, e := range entries {
+		bw.Write(e.Hash[:])
+	}
+	for _, e := range entries {
+		put32(e.CRC32)
+	}
+	large := []uint64(nil)
+	for _, e := range entries {
+		if e.Offset < 1<<31 {
+			put32(uint32(e.Offset))
+		} else {
+			put32(1<<31 | uint32(len(large)))
+			large = append(large, e.Offset)
+		}
+	}
+	for _, offset := range large {
+		binary.BigEndian.PutUint64(word, offset)
+		bw.Write(word)
+	}
+	bw.Write(packSum[:])
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	_, err := w.Write(sum.Sum(nil))
+	return err
+}
+
+// writeTempFile writes a new file in dir with write, returning its name.
+func writeTempFile(dir string, write func(w io.Writer) error) (string, error) {
+	f, err := ioutil.TempFile(dir, "tmp_pack_")
+	if err != nil {
+		return "", err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), os.Chmod(f.Name(), 0444)
+}
+
+// WritePackIndexFile writes the index of a pack holding entries to path.
+func WritePackIndexFile(path string, entries []PackEntry, packSum [sha1.Size]byte) error {
+	temp, err := writeTempFile(filepath.Dir(path), func(w io.Writer) error {
+		return WritePackIndex(w, entries, packSum)
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+// WritePackFiles writes a pack of objects and its index to
+// <prefix>-<checksum>.pack and .idx, and returns the checksum in hex.
+func WritePackFiles(prefix string, objects []ListedObject, opts PackOptions) (string, error) {
+	var entries []PackEntry
+	var sum [sha1.Size]byte
+	packTemp, err := writeTempFile(filepath.Dir(prefix), func(w io.Writer) error {
+		var err error
+		entries, sum, err = WritePack(w, objects, opts)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(packTemp)
+	name := fmt.Sprintf("%x", sum)
+	if err := os.Rename(packTemp, prefix+"-"+name+".pack"); err != nil {
+		return "", err
+	}
+	if err := WritePackIndexFile(prefix+"-"+name+".idx", entries, sum); err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePack(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	text := strings.Repeat("a line of text that is long enough to compress\n", 100)
+	parent := []string(nil)
+	for i := 0; i < 8; i++ {
+		tree := makeTree(t, map[string]string{
+			"file":     fmt.Sprintf("%s%d\n", text, i),
+			"dir/same": "unchanging\n",
+		})
+		hash, err := CommitTree(tree, parent, who, who, fmt.Sprintf("commit %d\n", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent = []string{hash}
+	}
+	objects, err := ListObjects(parent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Commits, root trees and file blobs, and dir with its blob.
+	if len(objects) != 8+8+8+2 {
+		t.Fatalf("listed %d objects, want %d", len(objects), 8+8+8+2)
+	}
+	older, _ := ResolveRevision(parent[0] + "~2")
+	if some, err := ListObjects(parent, []string{older}); err != nil || len(some) != 6 {
+		t.Errorf("listed %d objects since two commits back, want 6 (%v)", len(some), err)
+	}
+
+	check := func(name string, opts PackOptions) {
+		t.Helper()
+		parsedPackFiles = nil
+		data, _ := ioutil.ReadFile(".git/objects/pack/pack-" + name + ".pack")
+		p, err := parsePackFile(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadFile(".git/objects/pack/pack-" + name + ".idx")
+		idx, err := parsePackIndexFile(b)
+		if err != nil || idx.numEntries != len(objects) {
+			t.Fatalf("index has %d entries, %v", idx.numEntries, err)
+		}
+		deltas := 0
+		for i := 0; i < idx.numEntries; i++ {
+			depth := 0
+			for offset := idx.offset(i); ; depth++ {
+				typ, _, _, base, _, err := p.entryHeader(offset)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if typ != OBJ_OFS_DELTA {
+					break
+				}
+				offset = base
+			}
+			if depth > 0 {
+				deltas++
+			}
+			if depth > opts.Depth {
+				t.Errorf("%x has a delta chain of %d", idx.hash(i), depth)
+			}
+			o, err := p.extractObject(idx.offset(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents, _ := ioutil.ReadAll(o.Reader)
+			if got := hashObject(o.ObjectType, contents); string(got[:]) != string(idx.hash(i)) {
+				t.Errorf("%x reads back as %x", idx.hash(i), got)
+			}
+		}
+		if deltas < 6 {
+			t.Errorf("only %d objects were stored as deltas", deltas)
+		}
+	}
+
+	opts := PackOptions{Window: 10, Depth: 3, Threads: 2}
+	name, err := WritePackFiles(".git/objects/pack/pack", objects, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(name, opts)
+
+	// With the loose objects gone the next pack copies from the first.
+	loose, _ := filepath.Glob(".git/objects/??")
+	for _, dir := range loose {
+		os.RemoveAll(dir)
+	}
+	parsedPackFiles = nil
+	again, err := WritePackFiles(".git/objects/pack/pack", objects, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != name {
+		t.Errorf("repacking with reused deltas gave pack %s, want %s", again, name)
+	}
+	opts.Depth = 1
+	name, err = WritePackFiles(".git/objects/pack/pack", objects, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(name, opts)
+}
+
+func TestWritePackBigFiles(t *testing.T) {
+	defer withTempRepo(t)()
+
+	// Versions of a file that would make good deltas, were they small
+	// enough.
+	text := strings.Repeat("a line of text that is long enough to compress\n", 100)
+	objects := []ListedObject(nil)
+	contents := make(map[string]string)
+	for i := 0; i < 4; i++ {
+		data := fmt.Sprintf("%s%d\n", text, i)
+		hash, _ := WriteObject("blob", []byte(data))
+		objects = append(objects, ListedObject{Hash: hash, Name: "big"})
+		contents[hash] = data
+	}
+	name, err := WritePackFiles(".git/objects/pack/pack", objects, PackOptions{Window: 10, Depth: 50, BigFileThreshold: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(".git/objects/pack/pack-" + name + ".pack")
+	p, err := parsePackFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(".git/objects/pack/pack-" + name + ".idx")
+	idx, err := parsePackIndexFile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < idx.numEntries; i++ {
+		if typ, _, _, _, _, err := p.entryHeader(idx.offset(i)); err != nil || typ != OBJ_BLOB {
+			t.Errorf("%x stored as type %d, want a whole blob (%v)", idx.hash(i), typ, err)
+		}
+		o, err := p.extractObject(idx.offset(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(o.Reader)
+		if want := contents[fmt.Sprintf("%x", idx.hash(i))]; string(got) != want || o.Size != int64(len(want)) {
+			t.Errorf("%x reads back as %d bytes, want %d", idx.hash(i), len(got), len(want))
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import "strings"
+
+// PathFilter limits a history walk to the commits that change the paths a
+// pathspec selects, simplifying history the way git log -- <paths> does by
+// default. Where the commit-graph has changed-path filters, commits they
+// show leave the paths alone are skipped without comparing trees.
+type PathFilter struct {
+	ps Pathspec
+	// keys holds, for each item of the pathspec, the keys of its path and
+	// leading directories. It is nil if some item can't use the filters.
+	keys [][]bloomKey
+	// BloomChecked counts the commits whose filters were consulted, and
+	// BloomSkipped those they showed to be unchanged.
+	BloomChecked, BloomSkipped int
+}
+
+// NewPathFilter returns a PathFilter for ps.
+func NewPathFilter(ps Pathspec) *PathFilter {
+	f := &PathFilter{ps: ps}
+	for _, item := range ps {
+		if item.exclude || item.icase || item.pattern == "" || !item.literal && hasWildcard(item.pattern) {
+			f.keys = nil
+			break
+		}
+		keys := []bloomKey(nil)
+		for p := item.pattern; ; {
+			keys = append(keys, newBloomKey(p))
+			slash := strings.LastIndexByte(p, '/')
+			if slash == -1 {
+				break
+			}
+			p = p[:slash]
+		}
+		f.keys = append(f.keys, keys)
+	}
+	return f
+}
+
+// changed reports whether the paths differ between the trees a and b.
+func (f *PathFilter) changed(a, b string) (bool, error) {
+	found := false
+	err := diffTrees(a, b, "", f.ps.mayMatchUnder, func(p string) bool {
+		found = f.ps.Match(p)
+		return !found
+	})
+	return found, err
+}
+
+// unchangedByFilter reports whether the commit-graph's changed-path filter
+// shows that the commit leaves the paths as its first parent had them.
+func (f *PathFilter) unchangedByFilter(hash string) bool {
+	g := loadCommitGraph()
+	if f.keys == nil || g == nil {
+		return false
+	}
+	pos := g.find(hashToBytes(hash))
+	if pos == -1 {
+		return false
+	}
+	filter, ok := g.bloomFilter(pos)
+	if !ok {
+		return false
+	}
+	f.BloomChecked++
+	for _, keys := range f.keys {
+		maybe := true
+		for _, k := range keys {
+			maybe = maybe && filter.mayContain(k)
+		}
+		if maybe {
+			return false
+		}
+	}
+	f.BloomSkipped++
+	return true
+}
+
+// Simplify reports whether the walk should show the commit c, and returns
+// the parents it should go on to. A commit is shown unless it leaves the
+// paths as one of its parents had them, in which case the walk follows just
+// the first such parent.
+func (f *PathFilter) Simplify(c CommitInfo) (show bool, parents []string, err error) {
+	if len(c.Parents) == 0 {
+		show, err := f.changed("", c.Tree)
+		return show, nil, err
+	}
+	for i, p := range c.Parents {
+		if i == 0 && f.unchangedByFilter(c.Hash) {
+			return false, []string{p}, nil
+		}
+		parent, err := LookupCommitInfo(p)
+		if err != nil {
+			return false, nil, err
+		}
+		if changed, err := f.changed(parent.Tree, c.Tree); err != nil {
+			return false, nil, err
+		} else if !changed {
+			return false, []string{p}, nil
+		}
+	}
+	return true, c.Parents, nil
+}
@@ -0,0 +1,394 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	// wmPathname keeps wildcards from matching '/' and enables "**".
+	wmPathname = 1 << iota
+	wmCasefold
+)
+
+func foldByte(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func upperByte(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+func eqByte(a, b byte, flags int) bool {
+	if flags&wmCasefold != 0 {
+		return foldByte(a) == foldByte(b)
+	}
+	return a == b
+}
+
+var charClasses = map[string]func(byte) bool{
+	"alnum":  func(c byte) bool { return isAlpha(c) || isDigit(c) },
+	"alpha":  isAlpha,
+	"blank":  func(c byte) bool { return c == ' ' || c == '\t' },
+	"digit":  isDigit,
+	"lower":  func(c byte) bool { return c >= 'a' && c <= 'z' },
+	"punct":  func(c byte) bool { return c > ' ' && c < 0x7f && !isAlpha(c) && !isDigit(c) },
+	"space":  func(c byte) bool { return strings.IndexByte(" \t\n\r\v\f", c) != -1 },
+	"upper":  func(c byte) bool { return c >= 'A' && c <= 'Z' },
+	"xdigit": func(c byte) bool { return isDigit(c) || (foldByte(c) >= 'a' && foldByte(c) <= 'f') },
+}
+
+func isAlpha(c byte) bool { return foldByte(c) >= 'a' && foldByte(c) <= 'z' }
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// matchClass matches c against the bracket expression starting at
+// pattern[pi]. It returns whether c matched, the index just past the
+// expression and false if the expression isn't terminated.
+func matchClass(pattern string, pi int, c byte, flags int) (bool, int, bool) {
+	i := pi + 1
+	negate := false
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		negate = true
+		i++
+	}
+	matched := false
+	for first := true; i < len(pattern); first = false {
+		lo := pattern[i]
+		switch {
+		case lo == ']' && !first:
+			if flags&wmPathname != 0 && c == '/' {
+				return false, i + 1, true
+			}
+			return matched != negate, i + 1, true
+		case lo == '[' && i+1 < len(pattern) && pattern[i+1] == ':':
+			end := strings.Index(pattern[i+2:], ":]")
+			if end == -1 {
+				return false, 0, false
+			}
+			class, ok := charClasses[pattern[i+2:i+2+end]]
+			if !ok {
+				return false, 0, false
+			}
+			if class(c) || (flags&wmCasefold != 0 && (class(foldByte(c)) || class(upperByte(c)))) {
+				matched = true
+			}
+			i += end + 4
+			continue
+		case lo == '\\' && i+1 < len(pattern):
+			i++
+			lo = pattern[i]
+		}
+		hi := lo
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			hi = pattern[i+2]
+			if hi == '\\' && i+3 < len(pattern) {
+				i++
+				hi = pattern[i+2]
+			}
+			i += 2
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		} else if u, l := upperByte(c), foldByte(c); flags&wmCasefold != 0 &&
+			((lo <= u && u <= hi) || (lo <= l && l <= hi)) {
+			matched = true
+		}
+		i++
+	}
+	return false, 0, false
+}
+
+// wildmatch matches text against a shell glob pattern with git's extensions:
+// with wmPathname, '*', '?' and bracket expressions don't match '/', while
+// "**" surrounded by slashes matches any number of directories.
+func wildmatch(pattern, text string, flags int) bool {
+	return wildmatchAt(pattern, 0, text, flags)
+}
+
+func wildmatchAt(pattern string, pi int, text string, flags int) bool {
+	ti := 0
+	for pi < len(pattern) {
+		switch c := pattern[pi]; c {
+		case '?':
+			if ti == len(text) || (flags&wmPathname != 0 && text[ti] == '/') {
+				return false
+			}
+			pi++
+			ti++
+		case '*':
+			start := pi
+			for pi < len(pattern) && pattern[pi] == '*' {
+				pi++
+			}
+			matchSlash := flags&wmPathname == 0
+			if pi-start >= 2 && (start == 0 || pattern[start-1] == '/') {
+				if pi == len(pattern) {
+					return true
+				}
+				if pattern[pi] == '/' {
+					// "**/" matches zero or more leading directories.
+					rest := text[ti:]
+					for {
+						if wildmatchAt(pattern, pi+1, rest, flags) {
+							return true
+						}
+						slash := strings.IndexByte(rest, '/')
+						if slash == -1 {
+							return false
+						}
+						rest = rest[slash+1:]
+					}
+				}
+			}
+			if pi == len(pattern) {
+				return matchSlash || strings.IndexByte(text[ti:], '/') == -1
+			}
+			for i := ti; i <= len(text); i++ {
+				if wildmatchAt(pattern, pi, text[i:], flags) {
+					return true
+				}
+				if i < len(text) && text[i] == '/' && !matchSlash {
+					return false
+				}
+			}
+			return false
+		case '[':
+			if ti == len(text) {
+				return false
+			}
+			matched, next, ok := matchClass(pattern, pi, text[ti], flags)
+			if ok {
+				if !matched {
+					return false
+				}
+				pi = next
+				ti++
+				continue
+			}
+			// An unterminated bracket is matched literally.
+			if text[ti] != '[' {
+				return false
+			}
+			pi++
+			ti++
+		case '\\':
+			if pi+1 < len(pattern) {
+				pi++
+			}
+			fallthrough
+		default:
+			if ti == len(text) || !eqByte(pattern[pi], text[ti], flags) {
+				return false
+			}
+			pi++
+			ti++
+		}
+	}
+	return ti == len(text)
+}
+
+func hasWildcard(s string) bool {
+	return strings.ContainsAny(s, "*?[\\")
+}
+
+type pathspecItem struct {
+	original, pattern             string
+	glob, icase, literal, exclude bool
+}
+
+// Pathspec selects paths in the way git's command line pathspecs do,
+// including the "glob", "icase", "literal", "exclude" and "top" magic. Since
+// ggit always runs from the top of the worktree, "top" has no effect.
+type Pathspec []pathspecItem
+
+// ParsePathspec parses command line arguments such as "src", "*.go",
+// ":(glob)**/*.c" or ":!vendor" into a Pathspec. An empty Pathspec matches
+// every path.
+func ParsePathspec(args []string) (Pathspec, error) {
+	ps := Pathspec(nil)
+	for _, arg := range args {
+		item := pathspecItem{original: arg}
+		p := arg
+		switch {
+		case strings.HasPrefix(p, ":("):
+			end := strings.IndexByte(p, ')')
+			if end == -1 {
+				return nil, fmt.Errorf("Missing ')' at the end of pathspec magic in '%s'", arg)
+			}
+			for _, magic := range strings.Split(p[2:end], ",") {
+				switch strings.TrimSpace(magic) {
+				case "glob":
+					item.glob = true
+				case "icase":
+					item.icase = true
+				case "literal":
+					item.literal = true
+				case "exclude":
+					item.exclude = true
+				case "top", "":
+				default:
+					return nil, fmt.Errorf("Invalid pathspec magic '%s' in '%s'", magic, arg)
+				}
+			}
+			p = p[end+1:]
+		case strings.HasPrefix(p, ":"):
+			p = p[1:]
+		shortMagic:
+			for len(p) > 0 {
+				switch p[0] {
+				case '/':
+				case '!', '^':
+					item.exclude = true
+				case ':':
+					p = p[1:]
+					break shortMagic
+				default:
+					break shortMagic
+				}
+				p = p[1:]
+			}
+		}
+		if item.glob && item.literal {
+			return nil, fmt.Errorf("'literal' and 'glob' are incompatible in '%s'", arg)
+		}
+		if len(p) > 0 {
+			p = path.Clean(p)
+		}
+		if p == "." {
+			p = ""
+		}
+		if strings.HasPrefix(p, "../") || p == ".." {
+			return nil, fmt.Errorf("'%s' is outside repository", arg)
+		}
+		item.pattern = p
+		ps = append(ps, item)
+	}
+	return ps, nil
+}
+
+// matchType tells how an item matched a path.
+const (
+	noMatch = iota
+	matchLeadingDir
+	matchExact
+)
+
+func (item pathspecItem) match(p string) int {
+	pattern := item.pattern
+	if item.icase {
+		pattern, p = strings.ToLower(pattern), strings.ToLower(p)
+	}
+	if pattern == "" {
+		return matchLeadingDir
+	}
+	if p == pattern {
+		return matchExact
+	}
+	if strings.HasPrefix(p, pattern+"/") {
+		return matchLeadingDir
+	}
+	if item.literal || !hasWildcard(pattern) {
+		return noMatch
+	}
+	flags := 0
+	if item.glob {
+		flags |= wmPathname
+	}
+	if wildmatch(pattern, p, flags) {
+		return matchExact
+	}
+	return noMatch
+}
+
+// matchItem returns how path is matched and the index of the item that
+// included it, or -1.
+func (ps Pathspec) matchItem(p string) (int, int) {
+	which, how := -1, noMatch
+	positive := false
+	for i, item := range ps {
+		if item.exclude {
+			continue
+		}
+		positive = true
+		if m := item.match(p); m > how {
+			which, how = i, m
+		}
+	}
+	if !positive {
+		how = matchExact
+	}
+	if how == noMatch {
+		return -1, noMatch
+	}
+	for _, item := range ps {
+		if item.exclude && item.match(p) != noMatch {
+			return -1, noMatch
+		}
+	}
+	return which, how
+}
+
+// Match reports whether ps selects path.
+func (ps Pathspec) Match(p string) bool {
+	_, how := ps.matchItem(p)
+	return how != noMatch
+}
+
+// MatchesAsDirectory reports whether path is selected only because a pathspec
+// names one of its leading directories, as "dir" selects "dir/file".
+func (ps Pathspec) MatchesAsDirectory(p string) bool {
+	_, how := ps.matchItem(p)
+	return how == matchLeadingDir && len(ps) > 0
+}
+
+// mayMatchUnder reports whether ps might select paths in the directory dir.
+func (ps Pathspec) mayMatchUnder(dir string) bool {
+	positive := false
+	for _, item := range ps {
+		if item.exclude {
+			continue
+		}
+		positive = true
+		pattern, d := item.pattern, dir
+		if item.icase {
+			pattern, d = strings.ToLower(pattern), strings.ToLower(d)
+		}
+		if pattern == "" || strings.HasPrefix(d+"/", pattern+"/") || strings.HasPrefix(pattern, d+"/") ||
+			!item.literal && hasWildcard(pattern) {
+			return true
+		}
+	}
+	return !positive
+}
+
+// Unmatched returns the pathspecs that select none of paths, which git
+// reports as errors.
+func (ps Pathspec) Unmatched(paths []string) []string {
+	used := make([]bool, len(ps))
+	for _, p := range paths {
+		for i, item := range ps {
+			if !item.exclude && !used[i] && item.match(p) != noMatch {
+				used[i] = true
+			}
+		}
+	}
+	unmatched := []string(nil)
+	for i, item := range ps {
+		if !item.exclude && !used[i] && item.pattern != "" {
+			unmatched = append(unmatched, item.original)
+		}
+	}
+	return unmatched
+}
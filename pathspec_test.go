@@ -0,0 +1,103 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWildmatch(t *testing.T) {
+	cases := []struct {
+		pattern, text string
+		flags         int
+		match         bool
+	}{
+		{"foo", "foo", 0, true},
+		{"foo", "bar", 0, false},
+		{"*.c", "a.c", 0, true},
+		{"*.c", "dir/a.c", 0, true},
+		{"*.c", "dir/a.c", wmPathname, false},
+		{"??", "ab", 0, true},
+		{"?", "/", wmPathname, false},
+		{"[a-c]x", "bx", 0, true},
+		{"[!a-c]x", "bx", 0, false},
+		{"[^a-c]x", "dx", 0, true},
+		{"[]]", "]", 0, true},
+		{"[[:digit:]]*", "7up", 0, true},
+		{"[[:upper:]]", "a", 0, false},
+		{"\\*", "*", 0, true},
+		{"\\*", "a", 0, false},
+		{"[abc", "[abc", 0, true},
+		{"**/foo", "foo", wmPathname, true},
+		{"**/foo", "a/b/foo", wmPathname, true},
+		{"a/**/b", "a/b", wmPathname, true},
+		{"a/**/b", "a/x/y/b", wmPathname, true},
+		{"a/**", "a/x/y", wmPathname, true},
+		{"a**b", "a/b", wmPathname, false},
+		{"FOO", "foo", wmCasefold, true},
+		{"[A-Z]", "q", wmCasefold, true},
+	}
+	for _, c := range cases {
+		if m := wildmatch(c.pattern, c.text, c.flags); m != c.match {
+			t.Errorf("wildmatch(%q, %q, %d) = %v, expected %v", c.pattern, c.text, c.flags, m, c.match)
+		}
+	}
+}
+
+func TestPathspec(t *testing.T) {
+	paths := []string{"README", "src/a.c", "src/sub/b.c", "src/a.h", "vendor/x.c", "Docs/Guide"}
+	cases := []struct {
+		args     []string
+		expected []string
+	}{
+		{nil, paths},
+		{[]string{"."}, paths},
+		{[]string{"src"}, []string{"src/a.c", "src/sub/b.c", "src/a.h"}},
+		{[]string{"./src/"}, []string{"src/a.c", "src/sub/b.c", "src/a.h"}},
+		{[]string{"*.c"}, []string{"src/a.c", "src/sub/b.c", "vendor/x.c"}},
+		{[]string{":(glob)*.c"}, nil},
+		{[]string{":(glob)src/*.c"}, []string{"src/a.c"}},
+		{[]string{":(glob)**/*.c"}, []string{"src/a.c", "src/sub/b.c", "vendor/x.c"}},
+		{[]string{"*.c", ":(exclude)vendor"}, []string{"src/a.c", "src/sub/b.c"}},
+		{[]string{":!src"}, []string{"README", "vendor/x.c", "Docs/Guide"}},
+		{[]string{":^src", ":!vendor"}, []string{"README", "Docs/Guide"}},
+		{[]string{":(icase)docs"}, []string{"Docs/Guide"}},
+		{[]string{":(top)README"}, []string{"README"}},
+		{[]string{":/src/a.h"}, []string{"src/a.h"}},
+		{[]string{":(literal)*.c"}, nil},
+	}
+	for _, c := range cases {
+		ps, err := ParsePathspec(c.args)
+		if err != nil {
+			t.Errorf("%q: %v", c.args, err)
+			continue
+		}
+		matched := []string(nil)
+		for _, p := range paths {
+			if ps.Match(p) {
+				matched = append(matched, p)
+			}
+		}
+		if !reflect.DeepEqual(matched, c.expected) {
+			t.Errorf("%q: expected %q, got %q", c.args, c.expected, matched)
+		}
+	}
+
+	for _, bad := range []string{":(bogus)x", ":(glob", ":(glob,literal)x", "../x"} {
+		if _, err := ParsePathspec([]string{bad}); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+
+	ps, _ := ParsePathspec([]string{"src", "nothing", "README"})
+	if u := ps.Unmatched(paths); !reflect.DeepEqual(u, []string{"nothing"}) {
+		t.Errorf("unexpected unmatched %q", u)
+	}
+	if !ps.MatchesAsDirectory("src/a.c") || ps.MatchesAsDirectory("README") {
+		t.Error("MatchesAsDirectory is wrong")
+	}
+}
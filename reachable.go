@@ -0,0 +1,224 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"fmt"
+	"path"
+)
+
+// ListedObject is an object found by ListObjects.
+type ListedObject struct {
+	Hash string
+	Name string // the path a tree or blob was found at, which guides delta search
+	Type string
+	// NameHash stands in for Name when only its hash is known, as for
+	// objects found through a reachability bitmap.
+	NameHash uint32
+}
+
+// markTree adds the tree named by hash and everything in it to seen.
+func markTree(hash string, seen map[string]bool) error {
+	if seen[hash] {
+		return nil
+	}
+	seen[hash] = true
+	entries, err := readTree(hash)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		h := fmt.Sprintf("%x", e.hash)
+		switch e.mode {
+		case "040000":
+			if err := markTree(h, seen); err != nil {
+				return err
+			}
+		case "160000":
+			// Submodule commits aren't in this repository.
+		default:
+			seen[h] = true
+		}
+	}
+	return nil
+}
+
+// listTree appends the tree named by hash, found at name, and the objects in
+// it that aren't in seen.
+func listTree(hash, name string, seen map[string]bool, objects []ListedObject) ([]ListedObject, error) {
+	if seen[hash] {
+		return objects, nil
+	}
+	seen[hash] = true
+	objects = append(objects, ListedObject{Hash: hash, Name: name, Type: "tree"})
+	entries, err := readTree(hash)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		h := fmt.Sprintf("%x", e.hash)
+		switch {
+		case e.mode == "040000":
+			if objects, err = listTree(h, path.Join(name, e.name), seen, objects); err != nil {
+				return nil, err
+			}
+		case e.mode == "160000" || seen[h]:
+		default:
+			seen[h] = true
+			objects = append(objects, ListedObject{Hash: h, Name: path.Join(name, e.name), Type: "blob"})
+		}
+	}
+	return objects, nil
+}
+
+// ListObjects returns the objects reachable from the objects named in
+// include but not from those in exclude. It uses the packs' reachability
+// bitmap if there is one, unless pack.useBitmaps is false, and otherwise
+// walks the history with WalkObjects.
+func ListObjects(include, exclude []string) ([]ListedObject, error) {
+	if use, err := ConfigBool("pack.useBitmaps", true); err == nil && use {
+		if objects, ok, err := BitmapObjects(include, exclude); err != nil || ok {
+			return objects, err
+		}
+	}
+	return WalkObjects(include, exclude)
+}
+
+// WalkObjects returns the objects reachable from the objects named in
+// include but not from those in exclude, as git rev-list --objects does:
+// commits newest first, then tags, then the trees and blobs the commits
+// introduce. Trees and blobs reachable from excluded commits at the edge of
+// the walk are left out too.
+func WalkObjects(include, exclude []string) ([]ListedObject, error) {
+	uninteresting := make(map[string]bool)
+	tips := make(map[string]bool)
+	queue := &commitQueue{}
+	for _, hash := range exclude {
+		hash, err := peel(hash, "")
+		if err != nil {
+			return nil, err
+		}
+		if objectType, _, err := readObject(hash); err != nil {
+			return nil, err
+		} else if objectType == "commit" {
+			if err := queue.insert(hash); err != nil {
+				return nil, err
+			}
+			tips[hash] = true
+			continue
+		} else if objectType == "tree" {
+			if err := markTree(hash, uninteresting); err != nil {
+				return nil, err
+			}
+		}
+		uninteresting[hash] = true
+	}
+	edge := []string(nil)
+	for len(queue.hashes) > 0 {
+		hash := queue.pop()
+		if uninteresting[hash] {
+			continue
+		}
+		uninteresting[hash] = true
+		c, err := LookupCommitInfo(hash)
+		if err != nil {
+			return nil, err
+		}
+		if tips[hash] {
+			edge = append(edge, c.Tree)
+		}
+		for _, p := range c.Parents {
+			if err := queue.insert(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	included := make(map[string]bool)
+	commits, tags, others := []ListedObject(nil), []ListedObject(nil), []ListedObject(nil)
+	for _, hash := range include {
+		for !included[hash] && !uninteresting[hash] {
+			objectType, data, err := readObject(hash)
+			if err != nil {
+				return nil, err
+			}
+			if objectType == "commit" {
+				if err := queue.insert(hash); err != nil {
+					return nil, err
+				}
+				break
+			}
+			included[hash] = true
+			if objectType != "tag" {
+				// Trees and blobs are listed after the commits.
+				others = append(others, ListedObject{Hash: hash, Type: objectType})
+				break
+			}
+			seen[hash] = true
+			tags = append(tags, ListedObject{Hash: hash, Type: "tag"})
+			if hash, _, err = tagTarget(data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	trees := []string(nil)
+	for len(queue.hashes) > 0 {
+		hash := queue.pop()
+		if seen[hash] || uninteresting[hash] {
+			continue
+		}
+		seen[hash] = true
+		c, err := LookupCommitInfo(hash)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, ListedObject{Hash: hash, Type: "commit"})
+		trees = append(trees, c.Tree)
+		for _, p := range c.Parents {
+			if uninteresting[p] {
+				// The walk stops here; what the parent has the other end
+				// has.
+				pc, err := LookupCommitInfo(p)
+				if err != nil {
+					return nil, err
+				}
+				edge = append(edge, pc.Tree)
+				continue
+			}
+			if err := queue.insert(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, tree := range edge {
+		if err := markTree(tree, uninteresting); err != nil {
+			return nil, err
+		}
+	}
+	for hash := range uninteresting {
+		seen[hash] = true
+	}
+
+	objects := append(commits, tags...)
+	var err error
+	for _, o := range others {
+		if o.Type == "tree" {
+			if objects, err = listTree(o.Hash, "", seen, objects); err != nil {
+				return nil, err
+			}
+		} else if !seen[o.Hash] {
+			seen[o.Hash] = true
+			objects = append(objects, o)
+		}
+	}
+	for _, tree := range trees {
+		if objects, err = listTree(tree, "", seen, objects); err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
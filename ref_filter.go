@@ -0,0 +1,519 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ref is a ref and the object it names.
+type Ref struct {
+	Name   string
+	Hash   string
+	Symref string // the ref a symbolic ref points to
+}
+
+// ListRefs returns the refs whose full names start with prefix, sorted by
+// name. Symbolic refs are resolved, and left out if they point nowhere.
+func ListRefs(prefix string) ([]Ref, error) {
+	records, err := refStore().listRefs()
+	if err != nil {
+		return nil, err
+	}
+	refs := []Ref(nil)
+	for _, r := range records {
+		if !strings.HasPrefix(r.name, prefix) {
+			continue
+		}
+		ref := Ref{Name: r.name, Hash: r.value}
+		if strings.HasPrefix(r.value, refPrefix) {
+			if ref.Symref, ref.Hash, err = ResolveRef(r.name); err != nil {
+				return nil, err
+			}
+			if ref.Hash == "" {
+				continue
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// ShortRefName abbreviates a full ref name the way git shows it, such as
+// "master" for refs/heads/master, unless the short name would refer to
+// another ref.
+func ShortRefName(name string) string {
+	// The last rule, refs/remotes/%s/HEAD, is left out so that origin/HEAD
+	// stays distinguishable from the remote's name.
+	for i := len(refSearchPath) - 2; i > 0; i-- {
+		format := refSearchPath[i]
+		pre := format[:strings.Index(format, "%s")]
+		post := format[len(pre)+2:]
+		if len(name) <= len(pre)+len(post) || !strings.HasPrefix(name, pre) || !strings.HasSuffix(name, post) {
+			continue
+		}
+		short := name[len(pre) : len(name)-len(post)]
+		if ref, _, err := DwimRef(short); err == nil && (ref == "" || ref == name) {
+			return short
+		}
+	}
+	return name
+}
+
+// matchRefPattern reports whether the full ref name matches pattern, either
+// as a glob or as a prefix that ends at a slash.
+func matchRefPattern(name, pattern string) bool {
+	if strings.ContainsAny(pattern, "*?[") {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+	pattern = strings.TrimSuffix(pattern, "/")
+	return name == pattern || strings.HasPrefix(name, pattern+"/")
+}
+
+// RefFilter selects refs as the options of ref listing commands such as
+// for-each-ref do. Each non-empty field must be satisfied by at least one of
+// its values.
+type RefFilter struct {
+	Patterns []string
+	Contains []string // commits the ref's history must include
+	Merged   []string // commits whose history must include the ref
+	NoMerged []string // commits whose history must not include the ref
+	PointsAt []string // objects the ref, or the tag it names, must point to
+}
+
+// Filter returns the refs that pass f, in the order given.
+func (f *RefFilter) Filter(refs []Ref) ([]Ref, error) {
+	merged := []map[string]bool(nil)
+	for _, c := range f.Merged {
+		merged = append(merged, reachableFrom(c))
+	}
+	noMerged := []map[string]bool(nil)
+	for _, c := range f.NoMerged {
+		noMerged = append(noMerged, reachableFrom(c))
+	}
+	kept := []Ref(nil)
+	for _, r := range refs {
+		ok, err := f.match(r, merged, noMerged)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			kept = append(kept, r)
+		}
+	}
+	return kept, nil
+}
+
+func (f *RefFilter) match(r Ref, merged, noMerged []map[string]bool) (bool, error) {
+	if len(f.Patterns) > 0 && !anyOf(f.Patterns, func(p string) bool { return matchRefPattern(r.Name, p) }) {
+		return false, nil
+	}
+	if len(f.PointsAt) > 0 {
+		target := ""
+		if objectType, data, err := readObject(r.Hash); err == nil && objectType == "tag" {
+			target, _, _ = tagTarget(data)
+		}
+		if !anyOf(f.PointsAt, func(h string) bool { return h == r.Hash || h == target }) {
+			return false, nil
+		}
+	}
+	if len(f.Contains) == 0 && len(merged) == 0 && len(noMerged) == 0 {
+		return true, nil
+	}
+	c, err := peel(r.Hash, "commit")
+	if err != nil {
+		// Only commits, or tags of them, have a history to filter by.
+		return false, nil
+	}
+	if len(merged) > 0 {
+		found := false
+		for _, m := range merged {
+			found = found || m[c]
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	for _, m := range noMerged {
+		if m[c] {
+			return false, nil
+		}
+	}
+	if len(f.Contains) > 0 {
+		history := reachableFrom(c)
+		if !anyOf(f.Contains, func(h string) bool { return history[h] }) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func anyOf(values []string, pred func(string) bool) bool {
+	for _, v := range values {
+		if pred(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// refObject is the object a ref names, split into its header and message.
+type refObject struct {
+	hash, objectType string
+	size             int
+	header, message  string
+}
+
+func readRefObject(hash string) (*refObject, error) {
+	objectType, data, err := readObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	o := &refObject{hash: hash, objectType: objectType, size: len(data)}
+	if objectType == "commit" || objectType == "tag" {
+		if i := bytes.Index(data, []byte("\n\n")); i != -1 {
+			o.header, o.message = string(data[:i+1]), string(data[i+2:])
+		} else {
+			o.header = string(data)
+		}
+	}
+	return o, nil
+}
+
+// person parses the header line of role, such as "committer". It reports
+// false if the object has no such line.
+func (o *refObject) person(role string) (Signature, bool) {
+	for _, line := range strings.SplitAfter(o.header, "\n") {
+		if !strings.HasPrefix(line, role+" ") {
+			continue
+		}
+		name, email, zone, t, err := parsePersonLine(line, role)
+		if err != nil {
+			return Signature{}, false
+		}
+		if loc, err := parseZone(zone); err == nil {
+			t = t.In(loc)
+		}
+		return Signature{Name: name, Email: email, When: t}, true
+	}
+	return Signature{}, false
+}
+
+// RefFormatter expands the %(atom) placeholders of for-each-ref formats.
+type RefFormatter struct {
+	objects map[string]*refObject
+}
+
+// NewRefFormatter returns a formatter.
+func NewRefFormatter() *RefFormatter {
+	return &RefFormatter{objects: make(map[string]*refObject)}
+}
+
+func (f *RefFormatter) object(hash string) (*refObject, error) {
+	if o, ok := f.objects[hash]; ok {
+		return o, nil
+	}
+	o, err := readRefObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	f.objects[hash] = o
+	return o, nil
+}
+
+// Atom returns the value of atom, such as "refname:short", for r. An atom
+// starting with '*' describes the object an annotated tag points to, and is
+// empty for other refs.
+func (f *RefFormatter) Atom(r Ref, atom string) (string, error) {
+	name, modifier := atom, ""
+	if colon := strings.IndexByte(atom, ':'); colon != -1 {
+		name, modifier = atom[:colon], atom[colon+1:]
+	}
+	hash := r.Hash
+	if strings.HasPrefix(name, "*") {
+		name = name[1:]
+		o, err := f.object(hash)
+		if err != nil {
+			return "", err
+		}
+		if o.objectType != "tag" {
+			return "", nil
+		}
+		if hash, _, err = tagTarget([]byte(o.header)); err != nil {
+			return "", err
+		}
+	}
+	switch name {
+	case "refname":
+		return formatRefName(r.Name, modifier)
+	case "symref":
+		return formatRefName(r.Symref, modifier)
+	case "upstream":
+		return formatRefName(Upstream(r.Name), modifier)
+	case "objectname":
+		switch {
+		case modifier == "":
+			return hash, nil
+		case modifier == "short":
+			return hash[:7], nil
+		case strings.HasPrefix(modifier, "short="):
+			n, err := strconv.Atoi(modifier[len("short="):])
+			if err != nil || n < 4 {
+				n = 4
+			}
+			if n > len(hash) {
+				n = len(hash)
+			}
+			return hash[:n], nil
+		}
+		return "", fmt.Errorf("unrecognized %%(objectname) argument: %s", modifier)
+	}
+	o, err := f.object(hash)
+	if err != nil {
+		return "", err
+	}
+	switch name {
+	case "objecttype":
+		return o.objectType, nil
+	case "objectsize":
+		return strconv.Itoa(o.size), nil
+	case "subject":
+		return messageSubject(o.message), nil
+	case "body":
+		if i := strings.Index(o.message, "\n\n"); i != -1 {
+			return o.message[i+2:], nil
+		}
+		return "", nil
+	case "contents":
+		return o.message, nil
+	}
+	for _, role := range []string{"author", "committer", "tagger", "creator"} {
+		if !strings.HasPrefix(name, role) {
+			continue
+		}
+		field := name[len(role):]
+		if role == "creator" {
+			role = "committer"
+			if o.objectType == "tag" {
+				role = "tagger"
+			}
+		}
+		who, ok := o.person(role)
+		switch field {
+		case "name":
+			return who.Name, nil
+		case "email":
+			if !ok {
+				return "", nil
+			}
+			return "<" + who.Email + ">", nil
+		case "date":
+			if !ok {
+				return "", nil
+			}
+			return formatRefDate(who.When, modifier)
+		}
+	}
+	return "", fmt.Errorf("unknown field name: %s", name)
+}
+
+// messageSubject returns the first paragraph of message joined onto one
+// line, as git's %(subject) does.
+func messageSubject(message string) string {
+	if i := strings.Index(message, "\n\n"); i != -1 {
+		message = message[:i]
+	}
+	return strings.Replace(strings.TrimRight(message, "\n"), "\n", " ", -1)
+}
+
+func formatRefName(name, modifier string) (string, error) {
+	switch {
+	case modifier == "" || name == "":
+		return name, nil
+	case modifier == "short":
+		return ShortRefName(name), nil
+	case strings.HasPrefix(modifier, "strip=") || strings.HasPrefix(modifier, "lstrip="):
+		n, err := strconv.Atoi(modifier[strings.IndexByte(modifier, '=')+1:])
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("positive value expected %s", modifier)
+		}
+		parts := strings.Split(name, "/")
+		if n > len(parts) {
+			n = len(parts)
+		}
+		return strings.Join(parts[n:], "/"), nil
+	}
+	return "", fmt.Errorf("unrecognized refname argument: %s", modifier)
+}
+
+// formatRefDate formats t in git's default date format, or as modifier
+// asks.
+func formatRefDate(t time.Time, modifier string) (string, error) {
+	switch modifier {
+	case "", "default":
+		return t.Format(timeFormat + " -0700"), nil
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10), nil
+	case "raw":
+		return fmt.Sprintf("%d %s", t.Unix(), t.Format("-0700")), nil
+	case "iso", "iso8601":
+		return t.Format("2006-01-02 15:04:05 -0700"), nil
+	case "short":
+		return t.Format("2006-01-02"), nil
+	}
+	return "", fmt.Errorf("unknown date format %s", modifier)
+}
+
+// Format expands the placeholders of format for r: %(atom), %% for a
+// literal percent sign and %xx for the byte with hex code xx.
+func (f *RefFormatter) Format(format string, r Ref) (string, error) {
+	out := bytes.NewBuffer(nil)
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			out.WriteByte(c)
+			continue
+		}
+		rest := format[i+1:]
+		switch {
+		case strings.HasPrefix(rest, "%"):
+			out.WriteByte('%')
+			i++
+		case strings.HasPrefix(rest, "("):
+			end := strings.IndexByte(rest, ')')
+			if end == -1 {
+				return "", fmt.Errorf("malformed format string %s", format)
+			}
+			v, err := f.Atom(r, rest[1:end])
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(v)
+			i += end + 1
+		case len(rest) >= 2 && isHex(rest[:2]):
+			b, _ := hex.DecodeString(rest[:2])
+			out.Write(b)
+			i += 2
+		default:
+			out.WriteByte('%')
+		}
+	}
+	return out.String(), nil
+}
+
+// Sort sorts refs by keys, atoms such as "refname" or "-committerdate" where
+// a leading '-' reverses the order. The first key is the most significant.
+// Dates and sizes compare numerically, and atoms prefixed with "version:" or
+// "v:" as version numbers.
+func (f *RefFormatter) Sort(refs []Ref, keys []string) error {
+	type sortKey struct {
+		atom                      string
+		reverse, numeric, version bool
+	}
+	parsed := []sortKey(nil)
+	for _, k := range keys {
+		sk := sortKey{atom: strings.TrimPrefix(k, "-"), reverse: strings.HasPrefix(k, "-")}
+		for _, prefix := range []string{"version:", "v:"} {
+			if strings.HasPrefix(sk.atom, prefix) {
+				sk.atom, sk.version = sk.atom[len(prefix):], true
+			}
+		}
+		if strings.HasSuffix(sk.atom, "date") {
+			sk.atom += ":unix"
+		}
+		sk.numeric = strings.HasSuffix(sk.atom, "date:unix") || strings.HasSuffix(sk.atom, "objectsize")
+		parsed = append(parsed, sk)
+	}
+	values := make([][]string, len(refs))
+	for i, r := range refs {
+		for _, k := range parsed {
+			v, err := f.Atom(r, k.atom)
+			if err != nil {
+				return err
+			}
+			values[i] = append(values[i], v)
+		}
+	}
+	index := make([]int, len(refs))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(a, b int) bool {
+		va, vb := values[index[a]], values[index[b]]
+		for k, key := range parsed {
+			c := strings.Compare(va[k], vb[k])
+			if key.numeric {
+				c = compareNumbers(va[k], vb[k])
+			} else if key.version {
+				c = compareVersions(va[k], vb[k])
+			}
+			if key.reverse {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return refs[index[a]].Name < refs[index[b]].Name
+	})
+	sorted := make([]Ref, len(refs))
+	for i, j := range index {
+		sorted[i] = refs[j]
+	}
+	copy(refs, sorted)
+	return nil
+}
+
+// compareNumbers compares decimal numbers, with empty values first.
+func compareNumbers(a, b string) int {
+	x, _ := strconv.ParseInt(a, 10, 64)
+	y, _ := strconv.ParseInt(b, 10, 64)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// compareVersions compares strings treating runs of digits as numbers, so
+// that "v1.9" sorts before "v1.10".
+func compareVersions(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			i, j := 0, 0
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			x, y := strings.TrimLeft(a[:i], "0"), strings.TrimLeft(b[:j], "0")
+			if c := len(x) - len(y); c != 0 {
+				return c
+			}
+			if c := strings.Compare(x, y); c != 0 {
+				return c
+			}
+			a, b = a[i:], b[j:]
+			continue
+		}
+		if a[0] != b[0] {
+			return int(a[0]) - int(b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) - len(b)
+}
@@ -0,0 +1,147 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func refNames(refs []Ref) []string {
+	names := []string(nil)
+	for _, r := range refs {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+func TestRefFilter(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0).In(time.FixedZone("", -7*3600))}
+	later := who
+	later.When = later.When.Add(time.Hour)
+	tree := makeTree(t, map[string]string{"a": "a\n"})
+	one, _ := CommitTree(tree, nil, who, who, "one\n")
+	two, _ := CommitTree(tree, []string{one}, later, later, "two\n\nbody\n")
+	tag, _ := WriteObject("tag", []byte(fmt.Sprintf("object %s\ntype commit\ntag v1\ntagger %s\n\nfirst\nrelease\n", one, who)))
+	for ref, hash := range map[string]string{
+		"refs/heads/master":          two,
+		"refs/heads/topic":           one,
+		"refs/remotes/origin/master": one,
+		"refs/tags/v1":               tag,
+	} {
+		if err := UpdateRef(ref, hash, "", who, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ioutil.WriteFile(".git/refs/remotes/origin/HEAD", []byte("ref: refs/remotes/origin/master\n"), 0666)
+	ioutil.WriteFile(".git/config", []byte("[remote \"origin\"]\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n"+
+		"[branch \"master\"]\n\tremote = origin\n\tmerge = refs/heads/master\n"), 0666)
+
+	refs, err := ListRefs("refs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"refs/heads/master", "refs/heads/topic", "refs/remotes/origin/HEAD", "refs/remotes/origin/master", "refs/tags/v1"}
+	if got := refNames(refs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ListRefs = %v, want %v", got, want)
+	}
+
+	for _, c := range []struct {
+		filter RefFilter
+		want   []string
+	}{
+		{RefFilter{Patterns: []string{"refs/heads"}}, []string{"refs/heads/master", "refs/heads/topic"}},
+		{RefFilter{Patterns: []string{"refs/*/master"}}, []string{"refs/heads/master"}},
+		{RefFilter{Patterns: []string{"refs/hea"}}, nil},
+		{RefFilter{Contains: []string{two}}, []string{"refs/heads/master"}},
+		{RefFilter{Merged: []string{one}}, []string{"refs/heads/topic", "refs/remotes/origin/HEAD", "refs/remotes/origin/master", "refs/tags/v1"}},
+		{RefFilter{NoMerged: []string{one}}, []string{"refs/heads/master"}},
+		{RefFilter{PointsAt: []string{one}, Patterns: []string{"refs/tags", "refs/heads"}}, []string{"refs/heads/topic", "refs/tags/v1"}},
+	} {
+		got, err := c.filter.Filter(refs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names := refNames(got); !reflect.DeepEqual(names, c.want) {
+			t.Errorf("%+v selected %v, want %v", c.filter, names, c.want)
+		}
+	}
+
+	f := NewRefFormatter()
+	for _, c := range []struct {
+		ref    int
+		format string
+		want   string
+	}{
+		{0, "%(refname:short) %(upstream:short) %(objecttype)", "master origin/master commit"},
+		{0, "%(subject)|%(body)|%(committerdate)", "two|body\n|Thu Apr 24 14:53:39 2014 -0700"},
+		{2, "%(refname:short) -> %(symref:short)", "origin/HEAD -> origin/master"},
+		{4, "%(objectname:short=8) %(*objectname) %(*objecttype)", tag[:8] + " " + one + " commit"},
+		{4, "%(subject)%09%(taggername) %(taggeremail) %(creatordate:unix)%%", "first release\tA U Thor <author@example.com> 1398372819%"},
+		{1, "%(*objectname)|%(refname:lstrip=2)|%(taggerdate)", "|topic|"},
+	} {
+		got, err := f.Format(c.format, refs[c.ref])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("Format(%q, %s) = %q, want %q", c.format, refs[c.ref].Name, got, c.want)
+		}
+	}
+	if _, err := f.Format("%(bogus)", refs[0]); err == nil {
+		t.Errorf("unknown atom formatted")
+	}
+
+	sorted := append([]Ref(nil), refs...)
+	if err := f.Sort(sorted, []string{"-committerdate", "-refname"}); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"refs/heads/master", "refs/remotes/origin/master", "refs/remotes/origin/HEAD", "refs/heads/topic", "refs/tags/v1"}
+	if got := refNames(sorted); !reflect.DeepEqual(got, want) {
+		t.Errorf("sorted by date to %v, want %v", got, want)
+	}
+}
+
+func TestMapRefspec(t *testing.T) {
+	for _, c := range []struct{ refspec, ref, want string }{
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/heads/topic/x", "refs/remotes/origin/topic/x"},
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/tags/v1", ""},
+		{"refs/heads/master:refs/remotes/origin/main", "refs/heads/master", "refs/remotes/origin/main"},
+		{"refs/heads/master", "refs/heads/master", ""},
+	} {
+		if got := mapRefspec(c.refspec, c.ref); got != c.want {
+			t.Errorf("mapRefspec(%q, %q) = %q, want %q", c.refspec, c.ref, got, c.want)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"v1.9", "v1.10", -1},
+		{"v1.2-rc1", "v1.2", 1},
+		{"v1.02", "v1.2", 0},
+		{"v2", "v10", -1},
+		{"a", "b", -1},
+	} {
+		got := compareVersions(c.a, c.b)
+		if got < 0 {
+			got = -1
+		} else if got > 0 {
+			got = 1
+		}
+		if got != c.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
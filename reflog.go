@@ -0,0 +1,373 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ZeroHash is the all-zeros object name git uses for a ref that doesn't exist.
+const ZeroHash = "0000000000000000000000000000000000000000"
+
+// logsRefByDefault reports whether updates to ref are logged even if it has
+// no reflog yet. core.logAllRefUpdates may be "always" to log every ref or
+// false to log none; it defaults to true, which logs branches, HEAD and the
+// like.
+func logsRefByDefault(ref string) bool {
+	if c, err := ReadConfig(); err == nil {
+		if e, ok := c.Get("core.logAllRefUpdates"); ok {
+			if strings.EqualFold(e.Value, "always") {
+				return true
+			}
+			if b, err := e.Bool(); err == nil && !b {
+				return false
+			}
+		}
+	}
+	return ref == "HEAD" || strings.HasPrefix(ref, "refs/heads/") ||
+		strings.HasPrefix(ref, "refs/remotes/") || strings.HasPrefix(ref, "refs/notes/")
+}
+
+// appendReflog records in .git/logs/<ref> that ref moved from oldHash to
+// newHash.
+func appendReflog(ref, oldHash, newHash string, who Signature, msg string) error {
+	path := ".git/logs/" + ref
+	if !logsRefByDefault(ref) {
+		if _, err := os.Stat(path); err != nil {
+			return nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	msg = strings.Replace(strings.TrimSpace(msg), "\n", " ", -1)
+	_, err = fmt.Fprintf(f, "%s %s %s\t%s\n", oldHash, newHash, who, msg)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ReflogSignature returns the committer identity for reflog entries. Unlike
+// commits, ref updates go ahead without a configured identity, falling back
+// to the login name and host as git does.
+func ReflogSignature() Signature {
+	if s, err := DefaultSignature("committer"); err == nil {
+		return s
+	}
+	user := os.Getenv("USER")
+	if user == "" {
+		user = "unknown"
+	}
+	host, _ := os.Hostname()
+	name, _ := ConfigValue("user.name")
+	if name == "" {
+		name = user
+	}
+	email, _ := ConfigValue("user.email")
+	if email == "" {
+		email = user + "@" + host
+	}
+	return Signature{Name: name, Email: email, When: time.Now()}
+}
+
+// ReflogEntry is one line of a reflog: ref moved from Old to New.
+type ReflogEntry struct {
+	Old, New string
+	Who      Signature
+	Message  string
+
+	updateIndex uint64 // position in a reftable stack
+}
+
+func parseReflogLine(line string) (ReflogEntry, error) {
+	e := ReflogEntry{}
+	if len(line) < 82 || line[40] != ' ' || line[81] != ' ' {
+		return e, fmt.Errorf("bad reflog line %q", line)
+	}
+	e.Old, e.New = line[:40], line[41:81]
+	who := line[82:]
+	if tab := strings.IndexByte(who, '\t'); tab != -1 {
+		who, e.Message = who[:tab], who[tab+1:]
+	}
+	lt, gt := strings.IndexByte(who, '<'), strings.LastIndexByte(who, '>')
+	if lt == -1 || gt < lt {
+		return e, fmt.Errorf("bad reflog line %q", line)
+	}
+	e.Who.Name = strings.TrimSpace(who[:lt])
+	e.Who.Email = who[lt+1 : gt]
+	when, err := parseDate(who[gt+1:])
+	if err != nil {
+		return e, fmt.Errorf("bad reflog line %q: %v", line, err)
+	}
+	e.Who.When = when
+	return e, nil
+}
+
+// ReadReflog returns the entries in ref's reflog, oldest first. A ref with
+// no reflog has no entries.
+func ReadReflog(ref string) ([]ReflogEntry, error) {
+	return refStore().readReflog(ref)
+}
+
+func (filesBackend) readReflog(ref string) ([]ReflogEntry, error) {
+	b, err := readFile(".git/logs/" + ref)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := []ReflogEntry(nil)
+	for _, line := range strings.Split(string(b), "\n") {
+		if line == "" {
+			continue
+		}
+		e, err := parseReflogLine(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (filesBackend) writeReflog(ref string, entries []ReflogEntry) error {
+	l, err := lock(".git/logs/" + ref)
+	if err != nil {
+		return err
+	}
+	defer l.rollback()
+	for _, e := range entries {
+		if _, err := fmt.Fprintf(l, "%s %s %s\t%s\n", e.Old, e.New, e.Who, e.Message); err != nil {
+			return err
+		}
+	}
+	return l.commit()
+}
+
+// DeleteReflogEntry removes entry n of ref's reflog, counting from 0 for the
+// most recent as in ref@{n}.
+func DeleteReflogEntry(ref string, n int) error {
+	entries, err := ReadReflog(ref)
+	if err != nil {
+		return err
+	}
+	if n < 0 || n >= len(entries) {
+		return fmt.Errorf("reflog for %s has no entry %d", ref, n)
+	}
+	i := len(entries) - 1 - n
+	return refStore().writeReflog(ref, append(entries[:i], entries[i+1:]...))
+}
+
+// reachableFrom returns the set of commits reachable from hash.
+func reachableFrom(hash string) map[string]bool {
+	seen := make(map[string]bool)
+	queue := []string{hash}
+	for len(queue) > 0 {
+		h := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if seen[h] || h == ZeroHash {
+			continue
+		}
+		seen[h] = true
+		c, err := ReadCommit(h)
+		if err != nil {
+			continue
+		}
+		queue = append(queue, c.Parent...)
+		c.Close()
+	}
+	return seen
+}
+
+// ExpireReflog drops the entries of ref's reflog older than expire, and
+// those older than expireUnreachable whose commit is no longer reachable from
+// the tip of ref. It returns the number of entries dropped; with dryRun the
+// reflog is left as it was.
+func ExpireReflog(ref string, expire, expireUnreachable time.Time, dryRun bool) (int, error) {
+	entries, err := ReadReflog(ref)
+	if err != nil {
+		return 0, err
+	}
+	var reachable map[string]bool
+	kept := entries[:0:0]
+	for _, e := range entries {
+		if e.Who.When.Before(expire) {
+			continue
+		}
+		if e.Who.When.Before(expireUnreachable) {
+			if reachable == nil {
+				_, tip, _ := ResolveRef(ref)
+				reachable = reachableFrom(tip)
+			}
+			if !reachable[e.New] {
+				continue
+			}
+		}
+		kept = append(kept, e)
+	}
+	dropped := len(entries) - len(kept)
+	if dryRun || dropped == 0 {
+		return dropped, nil
+	}
+	return dropped, refStore().writeReflog(ref, kept)
+}
+
+// ReflogRefs lists the refs that have reflogs.
+func ReflogRefs() ([]string, error) {
+	return refStore().reflogRefs()
+}
+
+// ReflogExists reports whether ref has a reflog, even an empty one.
+func ReflogExists(ref string) bool {
+	refs, err := ReflogRefs()
+	if err != nil {
+		return false
+	}
+	for _, r := range refs {
+		if r == ref {
+			return true
+		}
+	}
+	return false
+}
+
+func (filesBackend) reflogRefs() ([]string, error) {
+	refs := []string(nil)
+	err := filepath.Walk(".git/logs", func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.IsDir() && !strings.HasSuffix(p, ".lock") {
+			refs = append(refs, filepath.ToSlash(p[len(".git/logs/"):]))
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// approxidate parses the dates git accepts in reflog selectors and expiry
+// times: absolute dates, "now", "never", "yesterday" and relative dates such
+// as "2.weeks.ago" or "3 hours ago".
+func approxidate(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	switch s {
+	case "now", "all":
+		return now, nil
+	case "never", "false":
+		return time.Time{}, nil
+	case "yesterday":
+		return now.AddDate(0, 0, -1), nil
+	}
+	fields := strings.Fields(strings.Replace(s, ".", " ", -1))
+	if len(fields) >= 2 && fields[len(fields)-1] == "ago" {
+		t := now
+		for i := 0; i+1 < len(fields)-1; i += 2 {
+			n, err := strconv.Atoi(fields[i])
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid date %q", s)
+			}
+			switch strings.TrimSuffix(fields[i+1], "s") {
+			case "second":
+				t = t.Add(-time.Duration(n) * time.Second)
+			case "minute":
+				t = t.Add(-time.Duration(n) * time.Minute)
+			case "hour":
+				t = t.Add(-time.Duration(n) * time.Hour)
+			case "day":
+				t = t.AddDate(0, 0, -n)
+			case "week":
+				t = t.AddDate(0, 0, -7*n)
+			case "month":
+				t = t.AddDate(0, -n, 0)
+			case "year":
+				t = t.AddDate(-n, 0, 0)
+			default:
+				return time.Time{}, fmt.Errorf("invalid date %q", s)
+			}
+		}
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return parseDate(s)
+}
+
+// ApproxiDate parses a date the way reflog selectors and expiry options do,
+// with relative dates counted back from now.
+func ApproxiDate(s string) (time.Time, error) {
+	return approxidate(s, time.Now())
+}
+
+// resolveReflog resolves the selector sel of ref@{sel}: either the nth
+// previous value of ref or its value at a date.
+func resolveReflog(ref, sel string) (string, error) {
+	entries, err := ReadReflog(ref)
+	if err != nil {
+		return "", err
+	}
+	if n, err := strconv.Atoi(sel); err == nil {
+		if n < 0 || n >= len(entries) {
+			return "", fmt.Errorf("log for '%s' only has %d entries", ref, len(entries))
+		}
+		return entries[len(entries)-1-n].New, nil
+	}
+	t, err := approxidate(sel, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("log for '%s' is empty", ref)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].Who.When.After(t) {
+			return entries[i].New, nil
+		}
+	}
+	// t is before the reflog starts, so the best answer is where it began.
+	if old := entries[0].Old; old != ZeroHash {
+		return old, nil
+	}
+	return entries[0].New, nil
+}
+
+// PreviousBranch returns the branch, or commit if HEAD was detached, that was
+// checked out n switches ago, as named by @{-n}.
+func PreviousBranch(n int) (string, error) {
+	entries, err := ReadReflog("HEAD")
+	if err != nil {
+		return "", err
+	}
+	const prefix = "checkout: moving from "
+	for i := len(entries) - 1; i >= 0; i-- {
+		msg := entries[i].Message
+		if !strings.HasPrefix(msg, prefix) {
+			continue
+		}
+		if n--; n == 0 {
+			from := msg[len(prefix):]
+			if to := strings.LastIndex(from, " to "); to != -1 {
+				from = from[:to]
+			}
+			return from, nil
+		}
+	}
+	return "", fmt.Errorf("no previous branch")
+}
@@ -0,0 +1,116 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApproxidate(t *testing.T) {
+	now := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		s    string
+		want time.Time
+	}{
+		{"now", now},
+		{"yesterday", now.AddDate(0, 0, -1)},
+		{"2.weeks.ago", now.AddDate(0, 0, -14)},
+		{"3 hours ago", now.Add(-3 * time.Hour)},
+		{"1 day 2 hours ago", now.AddDate(0, 0, -1).Add(-2 * time.Hour)},
+		{"never", time.Time{}},
+		{"1398372819 -0700", time.Unix(1398372819, 0)},
+	} {
+		got, err := approxidate(c.s, now)
+		if err != nil || !got.Equal(c.want) {
+			t.Errorf("approxidate(%q) = %v, %v, want %v", c.s, got, err, c.want)
+		}
+	}
+	if _, err := approxidate("3 fortnights ago", now); err == nil {
+		t.Errorf("expected an error for an unknown unit")
+	}
+}
+
+func TestReflog(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	tree := makeTree(t, map[string]string{"a": "a\n"})
+	commits := []string(nil)
+	parents := []string(nil)
+	for i := 0; i < 3; i++ {
+		c, err := CommitTree(tree, parents, who, who, "commit\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, c)
+		parents = []string{c}
+		who.When = who.When.Add(time.Hour)
+		if err := UpdateRef("refs/heads/master", c, "", who, "commit: commit"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := ReadReflog("refs/heads/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Old != ZeroHash || entries[2].New != commits[2] ||
+		entries[1].Message != "commit: commit" || entries[1].Who.Email != "author@example.com" {
+		t.Fatalf("unexpected reflog %+v", entries)
+	}
+
+	for _, c := range []struct{ rev, want string }{
+		{"master@{0}", commits[2]},
+		{"master@{2}", commits[0]},
+		{"@{1}", commits[1]},
+		{"HEAD@{1}", commits[1]},
+		{"master@{1}~1", commits[0]},
+		{"master@{2014-04-24 23:00:00 +0000}", commits[1]},
+		{"master@{2000-01-01}", commits[0]},
+	} {
+		got, err := ResolveRevision(c.rev)
+		if err != nil || got != c.want {
+			t.Errorf("ResolveRevision(%q) = %s, %v, want %s", c.rev, got, err, c.want)
+		}
+	}
+	if _, err := ResolveRevision("master@{3}"); err == nil {
+		t.Errorf("expected an error past the end of the reflog")
+	}
+
+	if err := DeleteReflogEntry("refs/heads/master", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ResolveRevision("master@{1}"); got != commits[0] {
+		t.Errorf("master@{1} after delete is %s, want %s", got, commits[0])
+	}
+	n, err := ExpireReflog("refs/heads/master", time.Unix(1398372819, 0).Add(90*time.Minute), time.Time{}, false)
+	if err != nil || n != 1 {
+		t.Errorf("ExpireReflog dropped %d, %v, want 1", n, err)
+	}
+	if entries, _ := ReadReflog("refs/heads/master"); len(entries) != 1 {
+		t.Errorf("%d entries left after expiry, want 1", len(entries))
+	}
+}
+
+func TestPreviousBranch(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	tree := makeTree(t, map[string]string{"a": "a\n"})
+	c, _ := CommitTree(tree, nil, who, who, "commit\n")
+	UpdateRef("refs/heads/master", c, "", who, "commit (initial): commit")
+	UpdateRef("refs/heads/topic", c, "", who, "branch: Created from master")
+	SetHEAD("refs/heads/topic", who, "checkout: moving from master to topic")
+	SetHEAD(c, who, "checkout: moving from topic to "+c)
+	for n, want := range map[int]string{1: "topic", 2: "master"} {
+		if got, err := PreviousBranch(n); err != nil || got != want {
+			t.Errorf("PreviousBranch(%d) = %s, %v, want %s", n, got, err, want)
+		}
+	}
+	if got, err := ResolveRevision("@{-1}"); err != nil || got != c {
+		t.Errorf("@{-1} = %s, %v, want %s", got, err, c)
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// refRecord is a ref and its raw value as a backend stores it.
+type refRecord struct {
+	name, value string
+	peeled      string // what an annotated tag points to, if the backend knows
+}
+
+// refBackend stores refs and their reflogs. Ref transactions lock, check and
+// apply their updates through it.
+type refBackend interface {
+	// readRef returns the raw value of the ref name: either a hash, or
+	// "ref: <target>" for a symbolic ref. It returns "" if the ref doesn't
+	// exist.
+	readRef(name string) (string, error)
+	// listRefs returns every ref under refs/, sorted by name.
+	listRefs() ([]refRecord, error)
+	// lockRefs takes whatever locks guard the refs t updates.
+	lockRefs(t *RefTransaction) error
+	// applyUpdates writes the updates and reflog entries of a locked and
+	// checked transaction.
+	applyUpdates(t *RefTransaction, who Signature) error
+	readReflog(ref string) ([]ReflogEntry, error)
+	writeReflog(ref string, entries []ReflogEntry) error
+	reflogRefs() ([]string, error)
+	// packRefs gathers refs into the backend's most compact form.
+	packRefs(all bool) error
+}
+
+// refStore returns the backend the repository keeps its refs in, as chosen
+// by extensions.refStorage.
+func refStore() refBackend {
+	if v, _ := ConfigValue("extensions.refStorage"); v == "reftable" {
+		return reftableBackend{}
+	}
+	return filesBackend{}
+}
+
+// filesBackend keeps each ref in a file under .git/refs, falling back to
+// .git/packed-refs, and reflogs under .git/logs.
+type filesBackend struct{}
+
+// readPackedRefs parses .git/packed-refs.
+func readPackedRefs() ([]refRecord, error) {
+	b, err := readFile(".git/packed-refs")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	refs := []refRecord(nil)
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case len(line) == 0 || line[0] == '#':
+		case line[0] == '^':
+			if len(refs) > 0 {
+				refs[len(refs)-1].peeled = line[1:]
+			}
+		default:
+			if sp := strings.IndexByte(line, ' '); sp != -1 {
+				refs = append(refs, refRecord{name: line[sp+1:], value: line[:sp]})
+			}
+		}
+	}
+	return refs, s.Err()
+}
+
+// packedRef returns the value of name in .git/packed-refs, or "" if it isn't
+// listed there.
+func packedRef(name string) (string, error) {
+	refs, err := readPackedRefs()
+	if err != nil {
+		return "", err
+	}
+	for _, r := range refs {
+		if r.name == name {
+			return r.value, nil
+		}
+	}
+	return "", nil
+}
+
+func (filesBackend) readRef(name string) (string, error) {
+	b, err := readFile(".git/" + name)
+	if os.IsNotExist(err) {
+		return packedRef(name)
+	}
+	if err != nil {
+		// A directory of refs such as refs/remotes/origin isn't a ref itself.
+		if fi, statErr := os.Stat(".git/" + name); statErr == nil && fi.IsDir() {
+			return packedRef(name)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (filesBackend) listRefs() ([]refRecord, error) {
+	packed, err := readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]refRecord)
+	for _, r := range packed {
+		byName[r.name] = r
+	}
+	err = filepath.Walk(".git/refs", func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() || strings.HasSuffix(p, ".lock") {
+			return nil
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(p[len(".git/"):])
+		byName[name] = refRecord{name: name, value: strings.TrimSpace(string(b))}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]refRecord, 0, len(byName))
+	for _, r := range byName {
+		refs = append(refs, r)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].name < refs[j].name })
+	return refs, nil
+}
+
+// readRef returns the raw value of the ref name from the repository's ref
+// backend. Pseudorefs such as ORIG_HEAD are always files.
+func readRef(name string) (string, error) {
+	if name != "HEAD" && !strings.HasPrefix(name, "refs/") {
+		return filesBackend{}.readRef(name)
+	}
+	return refStore().readRef(name)
+}
+
+// ResolveRef follows symbolic refs starting from name, for example "HEAD",
+// and returns the name of the ref it ends at along with the object name
+// stored there. hash is empty if that ref doesn't exist yet, as for HEAD on a
+// branch with no commits.
+func ResolveRef(name string) (ref, hash string, err error) {
+	for depth := 0; depth < 5; depth++ {
+		v, err := readRef(name)
+		if err != nil {
+			return "", "", err
+		}
+		if !strings.HasPrefix(v, refPrefix) {
+			return name, v, nil
+		}
+		name = v[len(refPrefix):]
+	}
+	return "", "", fmt.Errorf("symbolic ref %s nested too deeply", name)
+}
+
+// UpdateRef points ref at newHash and appends an entry to its reflog, and to
+// HEAD's when HEAD refers to ref. If oldHash is not empty the update only
+// happens if ref currently holds oldHash; pass ZeroHash to require that ref
+// does not exist yet.
+func UpdateRef(ref, newHash, oldHash string, who Signature, msg string) error {
+	t := NewRefTransaction()
+	if err := t.Update(ref, newHash, oldHash, msg, false); err != nil {
+		return err
+	}
+	return t.Commit(who)
+}
+
+// SetHEAD points HEAD at target and logs the move in HEAD's reflog. A target
+// under refs/ makes HEAD a symbolic ref to that branch; anything else is an
+// object name and detaches HEAD there.
+func SetHEAD(target string, who Signature, msg string) error {
+	t := NewRefTransaction()
+	err := error(nil)
+	if strings.HasPrefix(target, "refs/") {
+		err = t.UpdateSymref("HEAD", target, msg)
+	} else {
+		err = t.Update("HEAD", target, "", msg, true)
+	}
+	if err != nil {
+		return err
+	}
+	return t.Commit(who)
+}
+
+// RenameRef renames oldRef to newRef, moving its reflog along and logging
+// msg there, and repoints HEAD if it referred to oldRef. Unless force is set
+// newRef must not exist yet; if it does, it is deleted first.
+func RenameRef(oldRef, newRef string, force bool, who Signature, msg string) error {
+	hash, err := readRef(oldRef)
+	if err != nil {
+		return err
+	}
+	switch {
+	case hash == "":
+		return fmt.Errorf("no such ref: %s", oldRef)
+	case strings.HasPrefix(hash, refPrefix):
+		return fmt.Errorf("cannot rename symbolic ref %s", oldRef)
+	case oldRef == newRef:
+		return nil
+	}
+	if force {
+		if v, err := readRef(newRef); err != nil {
+			return err
+		} else if v != "" {
+			t := NewRefTransaction()
+			if err := t.Delete(newRef, "", "", true); err != nil {
+				return err
+			}
+			if err := t.Commit(who); err != nil {
+				return err
+			}
+		}
+	}
+	t := NewRefTransaction()
+	if err := t.Delete(oldRef, hash, "", true); err != nil {
+		return err
+	}
+	if err := t.add(&refUpdate{ref: newRef, newValue: hash, oldHash: ZeroHash, msg: msg, noDeref: true, renamedFrom: oldRef}); err != nil {
+		return err
+	}
+	if head, err := readRef("HEAD"); err != nil {
+		return err
+	} else if head == refPrefix+oldRef {
+		if err := t.UpdateSymref("HEAD", newRef, msg); err != nil {
+			return err
+		}
+	}
+	return t.Commit(who)
+}
@@ -0,0 +1,744 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A reftable stores refs and reflog entries in sorted, prefix-compressed
+// blocks, as described in git's Documentation/technical/reftable.txt. Only
+// version 1 tables with ref, index and log blocks are written; readers skip
+// the optional object blocks.
+
+const (
+	reftableHeaderLen       = 24
+	reftableFooterLen       = 68
+	reftableBlockSize       = 4096
+	reftableRestartInterval = 16
+	reftableMinIndexBlocks  = 4 // fewer ref blocks than this are scanned instead
+)
+
+const (
+	reftableRefBlock   = 'r'
+	reftableIndexBlock = 'i'
+	reftableLogBlock   = 'g'
+)
+
+// putVarint appends v in the varint encoding git uses for pack offsets:
+// big-endian groups of 7 bits where each continuation adds one, so that no
+// value has two encodings.
+func putVarint(b []byte, v uint64) []byte {
+	var tmp [10]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(v & 0x7f)
+	for v >>= 7; v != 0; v >>= 7 {
+		v--
+		i--
+		tmp[i] = 0x80 | byte(v&0x7f)
+	}
+	return append(b, tmp[i:]...)
+}
+
+// getVarint decodes the varint at the start of b. It returns the value and
+// the number of bytes it took, or 0 bytes if b is truncated.
+func getVarint(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	v, n := uint64(b[0]&0x7f), 1
+	for b[n-1]&0x80 != 0 {
+		if n == len(b) {
+			return 0, 0
+		}
+		v = (v+1)<<7 | uint64(b[n]&0x7f)
+		n++
+	}
+	return v, n
+}
+
+func putUint24(b []byte, v int) []byte {
+	return append(b, byte(v>>16), byte(v>>8), byte(v))
+}
+
+func getUint24(b []byte) int {
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+}
+
+func putString(b []byte, s string) []byte {
+	return append(putVarint(b, uint64(len(s))), s...)
+}
+
+func getString(b []byte) (string, int, error) {
+	l, n := getVarint(b)
+	if n == 0 || uint64(len(b)-n) < l {
+		return "", 0, errCorruptReftable
+	}
+	return string(b[n : n+int(l)]), n + int(l), nil
+}
+
+var errCorruptReftable = fmt.Errorf("corrupt reftable")
+
+// reftableRef is a ref record. A record with an empty value is a deletion,
+// which hides the ref in older tables of a stack.
+type reftableRef struct {
+	name        string
+	updateIndex uint64
+	value       string // hash, or "ref: <target>" for a symbolic ref
+	peeled      string // what an annotated tag points to
+}
+
+func (r reftableRef) encode(min uint64) (byte, []byte, error) {
+	b := putVarint(nil, r.updateIndex-min)
+	switch {
+	case r.value == "":
+		return 0, b, nil
+	case strings.HasPrefix(r.value, refPrefix):
+		return 3, putString(b, r.value[len(refPrefix):]), nil
+	}
+	id, err := hex.DecodeString(r.value)
+	if err != nil || len(id) != 20 {
+		return 0, nil, fmt.Errorf("bad value %q for ref %s", r.value, r.name)
+	}
+	b = append(b, id...)
+	if r.peeled == "" {
+		return 1, b, nil
+	}
+	if id, err = hex.DecodeString(r.peeled); err != nil || len(id) != 20 {
+		return 0, nil, fmt.Errorf("bad peeled value %q for ref %s", r.peeled, r.name)
+	}
+	return 2, append(b, id...), nil
+}
+
+func decodeReftableRef(key string, typ byte, v []byte, min uint64) (reftableRef, int, error) {
+	delta, n := getVarint(v)
+	if n == 0 {
+		return reftableRef{}, 0, errCorruptReftable
+	}
+	r := reftableRef{name: key, updateIndex: min + delta}
+	switch typ {
+	case 0:
+	case 1, 2:
+		end := n + 20*int(typ)
+		if len(v) < end {
+			return r, 0, errCorruptReftable
+		}
+		r.value = hex.EncodeToString(v[n : n+20])
+		if typ == 2 {
+			r.peeled = hex.EncodeToString(v[n+20 : end])
+		}
+		n = end
+	case 3:
+		target, m, err := getString(v[n:])
+		if err != nil {
+			return r, 0, err
+		}
+		r.value = refPrefix + target
+		n += m
+	default:
+		return r, 0, errCorruptReftable
+	}
+	return r, n, nil
+}
+
+// reftableLog is a reflog record, keyed by its ref and entry.updateIndex. A
+// deleted record hides the entry with the same key in older tables.
+type reftableLog struct {
+	ref     string
+	entry   ReflogEntry
+	deleted bool
+}
+
+// key sorts log records by ref, newest entry first.
+func (l reftableLog) key() string {
+	var idx [8]byte
+	binary.BigEndian.PutUint64(idx[:], math.MaxUint64-l.entry.updateIndex)
+	return l.ref + "\x00" + string(idx[:])
+}
+
+func (l reftableLog) encode() (byte, []byte, error) {
+	if l.deleted {
+		return 0, nil, nil
+	}
+	e := l.entry
+	b := []byte(nil)
+	for _, h := range []string{e.Old, e.New} {
+		id, err := hex.DecodeString(h)
+		if err != nil || len(id) != 20 {
+			return 0, nil, fmt.Errorf("bad reflog entry for %s: %q", l.ref, h)
+		}
+		b = append(b, id...)
+	}
+	b = putString(b, e.Who.Name)
+	b = putString(b, e.Who.Email)
+	b = putVarint(b, uint64(e.Who.When.Unix()))
+	_, offset := e.Who.When.Zone()
+	b = append(b, 0, 0)
+	binary.BigEndian.PutUint16(b[len(b)-2:], uint16(int16(offset/60)))
+	msg := e.Message
+	if msg != "" {
+		msg += "\n"
+	}
+	return 1, putString(b, msg), nil
+}
+
+func decodeReftableLog(key string, typ byte, v []byte) (reftableLog, int, error) {
+	l := reftableLog{}
+	if len(key) < 9 || key[len(key)-9] != 0 {
+		return l, 0, errCorruptReftable
+	}
+	l.ref = key[:len(key)-9]
+	l.entry.updateIndex = math.MaxUint64 - binary.BigEndian.Uint64([]byte(key[len(key)-8:]))
+	switch typ {
+	case 0:
+		l.deleted = true
+		return l, 0, nil
+	case 1:
+	default:
+		return l, 0, errCorruptReftable
+	}
+	if len(v) < 40 {
+		return l, 0, errCorruptReftable
+	}
+	l.entry.Old, l.entry.New = hex.EncodeToString(v[:20]), hex.EncodeToString(v[20:40])
+	n := 40
+	for _, s := range []*string{&l.entry.Who.Name, &l.entry.Who.Email} {
+		str, m, err := getString(v[n:])
+		if err != nil {
+			return l, 0, err
+		}
+		*s, n = str, n+m
+	}
+	sec, m := getVarint(v[n:])
+	if m == 0 || len(v) < n+m+2 {
+		return l, 0, errCorruptReftable
+	}
+	n += m
+	tz := int(int16(binary.BigEndian.Uint16(v[n:])))
+	n += 2
+	l.entry.Who.When = time.Unix(int64(sec), 0).In(time.FixedZone("", tz*60))
+	msg, m, err := getString(v[n:])
+	if err != nil {
+		return l, 0, err
+	}
+	l.entry.Message = strings.TrimSuffix(msg, "\n")
+	return l, n + m, nil
+}
+
+func reftableHeader(blockSize int, min, max uint64) []byte {
+	b := []byte{'R', 'E', 'F', 'T', 1}
+	b = putUint24(b, blockSize)
+	b = append(b, make([]byte, 16)...)
+	binary.BigEndian.PutUint64(b[8:], min)
+	binary.BigEndian.PutUint64(b[16:], max)
+	return b
+}
+
+// reftableWriter lays out a table in memory, one block at a time.
+type reftableWriter struct {
+	out       bytes.Buffer
+	blockSize int
+	min, max  uint64
+
+	typ      byte
+	start    int    // file offset of the current block
+	block    []byte // the current block, from its type byte
+	restarts []int
+	lastKey  string
+	count    int
+
+	refBlocks []reftableIndexEntry
+}
+
+type reftableIndexEntry struct {
+	lastKey string
+	pos     int
+}
+
+// headerLen is the size of the file header the current block starts with;
+// the first block of a table includes it.
+func (w *reftableWriter) headerLen() int {
+	if w.start == 0 {
+		return reftableHeaderLen
+	}
+	return 0
+}
+
+func (w *reftableWriter) begin(typ byte) {
+	w.typ, w.start = typ, w.out.Len()
+	if w.start == reftableHeaderLen {
+		w.start = 0
+	}
+	w.block = append(w.block[:0], typ, 0, 0, 0)
+	w.restarts, w.lastKey, w.count = w.restarts[:0], "", 0
+}
+
+// encodeRecord encodes a record as the next one in the current block.
+// Every reftableRestartInterval records the key is written in full, as a
+// restart point readers can binary search.
+func (w *reftableWriter) encodeRecord(key string, typ byte, value []byte) ([]byte, bool) {
+	restart := w.count%reftableRestartInterval == 0
+	prefix := 0
+	if !restart {
+		for prefix < len(key) && prefix < len(w.lastKey) && key[prefix] == w.lastKey[prefix] {
+			prefix++
+		}
+	}
+	b := putVarint(nil, uint64(prefix))
+	b = putVarint(b, uint64(len(key)-prefix)<<3|uint64(typ))
+	b = append(b, key[prefix:]...)
+	return append(b, value...), restart
+}
+
+// add appends a record to a block of type blockType, starting a new block
+// when the current one is full or of another type. With limit unset blocks
+// may grow past blockSize.
+func (w *reftableWriter) add(blockType byte, key string, typ byte, value []byte, limit bool) error {
+	if w.block != nil && w.typ != blockType {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	if w.block == nil {
+		w.begin(blockType)
+	}
+	rec, restart := w.encodeRecord(key, typ, value)
+	fits := func() bool {
+		restarts := len(w.restarts)
+		if restart {
+			restarts++
+		}
+		return !limit || w.headerLen()+len(w.block)+len(rec)+3*restarts+2 <= w.blockSize
+	}
+	if !fits() && w.count > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+		w.begin(blockType)
+		rec, restart = w.encodeRecord(key, typ, value)
+	}
+	if !fits() {
+		return fmt.Errorf("reftable record for %q does not fit in a block", key)
+	}
+	if restart {
+		w.restarts = append(w.restarts, w.headerLen()+len(w.block))
+	}
+	w.block = append(w.block, rec...)
+	w.lastKey = key
+	w.count++
+	return nil
+}
+
+// flush finishes the current block with its restart table and writes it out:
+// log blocks compressed, and other blocks padded to the block size.
+func (w *reftableWriter) flush() error {
+	if w.block == nil {
+		return nil
+	}
+	for _, r := range w.restarts {
+		w.block = putUint24(w.block, r)
+	}
+	w.block = append(w.block, byte(len(w.restarts)>>8), byte(len(w.restarts)))
+	size := w.headerLen() + len(w.block)
+	copy(w.block[1:4], putUint24(nil, size))
+	if w.typ == reftableLogBlock {
+		w.out.Write(w.block[:4])
+		z := zlib.NewWriter(&w.out)
+		if _, err := z.Write(w.block[4:]); err != nil {
+			return err
+		}
+		if err := z.Close(); err != nil {
+			return err
+		}
+	} else {
+		w.out.Write(w.block)
+		if size < w.blockSize {
+			w.out.Write(make([]byte, w.blockSize-size))
+		}
+	}
+	if w.typ == reftableRefBlock {
+		w.refBlocks = append(w.refBlocks, reftableIndexEntry{w.lastKey, w.start})
+	}
+	w.block = nil
+	return nil
+}
+
+// writeIndex writes an index of the ref blocks and returns its position, or 0
+// if the table is too small to need one. An index that doesn't fit in one
+// block is left out; readers then scan the ref blocks.
+func (w *reftableWriter) writeIndex() (int, error) {
+	if len(w.refBlocks) < reftableMinIndexBlocks {
+		return 0, nil
+	}
+	pos := w.out.Len()
+	for _, e := range w.refBlocks {
+		if err := w.add(reftableIndexBlock, e.lastKey, 0, putVarint(nil, uint64(e.pos)), false); err != nil {
+			return 0, err
+		}
+	}
+	if err := w.flush(); err != nil {
+		return 0, err
+	}
+	if w.out.Len()-pos > w.blockSize {
+		w.out.Truncate(pos)
+		return 0, nil
+	}
+	return pos, nil
+}
+
+// writeReftable encodes a table of refs and logs covering the update indexes
+// min to max.
+func writeReftable(refs []reftableRef, logs []reftableLog, min, max uint64) ([]byte, error) {
+	refs = append([]reftableRef(nil), refs...)
+	sort.Slice(refs, func(i, j int) bool { return refs[i].name < refs[j].name })
+	logs = append([]reftableLog(nil), logs...)
+	sort.Slice(logs, func(i, j int) bool { return logs[i].key() < logs[j].key() })
+
+	w := &reftableWriter{blockSize: reftableBlockSize, min: min, max: max}
+	w.out.Write(reftableHeader(w.blockSize, min, max))
+	for _, r := range refs {
+		if r.updateIndex < min || r.updateIndex > max {
+			return nil, fmt.Errorf("ref %s has update index %d outside %d-%d", r.name, r.updateIndex, min, max)
+		}
+		typ, v, err := r.encode(min)
+		if err != nil {
+			return nil, err
+		}
+		if err := w.add(reftableRefBlock, r.name, typ, v, true); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	indexPos, err := w.writeIndex()
+	if err != nil {
+		return nil, err
+	}
+	logPos := w.out.Len()
+	if logPos == reftableHeaderLen {
+		logPos = 0
+	}
+	for _, l := range logs {
+		typ, v, err := l.encode()
+		if err != nil {
+			return nil, err
+		}
+		if err := w.add(reftableLogBlock, l.key(), typ, v, true); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	if len(logs) == 0 {
+		logPos = 0
+	}
+
+	footer := reftableHeader(w.blockSize, min, max)
+	for _, v := range []uint64{uint64(indexPos), 0, 0, uint64(logPos), 0} {
+		footer = append(footer, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(footer[len(footer)-8:], v)
+	}
+	footer = append(footer, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(footer[len(footer)-4:], crc32.ChecksumIEEE(footer[:len(footer)-4]))
+	w.out.Write(footer)
+	return w.out.Bytes(), nil
+}
+
+// reftable is a parsed table held in memory.
+type reftable struct {
+	b           []byte
+	min, max    uint64
+	end         int // where the footer starts
+	refIndexPos int
+	logPos      int
+	hasLogs     bool
+}
+
+func parseReftable(b []byte) (*reftable, error) {
+	if len(b) < reftableHeaderLen+reftableFooterLen || string(b[:4]) != "REFT" {
+		return nil, fmt.Errorf("not a reftable")
+	}
+	if b[4] != 1 {
+		return nil, fmt.Errorf("unsupported reftable version %d", b[4])
+	}
+	f := b[len(b)-reftableFooterLen:]
+	if !bytes.Equal(f[:reftableHeaderLen], b[:reftableHeaderLen]) ||
+		crc32.ChecksumIEEE(f[:len(f)-4]) != binary.BigEndian.Uint32(f[len(f)-4:]) {
+		return nil, errCorruptReftable
+	}
+	t := &reftable{
+		b:           b,
+		min:         binary.BigEndian.Uint64(b[8:]),
+		max:         binary.BigEndian.Uint64(b[16:]),
+		end:         len(b) - reftableFooterLen,
+		refIndexPos: int(binary.BigEndian.Uint64(f[24:])),
+		logPos:      int(binary.BigEndian.Uint64(f[48:])),
+	}
+	// A table without refs starts with its logs, at position 0.
+	t.hasLogs = t.logPos != 0 || (t.end > reftableHeaderLen && b[reftableHeaderLen] == reftableLogBlock)
+	return t, nil
+}
+
+// reftableBlock is a block's records and restart points.
+type reftableBlock struct {
+	typ      byte
+	data     []byte // from the start of the block to its restart table
+	start    int    // offset of the first record in data
+	restarts []int
+	next     int // file offset of the next block, including padding
+}
+
+// blockType returns the type of the block at off, or 0 at the end of the
+// table.
+func (t *reftable) blockType(off int) byte {
+	if off == 0 {
+		off = reftableHeaderLen
+	}
+	if off >= t.end {
+		return 0
+	}
+	return t.b[off]
+}
+
+func (t *reftable) block(off int) (*reftableBlock, error) {
+	hdr := 0
+	if off == 0 {
+		hdr = reftableHeaderLen
+	}
+	if off+hdr+4 > t.end {
+		return nil, errCorruptReftable
+	}
+	blk := &reftableBlock{typ: t.b[off+hdr], start: hdr + 4}
+	size := getUint24(t.b[off+hdr+1:])
+	if size < blk.start+2 {
+		return nil, errCorruptReftable
+	}
+	if blk.typ == reftableLogBlock {
+		r := bytes.NewReader(t.b[off+blk.start : t.end])
+		z, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		inflated, err := ioutil.ReadAll(z)
+		if err != nil {
+			return nil, err
+		}
+		if blk.start+len(inflated) != size {
+			return nil, errCorruptReftable
+		}
+		blk.data = append(append([]byte(nil), t.b[off:off+blk.start]...), inflated...)
+		blk.next = t.end - r.Len()
+	} else {
+		if off+size > t.end {
+			return nil, errCorruptReftable
+		}
+		blk.data = t.b[off : off+size]
+		blk.next = off + size
+	}
+	for blk.next < t.end && t.b[blk.next] == 0 {
+		blk.next++
+	}
+	n := int(binary.BigEndian.Uint16(blk.data[size-2:]))
+	table := size - 2 - 3*n
+	if table < blk.start {
+		return nil, errCorruptReftable
+	}
+	for i := 0; i < n; i++ {
+		blk.restarts = append(blk.restarts, getUint24(blk.data[table+3*i:]))
+	}
+	blk.data = blk.data[:table]
+	return blk, nil
+}
+
+// reftableCursor reads the records of a block in order.
+type reftableCursor struct {
+	b   *reftableBlock
+	pos int
+	key string
+}
+
+// next decodes the key of the record at the cursor and passes it with the
+// record's value type and the bytes after the key to value, which returns
+// how many of them the value takes. At the end of the block next returns
+// false.
+func (c *reftableCursor) next(value func(key string, typ byte, v []byte) (int, error)) (bool, error) {
+	data := c.b.data
+	if c.pos >= len(data) {
+		return false, nil
+	}
+	prefix, n := getVarint(data[c.pos:])
+	if n == 0 {
+		return false, errCorruptReftable
+	}
+	p := c.pos + n
+	st, n := getVarint(data[p:])
+	if n == 0 {
+		return false, errCorruptReftable
+	}
+	p += n
+	suffix := int(st >> 3)
+	if prefix > uint64(len(c.key)) || p+suffix > len(data) {
+		return false, errCorruptReftable
+	}
+	key := c.key[:prefix] + string(data[p:p+suffix])
+	p += suffix
+	used, err := value(key, byte(st&7), data[p:])
+	if err != nil {
+		return false, err
+	}
+	c.pos, c.key = p+used, key
+	return true, nil
+}
+
+// seek returns a cursor at the last restart point whose key is not after
+// key, so that scanning from it finds key if the block has it.
+func (b *reftableBlock) seek(key string) *reftableCursor {
+	restartKey := func(i int) string {
+		c := &reftableCursor{b: b, pos: b.restarts[i]}
+		k := ""
+		c.next(func(key string, _ byte, _ []byte) (int, error) {
+			k = key
+			return 0, nil
+		})
+		return k
+	}
+	i := sort.Search(len(b.restarts), func(i int) bool { return restartKey(i) > key })
+	if i == 0 {
+		return &reftableCursor{b: b, pos: b.start}
+	}
+	return &reftableCursor{b: b, pos: b.restarts[i-1]}
+}
+
+// refs returns every ref record in the table, deletions included.
+func (t *reftable) refs() ([]reftableRef, error) {
+	refs := []reftableRef(nil)
+	for off := 0; t.blockType(off) == reftableRefBlock; {
+		blk, err := t.block(off)
+		if err != nil {
+			return nil, err
+		}
+		c := &reftableCursor{b: blk, pos: blk.start}
+		for {
+			ok, err := c.next(func(key string, typ byte, v []byte) (int, error) {
+				r, n, err := decodeReftableRef(key, typ, v, t.min)
+				refs = append(refs, r)
+				return n, err
+			})
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+		}
+		off = blk.next
+	}
+	return refs, nil
+}
+
+// ref looks up name, using the index if the table has one. It reports false
+// if the table has no record for name.
+func (t *reftable) ref(name string) (reftableRef, bool, error) {
+	off := 0
+	if t.refIndexPos != 0 {
+		idx, err := t.block(t.refIndexPos)
+		if err != nil {
+			return reftableRef{}, false, err
+		}
+		found := false
+		c := idx.seek(name)
+		for !found {
+			ok, err := c.next(func(key string, _ byte, v []byte) (int, error) {
+				pos, n := getVarint(v)
+				if n == 0 {
+					return 0, errCorruptReftable
+				}
+				if key >= name {
+					off, found = int(pos), true
+				}
+				return n, nil
+			})
+			if err != nil {
+				return reftableRef{}, false, err
+			}
+			if !ok {
+				return reftableRef{}, false, nil
+			}
+		}
+	}
+	for t.blockType(off) == reftableRefBlock {
+		blk, err := t.block(off)
+		if err != nil {
+			return reftableRef{}, false, err
+		}
+		c := blk.seek(name)
+		for {
+			var r reftableRef
+			ok, err := c.next(func(key string, typ byte, v []byte) (int, error) {
+				var n int
+				var err error
+				r, n, err = decodeReftableRef(key, typ, v, t.min)
+				return n, err
+			})
+			if err != nil {
+				return reftableRef{}, false, err
+			}
+			if !ok {
+				break
+			}
+			if r.name == name {
+				return r, true, nil
+			}
+			if r.name > name {
+				return reftableRef{}, false, nil
+			}
+		}
+		off = blk.next
+	}
+	return reftableRef{}, false, nil
+}
+
+// logs returns every log record in the table, deletions included.
+func (t *reftable) logs() ([]reftableLog, error) {
+	if !t.hasLogs {
+		return nil, nil
+	}
+	logs := []reftableLog(nil)
+	for off := t.logPos; t.blockType(off) == reftableLogBlock; {
+		blk, err := t.block(off)
+		if err != nil {
+			return nil, err
+		}
+		c := &reftableCursor{b: blk, pos: blk.start}
+		for {
+			ok, err := c.next(func(key string, typ byte, v []byte) (int, error) {
+				l, n, err := decodeReftableLog(key, typ, v)
+				logs = append(logs, l)
+				return n, err
+			})
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+		}
+		off = blk.next
+	}
+	return logs, nil
+}
@@ -0,0 +1,402 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+)
+
+const reftableDir = ".git/reftable/"
+
+// reftableBackend keeps refs and reflogs in a stack of reftables under
+// .git/reftable, listed oldest first in tables.list. Newer tables override
+// older ones. Every transaction adds a table, and the small tables that
+// accumulate at the top of the stack are merged geometrically.
+type reftableBackend struct{}
+
+type reftableStack struct {
+	names  []string
+	tables []*reftable
+}
+
+func readReftableStack() (*reftableStack, error) {
+	s := &reftableStack{}
+	b, err := readFile(reftableDir + "tables.list")
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(string(b), "\n") {
+		if name == "" {
+			continue
+		}
+		data, err := readFile(reftableDir + name)
+		if err != nil {
+			return nil, err
+		}
+		t, err := parseReftable(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		s.names = append(s.names, name)
+		s.tables = append(s.tables, t)
+	}
+	return s, nil
+}
+
+func (s *reftableStack) nextUpdateIndex() uint64 {
+	if len(s.tables) == 0 {
+		return 1
+	}
+	return s.tables[len(s.tables)-1].max + 1
+}
+
+// mergedRefs returns the refs of the tables from from on, sorted by name,
+// with newer records replacing older ones. Deletions are kept only if
+// keepDeletions is set.
+func (s *reftableStack) mergedRefs(from int, keepDeletions bool) ([]reftableRef, error) {
+	byName := make(map[string]reftableRef)
+	for _, t := range s.tables[from:] {
+		refs, err := t.refs()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range refs {
+			byName[r.name] = r
+		}
+	}
+	refs := []reftableRef(nil)
+	for _, r := range byName {
+		if r.value != "" || keepDeletions {
+			refs = append(refs, r)
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].name < refs[j].name })
+	return refs, nil
+}
+
+// mergedLogs is mergedRefs for log records, which it returns sorted by key.
+func (s *reftableStack) mergedLogs(from int, keepDeletions bool) ([]reftableLog, error) {
+	byKey := make(map[string]reftableLog)
+	for _, t := range s.tables[from:] {
+		logs, err := t.logs()
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range logs {
+			byKey[l.key()] = l
+		}
+	}
+	logs := []reftableLog(nil)
+	for _, l := range byKey {
+		if !l.deleted || keepDeletions {
+			logs = append(logs, l)
+		}
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i].key() < logs[j].key() })
+	return logs, nil
+}
+
+func (s *reftableStack) readReflog(ref string) ([]ReflogEntry, error) {
+	logs, err := s.mergedLogs(0, false)
+	if err != nil {
+		return nil, err
+	}
+	entries := []ReflogEntry(nil)
+	for i := len(logs) - 1; i >= 0; i-- {
+		if logs[i].ref == ref {
+			entries = append(entries, logs[i].entry)
+		}
+	}
+	return entries, nil
+}
+
+// writeReftableFile writes a new table file and returns its name.
+func writeReftableFile(b []byte, min, max uint64) (string, *reftable, error) {
+	t, err := parseReftable(b)
+	if err != nil {
+		return "", nil, err
+	}
+	name := fmt.Sprintf("0x%012x-0x%012x-%08x.ref", min, max, rand.Uint32())
+	l, err := lock(reftableDir + name)
+	if err != nil {
+		return "", nil, err
+	}
+	defer l.rollback()
+	if _, err := l.Write(b); err != nil {
+		return "", nil, err
+	}
+	return name, t, l.commit()
+}
+
+// compact replaces the tables from start on with a single table. Deletions
+// survive unless nothing older is left for them to hide. It returns the
+// names of the tables it replaced.
+func (s *reftableStack) compact(start int) ([]string, error) {
+	keepDeletions := start > 0
+	refs, err := s.mergedRefs(start, keepDeletions)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := s.mergedLogs(start, keepDeletions)
+	if err != nil {
+		return nil, err
+	}
+	min, max := s.tables[start].min, s.tables[len(s.tables)-1].max
+	b, err := writeReftable(refs, logs, min, max)
+	if err != nil {
+		return nil, err
+	}
+	name, t, err := writeReftableFile(b, min, max)
+	if err != nil {
+		return nil, err
+	}
+	replaced := append([]string(nil), s.names[start:]...)
+	s.names = append(s.names[:start], name)
+	s.tables = append(s.tables[:start], t)
+	return replaced, nil
+}
+
+// compactionStart returns the first of the tables at the top of the stack
+// that should be merged: those whose combined size isn't yet half that of
+// the table below them. Keeping each table at least twice the size of the
+// ones above it bounds the stack to a logarithmic number of tables.
+func compactionStart(sizes []int) int {
+	start := len(sizes) - 1
+	if start < 0 {
+		return 0
+	}
+	total := sizes[start]
+	for start > 0 && sizes[start-1] < 2*total {
+		start--
+		total += sizes[start]
+	}
+	return start
+}
+
+// add writes a table of refs and logs at update index idx on top of the
+// stack, compacts the stack if needed and commits the new table list to
+// list, the lock on tables.list.
+func (s *reftableStack) add(list *lockFile, refs []reftableRef, logs []reftableLog, idx uint64) error {
+	b, err := writeReftable(refs, logs, idx, idx)
+	if err != nil {
+		return err
+	}
+	name, t, err := writeReftableFile(b, idx, idx)
+	if err != nil {
+		return err
+	}
+	s.names = append(s.names, name)
+	s.tables = append(s.tables, t)
+	sizes := []int(nil)
+	for _, t := range s.tables {
+		sizes = append(sizes, len(t.b))
+	}
+	obsolete := []string(nil)
+	if start := compactionStart(sizes); start < len(s.tables)-1 {
+		if obsolete, err = s.compact(start); err != nil {
+			return err
+		}
+	}
+	if _, err := list.Write([]byte(strings.Join(s.names, "\n") + "\n")); err != nil {
+		return err
+	}
+	if err := list.commit(); err != nil {
+		return err
+	}
+	for _, name := range obsolete {
+		os.Remove(reftableDir + name)
+	}
+	return nil
+}
+
+// lockReftableStack locks tables.list, creating .git/reftable if necessary.
+func lockReftableStack() (*lockFile, error) {
+	if err := os.MkdirAll(reftableDir, 0777); err != nil {
+		return nil, err
+	}
+	return lock(reftableDir + "tables.list")
+}
+
+func (reftableBackend) readRef(name string) (string, error) {
+	s, err := readReftableStack()
+	if err != nil {
+		return "", err
+	}
+	for i := len(s.tables) - 1; i >= 0; i-- {
+		r, ok, err := s.tables[i].ref(name)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", s.names[i], err)
+		}
+		if ok {
+			return r.value, nil
+		}
+	}
+	return "", nil
+}
+
+func (reftableBackend) listRefs() ([]refRecord, error) {
+	s, err := readReftableStack()
+	if err != nil {
+		return nil, err
+	}
+	merged, err := s.mergedRefs(0, false)
+	if err != nil {
+		return nil, err
+	}
+	refs := []refRecord(nil)
+	for _, r := range merged {
+		if strings.HasPrefix(r.name, "refs/") {
+			refs = append(refs, refRecord{name: r.name, value: r.value, peeled: r.peeled})
+		}
+	}
+	return refs, nil
+}
+
+func (reftableBackend) lockRefs(t *RefTransaction) error {
+	var err error
+	if t.stack, err = lockReftableStack(); err != nil {
+		return fmt.Errorf("cannot lock references: %v", err)
+	}
+	return nil
+}
+
+func (reftableBackend) applyUpdates(t *RefTransaction, who Signature) error {
+	s, err := readReftableStack()
+	if err != nil {
+		return err
+	}
+	idx := s.nextUpdateIndex()
+	refs := []reftableRef(nil)
+	logs := []reftableLog(nil)
+	for _, u := range t.updates {
+		switch {
+		case u.verify:
+		case u.delete:
+			refs = append(refs, reftableRef{name: u.ref, updateIndex: idx})
+			old, err := s.readReflog(u.ref)
+			if err != nil {
+				return err
+			}
+			for _, e := range old {
+				logs = append(logs, reftableLog{ref: u.ref, entry: e, deleted: true})
+			}
+		default:
+			refs = append(refs, reftableRef{name: u.ref, updateIndex: idx, value: u.newValue})
+		}
+	}
+	for _, l := range t.logs {
+		if l.copied {
+			logs = append(logs, reftableLog{ref: l.ref, entry: l.entry})
+			continue
+		}
+		if !logsRefByDefault(l.ref) {
+			if old, err := s.readReflog(l.ref); err != nil {
+				return err
+			} else if len(old) == 0 {
+				continue
+			}
+		}
+		e := l.entry
+		e.Who, e.updateIndex = who, idx
+		logs = append(logs, reftableLog{ref: l.ref, entry: e})
+	}
+	return s.add(t.stack, refs, logs, idx)
+}
+
+func (reftableBackend) readReflog(ref string) ([]ReflogEntry, error) {
+	s, err := readReftableStack()
+	if err != nil {
+		return nil, err
+	}
+	return s.readReflog(ref)
+}
+
+// writeReflog drops the entries of ref's reflog that aren't in entries,
+// which must have been read with readReflog, by adding deletions for them.
+func (reftableBackend) writeReflog(ref string, entries []ReflogEntry) error {
+	l, err := lockReftableStack()
+	if err != nil {
+		return err
+	}
+	defer l.rollback()
+	s, err := readReftableStack()
+	if err != nil {
+		return err
+	}
+	old, err := s.readReflog(ref)
+	if err != nil {
+		return err
+	}
+	kept := make(map[uint64]bool)
+	for _, e := range entries {
+		kept[e.updateIndex] = true
+	}
+	logs := []reftableLog(nil)
+	for _, e := range old {
+		if !kept[e.updateIndex] {
+			logs = append(logs, reftableLog{ref: ref, entry: e, deleted: true})
+		}
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+	return s.add(l, nil, logs, s.nextUpdateIndex())
+}
+
+// packRefs compacts the whole stack into a single table.
+func (reftableBackend) packRefs(all bool) error {
+	l, err := lockReftableStack()
+	if err != nil {
+		return err
+	}
+	defer l.rollback()
+	s, err := readReftableStack()
+	if err != nil {
+		return err
+	}
+	if len(s.tables) < 2 {
+		return nil
+	}
+	obsolete, err := s.compact(0)
+	if err != nil {
+		return err
+	}
+	if _, err := l.Write([]byte(strings.Join(s.names, "\n") + "\n")); err != nil {
+		return err
+	}
+	if err := l.commit(); err != nil {
+		return err
+	}
+	for _, name := range obsolete {
+		os.Remove(reftableDir + name)
+	}
+	return nil
+}
+
+func (reftableBackend) reflogRefs() ([]string, error) {
+	s, err := readReftableStack()
+	if err != nil {
+		return nil, err
+	}
+	logs, err := s.mergedLogs(0, false)
+	if err != nil {
+		return nil, err
+	}
+	refs := []string(nil)
+	for _, l := range logs {
+		if len(refs) == 0 || refs[len(refs)-1] != l.ref {
+			refs = append(refs, l.ref)
+		}
+	}
+	return refs, nil
+}
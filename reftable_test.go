@@ -0,0 +1,223 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVarint(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 255, 16511, 16512, 1 << 40, 1<<64 - 1} {
+		b := putVarint(nil, v)
+		got, n := getVarint(b)
+		if got != v || n != len(b) {
+			t.Errorf("varint %d round-tripped to %d using %d of %d bytes", v, got, n, len(b))
+		}
+		if _, n := getVarint(b[:len(b)-1]); n != 0 {
+			t.Errorf("truncated varint %d decoded", v)
+		}
+	}
+	if b := putVarint(nil, 128); !reflect.DeepEqual(b, []byte{0x80, 0x00}) {
+		t.Errorf("128 encoded as %x, want 8000", b)
+	}
+}
+
+func TestReftable(t *testing.T) {
+	hash := func(i int) string { return fmt.Sprintf("%040x", i) }
+	refs := []reftableRef{
+		{name: "HEAD", updateIndex: 3, value: "ref: refs/heads/master"},
+		{name: "refs/heads/gone", updateIndex: 4},
+		{name: "refs/tags/v1", updateIndex: 3, value: hash(1), peeled: hash(2)},
+	}
+	for i := 0; i < 1000; i++ {
+		refs = append(refs, reftableRef{name: fmt.Sprintf("refs/heads/b%04d", i), updateIndex: 3, value: hash(i)})
+	}
+	when := time.Unix(1398372819, 0).In(time.FixedZone("", -7*3600))
+	logs := []reftableLog{
+		{ref: "HEAD", entry: ReflogEntry{Old: ZeroHash, New: hash(1), Message: "one", updateIndex: 3,
+			Who: Signature{Name: "A U Thor", Email: "author@example.com", When: when}}},
+		{ref: "HEAD", entry: ReflogEntry{Old: hash(1), New: hash(2), Message: "two", updateIndex: 4,
+			Who: Signature{Name: "A U Thor", Email: "author@example.com", When: when}}},
+		{ref: "refs/heads/gone", entry: ReflogEntry{updateIndex: 4}, deleted: true},
+	}
+	b, err := writeReftable(refs, logs, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := parseReftable(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.refIndexPos == 0 {
+		t.Errorf("table of %d bytes has no index", len(b))
+	}
+
+	got, err := table.refs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(refs) {
+		t.Fatalf("read %d refs, want %d", len(got), len(refs))
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i-1].name >= got[i].name {
+			t.Fatalf("refs out of order: %s before %s", got[i-1].name, got[i].name)
+		}
+	}
+	for _, want := range refs {
+		r, ok, err := table.ref(want.name)
+		if err != nil || !ok || r != want {
+			t.Errorf("ref(%s) = %+v, %v, %v; want %+v", want.name, r, ok, err, want)
+		}
+	}
+	if _, ok, err := table.ref("refs/heads/b0500x"); ok || err != nil {
+		t.Errorf("found a ref that isn't there: %v %v", ok, err)
+	}
+
+	gotLogs, err := table.logs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotLogs) != 3 || gotLogs[0].entry.Message != "two" || gotLogs[1].entry.Message != "one" || !gotLogs[2].deleted {
+		t.Fatalf("unexpected logs %+v", gotLogs)
+	}
+	if e := gotLogs[1].entry; e.Who.String() != logs[0].entry.Who.String() || e.Old != ZeroHash || e.New != hash(1) {
+		t.Errorf("log entry read back as %+v", e)
+	}
+
+	// A table with only logs starts with its log block.
+	b, err = writeReftable(nil, logs[:1], 5, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table, err = parseReftable(b); err != nil {
+		t.Fatal(err)
+	}
+	if gotLogs, err := table.logs(); err != nil || len(gotLogs) != 1 {
+		t.Errorf("logs-only table read back as %+v, %v", gotLogs, err)
+	}
+
+	b[len(b)-1] ^= 1
+	if _, err := parseReftable(b); err == nil {
+		t.Errorf("table with a bad footer checksum parsed")
+	}
+}
+
+func TestReftableBackend(t *testing.T) {
+	defer withTempRepo(t)()
+	if err := ioutil.WriteFile(".git/config", []byte("[extensions]\n\trefStorage = reftable\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	tree := makeTree(t, map[string]string{"a": "a\n"})
+	one, _ := CommitTree(tree, nil, who, who, "one\n")
+	two, _ := CommitTree(tree, []string{one}, who, who, "two\n")
+
+	if err := SetHEAD("refs/heads/master", who, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRef("HEAD", one, ZeroHash, who, "commit (initial): one"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		tx := NewRefTransaction()
+		tx.Create(fmt.Sprintf("refs/heads/topic%02d", i), one, "branch")
+		tx.Create(fmt.Sprintf("refs/tags/t%02d", i), two, "tag")
+		if err := tx.Commit(who); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := UpdateRef("refs/heads/master", two, one, who, "commit: two"); err != nil {
+		t.Fatal(err)
+	}
+	tx := NewRefTransaction()
+	tx.Delete("refs/heads/topic03", one, "", false)
+	if err := tx.Commit(who); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ioutil.ReadFile(".git/refs/heads/master"); err == nil {
+		t.Errorf("reftable repository wrote a loose ref")
+	}
+	if ref, hash, err := ResolveRef("HEAD"); ref != "refs/heads/master" || hash != two || err != nil {
+		t.Errorf("HEAD resolves to %s %s, %v", ref, hash, err)
+	}
+	if b, err := CurrentBranch(); b != "master" || err != nil {
+		t.Errorf("current branch %q, %v", b, err)
+	}
+	branches, err := ReadBranches()
+	if err != nil || len(branches) != 20 {
+		t.Fatalf("read %d branches, %v; want 20", len(branches), err)
+	}
+	for _, b := range branches {
+		if b.Name == "topic03" {
+			t.Errorf("deleted branch is still listed")
+		}
+	}
+	if v, _ := readRef("refs/heads/topic03"); v != "" {
+		t.Errorf("deleted ref still reads as %q", v)
+	}
+
+	entries, err := ReadReflog("HEAD")
+	if err != nil || len(entries) != 2 || entries[0].Message != "commit (initial): one" || entries[1].New != two {
+		t.Errorf("unexpected HEAD reflog %+v, %v", entries, err)
+	}
+	if ReflogExists("refs/heads/topic03") {
+		t.Errorf("reflog of a deleted branch survived")
+	}
+	if ReflogExists("refs/tags/t00") {
+		t.Errorf("tag updates were logged")
+	}
+	if err := DeleteReflogEntry("HEAD", 0); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := ReadReflog("HEAD"); len(entries) != 1 || entries[0].New != one {
+		t.Errorf("HEAD reflog after delete %+v", entries)
+	}
+
+	// The 23 transactions compacted into a logarithmic number of tables.
+	list, _ := ioutil.ReadFile(".git/reftable/tables.list")
+	names := strings.Fields(string(list))
+	if len(names) == 0 || len(names) > 5 {
+		t.Errorf("stack has %d tables", len(names))
+	}
+	files, _ := ioutil.ReadDir(".git/reftable")
+	if len(files) != len(names)+1 {
+		t.Errorf("%d files in .git/reftable for %d tables", len(files), len(names))
+	}
+	s, err := readReftableStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.compact(0); err != nil {
+		t.Fatal(err)
+	}
+	if refs, _ := s.mergedRefs(0, true); len(refs) != 41 {
+		t.Errorf("fully compacted stack has %d refs, want 41", len(refs))
+	}
+}
+
+func TestCompactionStart(t *testing.T) {
+	for _, c := range []struct {
+		sizes []int
+		want  int
+	}{
+		{[]int{100}, 0},
+		{[]int{400, 200, 100}, 2},
+		{[]int{400, 200, 200}, 0},
+		{[]int{1000, 100, 100}, 1},
+	} {
+		if got := compactionStart(c.sizes); got != c.want {
+			t.Errorf("compactionStart(%v) = %d, want %d", c.sizes, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// ResetMode says how much of the repository Reset changes besides HEAD.
+type ResetMode int
+
+const (
+	// ResetSoft only moves HEAD.
+	ResetSoft ResetMode = iota
+	// ResetMixed also resets the index, leaving the worktree alone.
+	ResetMixed
+	// ResetHard also makes the tracked files in the worktree match.
+	ResetHard
+	// ResetKeep resets the index and updates the files that differ between
+	// HEAD and the new commit, failing if any of those have local changes.
+	ResetKeep
+)
+
+// ResetIndex makes the index entries selected by ps, or all entries if ps is
+// nil, match tree. Entries whose contents don't change keep their stat
+// information so the worktree files aren't rehashed later.
+func ResetIndex(idx *Index, tree string, ps Pathspec) error {
+	files, err := flattenTree(tree)
+	if err != nil {
+		return err
+	}
+	for _, p := range sortedPaths(files, indexFiles(idx)) {
+		if ps != nil && !ps.Match(p) {
+			continue
+		}
+		f, inTree := files[p]
+		e, inIndex := idx.Entry(p)
+		switch {
+		case !inTree:
+			idx.Remove(p)
+		case !inIndex || !f.matches(e):
+			ne := Entry{Mode: f.mode, Hash: f.hash, Path: []byte(p)}
+			if inIndex && e.Hash == f.hash {
+				// Only the mode changed, so the file itself may be clean.
+				ne = e
+				ne.Mode = f.mode
+				ne.ExtendedFlags &^= entryIntentToAdd
+			}
+			idx.Add(ne)
+		}
+	}
+	return nil
+}
+
+// clearMergeState removes the files recording an in-progress merge or
+// cherry-pick, which a reset abandons.
+func clearMergeState() {
+	for _, name := range []string{"MERGE_HEAD", "MERGE_MSG", "MERGE_MODE", "CHERRY_PICK_HEAD", "REVERT_HEAD"} {
+		os.Remove(".git/" + name)
+	}
+}
+
+// Reset moves the current branch, or HEAD if it is detached, to commit,
+// updating the index and worktree according to mode. The previous HEAD is
+// saved in ORIG_HEAD and the move is logged with msg.
+func Reset(wt Worktree, idx *Index, mode ResetMode, commit string, who Signature, msg string) error {
+	headRef, headHash, err := ResolveRef("HEAD")
+	if err != nil {
+		return err
+	}
+	newTree, err := peel(commit, "tree")
+	if err != nil {
+		return err
+	}
+	oldTree := ""
+	if headHash != "" {
+		if oldTree, err = peel(headHash, "tree"); err != nil {
+			return err
+		}
+	}
+	switch mode {
+	case ResetMixed:
+		err = ResetIndex(idx, newTree, nil)
+	case ResetHard:
+		err = CheckoutTree(wt, idx, oldTree, newTree, true)
+	case ResetKeep:
+		if err = CheckoutTree(wt, idx, oldTree, newTree, false); err == nil {
+			err = ResetIndex(idx, newTree, nil)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if mode != ResetSoft {
+		clearMergeState()
+	}
+	if headHash != "" {
+		if err := ioutil.WriteFile(".git/ORIG_HEAD", []byte(headHash+"\n"), 0666); err != nil {
+			return err
+		}
+	}
+	if headRef == "HEAD" {
+		return SetHEAD(commit, who, msg)
+	}
+	if err := UpdateRef(headRef, commit, "", who, msg); err != nil {
+		return fmt.Errorf("cannot update %s: %v", headRef, err)
+	}
+	return nil
+}
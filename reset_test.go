@@ -0,0 +1,110 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReset(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	oneTree := makeTree(t, map[string]string{"a": "one\n", "b": "b\n"})
+	twoTree := makeTree(t, map[string]string{"a": "two\n", "b": "b\n", "c": "c\n"})
+	one, _ := CommitTree(oneTree, nil, who, who, "one\n")
+	two, _ := CommitTree(twoTree, []string{one}, who, who, "two\n")
+	if err := UpdateRef("refs/heads/master", two, "", who, "test"); err != nil {
+		t.Fatal(err)
+	}
+	wt := DirWorktree(".")
+	idx := &Index{Version: 2}
+	if err := CheckoutTree(wt, idx, "", twoTree, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Reset(wt, idx, ResetMixed, one, who, "reset: moving to HEAD~1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, head, _ := ResolveRef("HEAD"); head != one {
+		t.Errorf("HEAD is %s, want %s", head, one)
+	}
+	if b, _ := ioutil.ReadFile(".git/ORIG_HEAD"); strings.TrimSpace(string(b)) != two {
+		t.Errorf("ORIG_HEAD is %q, want %s", b, two)
+	}
+	if got, want := indexPaths(idx), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index has %v, want %v", got, want)
+	}
+	checkFile(t, "a", "two\n")
+	checkFile(t, "c", "c\n")
+	if tree, _ := WriteTree(idx); tree != oneTree {
+		t.Errorf("index tree is %s, want %s", tree, oneTree)
+	}
+
+	if err := Reset(wt, idx, ResetSoft, two, who, "reset: moving to two"); err != nil {
+		t.Fatal(err)
+	}
+	if tree, _ := WriteTree(idx); tree != oneTree {
+		t.Errorf("soft reset changed the index to %s", tree)
+	}
+
+	ioutil.WriteFile("b", []byte("local\n"), 0666)
+	if err := Reset(wt, idx, ResetHard, two, who, "reset: moving to two"); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, "a", "two\n")
+	checkFile(t, "b", "b\n")
+	if tree, _ := WriteTree(idx); tree != twoTree {
+		t.Errorf("index tree is %s, want %s", tree, twoTree)
+	}
+
+	ioutil.WriteFile("b", []byte("local\n"), 0666)
+	if err := Reset(wt, idx, ResetKeep, one, who, "reset: moving to one"); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, "a", "one\n")
+	checkFile(t, "b", "local\n")
+	checkFile(t, "c", "")
+	ioutil.WriteFile("a", []byte("local change\n"), 0666)
+	if err := Reset(wt, idx, ResetKeep, two, who, "reset: moving to two"); err == nil {
+		t.Errorf("keep reset overwrote a local change")
+	}
+}
+
+func TestRestorePaths(t *testing.T) {
+	defer withTempRepo(t)()
+
+	tree := makeTree(t, map[string]string{"a": "a\n", "b": "b\n"})
+	wt := DirWorktree(".")
+	idx := &Index{Version: 2}
+	if err := CheckoutTree(wt, idx, "", tree, false); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile("a", []byte("changed\n"), 0666)
+	ioutil.WriteFile("n", []byte("new\n"), 0666)
+	idx.AddFile("a", false)
+	idx.AddFile("n", false)
+
+	ps, _ := ParsePathspec([]string{"a", "n"})
+	if err := RestorePaths(wt, idx, tree, ps, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := WriteTree(idx); got != tree {
+		t.Errorf("index tree is %s, want %s", got, tree)
+	}
+	checkFile(t, "a", "changed\n")
+	checkFile(t, "n", "new\n")
+
+	ps, _ = ParsePathspec([]string{"a"})
+	if err := RestorePaths(wt, idx, "", ps, false, true); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, "a", "a\n")
+}
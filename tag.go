@@ -0,0 +1,253 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ValidTagName reports whether name can be used for a new tag.
+func ValidTagName(name string) bool {
+	return !strings.HasPrefix(name, "-") && validRefName("refs/tags/"+name)
+}
+
+// WriteTag writes an annotated tag object called name for object and returns
+// its name. message is stored as is.
+func WriteTag(object, name string, tagger Signature, message string) (string, error) {
+	objectType, _, err := readObject(object)
+	if err != nil {
+		return "", err
+	}
+	b := bytes.NewBuffer(nil)
+	fmt.Fprintf(b, "object %s\n", object)
+	fmt.Fprintf(b, "type %s\n", objectType)
+	fmt.Fprintf(b, "tag %s\n", name)
+	fmt.Fprintf(b, "tagger %s\n", tagger)
+	b.WriteString("\n")
+	b.WriteString(message)
+	return WriteObject("tag", b.Bytes())
+}
+
+// describeName is the tag that names a commit for Describe. Annotated tags
+// have priority 2 and lightweight ones 1.
+type describeName struct {
+	tag      string
+	priority int
+	tagger   Signature
+}
+
+// describeNames maps each tagged commit to its best tag: annotated tags win
+// over lightweight ones, and the most recently made annotated tag over older
+// ones.
+func describeNames() (map[string]describeName, error) {
+	refs, err := ListRefs("refs/tags/")
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]describeName)
+	for _, r := range refs {
+		target, err := peel(r.Hash, "commit")
+		if err != nil {
+			continue
+		}
+		n := describeName{tag: r.Name[len("refs/tags/"):], priority: 1}
+		if target != r.Hash {
+			n.priority = 2
+			if o, err := readRefObject(r.Hash); err == nil {
+				n.tagger, _ = o.person("tagger")
+			}
+		}
+		old, ok := names[target]
+		if !ok || old.priority < n.priority ||
+			old.priority == 2 && n.priority == 2 && n.tagger.When.After(old.tagger.When) {
+			names[target] = n
+		}
+	}
+	return names, nil
+}
+
+// commitQueue is a queue of commits ordered newest first, in which commits
+// with equal dates keep the order they were added in.
+type commitQueue struct {
+	hashes []string
+	dates  []int64
+}
+
+func (l *commitQueue) insert(hash string) error {
+	c, err := LookupCommitInfo(hash)
+	if err != nil {
+		return err
+	}
+	date := c.Date.Unix()
+	i := 0
+	for i < len(l.dates) && l.dates[i] >= date {
+		i++
+	}
+	l.hashes = append(l.hashes[:i], append([]string{hash}, l.hashes[i:]...)...)
+	l.dates = append(l.dates[:i], append([]int64{date}, l.dates[i:]...)...)
+	return nil
+}
+
+func (l *commitQueue) pop() string {
+	hash := l.hashes[0]
+	l.hashes, l.dates = l.hashes[1:], l.dates[1:]
+	return hash
+}
+
+// describeCandidate is a tag found while walking back from the commit being
+// described. Commits reachable from it carry its flag.
+type describeCandidate struct {
+	name  describeName
+	depth int
+	flag  uint
+}
+
+// maxDescribeCandidates is how many tags Describe considers before it stops
+// looking for closer ones.
+const maxDescribeCandidates = 10
+
+// Describe finds the tag nearest to the commit hash and returns its name and
+// how many commits hash has that the tag doesn't. Only annotated tags are
+// used unless tags is set. Like git it walks history newest first, so in
+// branchy history the tag chosen may not be the one with the fewest commits
+// between.
+func Describe(hash string, tags bool) (tag string, depth int, err error) {
+	names, err := describeNames()
+	if err != nil {
+		return "", 0, err
+	}
+	if len(names) == 0 {
+		return "", 0, fmt.Errorf("No names found, cannot describe anything.")
+	}
+	usable := func(n describeName) bool { return tags || n.priority == 2 }
+	if n, ok := names[hash]; ok && usable(n) {
+		return n.tag, 0, nil
+	}
+
+	// Each commit walked is charged to every candidate that can't reach it,
+	// so a candidate's depth counts the commits between it and hash.
+	flags := make(map[string]uint)
+	seen := map[string]bool{hash: true}
+	list := &commitQueue{}
+	if err := list.insert(hash); err != nil {
+		return "", 0, err
+	}
+	var candidates []*describeCandidate
+	walk := func(c string) error {
+		commit, err := LookupCommitInfo(c)
+		if err != nil {
+			return err
+		}
+		for _, p := range commit.Parents {
+			if !seen[p] {
+				seen[p] = true
+				if err := list.insert(p); err != nil {
+					return err
+				}
+			}
+			flags[p] |= flags[c]
+		}
+		return nil
+	}
+	seenCommits, unannotated, gaveUpOn := 0, 0, ""
+	for len(list.hashes) > 0 {
+		c := list.pop()
+		seenCommits++
+		if n, ok := names[c]; ok {
+			if !usable(n) {
+				unannotated++
+			} else if len(candidates) < maxDescribeCandidates {
+				flag := uint(1) << uint(len(candidates))
+				candidates = append(candidates, &describeCandidate{name: n, depth: seenCommits - 1, flag: flag})
+				flags[c] |= flag
+			} else {
+				gaveUpOn = c
+				break
+			}
+		}
+		for _, t := range candidates {
+			if flags[c]&t.flag == 0 {
+				t.depth++
+			}
+		}
+		if len(candidates) > 0 && len(list.hashes) == 0 {
+			break
+		}
+		if err := walk(c); err != nil {
+			return "", 0, err
+		}
+	}
+	if len(candidates) == 0 {
+		if unannotated > 0 {
+			return "", 0, fmt.Errorf("No annotated tags can describe '%s'.\n"+
+				"However, there were unannotated tags: try --tags.", hash)
+		}
+		return "", 0, fmt.Errorf("No tags can describe '%s'.\n"+
+			"Try --always, or create some tags.", hash)
+	}
+	// Candidates were found in order, so the earliest found wins ties.
+	best := candidates[0]
+	for _, t := range candidates[1:] {
+		if t.depth < best.depth {
+			best = t
+		}
+	}
+
+	// Finish counting the commits the best candidate can't reach, stopping
+	// once everything left to walk is reachable from it.
+	if gaveUpOn != "" {
+		list.insert(gaveUpOn)
+	}
+	for len(list.hashes) > 0 {
+		c := list.pop()
+		if flags[c]&best.flag != 0 {
+			covered := true
+			for _, h := range list.hashes {
+				covered = covered && flags[h]&best.flag != 0
+			}
+			if covered {
+				break
+			}
+		} else {
+			best.depth++
+		}
+		if err := walk(c); err != nil {
+			return "", 0, err
+		}
+	}
+	return best.name.tag, best.depth, nil
+}
+
+// WorktreeDirty reports whether the index or any tracked file in wt differs
+// from the tree of the commit HEAD points to.
+func WorktreeDirty(wt Worktree, idx *Index) (bool, error) {
+	tree, err := ResolveRevision("HEAD^{tree}")
+	if err != nil {
+		return false, err
+	}
+	files, err := flattenTree(tree)
+	if err != nil {
+		return false, err
+	}
+	if len(files) != len(idx.Entries) {
+		return true, nil
+	}
+	for _, e := range idx.Entries {
+		if f, ok := files[string(e.Path)]; !ok || !f.matches(e) {
+			return true, nil
+		}
+		if _, err := wt.Lstat(string(e.Path)); os.IsNotExist(err) {
+			return true, nil
+		}
+		if ok, err := worktreeMatches(wt, e); err != nil || !ok {
+			return true, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDescribe(t *testing.T) {
+	defer withTempRepo(t)()
+
+	if _, _, err := Describe(ZeroHash, false); err == nil || !strings.HasPrefix(err.Error(), "No names found") {
+		t.Errorf("Describe without tags returned %v", err)
+	}
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	tree := makeTree(t, map[string]string{"a": "a\n"})
+	commits := []string(nil)
+	parents := []string(nil)
+	for i := 0; i < 5; i++ {
+		who.When = who.When.Add(time.Minute)
+		c, err := CommitTree(tree, parents, who, who, "commit\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits, parents = append(commits, c), []string{c}
+	}
+	// A side branch off the second commit, merged into the last.
+	side, _ := CommitTree(tree, commits[1:2], who, who, "side\n")
+	who.When = who.When.Add(time.Minute)
+	merge, _ := CommitTree(tree, []string{commits[4], side}, who, who, "merge\n")
+
+	v1, err := WriteTag(commits[0], "v1", who, "one\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, _ := WriteTag(commits[2], "v2", who, "two\n")
+	for ref, hash := range map[string]string{
+		"refs/tags/v1":    v1,
+		"refs/tags/v2":    v2,
+		"refs/tags/light": commits[3],
+		"refs/tags/side":  side,
+	} {
+		if err := UpdateRef(ref, hash, ZeroHash, who, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		hash  string
+		tags  bool
+		tag   string
+		depth int
+	}{
+		{commits[0], false, "v1", 0},
+		{commits[1], false, "v1", 1},
+		{commits[2], true, "v2", 0},
+		{commits[3], false, "v2", 1},
+		{commits[3], true, "light", 0},
+		{commits[4], true, "light", 1},
+		{merge, false, "v2", 4},
+		{merge, true, "light", 3},
+	} {
+		tag, depth, err := Describe(c.hash, c.tags)
+		if err != nil {
+			t.Errorf("Describe(%s, %v): %v", c.hash, c.tags, err)
+			continue
+		}
+		if tag != c.tag || depth != c.depth {
+			t.Errorf("Describe(%s, %v) = %s, %d, want %s, %d", c.hash, c.tags, tag, depth, c.tag, c.depth)
+		}
+	}
+
+	if typ, data, _ := readObject(v1); typ != "tag" || !strings.HasPrefix(string(data), "object "+commits[0]+"\ntype commit\ntag v1\ntagger A U Thor") {
+		t.Errorf("unexpected tag object %s %q", typ, data)
+	}
+	if !ValidTagName("v1.0") || ValidTagName("-v1") || ValidTagName("v1..0") {
+		t.Errorf("ValidTagName accepts the wrong names")
+	}
+}
@@ -0,0 +1,442 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// logUpdate is a reflog entry a transaction writes.
+type logUpdate struct {
+	ref    string
+	entry  ReflogEntry
+	copied bool // an existing entry moved from another ref, kept as it was
+}
+
+type refUpdate struct {
+	ref      string
+	newValue string // hash, or "ref: <target>" for a symbolic ref
+	oldHash  string // expected current value; "" to skip the check
+	msg      string
+	delete   bool
+	verify   bool // only check oldHash
+	noDeref  bool // update a symbolic ref itself rather than its target
+
+	renamedFrom string // the ref being renamed to ref, whose reflog moves here
+	symref      string // the symbolic ref queued, if the update was moved to its target
+
+	lock        *lockFile
+	current     string // value of ref when it was locked
+	currentHash string // current, resolved if it is a symbolic ref
+	logHEAD     bool   // HEAD refers to ref, so the update is logged there too
+}
+
+type transactionState int
+
+const (
+	transactionOpen transactionState = iota
+	transactionPrepared
+	transactionClosed
+)
+
+// RefTransaction updates a set of refs atomically: either every update
+// happens or none does. Queue updates with Update, Create, Delete, Verify and
+// UpdateSymref, then call Commit, or Prepare first to take the locks and
+// check the expected old values without changing anything yet.
+type RefTransaction struct {
+	updates []*refUpdate
+	logs    []logUpdate
+	store   refBackend
+	packed  *lockFile // packed-refs, for the files backend
+	stack   *lockFile // tables.list, for the reftable backend
+	state   transactionState
+}
+
+// NewRefTransaction returns an empty transaction.
+func NewRefTransaction() *RefTransaction {
+	return &RefTransaction{}
+}
+
+func (t *RefTransaction) add(u *refUpdate) error {
+	if t.state != transactionOpen {
+		return fmt.Errorf("transaction is no longer open")
+	}
+	if u.ref != "HEAD" && (!strings.HasPrefix(u.ref, "refs/") || !validRefName(u.ref)) {
+		return fmt.Errorf("refusing to update ref with bad name '%s'", u.ref)
+	}
+	t.updates = append(t.updates, u)
+	return nil
+}
+
+// Update queues setting ref to newHash. If oldHash is not empty, ref must
+// currently hold it; ZeroHash means ref must not exist. Updating a symbolic
+// ref such as HEAD updates the ref it points to unless noDeref is set.
+func (t *RefTransaction) Update(ref, newHash, oldHash, msg string, noDeref bool) error {
+	if newHash == ZeroHash {
+		return t.Delete(ref, oldHash, msg, noDeref)
+	}
+	return t.add(&refUpdate{ref: ref, newValue: newHash, oldHash: oldHash, msg: msg, noDeref: noDeref})
+}
+
+// Create queues creating ref at newHash; it fails if ref already exists.
+func (t *RefTransaction) Create(ref, newHash, msg string) error {
+	return t.add(&refUpdate{ref: ref, newValue: newHash, oldHash: ZeroHash, msg: msg})
+}
+
+// Delete queues deleting ref, both the loose file and its entry in
+// packed-refs, along with its reflog.
+func (t *RefTransaction) Delete(ref, oldHash, msg string, noDeref bool) error {
+	return t.add(&refUpdate{ref: ref, oldHash: oldHash, msg: msg, delete: true, noDeref: noDeref})
+}
+
+// Verify queues a check that ref holds oldHash, or doesn't exist if oldHash
+// is ZeroHash or empty.
+func (t *RefTransaction) Verify(ref, oldHash string) error {
+	if oldHash == "" {
+		oldHash = ZeroHash
+	}
+	return t.add(&refUpdate{ref: ref, oldHash: oldHash, verify: true})
+}
+
+// UpdateSymref queues pointing the symbolic ref ref, usually HEAD, at the ref
+// target.
+func (t *RefTransaction) UpdateSymref(ref, target, msg string) error {
+	if !strings.HasPrefix(target, "refs/") || !validRefName(target) {
+		return fmt.Errorf("refusing to point %s outside refs/: %s", ref, target)
+	}
+	return t.add(&refUpdate{ref: ref, newValue: refPrefix + target, msg: msg, noDeref: true})
+}
+
+// Prepare locks every ref in the transaction and checks their current
+// values. Nothing is changed until Commit. On failure the transaction is
+// aborted.
+func (t *RefTransaction) Prepare() error {
+	if t.state != transactionOpen {
+		return fmt.Errorf("transaction is no longer open")
+	}
+	if err := t.prepare(); err != nil {
+		t.Abort()
+		return err
+	}
+	t.state = transactionPrepared
+	if err := t.runHook("prepared"); err != nil {
+		t.Abort()
+		return fmt.Errorf("ref updates aborted by hook")
+	}
+	return nil
+}
+
+// runHook runs the reference-transaction hook for state, giving it a line
+// "<old> <new> <ref>" for each update with zeros for a value not given. As
+// in git an update made through a symbolic ref is listed for it, and then
+// for its target after the other updates; an update to the branch HEAD is on
+// is listed for HEAD too. Updates to symbolic refs themselves are left out.
+func (t *RefTransaction) runHook(state string) error {
+	headUpdated := false
+	for _, u := range t.updates {
+		headUpdated = headUpdated || u.ref == "HEAD" || u.symref == "HEAD"
+	}
+	b := bytes.NewBuffer(nil)
+	later := []string(nil)
+	for _, u := range t.updates {
+		if strings.HasPrefix(u.newValue, refPrefix) {
+			continue
+		}
+		oldHash, newHash := u.oldHash, u.newValue
+		if oldHash == "" {
+			oldHash = ZeroHash
+		}
+		if u.delete || u.verify {
+			newHash = ZeroHash
+		}
+		line := oldHash + " " + newHash + " "
+		switch {
+		case u.symref != "":
+			b.WriteString(line + u.symref + "\n")
+			later = append(later, line+u.ref+"\n")
+		case u.logHEAD && u.ref != "HEAD" && !headUpdated:
+			b.WriteString(line + u.ref + "\n")
+			later = append(later, line+"HEAD\n")
+		default:
+			b.WriteString(line + u.ref + "\n")
+		}
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	b.WriteString(strings.Join(later, ""))
+	return Hook{Name: "reference-transaction", Args: []string{state}, Stdin: b.Bytes()}.Run()
+}
+
+func (t *RefTransaction) prepare() error {
+	t.store = refStore()
+	head, err := readRef("HEAD")
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, u := range t.updates {
+		if !u.noDeref {
+			target, _, err := ResolveRef(u.ref)
+			if err != nil {
+				return err
+			}
+			if target != u.ref {
+				u.logHEAD = u.ref == "HEAD"
+				u.symref, u.ref = u.ref, target
+			}
+		}
+		if seen[u.ref] {
+			return fmt.Errorf("multiple updates for ref '%s' not allowed", u.ref)
+		}
+		seen[u.ref] = true
+		if head == refPrefix+u.ref {
+			u.logHEAD = true
+		}
+	}
+	if err := t.store.lockRefs(t); err != nil {
+		return err
+	}
+	for _, u := range t.updates {
+		if u.current, err = t.store.readRef(u.ref); err != nil {
+			return fmt.Errorf("cannot lock ref '%s': %v", u.ref, err)
+		}
+		current := u.current
+		if strings.HasPrefix(current, refPrefix) {
+			if !u.noDeref {
+				return fmt.Errorf("cannot lock ref '%s': is a symbolic ref", u.ref)
+			}
+			if _, current, err = ResolveRef(u.ref); err != nil {
+				return err
+			}
+		}
+		u.currentHash = current
+		switch {
+		case u.oldHash == "":
+		case u.oldHash == ZeroHash && current != "":
+			return fmt.Errorf("cannot lock ref '%s': reference already exists", u.ref)
+		case u.oldHash != ZeroHash && current == "":
+			return fmt.Errorf("cannot lock ref '%s': unable to resolve reference '%s'", u.ref, u.ref)
+		case u.oldHash != ZeroHash && current != u.oldHash:
+			return fmt.Errorf("cannot lock ref '%s': is at %s but expected %s", u.ref, current, u.oldHash)
+		}
+	}
+	return t.collectLogs()
+}
+
+// collectLogs works out the reflog entries the transaction will write.
+func (t *RefTransaction) collectLogs() error {
+	for _, u := range t.updates {
+		if u.verify || u.delete {
+			continue
+		}
+		oldHash, newHash := u.currentHash, u.newValue
+		if oldHash == "" {
+			oldHash = ZeroHash
+		}
+		if u.renamedFrom != "" {
+			entries, err := t.store.readReflog(u.renamedFrom)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				t.logs = append(t.logs, logUpdate{u.ref, e, true})
+			}
+			oldHash = newHash
+		}
+		if strings.HasPrefix(newHash, refPrefix) {
+			// A symref update is logged as a move to its target's value, and
+			// not at all if the target doesn't exist yet.
+			target := newHash[len(refPrefix):]
+			_, hash, err := ResolveRef(target)
+			if err != nil {
+				return err
+			}
+			for _, v := range t.updates {
+				if v.ref == target && !v.delete && !v.verify {
+					hash = v.newValue
+				}
+			}
+			if newHash = hash; newHash == "" {
+				continue
+			}
+		}
+		msg := strings.Replace(strings.TrimSpace(u.msg), "\n", " ", -1)
+		e := ReflogEntry{Old: oldHash, New: newHash, Message: msg}
+		t.logs = append(t.logs, logUpdate{u.ref, e, false})
+		if u.logHEAD && u.ref != "HEAD" {
+			t.logs = append(t.logs, logUpdate{"HEAD", e, false})
+		}
+	}
+	return nil
+}
+
+// Commit applies the transaction, preparing it first if necessary, and logs
+// the updates in the reflogs as who.
+func (t *RefTransaction) Commit(who Signature) error {
+	if t.state == transactionOpen {
+		if err := t.Prepare(); err != nil {
+			return err
+		}
+	}
+	if t.state != transactionPrepared {
+		return fmt.Errorf("transaction is no longer open")
+	}
+	err := t.store.applyUpdates(t, who)
+	t.state = transactionClosed
+	t.release()
+	invalidateBranches()
+	if err != nil {
+		return err
+	}
+	t.runHook("committed")
+	return nil
+}
+
+// Abort releases the locks of a transaction that hasn't been committed,
+// telling the reference-transaction hook if it had been prepared.
+func (t *RefTransaction) Abort() {
+	t.release()
+	if t.state == transactionPrepared {
+		t.runHook("aborted")
+	}
+	t.state = transactionClosed
+}
+
+// release rolls back any locks the transaction still holds.
+func (t *RefTransaction) release() {
+	for _, u := range t.updates {
+		if u.lock != nil {
+			u.lock.rollback()
+		}
+	}
+	for _, l := range []*lockFile{t.packed, t.stack} {
+		if l != nil {
+			l.rollback()
+		}
+	}
+}
+
+func (filesBackend) lockRefs(t *RefTransaction) error {
+	needPacked := false
+	for _, u := range t.updates {
+		path := ".git/" + u.ref
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return fmt.Errorf("cannot lock ref '%s': %v", u.ref, err)
+		}
+		var err error
+		if u.lock, err = lock(path); err != nil {
+			return fmt.Errorf("cannot lock ref '%s': %v", u.ref, err)
+		}
+		if u.delete {
+			if p, err := packedRef(u.ref); err != nil {
+				return err
+			} else if p != "" {
+				needPacked = true
+			}
+		}
+	}
+	if needPacked {
+		var err error
+		if t.packed, err = lock(".git/packed-refs"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (filesBackend) applyUpdates(t *RefTransaction, who Signature) error {
+	if t.packed != nil {
+		if err := t.rewritePackedRefs(); err != nil {
+			return err
+		}
+	}
+	for _, u := range t.updates {
+		switch {
+		case u.verify:
+			u.lock.rollback()
+		case u.delete:
+			if err := os.Remove(".git/" + u.ref); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			u.lock.rollback()
+			os.Remove(".git/logs/" + u.ref)
+			removeEmptyRefDirs(u.ref)
+		default:
+			if _, err := fmt.Fprintf(u.lock, "%s\n", u.newValue); err != nil {
+				return err
+			}
+			if err := u.lock.commit(); err != nil {
+				return err
+			}
+		}
+	}
+	for _, l := range t.logs {
+		by := who
+		if l.copied {
+			by = l.entry.Who
+		}
+		if err := appendReflog(l.ref, l.entry.Old, l.entry.New, by, l.entry.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewritePackedRefs writes packed-refs without the refs being deleted,
+// dropping the peeled values that follow them too.
+func (t *RefTransaction) rewritePackedRefs() error {
+	deleted := make(map[string]bool)
+	for _, u := range t.updates {
+		if u.delete {
+			deleted[u.ref] = true
+		}
+	}
+	b, err := readFile(".git/packed-refs")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out := bytes.NewBuffer(nil)
+	skipping := false
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "^") {
+			if !skipping {
+				fmt.Fprintln(out, line)
+			}
+			continue
+		}
+		skipping = false
+		if sp := strings.IndexByte(line, ' '); sp != -1 && line[0] != '#' && deleted[line[sp+1:]] {
+			skipping = true
+			continue
+		}
+		fmt.Fprintln(out, line)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if _, err := t.packed.Write(out.Bytes()); err != nil {
+		return err
+	}
+	return t.packed.commit()
+}
+
+// removeEmptyRefDirs removes the directories under .git/refs left empty by
+// deleting ref.
+func removeEmptyRefDirs(ref string) {
+	for dir := filepath.Dir(ref); strings.Contains(dir, "/"); dir = filepath.Dir(dir) {
+		if os.Remove(".git/"+dir) != nil {
+			break
+		}
+	}
+}
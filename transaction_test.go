@@ -0,0 +1,103 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefTransaction(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	tree := makeTree(t, map[string]string{"a": "a\n"})
+	one, _ := CommitTree(tree, nil, who, who, "one\n")
+	two, _ := CommitTree(tree, []string{one}, who, who, "two\n")
+	if err := UpdateRef("refs/heads/master", one, ZeroHash, who, "create"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed check leaves every ref in the transaction alone.
+	tx := NewRefTransaction()
+	tx.Update("refs/heads/master", two, one, "move", false)
+	tx.Create("refs/heads/topic", one, "create")
+	tx.Verify("refs/heads/other", two)
+	if err := tx.Commit(who); err == nil {
+		t.Fatal("commit succeeded despite a failed verify")
+	}
+	if _, hash, _ := ResolveRef("refs/heads/master"); hash != one {
+		t.Errorf("master moved to %s by a failed transaction", hash)
+	}
+	if _, err := os.Stat(".git/refs/heads/topic"); !os.IsNotExist(err) {
+		t.Errorf("topic was created by a failed transaction")
+	}
+	if _, err := os.Stat(".git/refs/heads/master.lock"); !os.IsNotExist(err) {
+		t.Errorf("lock left behind after a failed transaction")
+	}
+
+	tx = NewRefTransaction()
+	tx.Update("HEAD", two, one, "move", false)
+	tx.Create("refs/heads/topic", one, "create")
+	if err := tx.Commit(who); err != nil {
+		t.Fatal(err)
+	}
+	if _, hash, _ := ResolveRef("HEAD"); hash != two {
+		t.Errorf("HEAD resolves to %s, want %s", hash, two)
+	}
+	if entries, _ := ReadReflog("HEAD"); len(entries) != 2 || entries[1].New != two {
+		t.Errorf("unexpected HEAD reflog %+v", entries)
+	}
+
+	tx = NewRefTransaction()
+	tx.Update("refs/heads/master", one, "", "a", false)
+	tx.Update("HEAD", one, "", "b", false)
+	if err := tx.Commit(who); err == nil || !strings.Contains(err.Error(), "multiple updates") {
+		t.Errorf("expected an error for two updates of master, got %v", err)
+	}
+
+	// A concurrent writer holding the lock makes the update fail.
+	ioutil.WriteFile(".git/refs/heads/topic.lock", nil, 0666)
+	if err := UpdateRef("refs/heads/topic", two, "", who, "move"); err == nil {
+		t.Errorf("update succeeded while the ref was locked")
+	}
+	os.Remove(".git/refs/heads/topic.lock")
+
+	packed := "# pack-refs with: peeled fully-peeled sorted \n" +
+		one + " refs/heads/topic\n" +
+		one + " refs/tags/v1\n^" + two + "\n" +
+		two + " refs/tags/v2\n"
+	ioutil.WriteFile(".git/packed-refs", []byte(packed), 0666)
+	tx = NewRefTransaction()
+	tx.Delete("refs/heads/topic", one, "delete", false)
+	tx.Delete("refs/tags/v1", "", "delete", false)
+	if err := tx.Commit(who); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(".git/packed-refs")
+	if want := "# pack-refs with: peeled fully-peeled sorted \n" + two + " refs/tags/v2\n"; string(b) != want {
+		t.Errorf("packed-refs is\n%s\nwant\n%s", b, want)
+	}
+	if _, hash, _ := ResolveRef("refs/heads/topic"); hash != "" {
+		t.Errorf("topic still resolves to %s", hash)
+	}
+
+	if err := SetHEAD(one, who, "detach"); err != nil {
+		t.Fatal(err)
+	}
+	if ref, hash, _ := ResolveRef("HEAD"); ref != "HEAD" || hash != one {
+		t.Errorf("HEAD is %s %s, want detached at %s", ref, hash, one)
+	}
+	if err := SetHEAD("refs/heads/master", who, "attach"); err != nil {
+		t.Fatal(err)
+	}
+	if ref, _, _ := ResolveRef("HEAD"); ref != "refs/heads/master" {
+		t.Errorf("HEAD refers to %s, want refs/heads/master", ref)
+	}
+}
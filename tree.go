@@ -7,9 +7,13 @@ package ggit
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/sha1"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 type treeEntry struct {
@@ -19,9 +23,9 @@ type treeEntry struct {
 
 func parseTreeEntries(tree Object) ([]treeEntry, error) {
 	entries := make([]treeEntry, 0)
+	r := bufio.NewReaderSize(tree.Reader, 64)
 	for {
 		entry := treeEntry{}
-		r := bufio.NewReaderSize(tree.Reader, 64)
 		mode, err := r.ReadString(' ')
 		if err == io.EOF {
 			break
@@ -40,7 +44,7 @@ func parseTreeEntries(tree Object) ([]treeEntry, error) {
 		}
 		entry.name = name[:len(name)-1]
 
-		n, err := r.Read(entry.hash[:])
+		n, err := io.ReadFull(r, entry.hash[:])
 		if err != nil {
 			return nil, err
 		}
@@ -53,6 +57,52 @@ func parseTreeEntries(tree Object) ([]treeEntry, error) {
 	return entries, nil
 }
 
+// readTree returns the entries of the tree object named by hash.
+func readTree(hash string) ([]treeEntry, error) {
+	o, err := LookupObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer o.Close()
+	if o.ObjectType != "tree" {
+		return nil, fmt.Errorf("object %s is a %s, not a tree", hash, o.ObjectType)
+	}
+	return parseTreeEntries(o)
+}
+
+// LookupPath finds path in the tree named by tree and returns the mode and
+// object name recorded for it. hash is empty if there is no such path.
+func LookupPath(tree, path string) (mode uint32, hash string, err error) {
+	for {
+		entries, err := readTree(tree)
+		if err != nil {
+			return 0, "", err
+		}
+		name, rest := path, ""
+		if slash := strings.IndexByte(path, '/'); slash != -1 {
+			name, rest = path[:slash], path[slash+1:]
+		}
+		found := false
+		for _, e := range entries {
+			if e.name == name {
+				tree, found = fmt.Sprintf("%x", e.hash), true
+				if rest == "" {
+					m, err := strconv.ParseUint(e.mode, 8, 32)
+					return uint32(m), tree, err
+				}
+				if !e.isTree() {
+					return 0, "", nil
+				}
+				break
+			}
+		}
+		if !found {
+			return 0, "", nil
+		}
+		path = rest
+	}
+}
+
 func PrettyPrintTree(tree Object, recurse, dirsOnly bool, dir string) (string, error) {
 	entries, err := parseTreeEntries(tree)
 	if err != nil {
@@ -86,3 +136,95 @@ func PrettyPrintTree(tree Object, recurse, dirsOnly bool, dir string) (string, e
 	}
 	return s, nil
 }
+
+func (e treeEntry) isTree() bool {
+	return e.mode == "40000" || e.mode == "040000"
+}
+
+// sortTreeEntries puts entries in the order git requires within a tree object:
+// by name, with directories compared as if their names ended in '/'.
+func sortTreeEntries(entries []treeEntry) {
+	key := func(e treeEntry) string {
+		if e.isTree() {
+			return e.name + "/"
+		}
+		return e.name
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return key(entries[i]) < key(entries[j])
+	})
+}
+
+func marshalTreeEntries(entries []treeEntry) []byte {
+	b := bytes.NewBuffer(nil)
+	for _, e := range entries {
+		b.WriteString(strings.TrimLeft(e.mode, "0"))
+		b.WriteByte(' ')
+		b.WriteString(e.name)
+		b.WriteByte(0)
+		b.Write(e.hash[:])
+	}
+	return b.Bytes()
+}
+
+// WriteTree writes tree objects for the entries in idx and returns the name of
+// the root tree. Directories for which the index's cache tree is still valid
+// are not rewritten, and the cache tree is updated to describe the result.
+func WriteTree(idx *Index) (string, error) {
+	for _, e := range idx.Entries {
+		if e.Stage() != 0 {
+			return "", fmt.Errorf("%s: unmerged (stage %d)", e.Path, e.Stage())
+		}
+	}
+	idx.sort()
+	ct, err := writeTreeLevel(idx.Entries, "", idx.cacheTree())
+	if err != nil {
+		return "", err
+	}
+	idx.setCacheTree(ct)
+	return fmt.Sprintf("%x", ct.hash), nil
+}
+
+// writeTreeLevel writes the tree for the directory prefix, whose entries are
+// exactly those passed in. old is the cache tree previously recorded for the
+// directory, if any.
+func writeTreeLevel(entries []Entry, prefix string, old *cacheTree) (*cacheTree, error) {
+	if old != nil && old.entryCount == len(entries) && objectExists(fmt.Sprintf("%x", old.hash)) {
+		return old, nil
+	}
+	ct := &cacheTree{entryCount: len(entries)}
+	tree := []treeEntry(nil)
+	for i := 0; i < len(entries); {
+		rel := string(entries[i].Path[len(prefix):])
+		if slash := strings.IndexByte(rel, '/'); slash != -1 {
+			dir := rel[:slash+1]
+			j := i + 1
+			for j < len(entries) && bytes.HasPrefix(entries[j].Path[len(prefix):], []byte(dir)) {
+				j++
+			}
+			sub, err := writeTreeLevel(entries[i:j], prefix+dir, old.subtree(dir[:slash]))
+			if err != nil {
+				return nil, err
+			}
+			sub.name = dir[:slash]
+			ct.subtrees = append(ct.subtrees, sub)
+			tree = append(tree, treeEntry{mode: "40000", name: sub.name, hash: sub.hash})
+			i = j
+			continue
+		}
+		if entries[i].ExtendedFlags&entryIntentToAdd == 0 {
+			tree = append(tree, treeEntry{
+				mode: fmt.Sprintf("%o", entries[i].Mode),
+				name: rel,
+				hash: entries[i].Hash})
+		}
+		i++
+	}
+	sortTreeEntries(tree)
+	hash, err := WriteObject("tree", marshalTreeEntries(tree))
+	if err != nil {
+		return nil, err
+	}
+	copy(ct.hash[:], hashToBytes(hash))
+	return ct, nil
+}
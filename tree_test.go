@@ -62,3 +62,62 @@ func TestLsTree(t *testing.T) {
 		t.Errorf("expected \"%v\" got \"%v\"", prettyTree, actual)
 	}
 }
+
+func TestWriteTree(t *testing.T) {
+	defer withTempRepo(t)()
+
+	files := []struct {
+		path, contents string
+		mode           uint32
+	}{
+		{"README", "readme\n", 0100644},
+		{"dir.txt", "d\n", 0100644},
+		{"dir/file", "file\n", 0100644},
+		{"dir/sub/exe", "exe\n", 0100755},
+	}
+	idx := &Index{Version: 2}
+	for _, f := range files {
+		hash, err := WriteObject("blob", []byte(f.contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := Entry{Mode: f.mode, Path: []byte(f.path)}
+		copy(e.Hash[:], hashToBytes(hash))
+		idx.Entries = append(idx.Entries, e)
+	}
+
+	const expected = "40c3bd0510e8197d9f05bbb57e7b696d05f34b87"
+	tree, err := WriteTree(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree != expected {
+		t.Errorf("expected tree %s, got %s", expected, tree)
+	}
+
+	ct := idx.cacheTree()
+	if ct == nil {
+		t.Fatal("no cache tree written")
+	}
+	if ct.entryCount != 4 || ct.subtree("dir").entryCount != 2 ||
+		ct.subtree("dir").subtree("sub").entryCount != 1 {
+		t.Errorf("unexpected cache tree %+v", ct)
+	}
+	if h := fmt.Sprintf("%x", ct.subtree("dir").hash); h != "40dec211f8c23de9d864647b2f7b8033ff5fcbf0" {
+		t.Errorf("unexpected hash for dir %s", h)
+	}
+
+	idx.invalidatePath("dir/sub/exe")
+	ct = idx.cacheTree()
+	if ct.entryCount != -1 || ct.subtree("dir").entryCount != -1 ||
+		ct.subtree("dir").subtree("sub").entryCount != -1 {
+		t.Errorf("invalidate left valid trees: %+v", ct)
+	}
+	tree, err = WriteTree(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree != expected {
+		t.Errorf("expected tree %s after invalidation, got %s", expected, tree)
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// PackIndex is the contents of a pack index file.
+type PackIndex struct {
+	Version  int
+	Entries  []PackEntry // sorted by name; version 1 indices have no CRC-32s
+	PackSum  [sha1.Size]byte
+	IndexSum [sha1.Size]byte // the checksum the index ends with
+}
+
+// ReadPackIndex parses a version 1 or 2 pack index file.
+func ReadPackIndex(data []byte) (PackIndex, error) {
+	idx := PackIndex{Version: 1}
+	const fanOutSize = 256 * 4
+	fanOut := data
+	if bytes.HasPrefix(data, []byte("\377tOc")) {
+		if len(data) < 8 {
+			return idx, errors.New("unable to read header")
+		}
+		idx.Version = int(binary.BigEndian.Uint32(data[4:]))
+		if idx.Version != 2 {
+			return idx, fmt.Errorf("unknown index version %d", idx.Version)
+		}
+		fanOut = data[8:]
+	}
+	if len(fanOut) < fanOutSize {
+		return idx, errors.New("unable to read header")
+	}
+	n := 0
+	for i := 0; i < 256; i++ {
+		count := int(binary.BigEndian.Uint32(fanOut[i*4:]))
+		if count < n {
+			return idx, errors.New("corrupt index file")
+		}
+		n = count
+	}
+	rest := fanOut[fanOutSize:]
+	idx.Entries = make([]PackEntry, n)
+	if idx.Version == 1 {
+		if len(rest) < n*(4+sha1.Size)+2*sha1.Size {
+			return idx, errors.New("unable to read index")
+		}
+		for i := range idx.Entries {
+			e := rest[i*(4+sha1.Size):]
+			idx.Entries[i].Offset = uint64(binary.BigEndian.Uint32(e))
+			copy(idx.Entries[i].Hash[:], e[4:])
+		}
+		rest = rest[n*(4+sha1.Size):]
+	} else {
+		if len(rest) < n*(sha1.Size+8)+2*sha1.Size {
+			return idx, errors.New("unable to read index")
+		}
+		hashes, crcs, offsets := rest, rest[n*sha1.Size:], rest[n*(sha1.Size+4):]
+		large := offsets[n*4:]
+		numLarge := 0
+		for i := range idx.Entries {
+			e := &idx.Entries[i]
+			copy(e.Hash[:], hashes[i*sha1.Size:])
+			e.CRC32 = binary.BigEndian.Uint32(crcs[i*4:])
+			offset := binary.BigEndian.Uint32(offsets[i*4:])
+			if offset&(1<<31) == 0 {
+				e.Offset = uint64(offset)
+				continue
+			}
+			k := int(offset &^ (1 << 31))
+			if len(large) < (k+1)*8+2*sha1.Size {
+				return idx, errors.New("corrupt index file")
+			}
+			e.Offset = binary.BigEndian.Uint64(large[k*8:])
+			if k >= numLarge {
+				numLarge = k + 1
+			}
+		}
+		rest = large[numLarge*8:]
+	}
+	if len(rest) != 2*sha1.Size {
+		return idx, errors.New("corrupt index file")
+	}
+	copy(idx.PackSum[:], rest)
+	copy(idx.IndexSum[:], rest[sha1.Size:])
+	return idx, nil
+}
+
+// PackedObject describes an object in a pack, as git verify-pack -v lists
+// it. Size is the size of the delta for deltas.
+type PackedObject struct {
+	Hash       [sha1.Size]byte
+	Type       string
+	Size       int
+	PackedSize uint64
+	Offset     uint64
+	Depth      int // the length of the delta chain, 0 for objects that aren't deltas
+	Base       [sha1.Size]byte
+}
+
+// VerifyPack checks the pack whose index is at idxPath: the checksums of the
+// pack and index, the index's CRC-32s and the contents of each object. It
+// returns the pack's objects in the order they are packed, even when it
+// finds a problem afterwards, and the first problem found.
+func VerifyPack(idxPath string) ([]PackedObject, error) {
+	objects, problems := verifyPack(idxPath)
+	if len(problems) > 0 {
+		return objects, problems[0]
+	}
+	return objects, nil
+}
+
+// verifyPack is VerifyPack, returning every problem it finds rather than
+// just the first.
+func verifyPack(idxPath string) ([]PackedObject, []error) {
+	packPath := strings.TrimSuffix(idxPath, ".idx") + ".pack"
+	data, err := ioutil.ReadFile(idxPath)
+	if err != nil {
+		return nil, []error{err}
+	}
+	idx, err := ReadPackIndex(data)
+	if err != nil {
+		return nil, []error{fmt.Errorf("%s: %v", idxPath, err)}
+	}
+	if sum := sha1.Sum(data[:len(data)-sha1.Size]); sum != idx.IndexSum {
+		return nil, []error{fmt.Errorf("Packfile index for %s SHA1 mismatch", packPath)}
+	}
+	problems := checkPackData(packPath, idx)
+	ix, err := indexPack(packPath, nil, IndexPackOptions{})
+	if err != nil {
+		return nil, append(problems, err)
+	}
+
+	objects := make([]PackedObject, len(ix.objects))
+	fi, err := os.Stat(packPath)
+	if err != nil {
+		return nil, append(problems, err)
+	}
+	end := uint64(fi.Size()) - sha1.Size
+	for i := len(ix.objects) - 1; i >= 0; i-- {
+		o := ix.objects[i]
+		objects[i] = PackedObject{Hash: o.hash, Type: objectTypeStrings[o.objectType], Size: o.size,
+			PackedSize: end - o.offset, Offset: o.offset, Depth: o.depth}
+		if o.depth > 0 {
+			objects[i].Base = ix.objects[o.base].hash
+		}
+		end = o.offset
+	}
+
+	if len(idx.Entries) != len(ix.objects) {
+		return objects, append(problems, fmt.Errorf("%s has %d objects, but its index lists %d", packPath, len(ix.objects), len(idx.Entries)))
+	}
+	offsets := make(map[[sha1.Size]byte]uint64, len(ix.objects))
+	for _, o := range ix.objects {
+		offsets[o.hash] = o.offset
+	}
+	for _, e := range idx.Entries {
+		if offset, ok := offsets[e.Hash]; !ok || offset != e.Offset {
+			problems = append(problems, fmt.Errorf("packed %x from %s is corrupt", e.Hash, packPath))
+		}
+	}
+	return objects, problems
+}
+
+// checkPackData reads the pack at packPath once, checking its contents
+// against its trailing checksum and, for version 2 indices, each entry's
+// bytes against idx's CRC-32. It returns every problem found.
+func checkPackData(packPath string, idx PackIndex) []error {
+	f, err := os.Open(packPath)
+	if err != nil {
+		return []error{err}
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return []error{err}
+	}
+	if fi.Size() < 12+sha1.Size {
+		return []error{fmt.Errorf("%s is too short to be a pack", packPath)}
+	}
+	end := uint64(fi.Size()) - sha1.Size
+	h := sha1.New()
+	r := io.TeeReader(bufio.NewReader(io.LimitReader(f, int64(end))), h)
+
+	problems := []error(nil)
+	if idx.Version > 1 {
+		entries := append([]PackEntry(nil), idx.Entries...)
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Offset < entries[j].Offset })
+		pos := uint64(0)
+		for i, e := range entries {
+			next := end
+			if i+1 < len(entries) {
+				next = entries[i+1].Offset
+			}
+			if e.Offset < pos || next < e.Offset || next > end {
+				continue // verifyPack reports the bad offset
+			}
+			if _, err := io.CopyN(ioutil.Discard, r, int64(e.Offset-pos)); err != nil {
+				return append(problems, err)
+			}
+			crc := crc32.NewIEEE()
+			if _, err := io.CopyN(crc, r, int64(next-e.Offset)); err != nil {
+				return append(problems, err)
+			}
+			pos = next
+			if crc.Sum32() != e.CRC32 {
+				problems = append(problems, fmt.Errorf("index CRC mismatch for object %x from %s at offset %d", e.Hash, packPath, e.Offset))
+			}
+		}
+	}
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return append(problems, err)
+	}
+	var sum, trailer [sha1.Size]byte
+	copy(sum[:], h.Sum(nil))
+	if _, err := io.ReadFull(f, trailer[:]); err != nil {
+		return append(problems, err)
+	}
+	checksums := []error(nil)
+	if sum != trailer {
+		checksums = append(checksums, fmt.Errorf("%s pack checksum mismatch", packPath))
+	}
+	if trailer != idx.PackSum {
+		checksums = append(checksums, fmt.Errorf("%s pack checksum does not match its index", packPath))
+	}
+	return append(checksums, problems...)
+}
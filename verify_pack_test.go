@@ -0,0 +1,135 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadPackIndex(t *testing.T) {
+	entries := []PackEntry{
+		{Hash: [sha1.Size]byte{1}, Offset: 12, CRC32: 0xdeadbeef},
+		{Hash: [sha1.Size]byte{2, 3}, Offset: 5 << 30, CRC32: 1},
+		{Hash: [sha1.Size]byte{0xff}, Offset: 6 << 30, CRC32: 2},
+	}
+	b := bytes.NewBuffer(nil)
+	if err := WritePackIndex(b, entries, [sha1.Size]byte{9}); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := ReadPackIndex(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Version != 2 || !reflect.DeepEqual(idx.Entries, entries) || idx.PackSum != [sha1.Size]byte{9} {
+		t.Errorf("read back %+v", idx)
+	}
+	if sum := sha1.Sum(b.Bytes()[:b.Len()-sha1.Size]); sum != idx.IndexSum {
+		t.Errorf("index checksum is %x, want %x", idx.IndexSum, sum)
+	}
+	if _, err := ReadPackIndex(b.Bytes()[:b.Len()-1]); err == nil {
+		t.Errorf("read a truncated index")
+	}
+}
+
+func TestVerifyPack(t *testing.T) {
+	defer withTempRepo(t)()
+
+	who := Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1398372819, 0)}
+	text := strings.Repeat("a line of text to delta against\n", 40)
+	parent := []string(nil)
+	for i := 0; i < 4; i++ {
+		tree := makeTree(t, map[string]string{"f": fmt.Sprintf("%s%d\n", text, i)})
+		hash, err := CommitTree(tree, parent, who, who, "commit\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent = []string{hash}
+	}
+	objects, err := ListObjects(parent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := WritePackFiles(".git/test", objects, PackOptions{Window: 10, Depth: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := ".git/test-" + name
+	packed, err := VerifyPack(base + ".idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packed) != len(objects) {
+		t.Fatalf("verified %d objects, want %d", len(packed), len(objects))
+	}
+	byHash := make(map[[sha1.Size]byte]PackedObject)
+	deltas := 0
+	end := uint64(12)
+	for _, o := range packed {
+		byHash[o.Hash] = o
+		if o.Offset != end {
+			t.Errorf("%x is at %d, want %d", o.Hash, o.Offset, end)
+		}
+		end += o.PackedSize
+		if o.Depth == 0 {
+			continue
+		}
+		deltas++
+		b, ok := byHash[o.Base]
+		if !ok || b.Depth != o.Depth-1 || b.Type != o.Type {
+			t.Errorf("%x at depth %d has base %+v", o.Hash, o.Depth, b)
+		}
+	}
+	if deltas == 0 {
+		t.Errorf("no deltas in the pack")
+	}
+
+	data, err := ioutil.ReadFile(base + ".idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := ReadPackIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := idx.Entries[0]
+	idx.Entries[0].CRC32++
+	b := bytes.NewBuffer(nil)
+	if err := WritePackIndex(b, idx.Entries, idx.PackSum); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(".git/bad.idx", b.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(".git/bad.pack", mustReadFile(t, base+".pack"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("index CRC mismatch for object %x from .git/bad.pack at offset %d", e.Hash, e.Offset)
+	if _, err := VerifyPack(".git/bad.idx"); err == nil || err.Error() != want {
+		t.Errorf("bad CRC gave error %v, want %s", err, want)
+	}
+	data[len(data)-1] ^= 1
+	if err := ioutil.WriteFile(".git/bad.idx", data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyPack(".git/bad.idx"); err == nil || err.Error() != "Packfile index for .git/bad.pack SHA1 mismatch" {
+		t.Errorf("bad index checksum gave error %v", err)
+	}
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,215 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// modeFromFileInfo returns the index mode git records for a file.
+func modeFromFileInfo(fi os.FileInfo) uint32 {
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		return 0120000
+	case fi.IsDir():
+		return 040000
+	case fi.Mode()&0100 != 0:
+		return 0100755
+	}
+	return 0100644
+}
+
+// NewEntry returns an index entry for the file at path, with stat information
+// from fi and contents named by hash.
+func NewEntry(path string, fi os.FileInfo, hash [20]byte) Entry {
+	e := Entry{
+		Mtime: fi.ModTime(),
+		Mode:  modeFromFileInfo(fi),
+		Size:  uint32(fi.Size()),
+		Hash:  hash,
+		Path:  []byte(path)}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		e.Ctime = time.Unix(st.Ctim.Unix())
+		e.Dev = uint32(st.Dev)
+		e.Ino = uint32(st.Ino)
+		e.Uid = st.Uid
+		e.Gid = st.Gid
+	}
+	return e
+}
+
+// StatMatches reports whether fi, from an lstat of the entry's path, shows
+// the file unchanged since the entry was recorded, so that the contents need
+// not be hashed again.
+func (e Entry) StatMatches(fi os.FileInfo) bool {
+	if e.ExtendedFlags&entryIntentToAdd != 0 {
+		return false
+	}
+	return e.Mtime.Equal(fi.ModTime()) && e.Size == uint32(fi.Size()) &&
+		e.Mode == modeFromFileInfo(fi) && (e.Ino == 0 || e.Ino == NewEntry("", fi, e.Hash).Ino)
+}
+
+// HashFile computes the blob name for the worktree file at path, whose lstat
+// result is fi. The target of a symlink is hashed rather than the file it
+// points to. The blob is added to the object database if write is set.
+func HashFile(path string, fi os.FileInfo, write bool) (string, error) {
+	return hashWorktreeFile(DirWorktree("."), path, fi, write)
+}
+
+// WalkWorktree calls fn for every file in the worktree below the current
+// directory, skipping .git, nested repositories and, if ig is not nil, ignored
+// paths.
+func WalkWorktree(ig *Ignorer, fn func(path string, fi os.FileInfo) error) error {
+	return filepath.Walk(".", func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == "." {
+			return nil
+		}
+		if fi.IsDir() {
+			if fi.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(p, ".git")); err == nil {
+				return filepath.SkipDir
+			}
+			if ig != nil && ig.Ignored(p, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ig != nil && ig.Ignored(p, false) {
+			return nil
+		}
+		return fn(p, fi)
+	})
+}
+
+// entryIndex returns the position of the first entry for path, or where one
+// would be inserted, and whether there is one.
+func (idx *Index) entryIndex(path string) (int, bool) {
+	p := []byte(path)
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return bytes.Compare(idx.Entries[i].Path, p) >= 0
+	})
+	return i, i < len(idx.Entries) && bytes.Equal(idx.Entries[i].Path, p)
+}
+
+// Entry returns the stage 0 entry for path.
+func (idx *Index) Entry(path string) (Entry, bool) {
+	i, ok := idx.entryIndex(path)
+	if !ok || idx.Entries[i].Stage() != 0 {
+		return Entry{}, false
+	}
+	return idx.Entries[i], true
+}
+
+// Remove drops every entry for path, including conflict stages, and reports
+// whether there were any.
+func (idx *Index) Remove(path string) bool {
+	i, ok := idx.entryIndex(path)
+	if !ok {
+		return false
+	}
+	j := i
+	for j < len(idx.Entries) && string(idx.Entries[j].Path) == path {
+		j++
+	}
+	idx.Entries = append(idx.Entries[:i], idx.Entries[j:]...)
+	idx.invalidatePath(path)
+	return true
+}
+
+// Add stores e, replacing any entries for the same path and any that would
+// conflict with it by treating a file as a directory or the reverse.
+func (idx *Index) Add(e Entry) {
+	path := string(e.Path)
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' {
+			idx.Remove(path[:i])
+		}
+	}
+	i, _ := idx.entryIndex(path + "/")
+	j := i
+	for j < len(idx.Entries) && bytes.HasPrefix(idx.Entries[j].Path, []byte(path+"/")) {
+		j++
+	}
+	idx.Entries = append(idx.Entries[:i], idx.Entries[j:]...)
+	idx.Remove(path)
+	i, _ = idx.entryIndex(path)
+	idx.Entries = append(idx.Entries, Entry{})
+	copy(idx.Entries[i+1:], idx.Entries[i:])
+	idx.Entries[i] = e
+	idx.invalidatePath(path)
+}
+
+// AddFile stages the worktree file at path, writing its contents to the object
+// database unless the existing entry's stat information shows it unchanged.
+// With intentToAdd, only a placeholder entry is recorded for a new file.
+func (idx *Index) AddFile(path string, intentToAdd bool) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if e, ok := idx.Entry(path); ok && e.StatMatches(fi) {
+		return nil
+	}
+	if intentToAdd {
+		if _, ok := idx.Entry(path); ok {
+			return nil
+		}
+		var hash [20]byte
+		copy(hash[:], hashToBytes(emptyBlobHash))
+		e := NewEntry(path, fi, hash)
+		e.Size = 0
+		e.ExtendedFlags |= entryIntentToAdd
+		idx.Add(e)
+		return nil
+	}
+	hash, err := HashFile(path, fi, true)
+	if err != nil {
+		return err
+	}
+	var h [20]byte
+	copy(h[:], hashToBytes(hash))
+	idx.Add(NewEntry(path, fi, h))
+	return nil
+}
+
+// Move renames the entry for from, or every entry below it if from is a
+// directory, to to, refreshing stat information from the worktree. It
+// reports whether anything was moved.
+func (idx *Index) Move(from, to string) bool {
+	moved := []Entry(nil)
+	for _, e := range idx.Entries {
+		p := string(e.Path)
+		if p == from || strings.HasPrefix(p, from+"/") {
+			moved = append(moved, e)
+		}
+	}
+	for _, e := range moved {
+		idx.Remove(string(e.Path))
+	}
+	for _, e := range moved {
+		e.Path = []byte(to + string(e.Path)[len(from):])
+		if fi, err := os.Lstat(string(e.Path)); err == nil {
+			flags, extended := e.Flags, e.ExtendedFlags
+			e = NewEntry(string(e.Path), fi, e.Hash)
+			e.Flags, e.ExtendedFlags = flags, extended
+		}
+		idx.Add(e)
+	}
+	return len(moved) > 0
+}
+
+const emptyBlobHash = "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
@@ -0,0 +1,123 @@
+// Copyright 2014 Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package ggit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func indexPaths(idx *Index) []string {
+	paths := []string(nil)
+	for _, e := range idx.Entries {
+		paths = append(paths, string(e.Path))
+	}
+	return paths
+}
+
+func TestIndexAddFile(t *testing.T) {
+	defer withTempRepo(t)()
+
+	os.MkdirAll("dir", 0777)
+	ioutil.WriteFile("dir/file", []byte("file\n"), 0666)
+	ioutil.WriteFile("exe", []byte("exe\n"), 0777)
+	os.Symlink("exe", "link")
+
+	idx := &Index{Version: 2}
+	for _, p := range []string{"link", "exe", "dir/file"} {
+		if err := idx.AddFile(p, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(indexPaths(idx)) != "[dir/file exe link]" {
+		t.Errorf("unexpected paths %v", indexPaths(idx))
+	}
+	expected := map[string]struct {
+		mode uint32
+		hash string
+	}{
+		"dir/file": {0100644, "f73f3093ff865c514c6c51f867e35f693487d0d3"},
+		"exe":      {0100755, "86daf54cf5c47c1e43ed4a8f31094f508b3d6264"},
+		"link":     {0120000, "f8e020bf1ebe4dbf093c3af3cf30fd9a82d5f65f"},
+	}
+	for p, x := range expected {
+		e, ok := idx.Entry(p)
+		if !ok {
+			t.Errorf("no entry for %s", p)
+			continue
+		}
+		if e.Mode != x.mode || fmt.Sprintf("%x", e.Hash) != x.hash {
+			t.Errorf("%s: got mode %o hash %x", p, e.Mode, e.Hash)
+		}
+		if !objectExists(x.hash) {
+			t.Errorf("%s: blob not written", p)
+		}
+	}
+
+	// Replacing a directory with a file drops the entries below it.
+	os.RemoveAll("dir")
+	ioutil.WriteFile("dir", []byte("now a file\n"), 0666)
+	if err := idx.AddFile("dir", false); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(indexPaths(idx)) != "[dir exe link]" {
+		t.Errorf("unexpected paths after replacing dir %v", indexPaths(idx))
+	}
+
+	ioutil.WriteFile("new", nil, 0666)
+	if err := idx.AddFile("new", true); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := idx.Entry("new"); e.ExtendedFlags&entryIntentToAdd == 0 {
+		t.Error("intent-to-add flag not set")
+	}
+
+	os.Rename("exe", "moved")
+	if !idx.Move("exe", "moved") {
+		t.Error("Move found nothing to move")
+	}
+	if !idx.Remove("link") || idx.Remove("link") {
+		t.Error("Remove reported the wrong result")
+	}
+	if fmt.Sprint(indexPaths(idx)) != "[dir moved new]" {
+		t.Errorf("unexpected paths after move %v", indexPaths(idx))
+	}
+}
+
+func TestHashFileStreamed(t *testing.T) {
+	defer withTempRepo(t)()
+
+	ioutil.WriteFile(".git/config", []byte("[core]\n\tbigFileThreshold = 100\n"), 0666)
+	data := []byte(strings.Repeat("a big file\n", 100))
+	ioutil.WriteFile("big", data, 0666)
+	want := fmt.Sprintf("%x", hashObject("blob", data))
+	fi, _ := os.Lstat("big")
+	if hash, err := HashFile("big", fi, false); err != nil || hash != want {
+		t.Errorf("HashFile(big) = %s, %v; want %s", hash, err, want)
+	}
+	if objectExists(want) {
+		t.Error("blob written without write")
+	}
+	if hash, err := HashFile("big", fi, true); err != nil || hash != want {
+		t.Errorf("HashFile(big, write) = %s, %v; want %s", hash, err, want)
+	}
+	if objectType, got, err := readObject(want); err != nil || objectType != "blob" || string(got) != string(data) {
+		t.Errorf("readObject(%s) = %s, %d bytes, %v", want, objectType, len(got), err)
+	}
+	if temps, _ := filepath.Glob(".git/objects/tmp_obj_*"); len(temps) != 0 {
+		t.Errorf("temporary files left behind: %v", temps)
+	}
+
+	// A file that changes size under the stat it was hashed for is refused.
+	ioutil.WriteFile("big", append(data, "more\n"...), 0666)
+	if hash, err := HashFile("big", fi, true); err == nil {
+		t.Errorf("HashFile of a grown file gave %s", hash)
+	}
+}